@@ -0,0 +1,84 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jbaikge/gocms/models/media"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func stores(t *testing.T) map[string]media.BlobStore {
+	stores := map[string]media.BlobStore{
+		"Filesystem": NewFilesystem(t.TempDir()),
+		"Memory":     NewMemory(),
+	}
+
+	// GridFS only runs when a database is available
+	if dbHost := os.Getenv("DB_HOST"); dbHost != "" {
+		dbName := "testing"
+		if dbNameEnv := os.Getenv("DB_NAME"); dbNameEnv != "" {
+			dbName = dbNameEnv
+		}
+		client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://"+dbHost))
+		assert.NoError(t, err)
+		db := client.Database(dbName)
+		assert.NoError(t, db.Drop(context.Background()))
+		stores["GridFS"] = NewGridFS(db)
+	}
+
+	return stores
+}
+
+func read(t *testing.T, store media.BlobStore, key string) string {
+	rc, err := store.Get(key)
+	assert.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestBlobStore(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Run("Put", func(t *testing.T) {
+				assert.NoError(t, store.Put("put", strings.NewReader("first")))
+				assert.Equal(t, "first", read(t, store, "put"))
+
+				assert.NoError(t, store.Put("put", strings.NewReader("second")))
+				assert.Equal(t, "second", read(t, store, "put"))
+			})
+
+			t.Run("Nested Key", func(t *testing.T) {
+				assert.NoError(t, store.Put("a/b/c", strings.NewReader("nested")))
+				assert.Equal(t, "nested", read(t, store, "a/b/c"))
+			})
+
+			t.Run("Get Missing", func(t *testing.T) {
+				_, err := store.Get("missing")
+				assert.Equal(t, media.ErrBlobNotFound, err)
+			})
+
+			t.Run("Delete", func(t *testing.T) {
+				assert.NoError(t, store.Put("delete", strings.NewReader("data")))
+				assert.NoError(t, store.Delete("delete"))
+				_, err := store.Get("delete")
+				assert.Equal(t, media.ErrBlobNotFound, err)
+				assert.Equal(t, media.ErrBlobNotFound, store.Delete("delete"))
+			})
+		})
+	}
+}
+
+func TestFilesystemKeys(t *testing.T) {
+	store := NewFilesystem(t.TempDir())
+	for _, key := range []string{"", "../escape", "/etc/passwd", "a/../../escape"} {
+		assert.Error(t, store.Put(key, strings.NewReader("data")))
+	}
+}
@@ -0,0 +1,82 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jbaikge/gocms/models/media"
+)
+
+type filesystemStore struct {
+	dir string
+}
+
+// Stores blobs as files below dir. Keys may contain slashes to group blobs
+// into subdirectories.
+func NewFilesystem(dir string) media.BlobStore {
+	return filesystemStore{
+		dir: dir,
+	}
+}
+
+func (s filesystemStore) Delete(key string) (err error) {
+	path, err := s.path(key)
+	if err != nil {
+		return
+	}
+	if err = os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+		err = media.ErrBlobNotFound
+	}
+	return
+}
+
+func (s filesystemStore) Get(key string) (rc io.ReadCloser, err error) {
+	path, err := s.path(key)
+	if err != nil {
+		return
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, media.ErrBlobNotFound
+	}
+	return f, err
+}
+
+// Writes to a temporary file first so readers never see partial contents
+func (s filesystemStore) Put(key string, r io.Reader) (err error) {
+	path, err := s.path(key)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s filesystemStore) path(key string) (path string, err error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key: %q", key)
+	}
+	return filepath.Join(s.dir, clean), nil
+}
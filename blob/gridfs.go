@@ -0,0 +1,70 @@
+package blob
+
+import (
+	"errors"
+	"io"
+
+	"github.com/jbaikge/gocms/models/media"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type gridFSStore struct {
+	db   *mongo.Database
+	opts *options.BucketOptions
+}
+
+// Stores blobs in the GridFS bucket named "blobs", using the key as the file
+// ID
+func NewGridFS(db *mongo.Database) media.BlobStore {
+	return gridFSStore{
+		db:   db,
+		opts: options.GridFSBucket().SetName("blobs"),
+	}
+}
+
+func (s gridFSStore) Delete(key string) (err error) {
+	bucket, err := s.bucket()
+	if err != nil {
+		return
+	}
+	if err = bucket.Delete(key); errors.Is(err, gridfs.ErrFileNotFound) {
+		err = media.ErrBlobNotFound
+	}
+	return
+}
+
+func (s gridFSStore) Get(key string) (rc io.ReadCloser, err error) {
+	bucket, err := s.bucket()
+	if err != nil {
+		return
+	}
+	stream, err := bucket.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, media.ErrBlobNotFound
+	}
+	if err != nil {
+		return
+	}
+	return stream, nil
+}
+
+// GridFS files cannot be overwritten, so any existing file under the key is
+// removed first
+func (s gridFSStore) Put(key string, r io.Reader) (err error) {
+	bucket, err := s.bucket()
+	if err != nil {
+		return
+	}
+	if err = bucket.Delete(key); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return
+	}
+	return bucket.UploadFromStreamWithID(key, key, r)
+}
+
+// Buckets share read and write buffers between operations, so every operation
+// gets its own
+func (s gridFSStore) bucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(s.db, s.opts)
+}
@@ -0,0 +1,56 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/jbaikge/gocms/models/media"
+)
+
+type memoryStore struct {
+	mutex *sync.RWMutex
+	blobs map[string][]byte
+}
+
+// Keeps blobs in memory, mostly useful for testing
+func NewMemory() media.BlobStore {
+	return memoryStore{
+		mutex: new(sync.RWMutex),
+		blobs: make(map[string][]byte),
+	}
+}
+
+func (s memoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.blobs[key]; !ok {
+		return media.ErrBlobNotFound
+	}
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s memoryStore) Get(key string) (io.ReadCloser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, media.ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s memoryStore) Put(key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blobs[key] = data
+	return nil
+}
@@ -3,40 +3,140 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/blob"
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
 	"github.com/jbaikge/gocms/repository"
 	"github.com/jbaikge/gocms/server"
+	"github.com/jbaikge/gocms/theme"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func main() {
+	ctx := context.Background()
+
+	// Interrupts stop the server and cut short webhook retries
+	shutdown, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Everything is kept in a single file when DB_FILE is set, otherwise in
+	// MongoDB with media in GridFS
+	var repo repository.Repository
+	var blobs media.BlobStore
+	if dbFile := os.Getenv("DB_FILE"); dbFile != "" {
+		var err error
+		if repo, err = repository.NewBolt(dbFile); err != nil {
+			log.Fatalf("Unable to open %s: %v", dbFile, err)
+		}
+		blobs = blob.NewFilesystem(filepath.Join(filepath.Dir(dbFile), "media"))
+	} else {
+		db := connectMongo(ctx)
+		repo = repository.NewMongo(ctx, db, mongoTimeouts())
+		blobs = blob.NewGridFS(db)
+	}
+
+	auditService := audit.NewAuditService(repo)
+
+	classService := class.NewClassService(repo, repo)
+	documentService := document.NewDocumentService(repo, classService)
+
+	// The audit log and webhooks hear about every change to classes and
+	// documents once it is stored
+	webhookService := webhook.NewWebhookService(shutdown, repo, &http.Client{Timeout: 10 * time.Second})
+	class.Audit(classService.Events(), auditService)
+	class.Notify(classService.Events(), class.NotifierFunc(webhookService.NotifyClass))
+	document.Audit(documentService.Events(), auditService)
+	document.Notify(documentService.Events(), document.NotifierFunc(webhookService.NotifyDocument))
+	userService := user.NewUserService(repo, auditService)
+
+	// Submissions are only logged until a notifier such as email is set up
+	formService := form.NewFormService(repo, form.NotifierFunc(func(f form.Form, sub form.Submission) error {
+		log.Printf("Form %q received submission %s", f.Name, sub.Id.Hex())
+		return nil
+	}))
+
+	// A media directory overrides where either backend keeps files
+	if mediaDir := os.Getenv("MEDIA_DIR"); mediaDir != "" {
+		blobs = blob.NewFilesystem(mediaDir)
+	}
+	mediaService := media.NewMediaService(repo, blobs)
+
+	router := gin.Default()
+	router.SetTrustedProxies(nil)
+	s := server.New(router, auditService, classService, documentService, formService, lock.NewLockService(repo), mediaService, userService, webhookService)
+
+	if retentionEnv := os.Getenv("TRASH_RETENTION"); retentionEnv != "" {
+		retention, err := time.ParseDuration(retentionEnv)
+		if err != nil {
+			log.Fatalf("Invalid TRASH_RETENTION %q: %v", retentionEnv, err)
+		}
+		s.SetTrashRetention(retention)
+	}
+	go s.PurgeTrash(shutdown, time.Hour)
+
+	if secret := os.Getenv("IMAGE_SECRET"); secret != "" {
+		s.SetImageSecret([]byte(secret))
+	} else {
+		log.Print("IMAGE_SECRET is not set, image URLs will change on restart")
+	}
+
+	// Templates are read again on every request while developing
+	if themeDir := os.Getenv("THEME_DIR"); themeDir != "" {
+		t, err := theme.New(themeDir, gin.Mode() == gin.DebugMode)
+		if err != nil {
+			log.Fatalf("Unable to load theme %s: %v", themeDir, err)
+		}
+		s.SetTheme(t)
+	}
+
+	if err := s.Run(shutdown, ":8080"); err != nil {
+		log.Fatal(err)
+	}
+	// Cancelled deliveries still log how their last attempt ended
+	webhookService.Wait()
+}
+
+func connectMongo(ctx context.Context) *mongo.Database {
 	dbHost := "localhost:27017"
 	if dbHostEnv := os.Getenv("DB_HOST"); dbHostEnv != "" {
 		dbHost = dbHostEnv
 	}
 
-	ctx := context.Background()
-
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://"+dbHost))
 	if err != nil {
 		log.Fatalf("Unable to create client %v", err)
 	}
+	return client.Database("gocms-web")
+}
 
-	db := client.Database("gocms-web")
-
-	repo := repository.NewMongo(ctx, db)
-	classService := class.NewClassService(repo)
-	documentService := document.NewDocumentService(repo)
-	userService := user.NewUserService(repo)
-
-	router := gin.Default()
-	router.SetTrustedProxies(nil)
-	s := server.New(router, classService, documentService, userService)
-	panic(s.Run(":8080"))
+// Each query gets its own deadline on top of the request it serves
+func mongoTimeouts() (timeouts repository.Timeouts) {
+	var err error
+	timeouts = repository.DefaultTimeouts
+	if readEnv := os.Getenv("DB_READ_TIMEOUT"); readEnv != "" {
+		if timeouts.Read, err = time.ParseDuration(readEnv); err != nil {
+			log.Fatalf("Invalid DB_READ_TIMEOUT %q: %v", readEnv, err)
+		}
+	}
+	if writeEnv := os.Getenv("DB_WRITE_TIMEOUT"); writeEnv != "" {
+		if timeouts.Write, err = time.ParseDuration(writeEnv); err != nil {
+			log.Fatalf("Invalid DB_WRITE_TIMEOUT %q: %v", writeEnv, err)
+		}
+	}
+	return
 }
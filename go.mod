@@ -6,9 +6,13 @@ require (
 	github.com/gin-contrib/multitemplate v0.0.0-20220427085757-0520a26e234e
 	github.com/gin-contrib/sessions v0.0.5
 	github.com/gin-gonic/gin v1.7.7
+	github.com/yuin/goldmark v1.4.12
 	github.com/zeebo/assert v1.3.0
+	go.etcd.io/bbolt v1.3.6
 	go.mongodb.org/mongo-driver v1.9.0
 	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f
+	golang.org/x/image v0.0.0-20220413100746-70e8d0d3baa9
+	golang.org/x/net v0.0.0-20220425223048-2871e0cb64e4
 )
 
 require (
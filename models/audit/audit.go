@@ -0,0 +1,157 @@
+// The audit log records who changed what. Entries are only ever appended;
+// nothing updates or removes them.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionTrash   = "trash"
+	ActionRestore = "restore"
+)
+
+// Kinds of things entries are about
+const (
+	TargetClass    = "class"
+	TargetDocument = "document"
+	TargetUser     = "user"
+)
+
+// Default number of entries listed when a filter has no limit
+const DefaultLimit = 100
+
+type Entry struct {
+	Id   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Time time.Time          `json:"time" bson:"time"`
+	// User who made the change, zero when made by the system such as when
+	// purging the trash
+	ActorId    primitive.ObjectID `json:"actor_id" bson:"actor_id,omitempty"`
+	Action     string             `json:"action" bson:"action"`
+	TargetType string             `json:"target_type" bson:"target_type"`
+	TargetId   primitive.ObjectID `json:"target_id" bson:"target_id"`
+	// Class of a document, or the class itself, so entries can be filtered by
+	// class
+	ClassId primitive.ObjectID `json:"class_id" bson:"class_id,omitempty"`
+	// Description of the change, such as: updated document "About Us"
+	Summary string `json:"summary" bson:"summary"`
+	// Names of the properties changed by an update
+	Changes []string `json:"changes,omitempty" bson:"changes,omitempty"`
+}
+
+// Narrows a listing of entries. Zero values match everything.
+type Filter struct {
+	ActorId primitive.ObjectID
+	ClassId primitive.ObjectID
+	// Entries at or after From and before To
+	From  time.Time
+	To    time.Time
+	Limit int64
+}
+
+var pastTense = map[string]string{
+	ActionCreate:  "created",
+	ActionUpdate:  "updated",
+	ActionDelete:  "deleted",
+	ActionTrash:   "trashed",
+	ActionRestore: "restored",
+}
+
+// Describes an action on a target, such as: updated document "About Us"
+func Summary(action, targetType, name string) string {
+	return fmt.Sprintf("%s %s %q", pastTense[action], targetType, name)
+}
+
+// Reports whether the entry passes the filter, ignoring the limit
+func (f Filter) Matches(e Entry) bool {
+	switch {
+	case !f.ActorId.IsZero() && e.ActorId != f.ActorId:
+		return false
+	case !f.ClassId.IsZero() && e.ClassId != f.ClassId:
+		return false
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !e.Time.Before(f.To):
+		return false
+	}
+	return true
+}
+
+// Appends entries to the audit log. Services changing content take one.
+type Recorder interface {
+	Record(Entry) error
+}
+
+type discard struct{}
+
+func (discard) Record(Entry) error { return nil }
+
+// Recorder which drops every entry
+var Discard Recorder = discard{}
+
+type AuditRepository interface {
+	// Lists entries newest first
+	GetAuditEntries(Filter) ([]Entry, error)
+	InsertAuditEntry(*Entry) error
+}
+
+type AuditService interface {
+	Recorder
+	Export(Filter, io.Writer) error
+	List(Filter) ([]Entry, error)
+}
+
+type auditService struct {
+	repo AuditRepository
+}
+
+func NewAuditService(repo AuditRepository) AuditService {
+	return auditService{
+		repo: repo,
+	}
+}
+
+// Writes the matching entries as JSON lines, newest first. Exports are not
+// limited unless the filter says so.
+func (s auditService) Export(filter Filter, w io.Writer) (err error) {
+	entries, err := s.repo.GetAuditEntries(filter)
+	if err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err = enc.Encode(e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s auditService) List(filter Filter) ([]Entry, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultLimit
+	}
+	return s.repo.GetAuditEntries(filter)
+}
+
+func (s auditService) Record(e Entry) error {
+	if e.Action == "" || e.TargetType == "" || e.TargetId.IsZero() {
+		return fmt.Errorf("audit entry needs an action and target")
+	}
+	if !e.Id.IsZero() {
+		return fmt.Errorf("audit entry already has an ID")
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	return s.repo.InsertAuditEntry(&e)
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ AuditRepository = &mockAuditRepository{}
+
+type mockAuditRepository struct {
+	entries []Entry
+}
+
+func (r *mockAuditRepository) GetAuditEntries(filter Filter) (entries []Entry, err error) {
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && int64(len(entries)) == filter.Limit {
+			break
+		}
+		if filter.Matches(r.entries[i]) {
+			entries = append(entries, r.entries[i])
+		}
+	}
+	return
+}
+
+func (r *mockAuditRepository) InsertAuditEntry(e *Entry) (err error) {
+	e.Id = primitive.NewObjectID()
+	r.entries = append(r.entries, *e)
+	return
+}
+
+func TestSummary(t *testing.T) {
+	assert.Equal(t, `updated document "About Us"`, Summary(ActionUpdate, TargetDocument, "About Us"))
+	assert.Equal(t, `trashed class "Pages"`, Summary(ActionTrash, TargetClass, "Pages"))
+}
+
+func TestFilterMatches(t *testing.T) {
+	actor, classId := primitive.NewObjectID(), primitive.NewObjectID()
+	now := time.Now()
+	e := Entry{ActorId: actor, ClassId: classId, Time: now}
+
+	assert.True(t, Filter{}.Matches(e))
+	assert.True(t, Filter{ActorId: actor, ClassId: classId}.Matches(e))
+	assert.False(t, Filter{ActorId: primitive.NewObjectID()}.Matches(e))
+	assert.False(t, Filter{ClassId: primitive.NewObjectID()}.Matches(e))
+	assert.True(t, Filter{From: now, To: now.Add(time.Second)}.Matches(e))
+	assert.False(t, Filter{From: now.Add(time.Second)}.Matches(e))
+	assert.False(t, Filter{To: now}.Matches(e))
+}
+
+func TestDiff(t *testing.T) {
+	type thing struct {
+		Name    string
+		Slug    string `bson:"slug_name"`
+		Hidden  string `bson:"-"`
+		Updated time.Time
+		Tags    []string
+		Values  map[string]interface{}
+	}
+
+	before := thing{
+		Name:    "Before",
+		Slug:    "same",
+		Hidden:  "a",
+		Updated: time.Now(),
+		Values:  map[string]interface{}{"kept": 1, "changed": "a", "removed": true},
+	}
+	after := thing{
+		Name:    "After",
+		Slug:    "same",
+		Hidden:  "b",
+		Updated: time.Now().Add(time.Minute),
+		Tags:    []string{},
+		Values:  map[string]interface{}{"kept": 1, "changed": "b", "added": false},
+	}
+	assert.DeepEqual(t, []string{"name", "values.added", "values.changed", "values.removed"}, Diff(before, &after))
+	assert.Equal(t, 0, len(Diff(before, before)))
+
+	t.Run("Stored Values", func(t *testing.T) {
+		// Lists come back from the database with a different type
+		stored := thing{Values: map[string]interface{}{"list": primitive.A{"x", "y"}}}
+		saved := thing{Values: map[string]interface{}{"list": []interface{}{"x", "y"}}}
+		assert.Equal(t, 0, len(Diff(stored, saved)))
+	})
+
+	t.Run("Mismatched Types", func(t *testing.T) {
+		assert.Equal(t, 0, len(Diff(before, Entry{})))
+		assert.Equal(t, 0, len(Diff("a", "b")))
+	})
+}
+
+func TestRecord(t *testing.T) {
+	repo := &mockAuditRepository{}
+	service := NewAuditService(repo)
+
+	t.Run("Missing Target", func(t *testing.T) {
+		assert.Error(t, service.Record(Entry{Action: ActionCreate, TargetType: TargetClass}))
+		assert.Error(t, service.Record(Entry{TargetType: TargetClass, TargetId: primitive.NewObjectID()}))
+	})
+
+	t.Run("Existing ID", func(t *testing.T) {
+		e := Entry{Id: primitive.NewObjectID(), Action: ActionCreate, TargetType: TargetClass, TargetId: primitive.NewObjectID()}
+		assert.Error(t, service.Record(e))
+	})
+
+	t.Run("Sets Time", func(t *testing.T) {
+		e := Entry{Action: ActionCreate, TargetType: TargetClass, TargetId: primitive.NewObjectID()}
+		assert.NoError(t, service.Record(e))
+		assert.Equal(t, 1, len(repo.entries))
+		assert.False(t, repo.entries[0].Time.IsZero())
+	})
+
+	assert.NoError(t, Discard.Record(Entry{}))
+}
+
+func TestListExport(t *testing.T) {
+	repo := &mockAuditRepository{}
+	service := NewAuditService(repo)
+	classId := primitive.NewObjectID()
+	for i := 0; i < DefaultLimit+5; i++ {
+		e := Entry{
+			Action:     ActionUpdate,
+			TargetType: TargetDocument,
+			TargetId:   primitive.NewObjectID(),
+			ClassId:    classId,
+		}
+		assert.NoError(t, service.Record(e))
+	}
+
+	t.Run("List", func(t *testing.T) {
+		entries, err := service.List(Filter{})
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultLimit, len(entries))
+		assert.Equal(t, repo.entries[len(repo.entries)-1].Id, entries[0].Id)
+
+		entries, err = service.List(Filter{Limit: 3})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(entries))
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, service.Export(Filter{ClassId: classId}, &buf))
+
+		lines := 0
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			var e Entry
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+			assert.Equal(t, classId, e.ClassId)
+			lines++
+		}
+		// Exports are not cut off at the default limit
+		assert.Equal(t, DefaultLimit+5, lines)
+	})
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Fields never worth reporting, since every save changes them
+var ignoredFields = map[string]bool{
+	"created": true,
+	"updated": true,
+	"version": true,
+}
+
+// Names of the properties which differ between two values of the same struct
+// type, using their bson names. Maps are compared key by key and reported as
+// name.key. Fields which are not stored are skipped.
+func Diff(before, after interface{}) (changes []string) {
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	if b.Kind() == reflect.Ptr {
+		b = b.Elem()
+	}
+	if a.Kind() == reflect.Ptr {
+		a = a.Elem()
+	}
+	if b.Kind() != reflect.Struct || b.Type() != a.Type() {
+		return nil
+	}
+
+	t := b.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := fieldName(f)
+		if !f.IsExported() || name == "-" || ignoredFields[name] {
+			continue
+		}
+
+		bv, av := b.Field(i), a.Field(i)
+		if f.Type.Kind() == reflect.Map {
+			for _, key := range changedKeys(bv, av) {
+				changes = append(changes, name+"."+key)
+			}
+			continue
+		}
+		if !same(bv, av) {
+			changes = append(changes, name)
+		}
+	}
+	return
+}
+
+func fieldName(f reflect.StructField) string {
+	tag := strings.Split(f.Tag.Get("bson"), ",")[0]
+	if tag != "" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
+
+func changedKeys(before, after reflect.Value) (keys []string) {
+	seen := make(map[string]bool)
+	for _, m := range []reflect.Value{before, after} {
+		iter := m.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			bv := before.MapIndex(iter.Key())
+			av := after.MapIndex(iter.Key())
+			if !bv.IsValid() || !av.IsValid() || !same(bv, av) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// Values loaded from a repository may not have the same types as those about
+// to be saved, such as a list decoded as primitive.A, so values printing the
+// same are treated as equal. Empty lists equal nil.
+func same(before, after reflect.Value) bool {
+	b, a := before.Interface(), after.Interface()
+	if reflect.DeepEqual(b, a) {
+		return true
+	}
+	if isEmpty(before) && isEmpty(after) {
+		return true
+	}
+	return fmt.Sprint(b) == fmt.Sprint(a)
+}
+
+func isEmpty(v reflect.Value) bool {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return false
+}
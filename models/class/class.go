@@ -1,13 +1,26 @@
 package class
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html/template"
+	"log"
+	"strings"
 	"time"
 
+	"github.com/jbaikge/gocms/models/event"
 	"github.com/jbaikge/gocms/models/field"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Event passed to notifiers whenever a class is created, updated, deleted,
+// trashed or restored
+const EventChanged = "class.changed"
+
+// Returned when a class is saved over a version other than the stored one
+var ErrConflict = errors.New("class was changed by someone else")
+
 // Classes define a type of Document
 type Class struct {
 	Id            primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
@@ -24,12 +37,36 @@ type Class struct {
 	Created       time.Time            `json:"created"`
 	Updated       time.Time            `json:"updated"`
 	Fields        []field.Field        `json:"fields"`
+
+	// Bumped by the repository on every save. An update carrying an older
+	// version than the stored one is refused with ErrConflict.
+	Version int64 `json:"version" bson:"version" form:"version"`
+
+	// Go html/template source the public site renders documents of the class
+	// with. Blank templates use the default document template.
+	Template string `json:"template" bson:"template,omitempty" form:"template"`
+
+	// Fieldsets are classes without documents whose fields are included in
+	// other classes. A class takes the fields of its base class first, then
+	// those of each included fieldset, then its own.
+	Fieldset    bool                 `json:"fieldset" bson:"fieldset,omitempty" form:"-"`
+	BaseId      primitive.ObjectID   `json:"base_id" bson:"base_id,omitempty" form:"-"`
+	FieldsetIds []primitive.ObjectID `json:"fieldset_ids" bson:"fieldset_ids,omitempty" form:"-"`
+	// Fields merged in from the base class and fieldsets, filled in by the
+	// class service when the class is loaded
+	Inherited []field.Field `json:"inherited" bson:"-"`
+
+	// Set when the class is moved to the trash. DeleteMode is applied to the
+	// class documents when it is purged.
+	Deleted    time.Time          `json:"deleted" bson:"deleted,omitempty"`
+	DeletedBy  primitive.ObjectID `json:"deleted_by" bson:"deleted_by,omitempty"`
+	DeleteMode string             `json:"delete_mode" bson:"delete_mode,omitempty"`
 }
 
-// Fetches the field represented by name. If the field does not exist, returns
-// an empty field.
+// Fetches the field represented by name, including inherited fields. If the
+// field does not exist, returns an empty field.
 func (c Class) Field(name string) (field field.Field) {
-	for _, f := range c.Fields {
+	for _, f := range c.AllFields() {
 		if f.Name == name {
 			return f
 		}
@@ -37,53 +74,317 @@ func (c Class) Field(name string) (field field.Field) {
 	return
 }
 
+// Returns the inherited fields followed by the fields of the class itself
+func (c Class) AllFields() (fields []field.Field) {
+	fields = make([]field.Field, 0, len(c.Inherited)+len(c.Fields))
+	fields = append(fields, c.Inherited...)
+	return append(fields, c.Fields...)
+}
+
+// Reports whether the class refers to id through its parents, base class,
+// fieldsets or any field data source or relation
+func (c Class) dependsOn(id primitive.ObjectID) bool {
+	if c.inherits(id) {
+		return true
+	}
+	for _, parent := range c.Parents {
+		if parent == id {
+			return true
+		}
+	}
+	for _, f := range c.Fields {
+		if f.DataSourceId == id {
+			return true
+		}
+		for _, classId := range f.RelationClassIds {
+			if classId == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether the class takes fields directly from id
+func (c Class) inherits(id primitive.ObjectID) bool {
+	if c.BaseId == id {
+		return true
+	}
+	for _, fieldsetId := range c.FieldsetIds {
+		if fieldsetId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Ways to handle the documents of a class when the class is deleted
+const (
+	DeleteRestrict = "restrict"
+	DeleteCascade  = "cascade"
+	DeleteArchive  = "archive"
+)
+
+// Describes everything depending on a class which would be affected by its
+// deletion
+type Dependents struct {
+	// Documents belonging to the class
+	Documents int64
+	// Documents in other classes relating to documents of this class
+	References int64
+	// Classes pointing at this class as a parent, base, fieldset, data source
+	// or relation
+	Classes []Class
+}
+
+func (d Dependents) Empty() bool {
+	return d.Documents == 0 && d.References == 0 && len(d.Classes) == 0
+}
+
+// Told about every change to a class, such as to call webhooks
+type Notifier interface {
+	Notify(string, Class) error
+}
+
+// Adapts a function to a Notifier
+type NotifierFunc func(string, Class) error
+
+func (fn NotifierFunc) Notify(event string, c Class) error {
+	return fn(event, c)
+}
+
 // Repositories manage data storage and retrieval
 type ClassRepository interface {
-	DeleteClass(primitive.ObjectID) error
-	GetAllClasses() ([]Class, error)
-	GetClassById(primitive.ObjectID) (Class, error)
-	GetClassBySlug(string) (Class, error)
-	InsertClass(*Class) error
-	UpdateClass(*Class) error
+	DeleteClass(context.Context, primitive.ObjectID) error
+	GetAllClasses(context.Context) ([]Class, error)
+	GetClassById(context.Context, primitive.ObjectID) (Class, error)
+	GetClassBySlug(context.Context, string) (Class, error)
+	GetTrashedClasses(context.Context, time.Time) ([]Class, error)
+	InsertClass(context.Context, *Class) error
+	UpdateClass(context.Context, *Class) error
+}
+
+// Document operations needed by the class service to keep references intact
+// when a class is deleted and document values in line with its fields
+type ClassDocumentRepository interface {
+	ArchiveClassDocuments(context.Context, primitive.ObjectID) (int64, error)
+	CountClassDocuments(context.Context, primitive.ObjectID) (int64, error)
+	CountClassReferences(context.Context, primitive.ObjectID) (int64, error)
+	CountFieldValues(context.Context, primitive.ObjectID, string) (int64, error)
+	// Counts the values under the key which convert refuses
+	CountUnconvertibleValues(context.Context, primitive.ObjectID, string, func(interface{}) (interface{}, error)) (int64, error)
+	// Replaces the values under the key with what convert returns. Values it
+	// refuses are left as they are, or removed when drop is set.
+	ConvertFieldValues(context.Context, primitive.ObjectID, string, func(interface{}) (interface{}, error), bool) (int64, error)
+	DeleteClassDocuments(context.Context, primitive.ObjectID) (int64, error)
+	DropFieldValues(context.Context, primitive.ObjectID, string) (int64, error)
+	EnsureGeoIndex(context.Context, string) error
+	RenameFieldValues(context.Context, primitive.ObjectID, string, string) (int64, error)
+	// Saves the class and applies the migrations to the documents of the
+	// classes with the given IDs as one change. Storage unable to do so
+	// returns a MigrationError when it fails part way through.
+	MigrateClass(context.Context, *Class, []primitive.ObjectID, []Migration) error
 }
 
 // Services manage business rules while interacting with repositories
 type ClassService interface {
-	All() ([]Class, error)
-	Delete(Class) error
-	GetById(primitive.ObjectID) (Class, error)
-	GetBySlug(string) (Class, error)
-	Insert(*Class) error
-	Update(*Class) error
+	All(context.Context) ([]Class, error)
+	As(primitive.ObjectID) ClassService
+	Delete(context.Context, Class, string) error
+	Dependents(context.Context, Class) (Dependents, error)
+	Events() *event.Bus[Class]
+	GetById(context.Context, primitive.ObjectID) (Class, error)
+	GetBySlug(context.Context, string) (Class, error)
+	Insert(context.Context, *Class) error
+	Migrations(context.Context, Class) ([]Migration, error)
+	Purge(context.Context, time.Time) (int, error)
+	Restore(context.Context, Class) error
+	Trash(context.Context, Class, string, primitive.ObjectID) error
+	Trashed(context.Context) ([]Class, error)
+	Update(context.Context, *Class, ...Migration) error
 }
 
 type classService struct {
-	repo ClassRepository
+	repo   ClassRepository
+	docs   ClassDocumentRepository
+	events *event.Bus[Class]
+	// User credited with changes in the audit log
+	actor primitive.ObjectID
 }
 
-func NewClassService(repo ClassRepository) ClassService {
+// Changes to classes are published through Events. Audit and Notify subscribe
+// the audit log and notifiers to them.
+func NewClassService(repo ClassRepository, docs ClassDocumentRepository) ClassService {
 	return classService{
-		repo: repo,
+		repo:   repo,
+		docs:   docs,
+		events: event.NewBus[Class](),
+	}
+}
+
+// Hooks run before and after classes are inserted, updated or deleted. Trashing
+// and restoring are published as updates.
+func (s classService) Events() *event.Bus[Class] {
+	return s.events
+}
+
+// Copy of the service attributing changes to the user
+func (s classService) As(actor primitive.ObjectID) ClassService {
+	s.actor = actor
+	return s
+}
+
+// Gives before handlers the chance to change or refuse the class
+func (s classService) before(op event.Op, class *Class, previous Class) error {
+	return s.events.PublishBefore(&event.Event[Class]{Op: op, Model: class, Previous: previous, Actor: s.actor})
+}
+
+// Tells after handlers the change was stored. It is too late to back out, so
+// handler errors are logged rather than returned.
+func (s classService) after(op event.Op, class Class, previous Class, details ...string) {
+	e := &event.Event[Class]{Op: op, Model: &class, Previous: previous, Actor: s.actor, Details: details}
+	if err := s.events.PublishAfter(e); err != nil {
+		log.Printf("Class %s: %v", class.Id.Hex(), err)
+	}
+}
+
+// Looks up base classes and fieldsets in the repository
+func (s classService) lookup(ctx context.Context) classLookup {
+	return func(id primitive.ObjectID) (Class, error) {
+		return s.repo.GetClassById(ctx, id)
+	}
+}
+
+func (s classService) All(ctx context.Context) (all []Class, err error) {
+	if all, err = s.repo.GetAllClasses(ctx); err != nil {
+		return
+	}
+
+	// Resolve from the list already loaded rather than going back to the
+	// repository for every base class and fieldset
+	byId := make(map[primitive.ObjectID]Class, len(all))
+	for _, c := range all {
+		byId[c.Id] = c
+	}
+	lookup := func(id primitive.ObjectID) (Class, error) {
+		if c, ok := byId[id]; ok {
+			return c, nil
+		}
+		return s.repo.GetClassById(ctx, id)
+	}
+
+	for i := range all {
+		if err = resolve(&all[i], lookup); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Deletes the class, handling its documents according to mode. Classes
+// pointing at this one always prevent deletion. Restrict refuses when any
+// documents exist, cascade removes them and archive keeps them out of sight.
+func (s classService) Delete(ctx context.Context, class Class, mode string) (err error) {
+	if err = s.checkDelete(ctx, class, mode); err != nil {
+		return
 	}
+
+	// Kept with the class so after handlers know what became of its documents
+	class.DeleteMode = mode
+	if err = s.before(event.Delete, &class, Class{}); err != nil {
+		return
+	}
+
+	switch mode {
+	case DeleteCascade:
+		if _, err = s.docs.DeleteClassDocuments(ctx, class.Id); err != nil {
+			return
+		}
+	case DeleteArchive:
+		if _, err = s.docs.ArchiveClassDocuments(ctx, class.Id); err != nil {
+			return
+		}
+	}
+
+	if err = s.repo.DeleteClass(ctx, class.Id); err != nil {
+		return
+	}
+	s.after(event.Delete, class, Class{})
+	return nil
 }
 
-func (s classService) All() ([]Class, error) {
-	return s.repo.GetAllClasses()
+// Verifies the class may be deleted with the given mode without making any
+// changes
+func (s classService) checkDelete(ctx context.Context, class Class, mode string) (err error) {
+	dependents, err := s.Dependents(ctx, class)
+	if err != nil {
+		return
+	}
+
+	if len(dependents.Classes) > 0 {
+		return fmt.Errorf("class %s is used by %d other classes", class.Slug, len(dependents.Classes))
+	}
+
+	switch mode {
+	case DeleteRestrict:
+		if dependents.Documents > 0 {
+			return fmt.Errorf("class %s still has %d documents", class.Slug, dependents.Documents)
+		}
+	case DeleteCascade:
+		if dependents.References > 0 {
+			return fmt.Errorf("class %s documents are referenced by %d documents in other classes", class.Slug, dependents.References)
+		}
+	case DeleteArchive:
+	default:
+		return fmt.Errorf("unknown delete mode: %s", mode)
+	}
+
+	return
 }
 
-func (s classService) Delete(class Class) error {
-	return s.repo.DeleteClass(class.Id)
+func (s classService) Dependents(ctx context.Context, class Class) (dependents Dependents, err error) {
+	if dependents.Documents, err = s.docs.CountClassDocuments(ctx, class.Id); err != nil {
+		return
+	}
+
+	if dependents.References, err = s.docs.CountClassReferences(ctx, class.Id); err != nil {
+		return
+	}
+
+	all, err := s.repo.GetAllClasses(ctx)
+	if err != nil {
+		return
+	}
+	for _, c := range all {
+		if c.Id != class.Id && c.dependsOn(class.Id) {
+			dependents.Classes = append(dependents.Classes, c)
+		}
+	}
+
+	return
 }
 
-func (s classService) GetById(id primitive.ObjectID) (Class, error) {
-	return s.repo.GetClassById(id)
+func (s classService) GetById(ctx context.Context, id primitive.ObjectID) (class Class, err error) {
+	if class, err = s.repo.GetClassById(ctx, id); err != nil {
+		return
+	}
+	err = resolve(&class, s.lookup(ctx))
+	return
 }
 
-func (s classService) GetBySlug(slug string) (class Class, err error) {
-	return s.repo.GetClassBySlug(slug)
+func (s classService) GetBySlug(ctx context.Context, slug string) (class Class, err error) {
+	if class, err = s.repo.GetClassBySlug(ctx, slug); err != nil {
+		return
+	}
+	err = resolve(&class, s.lookup(ctx))
+	return
 }
 
-func (s classService) Insert(class *Class) (err error) {
+func (s classService) Insert(ctx context.Context, class *Class) (err error) {
+	if err = s.before(event.Insert, class, Class{}); err != nil {
+		return
+	}
+
 	if err = s.Validate(class); err != nil {
 		return
 	}
@@ -92,27 +393,338 @@ func (s classService) Insert(class *Class) (err error) {
 		return fmt.Errorf("class already has an ID")
 	}
 
-	if check, err := s.GetBySlug(class.Slug); err == nil {
+	if check, err := s.GetBySlug(ctx, class.Slug); err == nil {
 		return fmt.Errorf("slug %s already exists in %s", class.Slug, check.Id.Hex())
 	}
 
-	return s.repo.InsertClass(class)
+	if err = resolve(class, s.lookup(ctx)); err != nil {
+		return
+	}
+
+	if err = s.ensureIndexes(ctx, *class); err != nil {
+		return
+	}
+
+	if err = s.repo.InsertClass(ctx, class); err != nil {
+		return
+	}
+	s.after(event.Insert, *class, Class{})
+	return nil
 }
 
-func (s classService) Update(class *Class) (err error) {
-	if err = s.Validate(class); err != nil {
+// Location fields need a geo index before documents can be filtered by
+// distance. Documents of every class share the index on each field name.
+func (s classService) ensureIndexes(ctx context.Context, class Class) (err error) {
+	for _, f := range class.AllFields() {
+		if f.Type != field.TypeGeoPoint {
+			continue
+		}
+		if err = s.docs.EnsureGeoIndex(ctx, f.Name); err != nil {
+			return fmt.Errorf("indexing %s: %w", f.Name, err)
+		}
+	}
+	return
+}
+
+// Makes sure the updated class and every class inheriting from it still
+// resolve to a valid set of fields
+func (s classService) checkInheritance(ctx context.Context, class *Class) (err error) {
+	if class.Fieldset {
+		count, err := s.docs.CountClassDocuments(ctx, class.Id)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("class %s has %d documents and cannot become a fieldset", class.Slug, count)
+		}
+	}
+
+	lookup := func(id primitive.ObjectID) (Class, error) {
+		if id == class.Id {
+			return *class, nil
+		}
+		return s.repo.GetClassById(ctx, id)
+	}
+
+	if err = resolve(class, lookup); err != nil {
+		return
+	}
+
+	all, err := s.repo.GetAllClasses(ctx)
+	if err != nil {
+		return
+	}
+	for _, c := range all {
+		if c.Id == class.Id {
+			continue
+		}
+		if err = resolve(&c, lookup); err != nil {
+			return fmt.Errorf("class %s: %w", c.Slug, err)
+		}
+	}
+	return
+}
+
+// Compares the class with the stored version and suggests migrations for
+// existing document values. Each migration reports how many documents it
+// would touch, counting those of every class inheriting the fields.
+func (s classService) Migrations(ctx context.Context, class Class) (migrations []Migration, err error) {
+	if err = s.Validate(&class); err != nil {
+		return
+	}
+
+	stored, err := s.GetById(ctx, class.Id)
+	if err != nil {
+		return
+	}
+
+	// Compare the merged fields so moving a field into a fieldset or base
+	// class under the same name leaves its values alone
+	migrations = diffFields(stored.AllFields(), class.AllFields())
+	if len(migrations) == 0 {
+		return
+	}
+
+	classes, err := s.inheritors(ctx, class)
+	if err != nil {
+		return
+	}
+	for i, m := range migrations {
+		for _, c := range classes {
+			count, err := s.docs.CountFieldValues(ctx, c.Id, m.Field)
+			if err != nil {
+				return nil, err
+			}
+			migrations[i].Documents += count
+
+			if m.Action != MigrateConvert {
+				continue
+			}
+			count, err = s.docs.CountUnconvertibleValues(ctx, c.Id, m.Field, m.Convert)
+			if err != nil {
+				return nil, err
+			}
+			migrations[i].Unconvertible += count
+		}
+	}
+	return
+}
+
+// Collects the class and every class taking fields from it, directly or
+// through other base classes and fieldsets. These are the classes whose
+// documents hold values for the fields of the class.
+func (s classService) inheritors(ctx context.Context, class Class) (classes []Class, err error) {
+	all, err := s.repo.GetAllClasses(ctx)
+	if err != nil {
+		return
+	}
+
+	classes = []Class{class}
+	seen := map[primitive.ObjectID]bool{class.Id: true}
+	for i := 0; i < len(classes); i++ {
+		for _, c := range all {
+			if !seen[c.Id] && c.inherits(classes[i].Id) {
+				seen[c.Id] = true
+				classes = append(classes, c)
+			}
+		}
+	}
+	return
+}
+
+// Names the classes whose documents were migrated when a class update failed
+// part way through
+func migrationError(classes []Class, err error) error {
+	var partial *MigrationError
+	if !errors.As(err, &partial) {
+		return err
+	}
+
+	slugs := make([]string, 0, len(partial.Migrated))
+	for _, id := range partial.Migrated {
+		for _, c := range classes {
+			if c.Id == id {
+				slugs = append(slugs, c.Slug)
+			}
+		}
+	}
+	if len(slugs) == 0 {
+		return fmt.Errorf("class not saved: %w", partial.Err)
+	}
+	return fmt.Errorf("class not saved, documents of %s were already migrated: %w", strings.Join(slugs, ", "), partial.Err)
+}
+
+// Permanently deletes every class trashed before the cutoff using the delete
+// mode chosen when it was trashed. Classes which can no longer be deleted
+// stay in the trash.
+func (s classService) Purge(ctx context.Context, before time.Time) (purged int, err error) {
+	classes, err := s.repo.GetTrashedClasses(ctx, before)
+	if err != nil {
 		return
 	}
 
+	for _, class := range classes {
+		if deleteErr := s.Delete(ctx, class, class.DeleteMode); deleteErr != nil {
+			err = deleteErr
+			continue
+		}
+		purged++
+	}
+	return
+}
+
+// Takes the class out of the trash as long as its slug has not been claimed in
+// the meantime
+func (s classService) Restore(ctx context.Context, class Class) error {
+	if class.Deleted.IsZero() {
+		return fmt.Errorf("class %s is not in the trash", class.Slug)
+	}
+
+	if check, err := s.GetBySlug(ctx, class.Slug); err == nil && check.Id != class.Id {
+		return fmt.Errorf("slug %s already exists in %s", class.Slug, check.Id.Hex())
+	}
+
+	previous := class
+	class.Deleted = time.Time{}
+	class.DeletedBy = primitive.NilObjectID
+	class.DeleteMode = ""
+	if err := s.before(event.Update, &class, previous); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateClass(ctx, &class); err != nil {
+		return err
+	}
+	s.after(event.Update, class, previous)
+	return nil
+}
+
+// Moves the class to the trash, remembering how its documents should be
+// handled once it is purged. The mode is checked now so the purge does not
+// fail later.
+func (s classService) Trash(ctx context.Context, class Class, mode string, userId primitive.ObjectID) error {
+	if !class.Deleted.IsZero() {
+		return fmt.Errorf("class %s is already in the trash", class.Slug)
+	}
+
+	if err := s.checkDelete(ctx, class, mode); err != nil {
+		return err
+	}
+
+	if !userId.IsZero() {
+		s.actor = userId
+	}
+
+	previous := class
+	class.Deleted = time.Now()
+	class.DeletedBy = userId
+	class.DeleteMode = mode
+	if err := s.before(event.Update, &class, previous); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateClass(ctx, &class); err != nil {
+		return err
+	}
+	s.after(event.Update, class, previous)
+	return nil
+}
+
+func (s classService) Trashed(ctx context.Context) ([]Class, error) {
+	return s.repo.GetTrashedClasses(ctx, time.Now())
+}
+
+// Updates the class and applies the migrations to the values of its documents
+// and those of every class inheriting its fields. Every suggested migration
+// touching existing values must be applied or skipped, and all of them are
+// checked against the stored class before anything is written. Values which
+// cannot be converted to a new type are kept unless the migration asks to
+// drop them, and renames must be confirmed.
+func (s classService) Update(ctx context.Context, class *Class, migrations ...Migration) (err error) {
 	if class.Id.IsZero() {
 		return fmt.Errorf("class has no ID")
 	}
 
-	if check, err := s.GetBySlug(class.Slug); err == nil && check.Id != class.Id {
+	before, err := s.repo.GetClassById(ctx, class.Id)
+	if err != nil {
+		return
+	}
+
+	if before.Version != class.Version {
+		return fmt.Errorf("%w: loaded at version %d, now at %d", ErrConflict, class.Version, before.Version)
+	}
+
+	if err = s.before(event.Update, class, before); err != nil {
+		return
+	}
+
+	if err = s.Validate(class); err != nil {
+		return
+	}
+
+	if check, err := s.GetBySlug(ctx, class.Slug); err == nil && check.Id != class.Id {
 		return fmt.Errorf("slug %s already exists in %s", class.Slug, check.Id.Hex())
 	}
 
-	return s.repo.UpdateClass(class)
+	if err = s.checkInheritance(ctx, class); err != nil {
+		return
+	}
+
+	if err = s.ensureIndexes(ctx, *class); err != nil {
+		return
+	}
+
+	suggested, err := s.Migrations(ctx, *class)
+	if err != nil {
+		return
+	}
+	if err = checkAcknowledged(suggested, migrations); err != nil {
+		return
+	}
+
+	stored, err := s.GetById(ctx, class.Id)
+	if err != nil {
+		return
+	}
+	apply := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if err = checkMigration(m, stored, *class); err != nil {
+			return
+		}
+		if m.Skip {
+			continue
+		}
+		if m.Action == MigrateRename {
+			class.TableFields = renameTableField(class.TableFields, m.Field, m.To)
+		}
+		apply = append(apply, m)
+	}
+
+	if len(apply) == 0 {
+		if err = s.repo.UpdateClass(ctx, class); err != nil {
+			return
+		}
+		s.after(event.Update, *class, before)
+		return nil
+	}
+
+	classes, err := s.inheritors(ctx, *class)
+	if err != nil {
+		return
+	}
+	ids := make([]primitive.ObjectID, len(classes))
+	for i, c := range classes {
+		ids[i] = c.Id
+	}
+
+	if err = s.docs.MigrateClass(ctx, class, ids, apply); err != nil {
+		return migrationError(classes, err)
+	}
+
+	details := make([]string, 0, len(apply))
+	for _, m := range apply {
+		details = append(details, fmt.Sprintf("%s %s", m.Action, m.Field))
+	}
+	s.after(event.Update, *class, before, details...)
+	return nil
 }
 
 func (s classService) Validate(class *Class) (err error) {
@@ -134,6 +746,16 @@ func (s classService) Validate(class *Class) (err error) {
 		if f.Type == "" {
 			return fmt.Errorf("field[%d] type is empty", i)
 		}
+		if err = f.ValidateFields(); err != nil {
+			return fmt.Errorf("field[%d] %v", i, err)
+		}
+		if _, err = field.ParsePolicy(f.AllowedHTML); err != nil {
+			return fmt.Errorf("field[%d] allowed HTML %v", i, err)
+		}
+	}
+
+	if _, err = template.New(class.Slug).Parse(class.Template); err != nil {
+		return fmt.Errorf("template: %w", err)
 	}
 
 	return
@@ -1,11 +1,14 @@
 package class
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/event"
 	"github.com/jbaikge/gocms/models/field"
 	"github.com/zeebo/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -92,6 +95,17 @@ func TestFieldValidate(t *testing.T) {
 			},
 			Error: errors.New("field[1] type is empty"),
 		},
+		{
+			Name: "Sub-Fields",
+			Fields: []field.Field{
+				{
+					Name:  "faq",
+					Label: "FAQ",
+					Type:  field.TypeRepeater,
+				},
+			},
+			Error: errors.New("field[0] faq has no sub-fields"),
+		},
 	}
 
 	for _, test := range tests {
@@ -121,7 +135,7 @@ func NewMockClassRepository() mockClassRepository {
 	}
 }
 
-func (r mockClassRepository) DeleteClass(id primitive.ObjectID) (err error) {
+func (r mockClassRepository) DeleteClass(ctx context.Context, id primitive.ObjectID) (err error) {
 	class, ok := r.byId[id]
 	if !ok {
 		// Silent failure
@@ -132,15 +146,17 @@ func (r mockClassRepository) DeleteClass(id primitive.ObjectID) (err error) {
 	return
 }
 
-func (r mockClassRepository) GetAllClasses() (all []Class, err error) {
+func (r mockClassRepository) GetAllClasses(ctx context.Context) (all []Class, err error) {
 	all = make([]Class, 0, len(r.byId))
 	for _, class := range r.byId {
-		all = append(all, class)
+		if class.Deleted.IsZero() {
+			all = append(all, class)
+		}
 	}
 	return
 }
 
-func (r mockClassRepository) GetClassById(id primitive.ObjectID) (class Class, err error) {
+func (r mockClassRepository) GetClassById(ctx context.Context, id primitive.ObjectID) (class Class, err error) {
 	class, ok := r.byId[id]
 	if !ok {
 		err = fmt.Errorf("class not found: %s", id)
@@ -148,72 +164,211 @@ func (r mockClassRepository) GetClassById(id primitive.ObjectID) (class Class, e
 	return
 }
 
-func (r mockClassRepository) GetClassBySlug(slug string) (class Class, err error) {
+func (r mockClassRepository) GetClassBySlug(ctx context.Context, slug string) (class Class, err error) {
 	class, ok := r.bySlug[slug]
-	if !ok {
+	if !ok || !class.Deleted.IsZero() {
 		err = fmt.Errorf("class not found: %s", slug)
 	}
 	return
 }
 
-func (r mockClassRepository) InsertClass(class *Class) (err error) {
+func (r mockClassRepository) GetTrashedClasses(ctx context.Context, before time.Time) (trashed []Class, err error) {
+	for _, class := range r.byId {
+		if !class.Deleted.IsZero() && class.Deleted.Before(before) {
+			trashed = append(trashed, class)
+		}
+	}
+	return
+}
+
+func (r mockClassRepository) InsertClass(ctx context.Context, class *Class) (err error) {
 	class.Id = primitive.NewObjectID()
 	r.byId[class.Id] = *class
 	r.bySlug[class.Slug] = *class
 	return
 }
 
-func (r mockClassRepository) UpdateClass(class *Class) (err error) {
+func (r mockClassRepository) UpdateClass(ctx context.Context, class *Class) (err error) {
 	class.Updated = time.Now()
-	if err = r.DeleteClass(class.Id); err != nil {
+	if err = r.DeleteClass(ctx, class.Id); err != nil {
 		return
 	}
+	class.Version++
 	r.byId[class.Id] = *class
 	r.bySlug[class.Slug] = *class
 	return
 }
 
+var _ ClassDocumentRepository = &mockClassDocumentRepository{}
+
+// Tracks document counts per class in place of real documents
+type mockClassDocumentRepository struct {
+	documents  map[primitive.ObjectID]int64
+	references map[primitive.ObjectID]int64
+	archived   map[primitive.ObjectID]int64
+	values     map[primitive.ObjectID][]map[string]interface{}
+	indexes    map[string]bool
+	// Where MigrateClass saves the class, set by tests applying migrations
+	classes ClassRepository
+	// Returned by MigrateClass in place of doing anything
+	migrateErr error
+}
+
+func NewMockClassDocumentRepository() *mockClassDocumentRepository {
+	return &mockClassDocumentRepository{
+		documents:  make(map[primitive.ObjectID]int64),
+		references: make(map[primitive.ObjectID]int64),
+		archived:   make(map[primitive.ObjectID]int64),
+		values:     make(map[primitive.ObjectID][]map[string]interface{}),
+		indexes:    make(map[string]bool),
+	}
+}
+
+func (r *mockClassDocumentRepository) ArchiveClassDocuments(ctx context.Context, id primitive.ObjectID) (count int64, err error) {
+	count = r.documents[id]
+	r.archived[id] += count
+	delete(r.documents, id)
+	return
+}
+
+func (r *mockClassDocumentRepository) CountClassDocuments(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return r.documents[id], nil
+}
+
+func (r *mockClassDocumentRepository) CountClassReferences(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return r.references[id], nil
+}
+
+func (r *mockClassDocumentRepository) CountFieldValues(ctx context.Context, id primitive.ObjectID, key string) (count int64, err error) {
+	for _, values := range r.values[id] {
+		if _, ok := values[key]; ok {
+			count++
+		}
+	}
+	return
+}
+
+func (r *mockClassDocumentRepository) CountUnconvertibleValues(ctx context.Context, id primitive.ObjectID, key string, convert func(interface{}) (interface{}, error)) (count int64, err error) {
+	for _, values := range r.values[id] {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if _, convertErr := convert(value); convertErr != nil {
+			count++
+		}
+	}
+	return
+}
+
+func (r *mockClassDocumentRepository) ConvertFieldValues(ctx context.Context, id primitive.ObjectID, key string, convert func(interface{}) (interface{}, error), drop bool) (count int64, err error) {
+	for _, values := range r.values[id] {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		converted, convertErr := convert(value)
+		switch {
+		case convertErr == nil:
+			values[key] = converted
+		case drop:
+			delete(values, key)
+		default:
+			continue
+		}
+		count++
+	}
+	return
+}
+
+func (r *mockClassDocumentRepository) DeleteClassDocuments(ctx context.Context, id primitive.ObjectID) (count int64, err error) {
+	count = r.documents[id]
+	delete(r.documents, id)
+	return
+}
+
+func (r *mockClassDocumentRepository) DropFieldValues(ctx context.Context, id primitive.ObjectID, key string) (count int64, err error) {
+	for _, values := range r.values[id] {
+		if _, ok := values[key]; ok {
+			delete(values, key)
+			count++
+		}
+	}
+	return
+}
+
+func (r *mockClassDocumentRepository) EnsureGeoIndex(ctx context.Context, key string) (err error) {
+	r.indexes[key] = true
+	return
+}
+
+func (r *mockClassDocumentRepository) MigrateClass(ctx context.Context, class *Class, ids []primitive.ObjectID, migrations []Migration) (err error) {
+	if r.migrateErr != nil {
+		return r.migrateErr
+	}
+	for _, id := range ids {
+		for _, values := range r.values[id] {
+			for _, m := range migrations {
+				m.Apply(values)
+			}
+		}
+	}
+	return r.classes.UpdateClass(ctx, class)
+}
+
+func (r *mockClassDocumentRepository) RenameFieldValues(ctx context.Context, id primitive.ObjectID, from string, to string) (count int64, err error) {
+	for _, values := range r.values[id] {
+		if value, ok := values[from]; ok {
+			values[to] = value
+			delete(values, from)
+			count++
+		}
+	}
+	return
+}
+
 func TestClassService(t *testing.T) {
+	ctx := context.Background()
 	t.Run("All", func(t *testing.T) {
-		service := NewClassService(NewMockClassRepository())
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
 
 		classes := []*Class{
 			{Name: "Test", Slug: "test1"},
 			{Name: "Test", Slug: "test2"},
 		}
 		for _, c := range classes {
-			assert.NoError(t, service.Insert(c))
+			assert.NoError(t, service.Insert(ctx, c))
 		}
 
-		all, err := service.All()
+		all, err := service.All(ctx)
 		assert.NoError(t, err)
 		assert.Equal(t, 2, len(all))
 	})
 
 	t.Run("GetById", func(t *testing.T) {
-		service := NewClassService(NewMockClassRepository())
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
 
 		class := Class{Name: "Test", Slug: "test"}
-		assert.NoError(t, service.Insert(&class))
+		assert.NoError(t, service.Insert(ctx, &class))
 
-		check, err := service.GetById(class.Id)
+		check, err := service.GetById(ctx, class.Id)
 		assert.NoError(t, err)
 		assert.Equal(t, class.Id, check.Id)
 	})
 
 	t.Run("GetBySlug", func(t *testing.T) {
-		service := NewClassService(NewMockClassRepository())
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
 
 		class := Class{Name: "Test", Slug: "test"}
-		assert.NoError(t, service.Insert(&class))
+		assert.NoError(t, service.Insert(ctx, &class))
 
-		check, err := service.GetBySlug(class.Slug)
+		check, err := service.GetBySlug(ctx, class.Slug)
 		assert.NoError(t, err)
 		assert.Equal(t, class.Id, check.Id)
 	})
 
 	t.Run("Insert", func(t *testing.T) {
-		service := NewClassService(NewMockClassRepository())
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
 
 		tests := []struct {
 			Name  string
@@ -245,11 +400,23 @@ func TestClassService(t *testing.T) {
 				true,
 				Class{Id: primitive.NewObjectID(), Name: "Test", Slug: "pre_id"},
 			},
+			{
+				"Bad Allowed HTML",
+				true,
+				Class{Name: "Test", Slug: "bad_html", Fields: []field.Field{
+					{Name: "body", Label: "Body", Type: field.TypeTinyMCE, AllowedHTML: "a onclick"},
+				}},
+			},
+			{
+				"Bad Template",
+				true,
+				Class{Name: "Test", Slug: "bad_template", Template: "{{ if }}"},
+			},
 		}
 
 		for _, test := range tests {
 			t.Run(test.Name, func(t *testing.T) {
-				err := service.Insert(&test.Class)
+				err := service.Insert(ctx, &test.Class)
 				if test.Error {
 					assert.Error(t, err)
 				} else {
@@ -260,49 +427,375 @@ func TestClassService(t *testing.T) {
 	})
 
 	t.Run("Update", func(t *testing.T) {
-		service := NewClassService(NewMockClassRepository())
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
 
 		t.Run("No ID", func(t *testing.T) {
 			class := Class{Name: "No ID", Slug: "no_id"}
-			assert.Error(t, service.Update(&class))
+			assert.Error(t, service.Update(ctx, &class))
 		})
 
 		banana := Class{Name: "Banana", Slug: "banana"}
-		assert.NoError(t, service.Insert(&banana))
+		assert.NoError(t, service.Insert(ctx, &banana))
 
 		orange := Class{Name: "Orange", Slug: "orange"}
-		assert.NoError(t, service.Insert(&orange))
+		assert.NoError(t, service.Insert(ctx, &orange))
 
 		t.Run("Name Update", func(t *testing.T) {
 			orange.Name = "Tangerine"
-			assert.NoError(t, service.Update(&orange))
+			assert.NoError(t, service.Update(ctx, &orange))
 
-			orangeTest, err := service.GetById(orange.Id)
+			orangeTest, err := service.GetById(ctx, orange.Id)
 			assert.NoError(t, err)
 			assert.Equal(t, orange.Name, orangeTest.Name)
 		})
 
 		t.Run("Blank Slug", func(t *testing.T) {
 			orange.Slug = ""
-			assert.Error(t, service.Update(&orange))
+			assert.Error(t, service.Update(ctx, &orange))
 		})
 
 		t.Run("Slug Takeover", func(t *testing.T) {
 			banana.Slug = "orange"
-			assert.Error(t, service.Update(&banana))
+			assert.Error(t, service.Update(ctx, &banana))
+		})
+
+		t.Run("Conflict", func(t *testing.T) {
+			lime := Class{Name: "Lime", Slug: "lime"}
+			assert.NoError(t, service.Insert(ctx, &lime))
+			stale := lime
+
+			lime.MenuLabel = "Limes"
+			assert.NoError(t, service.Update(ctx, &lime))
+			stale.MenuLabel = "Green Things"
+			assert.True(t, errors.Is(service.Update(ctx, &stale), ErrConflict))
+
+			stored, err := service.GetById(ctx, lime.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, "Limes", stored.MenuLabel)
 		})
 	})
 
 	t.Run("Delete", func(t *testing.T) {
-		service := NewClassService(NewMockClassRepository())
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
 
 		class := Class{Name: "Test", Slug: "test"}
-		assert.NoError(t, service.Insert(&class))
-		assert.NoError(t, service.Delete(class))
+		assert.NoError(t, service.Insert(ctx, &class))
+		assert.NoError(t, service.Delete(ctx, class, DeleteRestrict))
 		// Do it once more to make sure it fails silently
-		assert.NoError(t, service.Delete(class))
+		assert.NoError(t, service.Delete(ctx, class, DeleteRestrict))
 
-		_, err := service.GetById(class.Id)
+		_, err := service.GetById(ctx, class.Id)
 		assert.Error(t, err)
 	})
+	t.Run("Dependents", func(t *testing.T) {
+		docs := NewMockClassDocumentRepository()
+		service := NewClassService(NewMockClassRepository(), docs)
+
+		target := Class{Name: "Target", Slug: "target"}
+		assert.NoError(t, service.Insert(ctx, &target))
+		docs.documents[target.Id] = 5
+		docs.references[target.Id] = 2
+
+		child := Class{Name: "Child", Slug: "child", Parents: []primitive.ObjectID{target.Id}}
+		assert.NoError(t, service.Insert(ctx, &child))
+
+		source := Class{
+			Name: "Source",
+			Slug: "source",
+			Fields: []field.Field{
+				{Name: "pick", Label: "Pick", Type: field.TypeSelect, DataSourceId: target.Id},
+			},
+		}
+		assert.NoError(t, service.Insert(ctx, &source))
+
+		related := Class{
+			Name: "Related",
+			Slug: "related",
+			Fields: []field.Field{
+				{Name: "rel", Label: "Rel", Type: field.TypeRelation, RelationClassIds: []primitive.ObjectID{target.Id}},
+			},
+		}
+		assert.NoError(t, service.Insert(ctx, &related))
+
+		unrelated := Class{Name: "Unrelated", Slug: "unrelated"}
+		assert.NoError(t, service.Insert(ctx, &unrelated))
+
+		dependents, err := service.Dependents(ctx, target)
+		assert.NoError(t, err)
+		assert.False(t, dependents.Empty())
+		assert.Equal(t, 5, dependents.Documents)
+		assert.Equal(t, 2, dependents.References)
+		assert.Equal(t, 3, len(dependents.Classes))
+
+		dependents, err = service.Dependents(ctx, unrelated)
+		assert.NoError(t, err)
+		assert.True(t, dependents.Empty())
+	})
+
+	t.Run("Geo Index", func(t *testing.T) {
+		docs := NewMockClassDocumentRepository()
+		service := NewClassService(NewMockClassRepository(), docs)
+
+		places := Class{Name: "Places", Slug: "places"}
+		assert.NoError(t, service.Insert(ctx, &places))
+		assert.Equal(t, 0, len(docs.indexes))
+
+		places.Fields = []field.Field{
+			{Name: "title", Label: "Title", Type: field.TypeText},
+			{Name: "location", Label: "Location", Type: field.TypeGeoPoint},
+		}
+		assert.NoError(t, service.Update(ctx, &places))
+		assert.True(t, docs.indexes["location"])
+		assert.False(t, docs.indexes["title"])
+	})
+
+	t.Run("Delete Modes", func(t *testing.T) {
+		docs := NewMockClassDocumentRepository()
+		service := NewClassService(NewMockClassRepository(), docs)
+
+		newClass := func(slug string, documents, references int64) Class {
+			class := Class{Name: "Test", Slug: slug}
+			assert.NoError(t, service.Insert(ctx, &class))
+			docs.documents[class.Id] = documents
+			docs.references[class.Id] = references
+			return class
+		}
+
+		t.Run("Restrict", func(t *testing.T) {
+			class := newClass("restrict", 3, 0)
+			assert.Error(t, service.Delete(ctx, class, DeleteRestrict))
+			_, err := service.GetById(ctx, class.Id)
+			assert.NoError(t, err)
+		})
+
+		t.Run("Cascade", func(t *testing.T) {
+			class := newClass("cascade", 3, 0)
+			assert.NoError(t, service.Delete(ctx, class, DeleteCascade))
+			assert.Equal(t, 0, docs.documents[class.Id])
+			_, err := service.GetById(ctx, class.Id)
+			assert.Error(t, err)
+		})
+
+		t.Run("Cascade Referenced", func(t *testing.T) {
+			class := newClass("cascade_referenced", 3, 1)
+			assert.Error(t, service.Delete(ctx, class, DeleteCascade))
+			assert.Equal(t, 3, docs.documents[class.Id])
+		})
+
+		t.Run("Archive", func(t *testing.T) {
+			class := newClass("archive", 3, 1)
+			assert.NoError(t, service.Delete(ctx, class, DeleteArchive))
+			assert.Equal(t, 3, docs.archived[class.Id])
+			_, err := service.GetById(ctx, class.Id)
+			assert.Error(t, err)
+		})
+
+		t.Run("Dependent Class", func(t *testing.T) {
+			class := newClass("parent", 0, 0)
+			child := Class{Name: "Child", Slug: "dependent_child", Parents: []primitive.ObjectID{class.Id}}
+			assert.NoError(t, service.Insert(ctx, &child))
+			assert.Error(t, service.Delete(ctx, class, DeleteCascade))
+		})
+
+		t.Run("Unknown Mode", func(t *testing.T) {
+			class := newClass("unknown", 0, 0)
+			assert.Error(t, service.Delete(ctx, class, "bogus"))
+		})
+	})
+	t.Run("Trash", func(t *testing.T) {
+		docs := NewMockClassDocumentRepository()
+		service := NewClassService(NewMockClassRepository(), docs)
+		userId := primitive.NewObjectID()
+
+		class := Class{Name: "Trash", Slug: "trash"}
+		assert.NoError(t, service.Insert(ctx, &class))
+		docs.documents[class.Id] = 2
+
+		// Restricted deletes are refused before reaching the trash
+		assert.Error(t, service.Trash(ctx, class, DeleteRestrict, userId))
+		assert.NoError(t, service.Trash(ctx, class, DeleteCascade, userId))
+
+		trashed, err := service.GetById(ctx, class.Id)
+		assert.NoError(t, err)
+		assert.False(t, trashed.Deleted.IsZero())
+		assert.Equal(t, userId, trashed.DeletedBy)
+		assert.Equal(t, DeleteCascade, trashed.DeleteMode)
+		assert.Error(t, service.Trash(ctx, trashed, DeleteCascade, userId))
+
+		// Trashed classes are hidden and release their slug
+		_, err = service.GetBySlug(ctx, class.Slug)
+		assert.Error(t, err)
+		all, err := service.All(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(all))
+
+		list, err := service.Trashed(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(list))
+
+		t.Run("Restore", func(t *testing.T) {
+			assert.NoError(t, service.Restore(ctx, trashed))
+			restored, err := service.GetBySlug(ctx, class.Slug)
+			assert.NoError(t, err)
+			assert.True(t, restored.Deleted.IsZero())
+			assert.Equal(t, "", restored.DeleteMode)
+			assert.Error(t, service.Restore(ctx, restored))
+		})
+
+		t.Run("Restore Slug Taken", func(t *testing.T) {
+			restored, err := service.GetById(ctx, class.Id)
+			assert.NoError(t, err)
+			assert.NoError(t, service.Trash(ctx, restored, DeleteCascade, userId))
+
+			taken := Class{Name: "Taken", Slug: class.Slug}
+			assert.NoError(t, service.Insert(ctx, &taken))
+
+			trashed, err := service.GetById(ctx, class.Id)
+			assert.NoError(t, err)
+			assert.Error(t, service.Restore(ctx, trashed))
+		})
+
+		t.Run("Purge", func(t *testing.T) {
+			purged, err := service.Purge(ctx, time.Now().Add(-time.Hour))
+			assert.NoError(t, err)
+			assert.Equal(t, 0, purged)
+
+			purged, err = service.Purge(ctx, time.Now().Add(time.Second))
+			assert.NoError(t, err)
+			assert.Equal(t, 1, purged)
+			assert.Equal(t, 0, docs.documents[class.Id])
+
+			_, err = service.GetById(ctx, class.Id)
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		recorder := &mockRecorder{}
+		userId := primitive.NewObjectID()
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository()).As(userId)
+		Audit(service.Events(), recorder)
+
+		class := Class{Name: "Audited", Slug: "audited"}
+		assert.NoError(t, service.Insert(ctx, &class))
+		class.MenuLabel = "Audited Things"
+		assert.NoError(t, service.Update(ctx, &class))
+		assert.NoError(t, service.Delete(ctx, class, DeleteCascade))
+
+		assert.Equal(t, 3, len(recorder.entries))
+		for _, e := range recorder.entries {
+			assert.Equal(t, userId, e.ActorId)
+			assert.Equal(t, class.Id, e.TargetId)
+			assert.Equal(t, class.Id, e.ClassId)
+			assert.Equal(t, audit.TargetClass, e.TargetType)
+		}
+		assert.Equal(t, `created class "Audited"`, recorder.entries[0].Summary)
+		assert.DeepEqual(t, []string{"menu_label"}, recorder.entries[1].Changes)
+		assert.Equal(t, `deleted class "Audited", documents: cascade`, recorder.entries[2].Summary)
+
+		trashed := Class{Name: "Trashed", Slug: "trashed"}
+		assert.NoError(t, service.Insert(ctx, &trashed))
+		assert.NoError(t, service.Trash(ctx, trashed, DeleteCascade, userId))
+		trashed, err := service.GetById(ctx, trashed.Id)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Restore(ctx, trashed))
+		assert.Equal(t, 6, len(recorder.entries))
+		assert.Equal(t, audit.ActionTrash, recorder.entries[4].Action)
+		assert.Equal(t, audit.ActionRestore, recorder.entries[5].Action)
+		assert.Equal(t, 0, len(recorder.entries[5].Changes))
+	})
+
+	t.Run("Notify", func(t *testing.T) {
+		var changed []string
+		notifier := NotifierFunc(func(event string, c Class) error {
+			changed = append(changed, event+" "+c.Slug)
+			return nil
+		})
+		service := NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
+		Notify(service.Events(), notifier)
+
+		class := Class{Name: "Notified", Slug: "notified"}
+		assert.NoError(t, service.Insert(ctx, &class))
+		class.Name = "Renamed"
+		assert.NoError(t, service.Update(ctx, &class))
+		assert.NoError(t, service.Delete(ctx, class, DeleteCascade))
+		assert.DeepEqual(t, []string{
+			"class.changed notified",
+			"class.changed notified",
+			"class.changed notified",
+		}, changed)
+
+		// The class is stored by the time notifiers run, so a failure is
+		// logged and the rest are still told
+		changed = nil
+		failing := NotifierFunc(func(string, Class) error {
+			return errors.New("unreachable")
+		})
+		service = NewClassService(NewMockClassRepository(), NewMockClassDocumentRepository())
+		Notify(service.Events(), failing, notifier)
+		class = Class{Name: "Failing", Slug: "failing"}
+		assert.NoError(t, service.Insert(ctx, &class))
+		assert.DeepEqual(t, []string{"class.changed failing"}, changed)
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		repo := NewMockClassRepository()
+		userId := primitive.NewObjectID()
+		service := NewClassService(repo, NewMockClassDocumentRepository()).As(userId)
+
+		var seen []string
+		for _, op := range []event.Op{event.Insert, event.Update, event.Delete} {
+			service.Events().After(op, func(e *event.Event[Class]) error {
+				assert.Equal(t, userId, e.Actor)
+				seen = append(seen, fmt.Sprintf("%s %s %s", e.Op, e.Model.Slug, e.Previous.Slug))
+				return nil
+			})
+		}
+		service.Events().Before(event.Insert, func(e *event.Event[Class]) error {
+			if e.Model.Name == "" {
+				e.Model.Name = "Unnamed"
+			}
+			return nil
+		})
+		service.Events().Before(event.Update, func(e *event.Event[Class]) error {
+			if e.Model.Slug != e.Previous.Slug {
+				return errors.New("slugs are permanent")
+			}
+			return nil
+		})
+
+		// Before handlers run ahead of validation so they can fill in fields
+		class := Class{Slug: "hooked"}
+		assert.NoError(t, service.Insert(ctx, &class))
+		assert.Equal(t, "Unnamed", class.Name)
+
+		renamed := class
+		renamed.Slug = "unhooked"
+		err := service.Update(ctx, &renamed)
+		var veto event.VetoError
+		assert.True(t, errors.As(err, &veto))
+		stored, err := service.GetById(ctx, class.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "hooked", stored.Slug)
+
+		assert.NoError(t, service.Trash(ctx, class, DeleteCascade, userId))
+		trashed, err := service.GetById(ctx, class.Id)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Delete(ctx, trashed, DeleteCascade))
+		assert.DeepEqual(t, []string{
+			"insert hooked ",
+			"update hooked hooked",
+			"delete hooked ",
+		}, seen)
+	})
+}
+
+// Keeps every entry recorded by a service
+type mockRecorder struct {
+	entries []audit.Entry
+}
+
+func (r *mockRecorder) Record(e audit.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
 }
@@ -0,0 +1,75 @@
+package class
+
+import (
+	"fmt"
+
+	"github.com/jbaikge/gocms/models/field"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Finds the classes a class inherits fields from
+type classLookup func(primitive.ObjectID) (Class, error)
+
+// Fills in the inherited fields of the class from its base class and
+// fieldsets. Inheritance cycles, missing classes and field names defined more
+// than once are reported as errors.
+func resolve(class *Class, lookup classLookup) (err error) {
+	path := map[primitive.ObjectID]bool{class.Id: true}
+	included := make(map[primitive.ObjectID]bool)
+	if class.Inherited, err = inheritedFields(*class, lookup, path, included); err != nil {
+		return
+	}
+	return checkFieldNames(class.AllFields())
+}
+
+// Collects the fields of every class inherited by class, depth first. path
+// holds the classes currently being walked to detect cycles, included holds
+// every class already merged so a fieldset reached twice only counts once.
+func inheritedFields(class Class, lookup classLookup, path, included map[primitive.ObjectID]bool) (fields []field.Field, err error) {
+	ids := class.FieldsetIds
+	if !class.BaseId.IsZero() {
+		ids = append([]primitive.ObjectID{class.BaseId}, ids...)
+	}
+
+	for i, id := range ids {
+		if path[id] {
+			return nil, fmt.Errorf("class %s inherits from itself", class.Slug)
+		}
+		if included[id] {
+			continue
+		}
+
+		source, err := lookup(id)
+		if err != nil {
+			return nil, fmt.Errorf("class %s inherits from missing class %s: %w", class.Slug, id.Hex(), err)
+		}
+		isBase := i == 0 && id == class.BaseId
+		if !isBase && !source.Fieldset {
+			return nil, fmt.Errorf("class %s includes %s, which is not a fieldset", class.Slug, source.Slug)
+		}
+
+		path[id] = true
+		inherited, err := inheritedFields(source, lookup, path, included)
+		delete(path, id)
+		if err != nil {
+			return nil, err
+		}
+
+		included[id] = true
+		fields = append(fields, inherited...)
+		fields = append(fields, source.Fields...)
+	}
+
+	return
+}
+
+func checkFieldNames(fields []field.Field) error {
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if names[f.Name] {
+			return fmt.Errorf("field %s is defined more than once", f.Name)
+		}
+		names[f.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package class
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestResolve(t *testing.T) {
+	classes := make(map[primitive.ObjectID]Class)
+	lookup := func(id primitive.ObjectID) (Class, error) {
+		if c, ok := classes[id]; ok {
+			return c, nil
+		}
+		return Class{}, fmt.Errorf("class not found: %s", id.Hex())
+	}
+	add := func(c Class) Class {
+		c.Id = primitive.NewObjectID()
+		classes[c.Id] = c
+		return c
+	}
+	names := func(fields []field.Field) (n []string) {
+		for _, f := range fields {
+			n = append(n, f.Name)
+		}
+		return
+	}
+
+	seo := add(Class{Slug: "seo", Fieldset: true, Fields: []field.Field{{Name: "meta_title"}, {Name: "meta_description"}}})
+	hero := add(Class{Slug: "hero", Fieldset: true, Fields: []field.Field{{Name: "hero_image"}}})
+	page := add(Class{Slug: "page", FieldsetIds: []primitive.ObjectID{seo.Id}, Fields: []field.Field{{Name: "body"}}})
+
+	t.Run("Merge Order", func(t *testing.T) {
+		landing := Class{
+			Slug:        "landing",
+			BaseId:      page.Id,
+			FieldsetIds: []primitive.ObjectID{hero.Id},
+			Fields:      []field.Field{{Name: "cta"}},
+		}
+		assert.NoError(t, resolve(&landing, lookup))
+		assert.DeepEqual(t, []string{"meta_title", "meta_description", "body", "hero_image"}, names(landing.Inherited))
+		assert.Equal(t, "hero_image", landing.Field("hero_image").Name)
+		assert.Equal(t, "cta", landing.Field("cta").Name)
+		assert.Equal(t, 5, len(landing.AllFields()))
+	})
+
+	t.Run("Fieldset Included Twice", func(t *testing.T) {
+		landing := Class{Slug: "landing", BaseId: page.Id, FieldsetIds: []primitive.ObjectID{seo.Id}}
+		assert.NoError(t, resolve(&landing, lookup))
+		assert.DeepEqual(t, []string{"meta_title", "meta_description", "body"}, names(landing.Inherited))
+	})
+
+	t.Run("Duplicate Field", func(t *testing.T) {
+		landing := Class{Slug: "landing", BaseId: page.Id, Fields: []field.Field{{Name: "body"}}}
+		assert.Error(t, resolve(&landing, lookup))
+	})
+
+	t.Run("Not A Fieldset", func(t *testing.T) {
+		landing := Class{Slug: "landing", FieldsetIds: []primitive.ObjectID{page.Id}}
+		assert.Error(t, resolve(&landing, lookup))
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		landing := Class{Slug: "landing", BaseId: primitive.NewObjectID()}
+		assert.Error(t, resolve(&landing, lookup))
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		a := add(Class{Slug: "a"})
+		b := add(Class{Slug: "b", BaseId: a.Id})
+		a.BaseId = b.Id
+		classes[a.Id] = a
+		assert.Error(t, resolve(&a, lookup))
+	})
+}
+
+func TestClassInheritance(t *testing.T) {
+	ctx := context.Background()
+	docs := NewMockClassDocumentRepository()
+	service := NewClassService(NewMockClassRepository(), docs)
+
+	seo := Class{
+		Name:     "SEO",
+		Slug:     "seo",
+		Fieldset: true,
+		Fields:   []field.Field{{Name: "meta_title", Label: "Meta Title", Type: field.TypeText}},
+	}
+	assert.NoError(t, service.Insert(ctx, &seo))
+
+	page := Class{
+		Name:        "Pages",
+		Slug:        "pages",
+		FieldsetIds: []primitive.ObjectID{seo.Id},
+		Fields:      []field.Field{{Name: "body", Label: "Body", Type: field.TypeTinyMCE}},
+	}
+	assert.NoError(t, service.Insert(ctx, &page))
+	assert.Equal(t, 1, len(page.Inherited))
+
+	t.Run("Group Changes Reach Classes", func(t *testing.T) {
+		seo.Fields = append(seo.Fields, field.Field{Name: "meta_description", Label: "Meta Description", Type: field.TypeTextArea})
+		assert.NoError(t, service.Update(ctx, &seo))
+
+		check, err := service.GetBySlug(ctx, page.Slug)
+		assert.NoError(t, err)
+		assert.Equal(t, "meta_description", check.Field("meta_description").Name)
+
+		all, err := service.All(ctx)
+		assert.NoError(t, err)
+		for _, c := range all {
+			if c.Id == page.Id {
+				assert.Equal(t, 2, len(c.Inherited))
+			}
+		}
+	})
+
+	t.Run("Group Conflicts", func(t *testing.T) {
+		conflict := seo
+		conflict.Fields = append(conflict.Fields, field.Field{Name: "body", Label: "Body", Type: field.TypeText})
+		assert.Error(t, service.Update(ctx, &conflict))
+	})
+
+	t.Run("Fieldset With Documents", func(t *testing.T) {
+		docs.documents[page.Id] = 1
+		defer delete(docs.documents, page.Id)
+
+		check, err := service.GetById(ctx, page.Id)
+		assert.NoError(t, err)
+		check.Fieldset = true
+		assert.Error(t, service.Update(ctx, &check))
+	})
+
+	t.Run("Dependents", func(t *testing.T) {
+		dependents, err := service.Dependents(ctx, seo)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(dependents.Classes))
+		assert.Error(t, service.Delete(ctx, seo, DeleteRestrict))
+	})
+}
@@ -0,0 +1,218 @@
+package class
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jbaikge/gocms/models/field"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions available to bring existing document values in line with changed
+// class fields
+const (
+	MigrateRename  = "rename"
+	MigrateConvert = "convert"
+	MigrateDrop    = "drop"
+)
+
+// Describes a bulk change to the values stored under a field in every
+// document of a class
+type Migration struct {
+	Action string `json:"action"`
+	// Field is the name of the field as it is currently stored
+	Field string `json:"field"`
+	// To is the new field name when renaming
+	To string `json:"to,omitempty"`
+	// FromType and Type are the old and new field types when converting
+	FromType string `json:"from_type,omitempty"`
+	Type     string `json:"type,omitempty"`
+	// Number of documents holding a value for Field, filled in by
+	// ClassService.Migrations as a dry run
+	Documents int64 `json:"documents"`
+	// Number of values which cannot be converted to Type, filled in by
+	// ClassService.Migrations as a dry run. They are kept as they are unless
+	// DropUnconvertible is set.
+	Unconvertible     int64 `json:"unconvertible,omitempty"`
+	DropUnconvertible bool  `json:"drop_unconvertible,omitempty"`
+	// Fields carry no identity beyond their name, so renames are only a guess
+	// from their position in the list and must be confirmed before they apply
+	Confirmed bool `json:"confirmed,omitempty"`
+	// Skip acknowledges a suggested migration while leaving the values as they
+	// are
+	Skip bool `json:"skip,omitempty"`
+}
+
+// Converts a value to the type of a convert migration
+func (m Migration) Convert(value interface{}) (interface{}, error) {
+	return field.Convert(value, m.Type)
+}
+
+// Applies the migration to the values of a single document, reporting whether
+// they changed
+func (m Migration) Apply(values map[string]interface{}) bool {
+	value, ok := values[m.Field]
+	if !ok || m.Skip {
+		return false
+	}
+
+	switch m.Action {
+	case MigrateRename:
+		values[m.To] = value
+		delete(values, m.Field)
+	case MigrateConvert:
+		converted, err := m.Convert(value)
+		switch {
+		case err == nil:
+			values[m.Field] = converted
+		case m.DropUnconvertible:
+			delete(values, m.Field)
+		default:
+			return false
+		}
+	case MigrateDrop:
+		delete(values, m.Field)
+	default:
+		return false
+	}
+	return true
+}
+
+// Returned when the storage could not apply a class update and its migrations
+// as one change and failed part way through
+type MigrationError struct {
+	// Classes whose documents were fully migrated before the failure
+	Migrated []primitive.ObjectID
+	Err      error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("%d classes migrated before failing: %v", len(e.Migrated), e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Compares the fields of the stored class with the updated class and suggests
+// a migration for every removed or retyped field. A removed field is
+// suggested as an unconfirmed rename when a new field of the same type takes
+// its place in the list, otherwise its values are dropped.
+func diffFields(stored, updated []field.Field) (migrations []Migration) {
+	storedNames := make(map[string]field.Field, len(stored))
+	for _, f := range stored {
+		storedNames[f.Name] = f
+	}
+	updatedNames := make(map[string]field.Field, len(updated))
+	for _, f := range updated {
+		updatedNames[f.Name] = f
+	}
+
+	for i, old := range stored {
+		if f, ok := updatedNames[old.Name]; ok {
+			if f.Type != old.Type {
+				migrations = append(migrations, Migration{
+					Action:   MigrateConvert,
+					Field:    old.Name,
+					FromType: old.Type,
+					Type:     f.Type,
+				})
+			}
+			continue
+		}
+
+		if i < len(updated) {
+			candidate := updated[i]
+			if _, exists := storedNames[candidate.Name]; !exists && candidate.Type == old.Type {
+				migrations = append(migrations, Migration{
+					Action: MigrateRename,
+					Field:  old.Name,
+					To:     candidate.Name,
+				})
+				continue
+			}
+		}
+
+		migrations = append(migrations, Migration{
+			Action: MigrateDrop,
+			Field:  old.Name,
+		})
+	}
+
+	return
+}
+
+// Makes sure every suggested migration touching existing values is either
+// applied or skipped, so no change to the fields leaves values behind
+// unnoticed
+func checkAcknowledged(suggested, migrations []Migration) error {
+	handled := make(map[string]bool, len(migrations))
+	for _, m := range migrations {
+		handled[m.Field] = true
+	}
+	for _, s := range suggested {
+		if s.Documents > 0 && !handled[s.Field] {
+			return fmt.Errorf("%s: %d documents hold values for %s, apply or skip the migration", s.Action, s.Documents, s.Field)
+		}
+	}
+	return nil
+}
+
+// Makes sure a migration is consistent with the field changes between the
+// stored and updated class
+func checkMigration(m Migration, stored, updated Class) error {
+	old := stored.Field(m.Field)
+	current := updated.Field(m.Field)
+
+	if old.Name == "" {
+		return fmt.Errorf("%s: field %s does not exist", m.Action, m.Field)
+	}
+	if m.Skip {
+		return nil
+	}
+
+	switch m.Action {
+	case MigrateRename:
+		if !m.Confirmed {
+			return fmt.Errorf("rename: %s to %s is not confirmed", m.Field, m.To)
+		}
+		if current.Name != "" {
+			return fmt.Errorf("rename: field %s still exists", m.Field)
+		}
+		if m.To == "" || updated.Field(m.To).Name == "" {
+			return fmt.Errorf("rename: target field %s does not exist", m.To)
+		}
+		if stored.Field(m.To).Name != "" {
+			return fmt.Errorf("rename: target field %s already has values", m.To)
+		}
+	case MigrateConvert:
+		if current.Name == "" {
+			return fmt.Errorf("convert: field %s was removed", m.Field)
+		}
+		if current.Type != m.Type {
+			return fmt.Errorf("convert: field %s is not of type %s", m.Field, m.Type)
+		}
+	case MigrateDrop:
+		if current.Name != "" {
+			return fmt.Errorf("drop: field %s still exists", m.Field)
+		}
+	default:
+		return fmt.Errorf("unknown migration: %s", m.Action)
+	}
+
+	return nil
+}
+
+// Swaps a renamed field in the space separated list of table fields,
+// including relation lookups in the form field.key
+func renameTableField(fields string, from string, to string) string {
+	names := strings.Fields(fields)
+	for i, name := range names {
+		if name == from {
+			names[i] = to
+		} else if strings.HasPrefix(name, from+".") {
+			names[i] = to + strings.TrimPrefix(name, from)
+		}
+	}
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,254 @@
+package class
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDiffFields(t *testing.T) {
+	stored := []field.Field{
+		{Name: "title", Type: field.TypeText},
+		{Name: "count", Type: field.TypeText},
+		{Name: "author", Type: field.TypeText},
+		{Name: "legacy", Type: field.TypeDate},
+	}
+	updated := []field.Field{
+		{Name: "title", Type: field.TypeText},
+		{Name: "count", Type: field.TypeNumber},
+		{Name: "byline", Type: field.TypeText},
+	}
+
+	expect := []Migration{
+		{Action: MigrateConvert, Field: "count", FromType: field.TypeText, Type: field.TypeNumber},
+		{Action: MigrateRename, Field: "author", To: "byline"},
+		{Action: MigrateDrop, Field: "legacy"},
+	}
+	assert.DeepEqual(t, expect, diffFields(stored, updated))
+	assert.Equal(t, 0, len(diffFields(stored, stored)))
+}
+
+func TestCheckMigration(t *testing.T) {
+	stored := Class{Fields: []field.Field{
+		{Name: "a", Type: field.TypeText},
+		{Name: "b", Type: field.TypeText},
+	}}
+	updated := Class{Fields: []field.Field{
+		{Name: "b", Type: field.TypeNumber},
+		{Name: "c", Type: field.TypeText},
+	}}
+
+	table := []struct {
+		Name      string
+		Migration Migration
+		Error     bool
+	}{
+		{"Rename", Migration{Action: MigrateRename, Field: "a", To: "c", Confirmed: true}, false},
+		{"Rename Unconfirmed", Migration{Action: MigrateRename, Field: "a", To: "c"}, true},
+		{"Rename Missing Target", Migration{Action: MigrateRename, Field: "a", To: "d", Confirmed: true}, true},
+		{"Rename Existing Field", Migration{Action: MigrateRename, Field: "b", To: "c", Confirmed: true}, true},
+		{"Rename Unknown", Migration{Action: MigrateRename, Field: "z", To: "c", Confirmed: true}, true},
+		{"Convert", Migration{Action: MigrateConvert, Field: "b", Type: field.TypeNumber}, false},
+		{"Convert Wrong Type", Migration{Action: MigrateConvert, Field: "b", Type: field.TypeDate}, true},
+		{"Convert Removed", Migration{Action: MigrateConvert, Field: "a", Type: field.TypeText}, true},
+		{"Drop", Migration{Action: MigrateDrop, Field: "a"}, false},
+		{"Drop Existing", Migration{Action: MigrateDrop, Field: "b"}, true},
+		{"Unknown Action", Migration{Action: "bogus", Field: "a"}, true},
+		{"Skip Unconfirmed Rename", Migration{Action: MigrateRename, Field: "a", To: "c", Skip: true}, false},
+		{"Skip Unknown", Migration{Action: MigrateDrop, Field: "z", Skip: true}, true},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			err := checkMigration(test.Migration, stored, updated)
+			if test.Error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckAcknowledged(t *testing.T) {
+	suggested := []Migration{
+		{Action: MigrateConvert, Field: "a", Documents: 2},
+		{Action: MigrateDrop, Field: "b"},
+	}
+	assert.Error(t, checkAcknowledged(suggested, nil))
+	assert.NoError(t, checkAcknowledged(suggested, []Migration{{Action: MigrateConvert, Field: "a", Skip: true}}))
+	// A rename suggestion may be turned down in favor of dropping the values
+	assert.NoError(t, checkAcknowledged(suggested, []Migration{{Action: MigrateDrop, Field: "a"}}))
+}
+
+func TestMigrationApply(t *testing.T) {
+	values := map[string]interface{}{"a": "1", "b": "two", "c": "x"}
+
+	assert.True(t, Migration{Action: MigrateRename, Field: "a", To: "z"}.Apply(values))
+	assert.True(t, Migration{Action: MigrateConvert, Field: "z", Type: field.TypeNumber}.Apply(values))
+	assert.False(t, Migration{Action: MigrateConvert, Field: "b", Type: field.TypeNumber}.Apply(values))
+	assert.False(t, Migration{Action: MigrateDrop, Field: "c", Skip: true}.Apply(values))
+	assert.False(t, Migration{Action: MigrateDrop, Field: "missing"}.Apply(values))
+	assert.Equal(t, "two", values["b"])
+	assert.Equal(t, "x", values["c"])
+
+	assert.True(t, Migration{Action: MigrateConvert, Field: "b", Type: field.TypeNumber, DropUnconvertible: true}.Apply(values))
+	_, ok := values["b"]
+	assert.False(t, ok)
+}
+
+func TestRenameTableField(t *testing.T) {
+	assert.Equal(t, "title byline byline.name", renameTableField("title author author.name", "author", "byline"))
+	assert.Equal(t, "title authors", renameTableField("title authors", "author", "byline"))
+}
+
+func TestClassMigrations(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockClassRepository()
+	docs := NewMockClassDocumentRepository()
+	docs.classes = repo
+	service := NewClassService(repo, docs)
+
+	class := Class{
+		Name:        "Test",
+		Slug:        "test",
+		TableFields: "author count",
+		Fields: []field.Field{
+			{Name: "author", Label: "Author", Type: field.TypeText},
+			{Name: "count", Label: "Count", Type: field.TypeText},
+			{Name: "legacy", Label: "Legacy", Type: field.TypeText},
+		},
+	}
+	assert.NoError(t, service.Insert(ctx, &class))
+	docs.values[class.Id] = []map[string]interface{}{
+		{"author": "Alice", "count": "1", "legacy": "x"},
+		{"author": "Bob", "count": "two"},
+		{"count": "3"},
+	}
+
+	updated := class
+	updated.Fields = []field.Field{
+		{Name: "byline", Label: "Byline", Type: field.TypeText},
+		{Name: "count", Label: "Count", Type: field.TypeNumber},
+	}
+
+	t.Run("Dry Run", func(t *testing.T) {
+		migrations, err := service.Migrations(ctx, updated)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(migrations))
+		assert.Equal(t, MigrateRename, migrations[0].Action)
+		assert.Equal(t, 2, migrations[0].Documents)
+		assert.False(t, migrations[0].Confirmed)
+		assert.Equal(t, MigrateConvert, migrations[1].Action)
+		assert.Equal(t, 3, migrations[1].Documents)
+		assert.Equal(t, 1, migrations[1].Unconvertible)
+		assert.Equal(t, MigrateDrop, migrations[2].Action)
+		assert.Equal(t, 1, migrations[2].Documents)
+
+		// Nothing changes until the migrations are applied
+		assert.Equal(t, "Alice", docs.values[class.Id][0]["author"])
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		bad := updated
+		err := service.Update(ctx, &bad, Migration{Action: MigrateDrop, Field: "count"})
+		assert.Error(t, err)
+		stored, err := service.GetById(ctx, class.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(stored.Fields))
+	})
+
+	t.Run("Unacknowledged", func(t *testing.T) {
+		bad := updated
+		assert.Error(t, service.Update(ctx, &bad))
+		stored, err := service.GetById(ctx, class.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(stored.Fields))
+	})
+
+	t.Run("Partial Failure", func(t *testing.T) {
+		docs.migrateErr = &MigrationError{Migrated: []primitive.ObjectID{class.Id}, Err: fmt.Errorf("connection lost")}
+		defer func() { docs.migrateErr = nil }()
+
+		migrations, err := service.Migrations(ctx, updated)
+		assert.NoError(t, err)
+		migrations[0].Confirmed = true
+		bad := updated
+		err = service.Update(ctx, &bad, migrations...)
+		assert.Error(t, err)
+		assert.Equal(t, "class not saved, documents of test were already migrated: connection lost", err.Error())
+	})
+
+	t.Run("Unconfirmed Rename", func(t *testing.T) {
+		migrations, err := service.Migrations(ctx, updated)
+		assert.NoError(t, err)
+		bad := updated
+		assert.Error(t, service.Update(ctx, &bad, migrations...))
+		assert.Equal(t, "Alice", docs.values[class.Id][0]["author"])
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		migrations, err := service.Migrations(ctx, updated)
+		assert.NoError(t, err)
+		migrations[0].Confirmed = true
+		assert.NoError(t, service.Update(ctx, &updated, migrations...))
+		assert.Equal(t, "byline count", updated.TableFields)
+
+		values := docs.values[class.Id]
+		assert.DeepEqual(t, map[string]interface{}{"byline": "Alice", "count": "1"}, values[0])
+		// Values that cannot be converted are kept unless the admin asks to
+		// drop them
+		assert.DeepEqual(t, map[string]interface{}{"byline": "Bob", "count": "two"}, values[1])
+		assert.DeepEqual(t, map[string]interface{}{"count": "3"}, values[2])
+	})
+}
+
+func TestClassMigrationsInherited(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMockClassRepository()
+	docs := NewMockClassDocumentRepository()
+	docs.classes = repo
+	service := NewClassService(repo, docs)
+
+	seo := Class{
+		Name:     "SEO",
+		Slug:     "seo",
+		Fieldset: true,
+		Fields:   []field.Field{{Name: "keywords", Label: "Keywords", Type: field.TypeText}},
+	}
+	assert.NoError(t, service.Insert(ctx, &seo))
+	page := Class{Name: "Page", Slug: "page", FieldsetIds: []primitive.ObjectID{seo.Id}}
+	assert.NoError(t, service.Insert(ctx, &page))
+	landing := Class{Name: "Landing", Slug: "landing", BaseId: page.Id}
+	assert.NoError(t, service.Insert(ctx, &landing))
+	other := Class{
+		Name:   "Other",
+		Slug:   "other",
+		Fields: []field.Field{{Name: "keywords", Label: "Keywords", Type: field.TypeText}},
+	}
+	assert.NoError(t, service.Insert(ctx, &other))
+
+	docs.values[page.Id] = []map[string]interface{}{{"keywords": "a"}, {"keywords": "b"}}
+	docs.values[landing.Id] = []map[string]interface{}{{"keywords": "c"}}
+	docs.values[other.Id] = []map[string]interface{}{{"keywords": "d"}}
+
+	updated := seo
+	updated.Fields = nil
+
+	migrations, err := service.Migrations(ctx, updated)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(migrations))
+	assert.Equal(t, MigrateDrop, migrations[0].Action)
+	// Documents of the page and of the landing page built on it
+	assert.Equal(t, 3, migrations[0].Documents)
+
+	assert.NoError(t, service.Update(ctx, &updated, migrations...))
+	assert.DeepEqual(t, map[string]interface{}{}, docs.values[page.Id][0])
+	assert.DeepEqual(t, map[string]interface{}{}, docs.values[landing.Id][0])
+	// Classes which do not inherit the fieldset are left alone
+	assert.Equal(t, "d", docs.values[other.Id][0]["keywords"])
+}
@@ -0,0 +1,65 @@
+package class
+
+import (
+	"fmt"
+
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/event"
+)
+
+// Appends an entry to the audit log for every change stored through the
+// service publishing the events
+func Audit(events *event.Bus[Class], recorder audit.Recorder) {
+	record := func(e *event.Event[Class]) error {
+		return recorder.Record(auditEntry(e))
+	}
+	for _, op := range []event.Op{event.Insert, event.Update, event.Delete} {
+		events.After(op, record)
+	}
+}
+
+// Tells the notifiers about every change stored through the service publishing
+// the events. Each notifier is told even if an earlier one failed.
+func Notify(events *event.Bus[Class], notifiers ...Notifier) {
+	for _, n := range notifiers {
+		n := n
+		notify := func(e *event.Event[Class]) error {
+			return n.Notify(EventChanged, *e.Model)
+		}
+		for _, op := range []event.Op{event.Insert, event.Update, event.Delete} {
+			events.After(op, notify)
+		}
+	}
+}
+
+// Describes a stored change for the audit log. Trashing and restoring are
+// published as updates and told apart by the deleted time.
+func auditEntry(e *event.Event[Class]) audit.Entry {
+	class := *e.Model
+	entry := audit.Entry{
+		ActorId:    e.Actor,
+		TargetType: audit.TargetClass,
+		TargetId:   class.Id,
+		ClassId:    class.Id,
+	}
+
+	switch {
+	case e.Op == event.Insert:
+		entry.Action = audit.ActionCreate
+	case e.Op == event.Delete:
+		entry.Action = audit.ActionDelete
+	case e.Previous.Deleted.IsZero() && !class.Deleted.IsZero():
+		entry.Action = audit.ActionTrash
+	case !e.Previous.Deleted.IsZero() && class.Deleted.IsZero():
+		entry.Action = audit.ActionRestore
+	default:
+		entry.Action = audit.ActionUpdate
+		entry.Changes = append(audit.Diff(e.Previous, class), e.Details...)
+	}
+
+	entry.Summary = audit.Summary(entry.Action, audit.TargetClass, class.Name)
+	if e.Op == event.Delete && class.DeleteMode != "" {
+		entry.Summary += fmt.Sprintf(", documents: %s", class.DeleteMode)
+	}
+	return entry
+}
@@ -0,0 +1,61 @@
+package document
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Number of documents of a class in each publishing state. Archived and
+// trashed documents are not counted.
+type ClassCount struct {
+	ClassId primitive.ObjectID `bson:"_id"`
+	Total   int64              `bson:"total"`
+	// Documents with a publish date in the future
+	Scheduled int64 `bson:"scheduled"`
+	// Documents without a publish date
+	Drafts int64 `bson:"drafts"`
+}
+
+func (c ClassCount) Published() int64 {
+	return c.Total - c.Scheduled - c.Drafts
+}
+
+// What has been happening with documents across every class
+type Activity struct {
+	Counts []ClassCount
+	// Most recently updated documents, newest first
+	Recent []Document
+	// Documents to be published, soonest first
+	Scheduled []Document
+	// Unpublished documents created by the user, most recently updated first
+	Drafts []Document
+}
+
+// Documents without a publish date are drafts
+func (d Document) IsDraft() bool {
+	return d.Published.IsZero()
+}
+
+// Whether the document is visible on the site as of now
+func (d Document) isLive(now time.Time) bool {
+	return !d.IsDraft() && !d.Published.After(now) && d.Deleted.IsZero() && d.Archived.IsZero()
+}
+
+// Gathers document counts and the latest documents of each kind, up to limit
+// of each
+func (s documentService) Activity(ctx context.Context, userId primitive.ObjectID, limit int64) (a Activity, err error) {
+	now := time.Now()
+	if a.Counts, err = s.repo.CountDocumentsByClass(ctx, now); err != nil {
+		return
+	}
+	if a.Recent, err = s.repo.GetRecentDocuments(ctx, limit); err != nil {
+		return
+	}
+	if a.Scheduled, err = s.repo.GetScheduledDocuments(ctx, now, limit); err != nil {
+		return
+	}
+	a.Drafts, err = s.repo.GetDraftDocuments(ctx, userId, limit)
+	return
+}
@@ -1,12 +1,32 @@
 package document
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/event"
+	"github.com/jbaikge/gocms/models/field"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Events passed to notifiers. Documents are published when a save makes them
+// visible on the site. Trashed documents count as deleted and restored ones as
+// updated.
+const (
+	EventCreated   = "document.created"
+	EventUpdated   = "document.updated"
+	EventPublished = "document.published"
+	EventDeleted   = "document.deleted"
+)
+
+// Returned when a document is saved over a version other than the stored one
+var ErrConflict = errors.New("document was changed by someone else")
+
 type Document struct {
 	Id        primitive.ObjectID `bson:"_id,omitempty"`
 	ClassId   primitive.ObjectID `bson:"class_id"`
@@ -17,9 +37,77 @@ type Document struct {
 	Updated   time.Time
 	Published time.Time
 	Values    map[string]interface{}
+
+	// Save counter kept by the repository. Updates must carry the version
+	// they were loaded at.
+	Version int64 `bson:"version"`
+
+	// Admin users who created and last updated the document
+	CreatedBy primitive.ObjectID `bson:"created_by,omitempty"`
+	UpdatedBy primitive.ObjectID `bson:"updated_by,omitempty"`
+
+	// Set when the document's class was deleted with its documents archived.
+	// Archived documents no longer appear in lists.
+	Archived time.Time `bson:"archived,omitempty"`
+
+	// Set when the document is moved to the trash
+	Deleted   time.Time          `bson:"deleted,omitempty"`
+	DeletedBy primitive.ObjectID `bson:"deleted_by,omitempty"`
+
+	// Reverse index of every document referenced through relation fields,
+	// maintained by the service on insert and update
+	References []primitive.ObjectID `bson:"references,omitempty"`
+
+	// Related documents loaded by DocumentService.Expand, used to resolve
+	// dotted keys such as "author.title" in Value
+	Related map[primitive.ObjectID]Document `bson:"-" json:"-"`
+}
+
+// Normalizes a stored relation value into a list of IDs. Values may come back
+// from the repository as []primitive.ObjectID, primitive.A or hex strings.
+func RelationIds(value interface{}) (ids []primitive.ObjectID) {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return []primitive.ObjectID{v}
+	case []primitive.ObjectID:
+		return v
+	case string:
+		if id, err := primitive.ObjectIDFromHex(v); err == nil {
+			return []primitive.ObjectID{id}
+		}
+	case []string:
+		for _, hex := range v {
+			if id, err := primitive.ObjectIDFromHex(hex); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	case primitive.A:
+		return RelationIds([]interface{}(v))
+	case []interface{}:
+		for _, item := range v {
+			ids = append(ids, RelationIds(item)...)
+		}
+	}
+	return
 }
 
 func (d Document) Value(key string) interface{} {
+	// Keys in the form relation.key expand into the values of each related
+	// document
+	if name, subkey, ok := strings.Cut(key, "."); ok {
+		ids := RelationIds(d.Values[name])
+		if len(ids) == 0 {
+			return nil
+		}
+		values := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			if related, ok := d.Related[id]; ok {
+				values = append(values, related.Value(subkey))
+			}
+		}
+		return values
+	}
+
 	switch key {
 	case "id":
 		return d.Id
@@ -50,30 +138,99 @@ type DocumentListParams struct {
 	ClassId primitive.ObjectID
 	Page    int64
 	Size    int64
+	// When set, only documents published at or before this time are listed,
+	// which leaves out drafts
+	PublishedBefore time.Time
+	// When set, only documents located near a point are listed
+	Near NearParams
+	// When set, only documents with a title containing it, ignoring case, are
+	// listed
+	Title string
+}
+
+// Restricts a list to documents whose geo point field lies within Radius
+// meters of Point
+type NearParams struct {
+	Field  string
+	Point  field.GeoPoint
+	Radius float64
+}
+
+func (n NearParams) IsZero() bool {
+	return n.Field == ""
+}
+
+// Reports whether a document value lies within the radius
+func (n NearParams) Contains(value interface{}) bool {
+	point, ok := field.Point(value)
+	return ok && n.Point.Distance(point) <= n.Radius
+}
+
+// Told about every change to a document, such as to call webhooks
+type Notifier interface {
+	Notify(string, Document) error
+}
+
+// Adapts a function to a Notifier
+type NotifierFunc func(string, Document) error
+
+func (fn NotifierFunc) Notify(event string, doc Document) error {
+	return fn(event, doc)
 }
 
 type DocumentRepository interface {
-	DeleteDocument(primitive.ObjectID) error
-	GetChildDocumentBySlug(primitive.ObjectID, string) (Document, error)
-	GetClassDocumentBySlug(primitive.ObjectID, string) (Document, error)
-	GetDocumentList(DocumentListParams) (DocumentList, error)
-	GetDocumentById(primitive.ObjectID) (Document, error)
-	InsertDocument(*Document) error
-	UpdateDocument(*Document) error
+	CountDocumentsByClass(context.Context, time.Time) ([]ClassCount, error)
+	DeleteDocument(context.Context, primitive.ObjectID) error
+	GetChildDocumentBySlug(context.Context, primitive.ObjectID, string) (Document, error)
+	GetClassDocumentBySlug(context.Context, primitive.ObjectID, string) (Document, error)
+	GetDocumentList(context.Context, DocumentListParams) (DocumentList, error)
+	GetDocumentById(context.Context, primitive.ObjectID) (Document, error)
+	GetDocumentsByIds(context.Context, []primitive.ObjectID) ([]Document, error)
+	GetDraftDocuments(context.Context, primitive.ObjectID, int64) ([]Document, error)
+	GetRecentDocuments(context.Context, int64) ([]Document, error)
+	GetReferencingDocuments(context.Context, primitive.ObjectID) ([]Document, error)
+	GetScheduledDocuments(context.Context, time.Time, int64) ([]Document, error)
+	GetTrashedDocuments(context.Context, time.Time) ([]Document, error)
+	InsertDocument(context.Context, *Document) error
+	UpdateDocument(context.Context, *Document) error
+}
+
+// Class lookups the document service needs to enforce relation rules and
+// resolve document links. The class service satisfies this so inherited
+// fields are taken into account.
+type ClassFinder interface {
+	GetById(context.Context, primitive.ObjectID) (class.Class, error)
+	GetBySlug(context.Context, string) (class.Class, error)
 }
 
 type DocumentService interface {
-	Delete(Document) error
-	GetById(primitive.ObjectID) (Document, error)
-	GetChildBySlug(primitive.ObjectID, string) (Document, error)
-	GetClassChildBySlug(primitive.ObjectID, string) (Document, error)
-	Insert(*Document) error
-	List(DocumentListParams) (DocumentList, error)
-	Update(*Document) error
+	Activity(context.Context, primitive.ObjectID, int64) (Activity, error)
+	As(primitive.ObjectID) DocumentService
+	Delete(context.Context, Document) error
+	Events() *event.Bus[Document]
+	Expand(context.Context, []Document) error
+	GetById(context.Context, primitive.ObjectID) (Document, error)
+	GetChildBySlug(context.Context, primitive.ObjectID, string) (Document, error)
+	GetClassChildBySlug(context.Context, primitive.ObjectID, string) (Document, error)
+	Insert(context.Context, *Document) error
+	List(context.Context, DocumentListParams) (DocumentList, error)
+	Path(context.Context, Document) (string, error)
+	Purge(context.Context, time.Time) (int, error)
+	ReferencedBy(context.Context, Document) ([]Document, error)
+	RenderMarkdown(context.Context, class.Class, field.Field, string) string
+	Resolve(context.Context, string) ([]Document, error)
+	Restore(context.Context, Document) error
+	Trash(context.Context, Document, primitive.ObjectID) error
+	Trashed(context.Context) ([]Document, error)
+	Update(context.Context, *Document) error
 }
 
 type documentService struct {
-	repo DocumentRepository
+	repo    DocumentRepository
+	classes ClassFinder
+	events  *event.Bus[Document]
+	// User credited with changes in the audit log
+	actor primitive.ObjectID
 }
 
 func (p DocumentListParams) Offset() (offset int64) {
@@ -83,29 +240,237 @@ func (p DocumentListParams) Offset() (offset int64) {
 	return
 }
 
-func NewDocumentService(repo DocumentRepository) DocumentService {
+// Reports whether a title passes the Title filter
+func (p DocumentListParams) MatchTitle(title string) bool {
+	return strings.Contains(strings.ToLower(title), strings.ToLower(p.Title))
+}
+
+// Changes to documents are published through Events. Audit and Notify
+// subscribe the audit log and notifiers to them.
+func NewDocumentService(repo DocumentRepository, classes ClassFinder) DocumentService {
 	return documentService{
-		repo: repo,
+		repo:    repo,
+		classes: classes,
+		events:  event.NewBus[Document](),
+	}
+}
+
+// Copy of the service attributing changes to the user
+func (s documentService) As(actor primitive.ObjectID) DocumentService {
+	s.actor = actor
+	return s
+}
+
+// Hooks run before and after documents are inserted, updated or deleted.
+// Trashing and restoring are published as updates, as are referrers losing a
+// relation to a deleted document.
+func (s documentService) Events() *event.Bus[Document] {
+	return s.events
+}
+
+// Gives before handlers the chance to change or refuse the document
+func (s documentService) before(op event.Op, doc *Document, previous Document) error {
+	return s.events.PublishBefore(&event.Event[Document]{Op: op, Model: doc, Previous: previous, Actor: s.actor})
+}
+
+// Tells after handlers the change was stored. It is too late to back out, so
+// handler errors are logged rather than returned.
+func (s documentService) after(op event.Op, doc Document, previous Document) {
+	e := &event.Event[Document]{Op: op, Model: &doc, Previous: previous, Actor: s.actor}
+	if err := s.events.PublishAfter(e); err != nil {
+		log.Printf("Document %s: %v", doc.Id.Hex(), err)
+	}
+}
+
+// Permanently deletes the document after applying the delete rule of every
+// relation field pointing at it. Restricted relations prevent the delete
+// entirely.
+func (s documentService) Delete(ctx context.Context, doc Document) (err error) {
+	plan := newDeletePlan()
+	if err = s.planDelete(ctx, doc, plan); err != nil {
+		return
+	}
+
+	// Every document touched is put to the before handlers ahead of the first
+	// write so a veto leaves everything as it was
+	previous := make(map[primitive.ObjectID]Document, len(plan.nullify))
+	for _, id := range plan.order {
+		if plan.remove[id] {
+			removed := plan.removed[id]
+			if err = s.before(event.Delete, &removed, Document{}); err != nil {
+				return
+			}
+			continue
+		}
+		referrer := plan.nullify[id]
+		referrer.References = collectReferences(referrer.Values)
+		if previous[id], err = s.repo.GetDocumentById(ctx, id); err != nil {
+			return
+		}
+		if err = s.before(event.Update, referrer, previous[id]); err != nil {
+			return
+		}
+	}
+
+	for _, id := range plan.order {
+		if plan.remove[id] {
+			continue
+		}
+		referrer := plan.nullify[id]
+		if err = s.repo.UpdateDocument(ctx, referrer); err != nil {
+			return
+		}
+		s.after(event.Update, *referrer, previous[id])
+	}
+
+	for _, id := range plan.order {
+		if plan.remove[id] {
+			if err = s.repo.DeleteDocument(ctx, id); err != nil {
+				return
+			}
+			s.after(event.Delete, plan.removed[id], Document{})
+		}
 	}
+
+	return
 }
 
-func (s documentService) Delete(doc Document) error {
-	return s.repo.DeleteDocument(doc.Id)
+// Everything that changes when a document is deleted: referrers losing the
+// relation and documents removed along with it
+type deletePlan struct {
+	order   []primitive.ObjectID
+	nullify map[primitive.ObjectID]*Document
+	remove  map[primitive.ObjectID]bool
+	removed map[primitive.ObjectID]Document
 }
 
-func (s documentService) GetById(id primitive.ObjectID) (Document, error) {
-	return s.repo.GetDocumentById(id)
+func newDeletePlan() *deletePlan {
+	return &deletePlan{
+		order:   make([]primitive.ObjectID, 0, 8),
+		nullify: make(map[primitive.ObjectID]*Document),
+		remove:  make(map[primitive.ObjectID]bool),
+		removed: make(map[primitive.ObjectID]Document),
+	}
 }
 
-func (s documentService) GetChildBySlug(parentId primitive.ObjectID, slug string) (Document, error) {
-	return s.repo.GetChildDocumentBySlug(parentId, slug)
+// Walks the documents referring to doc and records what their relation rules
+// require. Nothing is written, so a restricted relation anywhere in the chain
+// aborts before any changes are made.
+func (s documentService) planDelete(ctx context.Context, doc Document, plan *deletePlan) (err error) {
+	plan.order = append(plan.order, doc.Id)
+	plan.remove[doc.Id] = true
+	plan.removed[doc.Id] = doc
+
+	referrers, err := s.repo.GetReferencingDocuments(ctx, doc.Id)
+	if err != nil {
+		return
+	}
+
+	for _, referrer := range referrers {
+		if plan.remove[referrer.Id] {
+			continue
+		}
+
+		c, err := s.classes.GetById(ctx, referrer.ClassId)
+		if err != nil {
+			return err
+		}
+
+		// Nullify against the copy already in the plan so a referrer losing
+		// several relations keeps every change. The first time a referrer is
+		// seen its values are copied: the Repository interface does not
+		// promise a fresh map, and a plan aborted by a restricted relation
+		// must leave the referrer as it was found.
+		if pending, ok := plan.nullify[referrer.Id]; ok {
+			referrer = *pending
+		} else {
+			values := make(map[string]interface{}, len(referrer.Values))
+			for k, v := range referrer.Values {
+				values[k] = v
+			}
+			referrer.Values = values
+		}
+
+		cascade, nullify := false, false
+		for _, f := range c.AllFields() {
+			if f.Type != field.TypeRelation || !containsId(RelationIds(referrer.Values[f.Name]), doc.Id) {
+				continue
+			}
+			switch f.DeleteRule() {
+			case field.OnDeleteRestrict:
+				return fmt.Errorf("document %s is referenced by %s through %s", doc.Id.Hex(), referrer.Id.Hex(), f.Name)
+			case field.OnDeleteCascade:
+				cascade = true
+			case field.OnDeleteNullify:
+				nullify = true
+				referrer.Values[f.Name] = removeId(RelationIds(referrer.Values[f.Name]), doc.Id)
+			}
+		}
+
+		switch {
+		case cascade:
+			if err = s.planDelete(ctx, referrer, plan); err != nil {
+				return err
+			}
+		case nullify:
+			if _, ok := plan.nullify[referrer.Id]; !ok {
+				plan.order = append(plan.order, referrer.Id)
+			}
+			pending := referrer
+			plan.nullify[referrer.Id] = &pending
+		}
+	}
+
+	return
 }
 
-func (s documentService) GetClassChildBySlug(classId primitive.ObjectID, slug string) (Document, error) {
-	return s.repo.GetClassDocumentBySlug(classId, slug)
+// Loads every document referenced by the given documents' relation fields so
+// dotted keys resolve in Value
+func (s documentService) Expand(ctx context.Context, docs []Document) (err error) {
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		for _, id := range doc.References {
+			if !containsId(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	related, err := s.repo.GetDocumentsByIds(ctx, ids)
+	if err != nil {
+		return
+	}
+
+	lookup := make(map[primitive.ObjectID]Document, len(related))
+	for _, doc := range related {
+		lookup[doc.Id] = doc
+	}
+	for i := range docs {
+		docs[i].Related = lookup
+	}
+	return
+}
+
+func (s documentService) GetById(ctx context.Context, id primitive.ObjectID) (Document, error) {
+	return s.repo.GetDocumentById(ctx, id)
+}
+
+func (s documentService) GetChildBySlug(ctx context.Context, parentId primitive.ObjectID, slug string) (Document, error) {
+	return s.repo.GetChildDocumentBySlug(ctx, parentId, slug)
 }
 
-func (s documentService) Insert(doc *Document) error {
+func (s documentService) GetClassChildBySlug(ctx context.Context, classId primitive.ObjectID, slug string) (Document, error) {
+	return s.repo.GetClassDocumentBySlug(ctx, classId, slug)
+}
+
+func (s documentService) Insert(ctx context.Context, doc *Document) error {
+	if err := s.before(event.Insert, doc, Document{}); err != nil {
+		return err
+	}
+
 	if err := s.Validate(doc); err != nil {
 		return err
 	}
@@ -115,50 +480,165 @@ func (s documentService) Insert(doc *Document) error {
 	}
 
 	if doc.ParentId.IsZero() {
-		check, err := s.GetClassChildBySlug(doc.ClassId, doc.Slug)
+		check, err := s.GetClassChildBySlug(ctx, doc.ClassId, doc.Slug)
 		if err == nil {
 			return fmt.Errorf("slug %s already exists in %s", doc.Slug, check.Id.Hex())
 		}
 	}
 
 	if !doc.ParentId.IsZero() {
-		check, err := s.GetChildBySlug(doc.ParentId, doc.Slug)
+		check, err := s.GetChildBySlug(ctx, doc.ParentId, doc.Slug)
 		if err == nil {
 			return fmt.Errorf("slug %s already exists in %s", doc.Slug, check.Id.Hex())
 		}
 	}
 
-	return s.repo.InsertDocument(doc)
+	if err := s.prepareValues(ctx, doc); err != nil {
+		return err
+	}
+
+	if err := s.repo.InsertDocument(ctx, doc); err != nil {
+		return err
+	}
+	s.after(event.Insert, *doc, Document{})
+	return nil
 }
 
-func (s documentService) List(params DocumentListParams) (DocumentList, error) {
-	return s.repo.GetDocumentList(params)
+func (s documentService) List(ctx context.Context, params DocumentListParams) (DocumentList, error) {
+	return s.repo.GetDocumentList(ctx, params)
 }
 
-func (s documentService) Update(doc *Document) error {
-	if err := s.Validate(doc); err != nil {
+// Permanently deletes every document trashed before the cutoff. Documents
+// still held by restricted relations are left in the trash.
+func (s documentService) Purge(ctx context.Context, before time.Time) (purged int, err error) {
+	docs, err := s.repo.GetTrashedDocuments(ctx, before)
+	if err != nil {
+		return
+	}
+
+	for _, doc := range docs {
+		if deleteErr := s.Delete(ctx, doc); deleteErr != nil {
+			err = deleteErr
+			continue
+		}
+		purged++
+	}
+	return
+}
+
+func (s documentService) ReferencedBy(ctx context.Context, doc Document) ([]Document, error) {
+	return s.repo.GetReferencingDocuments(ctx, doc.Id)
+}
+
+// Takes the document out of the trash as long as its slug has not been
+// claimed in the meantime
+func (s documentService) Restore(ctx context.Context, doc Document) error {
+	if doc.Deleted.IsZero() {
+		return fmt.Errorf("document %s is not in the trash", doc.Id.Hex())
+	}
+
+	if doc.ParentId.IsZero() {
+		check, err := s.GetClassChildBySlug(ctx, doc.ClassId, doc.Slug)
+		if err == nil && check.Id != doc.Id {
+			return fmt.Errorf("slug %s already exists in %s", doc.Slug, check.Id.Hex())
+		}
+	} else {
+		check, err := s.GetChildBySlug(ctx, doc.ParentId, doc.Slug)
+		if err == nil && check.Id != doc.Id {
+			return fmt.Errorf("slug %s already exists in %s", doc.Slug, check.Id.Hex())
+		}
+	}
+
+	previous := doc
+	doc.Deleted = time.Time{}
+	doc.DeletedBy = primitive.NilObjectID
+	if err := s.before(event.Update, &doc, previous); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateDocument(ctx, &doc); err != nil {
+		return err
+	}
+	s.after(event.Update, doc, previous)
+	return nil
+}
+
+// Moves the document to the trash. The relation rules are checked up front so
+// a document which could never be purged is not trashed.
+func (s documentService) Trash(ctx context.Context, doc Document, userId primitive.ObjectID) error {
+	if !doc.Deleted.IsZero() {
+		return fmt.Errorf("document %s is already in the trash", doc.Id.Hex())
+	}
+
+	if err := s.planDelete(ctx, doc, newDeletePlan()); err != nil {
 		return err
 	}
 
+	if !userId.IsZero() {
+		s.actor = userId
+	}
+
+	previous := doc
+	doc.Deleted = time.Now()
+	doc.DeletedBy = userId
+	if err := s.before(event.Update, &doc, previous); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateDocument(ctx, &doc); err != nil {
+		return err
+	}
+	s.after(event.Update, doc, previous)
+	return nil
+}
+
+func (s documentService) Trashed(ctx context.Context) ([]Document, error) {
+	return s.repo.GetTrashedDocuments(ctx, time.Now())
+}
+
+func (s documentService) Update(ctx context.Context, doc *Document) error {
 	if doc.Id.IsZero() {
 		return fmt.Errorf("document has no ID")
 	}
 
+	previous, err := s.repo.GetDocumentById(ctx, doc.Id)
+	if err != nil {
+		return err
+	}
+
+	if previous.Version != doc.Version {
+		return fmt.Errorf("%w: loaded at version %d, now at %d", ErrConflict, doc.Version, previous.Version)
+	}
+
+	if err = s.before(event.Update, doc, previous); err != nil {
+		return err
+	}
+
+	if err := s.Validate(doc); err != nil {
+		return err
+	}
+
 	if doc.ParentId.IsZero() {
-		check, err := s.GetClassChildBySlug(doc.ClassId, doc.Slug)
+		check, err := s.GetClassChildBySlug(ctx, doc.ClassId, doc.Slug)
 		if err == nil && check.Id != doc.Id {
 			return fmt.Errorf("slug %s already exists in %s", doc.Slug, check.Id.Hex())
 		}
 	}
 
 	if !doc.ParentId.IsZero() {
-		check, err := s.GetChildBySlug(doc.ParentId, doc.Slug)
+		check, err := s.GetChildBySlug(ctx, doc.ParentId, doc.Slug)
 		if err == nil {
 			return fmt.Errorf("slug %s already exists in %s", doc.Slug, check.Id.Hex())
 		}
 	}
 
-	return s.repo.UpdateDocument(doc)
+	if err := s.prepareValues(ctx, doc); err != nil {
+		return err
+	}
+
+	if err = s.repo.UpdateDocument(ctx, doc); err != nil {
+		return err
+	}
+	s.after(event.Update, *doc, previous)
+	return nil
 }
 
 func (s documentService) Validate(doc *Document) (err error) {
@@ -172,3 +652,160 @@ func (s documentService) Validate(doc *Document) (err error) {
 
 	return
 }
+
+// Brings the document values in line with the class fields before saving.
+// Typed values are converted to their stored form, blank slug fields are
+// generated from their source, nested values are checked item by item and
+// relations are verified.
+func (s documentService) prepareValues(ctx context.Context, doc *Document) (err error) {
+	c, err := s.classes.GetById(ctx, doc.ClassId)
+	if err != nil {
+		return
+	}
+
+	if err = convertValues(c, doc); err != nil {
+		return
+	}
+
+	if err = validateNested(c, doc); err != nil {
+		return
+	}
+
+	sanitizeValues(c, doc)
+	doc.References = collectReferences(doc.Values)
+	return s.validateRelations(ctx, c, doc)
+}
+
+func convertValues(c class.Class, doc *Document) (err error) {
+	for _, f := range c.AllFields() {
+		if !f.IsTyped() {
+			continue
+		}
+		if doc.Values == nil {
+			doc.Values = make(map[string]interface{})
+		}
+
+		value := doc.Values[f.Name]
+		if f.Type == field.TypeSlug && (value == nil || value == "") {
+			value = doc.Value(f.SlugSource)
+		}
+
+		if doc.Values[f.Name], err = field.Convert(value, f.Type); err != nil {
+			return fmt.Errorf("%s: %w", f.Label, err)
+		}
+	}
+	return
+}
+
+// Strips disallowed HTML from rich text values before they are stored
+func sanitizeValues(c class.Class, doc *Document) {
+	for _, f := range c.AllFields() {
+		if value, ok := doc.Values[f.Name]; ok {
+			doc.Values[f.Name] = f.SanitizeValue(value)
+		}
+	}
+}
+
+// Checks repeater and group values item by item
+func validateNested(c class.Class, doc *Document) (err error) {
+	for _, f := range c.AllFields() {
+		if !f.IsNested() {
+			continue
+		}
+		if err = f.CheckValue(doc.Values[f.Name]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Ensures every related document exists and belongs to one of the classes
+// the relation field allows
+func (s documentService) validateRelations(ctx context.Context, c class.Class, doc *Document) (err error) {
+	if len(doc.References) == 0 {
+		return
+	}
+
+	for _, f := range c.AllFields() {
+		if f.Type != field.TypeRelation {
+			continue
+		}
+
+		ids := RelationIds(doc.Values[f.Name])
+		if len(ids) == 0 {
+			continue
+		}
+
+		related, err := s.repo.GetDocumentsByIds(ctx, ids)
+		if err != nil {
+			return err
+		}
+		if len(related) != len(ids) {
+			return fmt.Errorf("%s references a document that does not exist", f.Name)
+		}
+		for _, r := range related {
+			if r.Id == doc.Id {
+				return fmt.Errorf("%s cannot reference itself", f.Name)
+			}
+			if len(f.RelationClassIds) > 0 && !containsId(f.RelationClassIds, r.ClassId) {
+				return fmt.Errorf("%s cannot reference documents of class %s", f.Name, r.ClassId.Hex())
+			}
+		}
+	}
+
+	return
+}
+
+// Builds the reverse index from relation values, which are always stored as
+// lists of IDs
+func collectReferences(values map[string]interface{}) (refs []primitive.ObjectID) {
+	for _, value := range values {
+		for _, id := range relationList(value) {
+			if !containsId(refs, id) {
+				refs = append(refs, id)
+			}
+		}
+	}
+	return
+}
+
+// Relation values come from the handler as []primitive.ObjectID and from the
+// repository as arrays of ObjectIDs. Anything else is not a relation.
+func relationList(value interface{}) []primitive.ObjectID {
+	switch v := value.(type) {
+	case []primitive.ObjectID:
+		return v
+	case primitive.A:
+		return relationList([]interface{}(v))
+	case []interface{}:
+		ids := make([]primitive.ObjectID, len(v))
+		for i := range v {
+			id, ok := v[i].(primitive.ObjectID)
+			if !ok {
+				return nil
+			}
+			ids[i] = id
+		}
+		return ids
+	}
+	return nil
+}
+
+func containsId(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, check := range ids {
+		if check == id {
+			return true
+		}
+	}
+	return false
+}
+
+func removeId(ids []primitive.ObjectID, id primitive.ObjectID) []primitive.ObjectID {
+	kept := make([]primitive.ObjectID, 0, len(ids))
+	for _, check := range ids {
+		if check != id {
+			kept = append(kept, check)
+		}
+	}
+	return kept
+}
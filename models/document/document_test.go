@@ -1,10 +1,17 @@
 package document
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/event"
+	"github.com/jbaikge/gocms/models/field"
 	"github.com/zeebo/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -33,6 +40,64 @@ func TestDocumentValue(t *testing.T) {
 	assert.Equal(t, nil, doc.Value("nil"))
 }
 
+func TestDocumentValueRelation(t *testing.T) {
+	author1 := Document{Id: primitive.NewObjectID(), Title: "Author 1"}
+	author2 := Document{Id: primitive.NewObjectID(), Title: "Author 2"}
+	doc := Document{
+		Values: map[string]interface{}{
+			"authors": primitive.A{author1.Id, author2.Id},
+			"plain":   "value",
+		},
+		Related: map[primitive.ObjectID]Document{
+			author1.Id: author1,
+			author2.Id: author2,
+		},
+	}
+	assert.DeepEqual(t, []interface{}{"Author 1", "Author 2"}, doc.Value("authors.title"))
+	assert.Equal(t, nil, doc.Value("plain.title"))
+	assert.Equal(t, nil, doc.Value("missing.title"))
+}
+
+func TestRelationIds(t *testing.T) {
+	id1 := primitive.NewObjectID()
+	id2 := primitive.NewObjectID()
+	expect := []primitive.ObjectID{id1, id2}
+
+	assert.DeepEqual(t, expect, RelationIds(expect))
+	assert.DeepEqual(t, expect, RelationIds(primitive.A{id1, id2}))
+	assert.DeepEqual(t, expect, RelationIds([]string{id1.Hex(), id2.Hex()}))
+	assert.DeepEqual(t, expect[:1], RelationIds(id1.Hex()))
+	assert.Equal(t, 0, len(RelationIds("not an id")))
+	assert.Equal(t, 0, len(RelationIds(nil)))
+}
+
+var _ ClassFinder = mockClassFinder{}
+
+// Classes which were never added resolve to a class without fields, so tests
+// can store documents without setting up a class first
+type mockClassFinder map[primitive.ObjectID]class.Class
+
+func NewMockClassFinder() mockClassFinder {
+	return make(mockClassFinder)
+}
+
+func (f mockClassFinder) GetById(ctx context.Context, id primitive.ObjectID) (c class.Class, err error) {
+	c, ok := f[id]
+	if !ok {
+		c = class.Class{Id: id}
+	}
+	return
+}
+
+func (f mockClassFinder) GetBySlug(ctx context.Context, slug string) (c class.Class, err error) {
+	for _, c = range f {
+		if c.Slug == slug {
+			return
+		}
+	}
+	return class.Class{}, fmt.Errorf("class not found: %s", slug)
+}
+
 var _ DocumentRepository = mockDocumentRepository{}
 
 type mockDocumentRepository struct {
@@ -51,7 +116,23 @@ func NewMockDocumentRepository() mockDocumentRepository {
 	}
 }
 
-func (r mockDocumentRepository) DeleteDocument(id primitive.ObjectID) (err error) {
+func (r mockDocumentRepository) CountDocumentsByClass(ctx context.Context, now time.Time) (counts []ClassCount, err error) {
+	for classId, docs := range r.byClassId {
+		count := ClassCount{ClassId: classId}
+		for _, doc := range docs {
+			count.Total++
+			if doc.IsDraft() {
+				count.Drafts++
+			} else if doc.Published.After(now) {
+				count.Scheduled++
+			}
+		}
+		counts = append(counts, count)
+	}
+	return
+}
+
+func (r mockDocumentRepository) DeleteDocument(ctx context.Context, id primitive.ObjectID) (err error) {
 	doc, ok := r.byId[id]
 	if !ok {
 		// Silent failure
@@ -63,7 +144,7 @@ func (r mockDocumentRepository) DeleteDocument(id primitive.ObjectID) (err error
 	return
 }
 
-func (r mockDocumentRepository) GetDocumentById(id primitive.ObjectID) (doc Document, err error) {
+func (r mockDocumentRepository) GetDocumentById(ctx context.Context, id primitive.ObjectID) (doc Document, err error) {
 	doc, ok := r.byId[id]
 	if !ok {
 		err = fmt.Errorf("document not found: %s", id)
@@ -71,9 +152,9 @@ func (r mockDocumentRepository) GetDocumentById(id primitive.ObjectID) (doc Docu
 	return
 }
 
-func (r mockDocumentRepository) GetChildDocumentBySlug(parentId primitive.ObjectID, slug string) (doc Document, err error) {
+func (r mockDocumentRepository) GetChildDocumentBySlug(ctx context.Context, parentId primitive.ObjectID, slug string) (doc Document, err error) {
 	doc, ok := r.byParentSlug[r.slugKey(parentId, slug)]
-	if ok {
+	if ok && doc.Deleted.IsZero() {
 		return
 	}
 
@@ -81,9 +162,9 @@ func (r mockDocumentRepository) GetChildDocumentBySlug(parentId primitive.Object
 	return
 }
 
-func (r mockDocumentRepository) GetClassDocumentBySlug(classId primitive.ObjectID, slug string) (doc Document, err error) {
+func (r mockDocumentRepository) GetClassDocumentBySlug(ctx context.Context, classId primitive.ObjectID, slug string) (doc Document, err error) {
 	doc, ok := r.byClassSlug[r.slugKey(classId, slug)]
-	if ok {
+	if ok && doc.Deleted.IsZero() {
 		return
 	}
 
@@ -91,7 +172,7 @@ func (r mockDocumentRepository) GetClassDocumentBySlug(classId primitive.ObjectI
 	return
 }
 
-func (r mockDocumentRepository) GetDocumentList(params DocumentListParams) (list DocumentList, err error) {
+func (r mockDocumentRepository) GetDocumentList(ctx context.Context, params DocumentListParams) (list DocumentList, err error) {
 	docs, ok := r.byClassId[params.ClassId]
 	if !ok {
 		err = fmt.Errorf("no documents for class: %s", params.ClassId.Hex())
@@ -113,7 +194,63 @@ func (r mockDocumentRepository) GetDocumentList(params DocumentListParams) (list
 	return
 }
 
-func (r mockDocumentRepository) InsertDocument(doc *Document) (err error) {
+func (r mockDocumentRepository) GetDocumentsByIds(ctx context.Context, ids []primitive.ObjectID) (docs []Document, err error) {
+	for _, id := range ids {
+		if doc, ok := r.byId[id]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+func (r mockDocumentRepository) GetDraftDocuments(ctx context.Context, userId primitive.ObjectID, limit int64) (docs []Document, err error) {
+	for _, doc := range r.byId {
+		if doc.IsDraft() && doc.CreatedBy == userId && int64(len(docs)) < limit {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+func (r mockDocumentRepository) GetRecentDocuments(ctx context.Context, limit int64) (docs []Document, err error) {
+	for _, doc := range r.byId {
+		if int64(len(docs)) < limit {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+func (r mockDocumentRepository) GetScheduledDocuments(ctx context.Context, now time.Time, limit int64) (docs []Document, err error) {
+	for _, doc := range r.byId {
+		if doc.Published.After(now) && int64(len(docs)) < limit {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+func (r mockDocumentRepository) GetReferencingDocuments(ctx context.Context, id primitive.ObjectID) (docs []Document, err error) {
+	for _, doc := range r.byId {
+		for _, ref := range doc.References {
+			if ref == id {
+				docs = append(docs, doc)
+			}
+		}
+	}
+	return
+}
+
+func (r mockDocumentRepository) GetTrashedDocuments(ctx context.Context, before time.Time) (docs []Document, err error) {
+	for _, doc := range r.byId {
+		if !doc.Deleted.IsZero() && doc.Deleted.Before(before) {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+func (r mockDocumentRepository) InsertDocument(ctx context.Context, doc *Document) (err error) {
 	doc.Id = primitive.NewObjectID()
 	r.byId[doc.Id] = *doc
 	r.byClassSlug[r.slugKey(doc.ClassId, doc.Slug)] = *doc
@@ -125,10 +262,11 @@ func (r mockDocumentRepository) InsertDocument(doc *Document) (err error) {
 	return
 }
 
-func (r mockDocumentRepository) UpdateDocument(doc *Document) (err error) {
-	if err = r.DeleteDocument(doc.Id); err != nil {
+func (r mockDocumentRepository) UpdateDocument(ctx context.Context, doc *Document) (err error) {
+	if err = r.DeleteDocument(ctx, doc.Id); err != nil {
 		return
 	}
+	doc.Version++
 	r.byId[doc.Id] = *doc
 	r.byClassSlug[r.slugKey(doc.ClassId, doc.Slug)] = *doc
 	r.byParentSlug[r.slugKey(doc.ParentId, doc.Slug)] = *doc
@@ -139,42 +277,292 @@ func (r mockDocumentRepository) slugKey(id primitive.ObjectID, slug string) stri
 	return id.Hex() + "_" + slug
 }
 
+// Keeps every entry recorded by a service
+type mockRecorder struct {
+	entries []audit.Entry
+}
+
+func (r *mockRecorder) Record(e audit.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	recorder := &mockRecorder{}
+	classes := NewMockClassFinder()
+	userId := primitive.NewObjectID()
+	service := NewDocumentService(NewMockDocumentRepository(), classes).As(userId)
+	Audit(service.Events(), recorder)
+
+	authors := class.Class{Id: primitive.NewObjectID()}
+	classes[authors.Id] = authors
+	posts := class.Class{
+		Id: primitive.NewObjectID(),
+		Fields: []field.Field{
+			{
+				Name:             "author",
+				Type:             field.TypeRelation,
+				RelationClassIds: []primitive.ObjectID{authors.Id},
+				OnDelete:         field.OnDeleteNullify,
+			},
+		},
+	}
+	classes[posts.Id] = posts
+
+	author := Document{ClassId: authors.Id, Title: "Author", Slug: "author"}
+	assert.NoError(t, service.Insert(ctx, &author))
+	post := Document{ClassId: posts.Id, Title: "Post", Slug: "post", Values: map[string]interface{}{"author": []primitive.ObjectID{author.Id}}}
+	assert.NoError(t, service.Insert(ctx, &post))
+
+	author.Title = "Renamed"
+	assert.NoError(t, service.Update(ctx, &author))
+	trasher := primitive.NewObjectID()
+	assert.NoError(t, service.Trash(ctx, author, trasher))
+	trashed, err := service.GetById(ctx, author.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, service.Restore(ctx, trashed))
+	assert.NoError(t, service.Delete(ctx, author))
+
+	expect := []struct {
+		action  string
+		target  primitive.ObjectID
+		actor   primitive.ObjectID
+		summary string
+	}{
+		{audit.ActionCreate, author.Id, userId, `created document "Author"`},
+		{audit.ActionCreate, post.Id, userId, `created document "Post"`},
+		{audit.ActionUpdate, author.Id, userId, `updated document "Renamed"`},
+		{audit.ActionTrash, author.Id, trasher, `trashed document "Renamed"`},
+		{audit.ActionRestore, author.Id, userId, `restored document "Renamed"`},
+		// Nullified referrers are updated before the document is removed
+		{audit.ActionUpdate, post.Id, userId, `updated document "Post"`},
+		{audit.ActionDelete, author.Id, userId, `deleted document "Renamed"`},
+	}
+	assert.Equal(t, len(expect), len(recorder.entries))
+	for i, e := range expect {
+		entry := recorder.entries[i]
+		assert.Equal(t, e.action, entry.Action)
+		assert.Equal(t, e.target, entry.TargetId)
+		assert.Equal(t, e.actor, entry.ActorId)
+		assert.Equal(t, e.summary, entry.Summary)
+		assert.Equal(t, audit.TargetDocument, entry.TargetType)
+	}
+	assert.DeepEqual(t, []string{"title"}, recorder.entries[2].Changes)
+	assert.DeepEqual(t, []string{"values.author", "references"}, recorder.entries[5].Changes)
+	assert.Equal(t, posts.Id, recorder.entries[5].ClassId)
+}
+
+func TestNotify(t *testing.T) {
+	ctx := context.Background()
+	var events []string
+	notifier := NotifierFunc(func(event string, doc Document) error {
+		events = append(events, event+" "+doc.Slug)
+		return nil
+	})
+	failing := NotifierFunc(func(string, Document) error {
+		return errors.New("unreachable")
+	})
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
+	Notify(service.Events(), notifier)
+	classId := primitive.NewObjectID()
+
+	draft := Document{ClassId: classId, Slug: "draft"}
+	assert.NoError(t, service.Insert(ctx, &draft))
+	live := Document{ClassId: classId, Slug: "live", Published: time.Now().Add(-time.Minute)}
+	assert.NoError(t, service.Insert(ctx, &live))
+	scheduled := Document{ClassId: classId, Slug: "scheduled", Published: time.Now().Add(time.Hour)}
+	assert.NoError(t, service.Insert(ctx, &scheduled))
+	assert.DeepEqual(t, []string{
+		"document.created draft",
+		"document.created live",
+		"document.published live",
+		"document.created scheduled",
+	}, events)
+
+	t.Run("Update", func(t *testing.T) {
+		events = nil
+		draft.Published = time.Now()
+		assert.NoError(t, service.Update(ctx, &draft))
+		draft.Title = "Edited"
+		assert.NoError(t, service.Update(ctx, &draft))
+		assert.DeepEqual(t, []string{
+			"document.updated draft",
+			"document.published draft",
+			"document.updated draft",
+		}, events)
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		events = nil
+		assert.NoError(t, service.Trash(ctx, live, primitive.NewObjectID()))
+		trashed, err := service.GetById(ctx, live.Id)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Restore(ctx, trashed))
+		assert.NoError(t, service.Trash(ctx, live, primitive.NewObjectID()))
+		trashed, err = service.GetById(ctx, live.Id)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Delete(ctx, trashed))
+		assert.NoError(t, service.Delete(ctx, scheduled))
+		assert.DeepEqual(t, []string{
+			"document.deleted live",
+			"document.updated live",
+			"document.deleted live",
+			"document.deleted scheduled",
+		}, events)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		events = nil
+		service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
+		Notify(service.Events(), failing, notifier)
+		doc := Document{ClassId: classId, Slug: "failure"}
+		// The document is stored, so the failure is only logged and later
+		// notifiers still hear about it
+		assert.NoError(t, service.Insert(ctx, &doc))
+		assert.False(t, doc.Id.IsZero())
+		assert.DeepEqual(t, []string{"document.created failure"}, events)
+	})
+}
+
+func TestEvents(t *testing.T) {
+	ctx := context.Background()
+	classes := NewMockClassFinder()
+	service := NewDocumentService(NewMockDocumentRepository(), classes)
+
+	authors := class.Class{Id: primitive.NewObjectID()}
+	classes[authors.Id] = authors
+	posts := class.Class{
+		Id: primitive.NewObjectID(),
+		Fields: []field.Field{
+			{
+				Name:             "author",
+				Type:             field.TypeRelation,
+				RelationClassIds: []primitive.ObjectID{authors.Id},
+				OnDelete:         field.OnDeleteNullify,
+			},
+		},
+	}
+	classes[posts.Id] = posts
+
+	var seen []string
+	for _, op := range []event.Op{event.Insert, event.Update, event.Delete} {
+		service.Events().After(op, func(e *event.Event[Document]) error {
+			seen = append(seen, fmt.Sprintf("%s %s %s", e.Op, e.Model.Slug, e.Previous.Title))
+			return nil
+		})
+	}
+	service.Events().Before(event.Insert, func(e *event.Event[Document]) error {
+		e.Model.Title = strings.ToUpper(e.Model.Slug[:1]) + e.Model.Slug[1:]
+		return nil
+	})
+
+	author := Document{ClassId: authors.Id, Slug: "author"}
+	assert.NoError(t, service.Insert(ctx, &author))
+	assert.Equal(t, "Author", author.Title)
+	post := Document{ClassId: posts.Id, Slug: "post", Values: map[string]interface{}{"author": []primitive.ObjectID{author.Id}}}
+	assert.NoError(t, service.Insert(ctx, &post))
+
+	t.Run("Veto", func(t *testing.T) {
+		// Refusing the referrer's update stops the delete before anything is
+		// written
+		unsubscribe := service.Events().Before(event.Update, func(e *event.Event[Document]) error {
+			return fmt.Errorf("%s is locked", e.Model.Slug)
+		})
+		err := service.Delete(ctx, author)
+		unsubscribe()
+		assert.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), "post is locked"))
+		_, err = service.GetById(ctx, author.Id)
+		assert.NoError(t, err)
+		stored, err := service.GetById(ctx, post.Id)
+		assert.NoError(t, err)
+		assert.DeepEqual(t, []primitive.ObjectID{author.Id}, stored.Values["author"])
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		seen = nil
+		assert.NoError(t, service.Delete(ctx, author))
+		assert.DeepEqual(t, []string{
+			"update post Post",
+			"delete author ",
+		}, seen)
+	})
+}
+
+func TestActivity(t *testing.T) {
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
+
+	classId := primitive.NewObjectID()
+	userId := primitive.NewObjectID()
+	docs := []Document{
+		{ClassId: classId, Slug: "live", Published: time.Now().Add(-time.Hour)},
+		{ClassId: classId, Slug: "later", Published: time.Now().Add(time.Hour)},
+		{ClassId: classId, Slug: "mine", CreatedBy: userId},
+		{ClassId: classId, Slug: "theirs", CreatedBy: primitive.NewObjectID()},
+	}
+	for i := range docs {
+		assert.NoError(t, service.Insert(ctx, &docs[i]))
+	}
+
+	activity, err := service.Activity(ctx, userId, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(activity.Counts))
+	assert.Equal(t, int64(4), activity.Counts[0].Total)
+	assert.Equal(t, int64(1), activity.Counts[0].Published())
+	assert.Equal(t, int64(1), activity.Counts[0].Scheduled)
+	assert.Equal(t, int64(2), activity.Counts[0].Drafts)
+	assert.Equal(t, 4, len(activity.Recent))
+	assert.Equal(t, 1, len(activity.Scheduled))
+	assert.Equal(t, "later", activity.Scheduled[0].Slug)
+	assert.Equal(t, 1, len(activity.Drafts))
+	assert.Equal(t, "mine", activity.Drafts[0].Slug)
+
+	activity, err = service.Activity(ctx, userId, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(activity.Recent))
+}
+
 func TestGetById(t *testing.T) {
-	service := NewDocumentService(NewMockDocumentRepository())
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
 
 	doc := Document{ClassId: primitive.NewObjectID(), Slug: "test"}
-	assert.NoError(t, service.Insert(&doc))
+	assert.NoError(t, service.Insert(ctx, &doc))
 
-	check, err := service.GetById(doc.Id)
+	check, err := service.GetById(ctx, doc.Id)
 	assert.NoError(t, err)
 	assert.Equal(t, doc.Id, check.Id)
 }
 
 func TestGetBySlug(t *testing.T) {
-	service := NewDocumentService(NewMockDocumentRepository())
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
 
 	doc := Document{
 		ClassId:  primitive.NewObjectID(),
 		ParentId: primitive.NewObjectID(),
 		Slug:     "test",
 	}
-	assert.NoError(t, service.Insert(&doc))
+	assert.NoError(t, service.Insert(ctx, &doc))
 
 	t.Run("Class ID", func(t *testing.T) {
-		byClass, err := service.GetClassChildBySlug(doc.ClassId, doc.Slug)
+		byClass, err := service.GetClassChildBySlug(ctx, doc.ClassId, doc.Slug)
 		assert.NoError(t, err)
 		assert.Equal(t, doc.Id, byClass.Id)
 	})
 
 	t.Run("Parent ID", func(t *testing.T) {
-		byParent, err := service.GetChildBySlug(doc.ParentId, doc.Slug)
+		byParent, err := service.GetChildBySlug(ctx, doc.ParentId, doc.Slug)
 		assert.NoError(t, err)
 		assert.Equal(t, doc.Id, byParent.Id)
 	})
 }
 
 func TestInsert(t *testing.T) {
-	service := NewDocumentService(NewMockDocumentRepository())
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
 	classId := primitive.NewObjectID()
 	parentId := primitive.NewObjectID()
 
@@ -244,7 +632,7 @@ func TestInsert(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			err := service.Insert(&test.Document)
+			err := service.Insert(ctx, &test.Document)
 			if test.Error {
 				assert.Error(t, err)
 			} else {
@@ -255,20 +643,21 @@ func TestInsert(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	service := NewDocumentService(NewMockDocumentRepository())
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
 
 	t.Run("No ID", func(t *testing.T) {
 		doc := Document{ClassId: primitive.NewObjectID(), Slug: "test"}
-		assert.Error(t, service.Update(&doc))
+		assert.Error(t, service.Update(ctx, &doc))
 	})
 
 	classId := primitive.NewObjectID()
 
 	banana := Document{ClassId: classId, Slug: "banana"}
-	assert.NoError(t, service.Insert(&banana))
+	assert.NoError(t, service.Insert(ctx, &banana))
 
 	orange := Document{ClassId: classId, Slug: "orange"}
-	assert.NoError(t, service.Insert(&orange))
+	assert.NoError(t, service.Insert(ctx, &orange))
 
 	t.Run("Blank Slug", func(t *testing.T) {
 		banana.Slug = ""
@@ -276,7 +665,7 @@ func TestUpdate(t *testing.T) {
 			banana.Slug = "banana"
 		}()
 
-		assert.Error(t, service.Update(&banana))
+		assert.Error(t, service.Update(ctx, &banana))
 	})
 
 	t.Run("Class Slug Takeover", func(t *testing.T) {
@@ -285,7 +674,7 @@ func TestUpdate(t *testing.T) {
 			banana.Slug = "banana"
 		}()
 
-		assert.Error(t, service.Update(&banana))
+		assert.Error(t, service.Update(ctx, &banana))
 	})
 
 	t.Run("New Class, Dupe Slug", func(t *testing.T) {
@@ -296,7 +685,7 @@ func TestUpdate(t *testing.T) {
 			banana.Slug = "banana"
 		}()
 
-		assert.NoError(t, service.Update(&banana))
+		assert.NoError(t, service.Update(ctx, &banana))
 	})
 
 	t.Run("Same Parent, Slug Frob", func(t *testing.T) {
@@ -308,30 +697,105 @@ func TestUpdate(t *testing.T) {
 			orange.Slug = "orange"
 		}()
 
-		assert.NoError(t, service.Update(&banana))
-		assert.NoError(t, service.Update(&orange))
+		assert.NoError(t, service.Update(ctx, &banana))
+		assert.NoError(t, service.Update(ctx, &orange))
 
 		orange.Slug = banana.Slug
-		assert.Error(t, service.Update(&orange))
+		assert.Error(t, service.Update(ctx, &orange))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		lime := Document{ClassId: classId, Slug: "lime"}
+		assert.NoError(t, service.Insert(ctx, &lime))
+		stale := lime
+
+		lime.Title = "Lime"
+		assert.NoError(t, service.Update(ctx, &lime))
+		stale.Title = "Key Lime"
+		assert.True(t, errors.Is(service.Update(ctx, &stale), ErrConflict))
+
+		stored, err := service.GetById(ctx, lime.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "Lime", stored.Title)
 	})
 }
 
 func TestDelete(t *testing.T) {
-	service := NewDocumentService(NewMockDocumentRepository())
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
 
 	doc := Document{ClassId: primitive.NewObjectID(), Slug: "test"}
-	assert.NoError(t, service.Insert(&doc))
-	assert.NoError(t, service.Delete(doc))
+	assert.NoError(t, service.Insert(ctx, &doc))
+	assert.NoError(t, service.Delete(ctx, doc))
 	// Do it once more to make sure it fails silently
-	assert.NoError(t, service.Delete(doc))
+	assert.NoError(t, service.Delete(ctx, doc))
 
 	// Make sure document no longer exists
-	_, err := service.GetById(doc.Id)
+	_, err := service.GetById(ctx, doc.Id)
 	assert.Error(t, err)
 }
 
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
+	userId := primitive.NewObjectID()
+
+	doc := Document{ClassId: primitive.NewObjectID(), Slug: "trash"}
+	assert.NoError(t, service.Insert(ctx, &doc))
+	assert.NoError(t, service.Trash(ctx, doc, userId))
+
+	trashed, err := service.GetById(ctx, doc.Id)
+	assert.NoError(t, err)
+	assert.False(t, trashed.Deleted.IsZero())
+	assert.Equal(t, userId, trashed.DeletedBy)
+	assert.Error(t, service.Trash(ctx, trashed, userId))
+
+	// The slug is free while the document is in the trash
+	_, err = service.GetClassChildBySlug(ctx, doc.ClassId, doc.Slug)
+	assert.Error(t, err)
+
+	list, err := service.Trashed(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	t.Run("Restore", func(t *testing.T) {
+		assert.NoError(t, service.Restore(ctx, trashed))
+		restored, err := service.GetClassChildBySlug(ctx, doc.ClassId, doc.Slug)
+		assert.NoError(t, err)
+		assert.True(t, restored.Deleted.IsZero())
+		assert.Error(t, service.Restore(ctx, restored))
+	})
+
+	t.Run("Restore Slug Taken", func(t *testing.T) {
+		restored, err := service.GetById(ctx, doc.Id)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Trash(ctx, restored, userId))
+
+		taken := Document{ClassId: doc.ClassId, Slug: doc.Slug}
+		assert.NoError(t, service.Insert(ctx, &taken))
+
+		trashed, err := service.GetById(ctx, doc.Id)
+		assert.NoError(t, err)
+		assert.Error(t, service.Restore(ctx, trashed))
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		purged, err := service.Purge(ctx, time.Now().Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, purged)
+
+		purged, err = service.Purge(ctx, time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+
+		_, err = service.GetById(ctx, doc.Id)
+		assert.Error(t, err)
+	})
+}
+
 func TestList(t *testing.T) {
-	service := NewDocumentService(NewMockDocumentRepository())
+	ctx := context.Background()
+	service := NewDocumentService(NewMockDocumentRepository(), NewMockClassFinder())
 
 	classId := primitive.NewObjectID()
 	ids := make([]primitive.ObjectID, 3)
@@ -341,7 +805,7 @@ func TestList(t *testing.T) {
 			ClassId: classId,
 			Slug:    fmt.Sprintf("test_%d", i),
 		}
-		assert.NoError(t, service.Insert(&doc))
+		assert.NoError(t, service.Insert(ctx, &doc))
 		ids[i] = doc.Id
 	}
 
@@ -350,7 +814,7 @@ func TestList(t *testing.T) {
 		Size:    2,
 		Page:    1,
 	}
-	page1, err := service.List(params)
+	page1, err := service.List(ctx, params)
 	assert.NoError(t, err)
 	assert.Equal(t, 3, page1.Total)
 	assert.Equal(t, 2, len(page1.Documents))
@@ -359,7 +823,7 @@ func TestList(t *testing.T) {
 	}
 
 	params.Page = 2
-	page2, err := service.List(params)
+	page2, err := service.List(ctx, params)
 	assert.NoError(t, err)
 	assert.Equal(t, 3, page2.Total)
 	assert.Equal(t, 1, len(page2.Documents))
@@ -367,3 +831,336 @@ func TestList(t *testing.T) {
 		assert.Equal(t, ids[i], page1.Documents[i].Id)
 	}
 }
+
+func TestRelations(t *testing.T) {
+	ctx := context.Background()
+	classes := NewMockClassFinder()
+	service := NewDocumentService(NewMockDocumentRepository(), classes)
+
+	authors := class.Class{Id: primitive.NewObjectID()}
+	classes[authors.Id] = authors
+
+	newPosts := func(rule string) class.Class {
+		c := class.Class{
+			Id: primitive.NewObjectID(),
+			Fields: []field.Field{
+				{
+					Name:             "authors",
+					Type:             field.TypeRelation,
+					RelationClassIds: []primitive.ObjectID{authors.Id},
+					OnDelete:         rule,
+				},
+			},
+		}
+		classes[c.Id] = c
+		return c
+	}
+
+	newAuthor := func(slug string) Document {
+		author := Document{ClassId: authors.Id, Slug: slug}
+		assert.NoError(t, service.Insert(ctx, &author))
+		return author
+	}
+
+	newPost := func(c class.Class, slug string, related ...primitive.ObjectID) Document {
+		post := Document{
+			ClassId: c.Id,
+			Slug:    slug,
+			Values:  map[string]interface{}{"authors": related},
+		}
+		assert.NoError(t, service.Insert(ctx, &post))
+		return post
+	}
+
+	t.Run("References", func(t *testing.T) {
+		posts := newPosts(field.OnDeleteRestrict)
+		a, b := newAuthor("ref_a"), newAuthor("ref_b")
+		post := newPost(posts, "references", a.Id, b.Id, a.Id)
+		assert.DeepEqual(t, []primitive.ObjectID{a.Id, b.Id}, post.References)
+
+		referrers, err := service.ReferencedBy(ctx, a)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(referrers))
+		assert.Equal(t, post.Id, referrers[0].Id)
+	})
+
+	t.Run("Wrong Class", func(t *testing.T) {
+		posts := newPosts(field.OnDeleteRestrict)
+		other := newPost(posts, "wrong_class_target")
+		post := Document{
+			ClassId: posts.Id,
+			Slug:    "wrong_class",
+			Values:  map[string]interface{}{"authors": []primitive.ObjectID{other.Id}},
+		}
+		assert.Error(t, service.Insert(ctx, &post))
+	})
+
+	t.Run("Missing Target", func(t *testing.T) {
+		posts := newPosts(field.OnDeleteRestrict)
+		post := Document{
+			ClassId: posts.Id,
+			Slug:    "missing_target",
+			Values:  map[string]interface{}{"authors": []primitive.ObjectID{primitive.NewObjectID()}},
+		}
+		assert.Error(t, service.Insert(ctx, &post))
+	})
+
+	t.Run("Restrict", func(t *testing.T) {
+		posts := newPosts(field.OnDeleteRestrict)
+		author := newAuthor("restrict")
+		newPost(posts, "restrict", author.Id)
+
+		assert.Error(t, service.Delete(ctx, author))
+		assert.Error(t, service.Trash(ctx, author, primitive.NewObjectID()))
+		check, err := service.GetById(ctx, author.Id)
+		assert.NoError(t, err)
+		assert.True(t, check.Deleted.IsZero())
+	})
+
+	t.Run("Nullify", func(t *testing.T) {
+		posts := newPosts(field.OnDeleteNullify)
+		a, b := newAuthor("nullify_a"), newAuthor("nullify_b")
+		post := newPost(posts, "nullify", a.Id, b.Id)
+
+		assert.NoError(t, service.Delete(ctx, a))
+		check, err := service.GetById(ctx, post.Id)
+		assert.NoError(t, err)
+		assert.DeepEqual(t, []primitive.ObjectID{b.Id}, check.Values["authors"])
+		assert.DeepEqual(t, []primitive.ObjectID{b.Id}, check.References)
+	})
+
+	t.Run("Cascade", func(t *testing.T) {
+		posts := newPosts(field.OnDeleteCascade)
+		author := newAuthor("cascade")
+		post := newPost(posts, "cascade", author.Id)
+
+		assert.NoError(t, service.Delete(ctx, author))
+		_, err := service.GetById(ctx, post.Id)
+		assert.Error(t, err)
+	})
+
+	t.Run("Expand", func(t *testing.T) {
+		posts := newPosts(field.OnDeleteRestrict)
+		author := Document{ClassId: authors.Id, Slug: "expand", Title: "Expanded"}
+		assert.NoError(t, service.Insert(ctx, &author))
+		post := newPost(posts, "expand", author.Id)
+
+		docs := []Document{post}
+		assert.NoError(t, service.Expand(ctx, docs))
+		assert.DeepEqual(t, []interface{}{"Expanded"}, docs[0].Value("authors.title"))
+	})
+}
+
+func TestNestedValues(t *testing.T) {
+	ctx := context.Background()
+	classes := NewMockClassFinder()
+	service := NewDocumentService(NewMockDocumentRepository(), classes)
+
+	faq := class.Class{
+		Id: primitive.NewObjectID(),
+		Fields: []field.Field{
+			{
+				Name: "faq",
+				Type: field.TypeRepeater,
+				Max:  "2",
+				Fields: []field.Field{
+					{Name: "question", Label: "Question", Type: field.TypeText},
+					{Name: "asked", Label: "Asked", Type: field.TypeDate},
+				},
+			},
+		},
+	}
+	classes[faq.Id] = faq
+
+	doc := Document{
+		ClassId: faq.Id,
+		Slug:    "faq",
+		Values: map[string]interface{}{
+			"faq": []map[string]interface{}{
+				{"question": "Why?", "asked": "2022-04-14"},
+			},
+		},
+	}
+	assert.NoError(t, service.Insert(ctx, &doc))
+
+	t.Run("Bad Item", func(t *testing.T) {
+		doc.Values = map[string]interface{}{
+			"faq": []map[string]interface{}{
+				{"question": "Why?", "asked": "2022-04-14"},
+				{"question": "When?", "asked": "yesterday"},
+			},
+		}
+		assert.Error(t, service.Update(ctx, &doc))
+	})
+
+	t.Run("Too Many", func(t *testing.T) {
+		doc.Values = map[string]interface{}{
+			"faq": []map[string]interface{}{{}, {}, {}},
+		}
+		assert.Error(t, service.Update(ctx, &doc))
+	})
+}
+
+func TestTypedValues(t *testing.T) {
+	ctx := context.Background()
+	classes := NewMockClassFinder()
+	service := NewDocumentService(NewMockDocumentRepository(), classes)
+
+	links := class.Class{
+		Id: primitive.NewObjectID(),
+		Fields: []field.Field{
+			{Name: "featured", Label: "Featured", Type: field.TypeBoolean},
+			{Name: "link", Label: "Link", Type: field.TypeURL},
+			{Name: "accent", Label: "Accent", Type: field.TypeColor},
+			{Name: "path", Label: "Path", Type: field.TypeSlug, SlugSource: "title"},
+			{Name: "data", Label: "Data", Type: field.TypeJSON},
+		},
+	}
+	classes[links.Id] = links
+
+	doc := Document{
+		ClassId: links.Id,
+		Slug:    "typed",
+		Title:   "Hello World",
+		Values: map[string]interface{}{
+			"featured": "on",
+			"link":     "https://example.com",
+			"accent":   "#FFF",
+			"data":     `{"a":1}`,
+		},
+	}
+	assert.NoError(t, service.Insert(ctx, &doc))
+
+	check, err := service.GetById(ctx, doc.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, true, check.Values["featured"])
+	assert.Equal(t, "#ffffff", check.Values["accent"])
+	assert.Equal(t, "hello_world", check.Values["path"])
+	assert.Equal(t, "{\n  \"a\": 1\n}", check.Values["data"])
+
+	t.Run("Slug Kept", func(t *testing.T) {
+		doc.Values["path"] = "custom path"
+		assert.NoError(t, service.Update(ctx, &doc))
+		assert.Equal(t, "custom_path", doc.Values["path"])
+	})
+
+	t.Run("Unchecked", func(t *testing.T) {
+		delete(doc.Values, "featured")
+		assert.NoError(t, service.Update(ctx, &doc))
+		assert.Equal(t, false, doc.Values["featured"])
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		doc.Values["link"] = "not a url"
+		assert.Error(t, service.Update(ctx, &doc))
+	})
+}
+
+func TestSanitizedValues(t *testing.T) {
+	ctx := context.Background()
+	classes := NewMockClassFinder()
+	service := NewDocumentService(NewMockDocumentRepository(), classes)
+
+	article := class.Class{
+		Id: primitive.NewObjectID(),
+		Fields: []field.Field{
+			{Name: "body", Label: "Body", Type: field.TypeTinyMCE},
+			{Name: "summary", Label: "Summary", Type: field.TypeTextArea, AllowedHTML: "em"},
+			{Name: "notes", Label: "Notes", Type: field.TypeTextArea},
+		},
+	}
+	classes[article.Id] = article
+
+	doc := Document{
+		ClassId: article.Id,
+		Slug:    "article",
+		Values: map[string]interface{}{
+			"body":    `<p onclick="steal()">Hello</p><script>steal()</script>`,
+			"summary": `<em>Short</em> <a href="/">summary</a>`,
+			"notes":   `<b>kept</b>`,
+		},
+	}
+	assert.NoError(t, service.Insert(ctx, &doc))
+
+	check, err := service.GetById(ctx, doc.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>Hello</p>", check.Values["body"])
+	assert.Equal(t, "<em>Short</em> summary", check.Values["summary"])
+	assert.Equal(t, "<b>kept</b>", check.Values["notes"])
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	ctx := context.Background()
+	classes := NewMockClassFinder()
+	service := NewDocumentService(NewMockDocumentRepository(), classes)
+
+	pages := class.Class{Id: primitive.NewObjectID(), Slug: "pages"}
+	news := class.Class{Id: primitive.NewObjectID(), Slug: "news"}
+	classes[pages.Id] = pages
+	classes[news.Id] = news
+
+	about := Document{ClassId: pages.Id, Slug: "about"}
+	assert.NoError(t, service.Insert(ctx, &about))
+	launch := Document{ClassId: news.Id, Slug: "launch"}
+	assert.NoError(t, service.Insert(ctx, &launch))
+
+	f := field.Field{Name: "body", Type: field.TypeMarkdown}
+	source := "[About](doc:about), [Launch](doc:news/launch), [Gone](doc:news/gone) and [Nowhere](doc:nowhere/about)"
+	expect := `<p><a href="/pages/about">About</a>, <a href="/news/launch">Launch</a>, Gone and Nowhere</p>` + "\n"
+	assert.Equal(t, expect, service.RenderMarkdown(ctx, pages, f, source))
+}
+
+func TestPath(t *testing.T) {
+	ctx := context.Background()
+	classes := NewMockClassFinder()
+	service := NewDocumentService(NewMockDocumentRepository(), classes)
+
+	pages := class.Class{Id: primitive.NewObjectID(), Slug: "pages"}
+	sections := class.Class{Id: primitive.NewObjectID(), Slug: "sections"}
+	fieldset := class.Class{Id: primitive.NewObjectID(), Slug: "common", Fieldset: true}
+	classes[pages.Id] = pages
+	classes[sections.Id] = sections
+	classes[fieldset.Id] = fieldset
+
+	about := Document{ClassId: pages.Id, Slug: "about"}
+	assert.NoError(t, service.Insert(ctx, &about))
+	team := Document{ClassId: sections.Id, ParentId: about.Id, Slug: "team"}
+	assert.NoError(t, service.Insert(ctx, &team))
+	history := Document{ClassId: sections.Id, ParentId: team.Id, Slug: "history"}
+	assert.NoError(t, service.Insert(ctx, &history))
+
+	t.Run("Path", func(t *testing.T) {
+		path, err := service.Path(ctx, about)
+		assert.NoError(t, err)
+		assert.Equal(t, "/pages/about", path)
+
+		path, err = service.Path(ctx, history)
+		assert.NoError(t, err)
+		assert.Equal(t, "/pages/about/team/history", path)
+	})
+
+	t.Run("Resolve", func(t *testing.T) {
+		docs, err := service.Resolve(ctx, "/pages/about/team/history/")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(docs))
+		assert.Equal(t, about.Id, docs[0].Id)
+		assert.Equal(t, team.Id, docs[1].Id)
+		assert.Equal(t, history.Id, docs[2].Id)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		for _, path := range []string{
+			"/",
+			"/pages",
+			"/pages/missing",
+			"/missing/about",
+			"/common/about",
+			"/sections/team",
+			"/pages/about/history",
+			"/pages/about/team/missing",
+		} {
+			_, err := service.Resolve(ctx, path)
+			assert.True(t, errors.Is(err, ErrNotFound))
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package document
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/field"
+)
+
+// Renders the Markdown source of a field. Document links without a class
+// resolve within c.
+func (s documentService) RenderMarkdown(ctx context.Context, c class.Class, f field.Field, source string) string {
+	return f.RenderMarkdown(source, func(ref string) (string, bool) {
+		target := c
+		slug := ref
+		if i := strings.LastIndex(ref, "/"); i >= 0 {
+			var err error
+			if target, err = s.classes.GetBySlug(ctx, ref[:i]); err != nil {
+				return "", false
+			}
+			slug = ref[i+1:]
+		}
+
+		doc, err := s.repo.GetClassDocumentBySlug(ctx, target.Id, slug)
+		if err != nil {
+			return "", false
+		}
+		path, err := s.Path(ctx, doc)
+		return path, err == nil
+	})
+}
@@ -0,0 +1,68 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Returned when a public path does not lead to a document
+var ErrNotFound = errors.New("document not found")
+
+// Public path of the document: the slug of its top-level ancestor's class
+// followed by the slugs of its ancestors and the document itself
+func (s documentService) Path(ctx context.Context, doc Document) (path string, err error) {
+	slugs := []string{doc.Slug}
+	seen := map[string]bool{doc.Id.Hex(): true}
+	for !doc.ParentId.IsZero() {
+		if seen[doc.ParentId.Hex()] {
+			return "", fmt.Errorf("document %s is its own ancestor", doc.ParentId.Hex())
+		}
+		seen[doc.ParentId.Hex()] = true
+
+		if doc, err = s.repo.GetDocumentById(ctx, doc.ParentId); err != nil {
+			return
+		}
+		slugs = append(slugs, doc.Slug)
+	}
+
+	c, err := s.classes.GetById(ctx, doc.ClassId)
+	if err != nil {
+		return
+	}
+
+	for i, j := 0, len(slugs)-1; i < j; i, j = i+1, j-1 {
+		slugs[i], slugs[j] = slugs[j], slugs[i]
+	}
+	return "/" + c.Slug + "/" + strings.Join(slugs, "/"), nil
+}
+
+// Finds the document at a public path, returning it after its ancestors. The
+// first document must belong to the class in the path and have no parent;
+// each following document must be a child of the one before it.
+func (s documentService) Resolve(ctx context.Context, path string) (docs []Document, err error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+
+	c, err := s.classes.GetBySlug(ctx, segments[0])
+	if err != nil || c.Fieldset {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+
+	doc, err := s.repo.GetClassDocumentBySlug(ctx, c.Id, segments[1])
+	if err != nil || !doc.ParentId.IsZero() {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	docs = append(docs, doc)
+
+	for _, slug := range segments[2:] {
+		if doc, err = s.repo.GetChildDocumentBySlug(ctx, doc.Id, slug); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
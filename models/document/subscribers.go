@@ -0,0 +1,94 @@
+package document
+
+import (
+	"time"
+
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/event"
+)
+
+// Appends an entry to the audit log for every change stored through the
+// service publishing the events
+func Audit(events *event.Bus[Document], recorder audit.Recorder) {
+	record := func(e *event.Event[Document]) error {
+		return recorder.Record(auditEntry(e))
+	}
+	for _, op := range []event.Op{event.Insert, event.Update, event.Delete} {
+		events.After(op, record)
+	}
+}
+
+// Tells the notifiers about every change stored through the service publishing
+// the events. Each notifier is told even if an earlier one failed.
+func Notify(events *event.Bus[Document], notifiers ...Notifier) {
+	for _, n := range notifiers {
+		n := n
+		notify := func(e *event.Event[Document]) (err error) {
+			for _, name := range notifyEvents(e, time.Now()) {
+				if notifyErr := n.Notify(name, *e.Model); notifyErr != nil && err == nil {
+					err = notifyErr
+				}
+			}
+			return
+		}
+		for _, op := range []event.Op{event.Insert, event.Update, event.Delete} {
+			events.After(op, notify)
+		}
+	}
+}
+
+// Describes a stored change for the audit log. Trashing and restoring are
+// published as updates and told apart by the deleted time.
+func auditEntry(e *event.Event[Document]) audit.Entry {
+	doc := *e.Model
+	entry := audit.Entry{
+		ActorId:    e.Actor,
+		TargetType: audit.TargetDocument,
+		TargetId:   doc.Id,
+		ClassId:    doc.ClassId,
+	}
+
+	switch {
+	case e.Op == event.Insert:
+		entry.Action = audit.ActionCreate
+	case e.Op == event.Delete:
+		entry.Action = audit.ActionDelete
+	case e.Previous.Deleted.IsZero() && !doc.Deleted.IsZero():
+		entry.Action = audit.ActionTrash
+	case !e.Previous.Deleted.IsZero() && doc.Deleted.IsZero():
+		entry.Action = audit.ActionRestore
+	default:
+		entry.Action = audit.ActionUpdate
+		entry.Changes = audit.Diff(e.Previous, doc)
+	}
+
+	name := doc.Title
+	if name == "" {
+		name = doc.Slug
+	}
+	entry.Summary = audit.Summary(entry.Action, audit.TargetDocument, name)
+	return entry
+}
+
+// Names the notifier events a stored change amounts to
+func notifyEvents(e *event.Event[Document], now time.Time) []string {
+	doc := *e.Model
+	switch {
+	case e.Op == event.Insert && doc.isLive(now):
+		return []string{EventCreated, EventPublished}
+	case e.Op == event.Insert:
+		return []string{EventCreated}
+	case e.Op == event.Delete && !doc.Deleted.IsZero():
+		// Trashed documents were already reported as deleted
+		return nil
+	case e.Op == event.Delete:
+		return []string{EventDeleted}
+	case e.Previous.Deleted.IsZero() && !doc.Deleted.IsZero():
+		return []string{EventDeleted}
+	case !e.Previous.Deleted.IsZero() && doc.Deleted.IsZero():
+		return []string{EventUpdated}
+	case doc.isLive(now) && !e.Previous.isLive(now):
+		return []string{EventUpdated, EventPublished}
+	}
+	return []string{EventUpdated}
+}
@@ -0,0 +1,156 @@
+// Lifecycle hooks for models. Services publish an event before and after each
+// change so other code can react without editing the services.
+package event
+
+import (
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of change published
+type Op string
+
+const (
+	Insert Op = "insert"
+	Update Op = "update"
+	Delete Op = "delete"
+)
+
+// When a handler runs relative to the change
+type Phase string
+
+const (
+	Before Phase = "before"
+	After  Phase = "after"
+)
+
+// A change to a model of type T
+type Event[T any] struct {
+	Op    Op
+	Phase Phase
+	// The model being changed. Before handlers may modify it; the change is
+	// made with whatever they leave behind.
+	Model *T
+	// The stored model an update replaces, zero for inserts and deletes
+	Previous T
+	// User making the change, zero when made by the system
+	Actor primitive.ObjectID
+	// Work done along with the change which the model does not show, such as
+	// the field migrations run with a class update. Only set after the change.
+	Details []string
+}
+
+// Handles events. Errors from before handlers veto the change.
+type Handler[T any] func(*Event[T]) error
+
+// Vetoes are wrapped in this so callers can tell a refused change from a
+// failed one
+type VetoError struct {
+	Op  Op
+	Err error
+}
+
+func (e VetoError) Error() string {
+	return fmt.Sprintf("%s vetoed: %v", e.Op, e.Err)
+}
+
+func (e VetoError) Unwrap() error {
+	return e.Err
+}
+
+type subscription[T any] struct {
+	id      int
+	op      Op
+	phase   Phase
+	handler Handler[T]
+}
+
+// Delivers events about one type of model to its subscribers. Safe for
+// concurrent use.
+type Bus[T any] struct {
+	mutex  *sync.RWMutex
+	nextId int
+	subs   []subscription[T]
+}
+
+func NewBus[T any]() *Bus[T] {
+	return &Bus[T]{
+		mutex: new(sync.RWMutex),
+	}
+}
+
+// Calls the handler before every op of the kind. Handlers run in the order
+// they subscribed. The returned function unsubscribes.
+func (b *Bus[T]) Before(op Op, handler Handler[T]) (unsubscribe func()) {
+	return b.subscribe(op, Before, handler)
+}
+
+// Calls the handler after every op of the kind is stored
+func (b *Bus[T]) After(op Op, handler Handler[T]) (unsubscribe func()) {
+	return b.subscribe(op, After, handler)
+}
+
+func (b *Bus[T]) subscribe(op Op, phase Phase, handler Handler[T]) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextId++
+	id := b.nextId
+	b.subs = append(b.subs, subscription[T]{id: id, op: op, phase: phase, handler: handler})
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		for i, sub := range b.subs {
+			if sub.id == id {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Runs the before handlers, stopping at the first error, which is returned as
+// a VetoError
+func (b *Bus[T]) PublishBefore(e *Event[T]) error {
+	e.Phase = Before
+	for _, handler := range b.handlers(e.Op, Before) {
+		if err := handler(e); err != nil {
+			return VetoError{Op: e.Op, Err: err}
+		}
+	}
+	return nil
+}
+
+// Runs the after handlers. The change is already stored, so every handler
+// runs. The first error is returned wrapped, with the messages of any later
+// ones appended.
+func (b *Bus[T]) PublishAfter(e *Event[T]) (err error) {
+	e.Phase = After
+	for _, handler := range b.handlers(e.Op, After) {
+		handlerErr := handler(e)
+		switch {
+		case handlerErr == nil:
+		case err == nil:
+			err = fmt.Errorf("after %s: %w", e.Op, handlerErr)
+		default:
+			err = fmt.Errorf("%w; %v", err, handlerErr)
+		}
+	}
+	return
+}
+
+// Copies the matching handlers so they may subscribe or unsubscribe while
+// running
+func (b *Bus[T]) handlers(op Op, phase Phase) (handlers []Handler[T]) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, sub := range b.subs {
+		if sub.op == op && sub.phase == phase {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	return
+}
@@ -0,0 +1,108 @@
+package event
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+type model struct {
+	Name string
+}
+
+func TestBus(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		bus := NewBus[model]()
+		var calls []string
+		for _, name := range []string{"first", "second"} {
+			name := name
+			bus.Before(Insert, func(e *Event[model]) error {
+				calls = append(calls, string(e.Phase)+" "+name)
+				return nil
+			})
+		}
+		bus.After(Insert, func(e *Event[model]) error {
+			calls = append(calls, string(e.Phase)+" after")
+			return nil
+		})
+		bus.Before(Update, func(e *Event[model]) error {
+			calls = append(calls, "wrong op")
+			return nil
+		})
+
+		e := &Event[model]{Op: Insert, Model: &model{}}
+		assert.NoError(t, bus.PublishBefore(e))
+		assert.NoError(t, bus.PublishAfter(e))
+		assert.DeepEqual(t, []string{"before first", "before second", "after after"}, calls)
+	})
+
+	t.Run("Mutate", func(t *testing.T) {
+		bus := NewBus[model]()
+		bus.Before(Update, func(e *Event[model]) error {
+			e.Model.Name = e.Previous.Name + " (edited)"
+			return nil
+		})
+
+		m := model{Name: "changed"}
+		assert.NoError(t, bus.PublishBefore(&Event[model]{Op: Update, Model: &m, Previous: model{Name: "stored"}}))
+		assert.Equal(t, "stored (edited)", m.Name)
+	})
+
+	t.Run("Veto", func(t *testing.T) {
+		bus := NewBus[model]()
+		refused := errors.New("refused")
+		called := false
+		bus.Before(Delete, func(*Event[model]) error { return refused })
+		bus.Before(Delete, func(*Event[model]) error {
+			called = true
+			return nil
+		})
+
+		err := bus.PublishBefore(&Event[model]{Op: Delete, Model: &model{}})
+		var veto VetoError
+		assert.True(t, errors.As(err, &veto))
+		assert.Equal(t, Delete, veto.Op)
+		assert.True(t, errors.Is(err, refused))
+		assert.False(t, called)
+	})
+
+	t.Run("After Errors", func(t *testing.T) {
+		bus := NewBus[model]()
+		first, second := errors.New("first"), errors.New("second")
+		calls := 0
+		bus.After(Insert, func(*Event[model]) error {
+			calls++
+			return first
+		})
+		bus.After(Insert, func(*Event[model]) error {
+			calls++
+			return second
+		})
+
+		err := bus.PublishAfter(&Event[model]{Op: Insert, Model: &model{}})
+		assert.True(t, errors.Is(err, first))
+		assert.True(t, strings.Contains(err.Error(), second.Error()))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		bus := NewBus[model]()
+		calls := 0
+		unsubscribe := bus.After(Update, func(*Event[model]) error {
+			calls++
+			return nil
+		})
+		bus.After(Update, func(*Event[model]) error {
+			calls += 10
+			return nil
+		})
+
+		assert.NoError(t, bus.PublishAfter(&Event[model]{Op: Update, Model: &model{}}))
+		unsubscribe()
+		unsubscribe()
+		assert.NoError(t, bus.PublishAfter(&Event[model]{Op: Update, Model: &model{}}))
+		assert.Equal(t, 21, calls)
+	})
+}
@@ -0,0 +1,290 @@
+package field
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Converts a stored value into the representation used by toType. Values
+// which cannot be represented by the new type return an error.
+func Convert(value interface{}, toType string) (interface{}, error) {
+	switch toType {
+	case TypeBoolean:
+		return toBool(value)
+	case TypeGeoPoint:
+		return toGeoPoint(value)
+	case TypeJSON:
+		return toJSON(value)
+	case TypeMultiSelect:
+		return toStrings(value), nil
+	case TypeRelation:
+		return toObjectIDs(value)
+	case TypeUpload:
+		return toMediaID(value)
+	case TypeRepeater:
+		if items, ok := Items(value); ok {
+			return items, nil
+		}
+		// A group becomes the only item of a repeater
+		if group, ok := Group(value); ok {
+			return []map[string]interface{}{group}, nil
+		}
+		return nil, fmt.Errorf("cannot convert %v to repeater items", value)
+	case TypeGroup:
+		if group, ok := Group(value); ok {
+			return group, nil
+		}
+		return nil, fmt.Errorf("cannot convert %v to a group", value)
+	}
+
+	s := toString(value)
+	if s == "" {
+		return s, nil
+	}
+
+	switch toType {
+	case TypeDate:
+		if t, err := parseTime(s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+		return nil, fmt.Errorf("cannot convert %q to a date", s)
+	case TypeDateTime:
+		if t, err := parseTime(s); err == nil {
+			return t.Format("2006-01-02T15:04"), nil
+		}
+		return nil, fmt.Errorf("cannot convert %q to a date and time", s)
+	case TypeTime:
+		if t, err := time.Parse("15:04", s); err == nil {
+			return t.Format("15:04"), nil
+		}
+		if t, err := time.Parse("2006-01-02T15:04", s); err == nil {
+			return t.Format("15:04"), nil
+		}
+		return nil, fmt.Errorf("cannot convert %q to a time", s)
+	case TypeNumber:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %q to a number", s)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case TypeEmail:
+		address, err := mail.ParseAddress(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %q to an email: %w", s, err)
+		}
+		return address.Address, nil
+	case TypeURL:
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("cannot convert %q to a URL", s)
+		}
+		return u.String(), nil
+	case TypeColor:
+		return toColor(s)
+	case TypeSlug:
+		return Slugify(s), nil
+	}
+
+	return s, nil
+}
+
+// Turns text into a slug of lowercase letters, digits and underscores,
+// matching the slugs accepted by the admin
+func Slugify(s string) string {
+	var b strings.Builder
+	pending := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if pending && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			pending = false
+			b.WriteRune(r)
+		default:
+			pending = true
+		}
+	}
+	return b.String()
+}
+
+func toBool(value interface{}) (bool, error) {
+	if b, ok := value.(bool); ok {
+		return b, nil
+	}
+	switch s := strings.ToLower(strings.TrimSpace(toString(value))); s {
+	case "", "0", "false", "off", "no":
+		return false, nil
+	case "1", "true", "on", "yes":
+		return true, nil
+	default:
+		return false, fmt.Errorf("cannot convert %q to a boolean", s)
+	}
+}
+
+// Accepts stored points and "latitude, longitude" text. Blank locations are
+// stored as nil since geo indexes reject anything but GeoJSON.
+func toGeoPoint(value interface{}) (interface{}, error) {
+	if point, ok := Point(value); ok {
+		return point, nil
+	}
+	s, ok := value.(string)
+	if value == nil || (ok && strings.TrimSpace(s) == "") {
+		return nil, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %v to a location", value)
+	}
+	point, err := ParseGeoPoint(s)
+	if err != nil {
+		return nil, err
+	}
+	return point, nil
+}
+
+// Uploads reference a single media item by ID. Blank uploads are stored as
+// nil.
+func toMediaID(value interface{}) (interface{}, error) {
+	if id, ok := value.(primitive.ObjectID); ok {
+		if id.IsZero() {
+			return nil, nil
+		}
+		return id, nil
+	}
+	s := strings.TrimSpace(toString(value))
+	if s == "" {
+		return nil, nil
+	}
+	id, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert %q to a media ID", s)
+	}
+	return id, nil
+}
+
+// Accepts #rgb and #rrggbb colors, normalized to lowercase #rrggbb
+func toColor(s string) (string, error) {
+	color := strings.ToLower(strings.TrimSpace(s))
+	if len(color) == 4 && color[0] == '#' {
+		color = string([]byte{'#', color[1], color[1], color[2], color[2], color[3], color[3]})
+	}
+	if len(color) != 7 || color[0] != '#' {
+		return "", fmt.Errorf("cannot convert %q to a color", s)
+	}
+	if _, err := hex.DecodeString(color[1:]); err != nil {
+		return "", fmt.Errorf("cannot convert %q to a color", s)
+	}
+	return color, nil
+}
+
+// Validates JSON text and pretty-prints it. Values which are not text are
+// encoded as JSON.
+func toJSON(value interface{}) (string, error) {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return "", nil
+		}
+		raw = []byte(v)
+	default:
+		var err error
+		if raw, err = json.Marshal(v); err != nil {
+			return "", fmt.Errorf("cannot convert %v to JSON: %w", v, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, "", "  "); err != nil {
+		return "", fmt.Errorf("invalid JSON: %w", err)
+	}
+	return out.String(), nil
+}
+
+// Accepts the date, datetime and time layouts produced by the document
+// builder inputs
+func parseTime(s string) (t time.Time, err error) {
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02", time.RFC3339} {
+		if t, err = time.Parse(layout, s); err == nil {
+			return
+		}
+	}
+	return
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02T15:04")
+	case primitive.ObjectID:
+		return v.Hex()
+	}
+	return strings.Join(toStrings(value), ", ")
+}
+
+func toStrings(value interface{}) (values []string) {
+	switch v := value.(type) {
+	case nil:
+		return []string{}
+	case []string:
+		return v
+	case primitive.A:
+		return toStrings([]interface{}(v))
+	case []interface{}:
+		values = make([]string, len(v))
+		for i := range v {
+			values[i] = toString(v[i])
+		}
+		return
+	case []primitive.ObjectID:
+		values = make([]string, len(v))
+		for i := range v {
+			values[i] = v[i].Hex()
+		}
+		return
+	case string:
+		if v == "" {
+			return []string{}
+		}
+		return []string{v}
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+func toObjectIDs(value interface{}) (ids []primitive.ObjectID, err error) {
+	if id, ok := value.(primitive.ObjectID); ok {
+		return []primitive.ObjectID{id}, nil
+	}
+	if ids, ok := value.([]primitive.ObjectID); ok {
+		return ids, nil
+	}
+
+	strs := toStrings(value)
+	ids = make([]primitive.ObjectID, 0, len(strs))
+	for _, s := range strs {
+		if s == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %q to a document ID", s)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package field
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConvert(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	table := []struct {
+		Name   string
+		Type   string
+		Value  interface{}
+		Expect interface{}
+		Error  bool
+	}{
+		{"Text to Number", TypeNumber, " 42 ", "42", false},
+		{"Float to Number", TypeNumber, "4.50", "4.5", false},
+		{"Bad Number", TypeNumber, "forty-two", nil, true},
+		{"Number to Text", TypeText, "42", "42", false},
+		{"Empty", TypeNumber, "", "", false},
+		{"Date to DateTime", TypeDateTime, "2022-04-14", "2022-04-14T00:00", false},
+		{"DateTime to Date", TypeDate, "2022-04-14T12:08", "2022-04-14", false},
+		{"DateTime to Time", TypeTime, "2022-04-14T12:08", "12:08", false},
+		{"Bad Date", TypeDate, "yesterday", nil, true},
+		{"Email", TypeEmail, "Test <test@test.com>", "test@test.com", false},
+		{"Bad Email", TypeEmail, "test", nil, true},
+		{"Text to Multi-Select", TypeMultiSelect, "a", []string{"a"}, false},
+		{"Multi-Select to Text", TypeText, primitive.A{"a", "b"}, "a, b", false},
+		{"Text to Relation", TypeRelation, id.Hex(), []primitive.ObjectID{id}, false},
+		{"Bad Relation", TypeRelation, "nope", nil, true},
+		{"Relation to Text", TypeText, primitive.A{id}, id.Hex(), false},
+		{"Checkbox", TypeBoolean, "on", true, false},
+		{"Unchecked", TypeBoolean, nil, false, false},
+		{"Boolean", TypeBoolean, true, true, false},
+		{"Bad Boolean", TypeBoolean, "maybe", nil, true},
+		{"URL", TypeURL, " https://example.com/a?b=c ", "https://example.com/a?b=c", false},
+		{"Relative URL", TypeURL, "/a/b", nil, true},
+		{"Bad URL Scheme", TypeURL, "javascript:alert(1)", nil, true},
+		{"Short Color", TypeColor, "#ABC", "#aabbcc", false},
+		{"Color", TypeColor, "#A1B2C3", "#a1b2c3", false},
+		{"Bad Color", TypeColor, "red", nil, true},
+		{"Slug", TypeSlug, "  Hello, World! 2022 ", "hello_world_2022", false},
+		{"JSON", TypeJSON, `{"a":[1,2]}`, "{\n  \"a\": [\n    1,\n    2\n  ]\n}", false},
+		{"JSON Value", TypeJSON, map[string]interface{}{"a": true}, "{\n  \"a\": true\n}", false},
+		{"Empty JSON", TypeJSON, " ", "", false},
+		{"Bad JSON", TypeJSON, "{a:1}", nil, true},
+		{"Group to Repeater", TypeRepeater, map[string]interface{}{"a": "1"}, []map[string]interface{}{{"a": "1"}}, false},
+		{"Text to Repeater", TypeRepeater, "a", nil, true},
+		{"Text to Group", TypeGroup, "a", nil, true},
+		{"Upload", TypeUpload, id.Hex(), id, false},
+		{"Upload ID", TypeUpload, id, id, false},
+		{"Empty Upload", TypeUpload, "", nil, false},
+		{"Bad Upload", TypeUpload, "file.png", nil, true},
+		{"Location", TypeGeoPoint, "38.8977, -77.0365", NewGeoPoint(38.8977, -77.0365), false},
+		{"Empty Location", TypeGeoPoint, " ", nil, false},
+		{"Bad Location", TypeGeoPoint, "38.8977", nil, true},
+		{"Out of Range Location", TypeGeoPoint, "91, 0", nil, true},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			value, err := Convert(test.Value, test.Type)
+			if test.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.DeepEqual(t, test.Expect, value)
+		})
+	}
+}
@@ -9,17 +9,34 @@ import (
 )
 
 const (
+	TypeBoolean     = "boolean"
+	TypeColor       = "color"
 	TypeDate        = "date"
 	TypeDateTime    = "datetime"
 	TypeEmail       = "email"
+	TypeGeoPoint    = "geopoint"
+	TypeGroup       = "group"
+	TypeJSON        = "json"
+	TypeMarkdown    = "markdown"
 	TypeMultiSelect = "multiselect"
 	TypeNumber      = "number"
+	TypeRelation    = "relation"
+	TypeRepeater    = "repeater"
 	TypeSelect      = "select"
+	TypeSlug        = "slug"
 	TypeText        = "text"
 	TypeTextArea    = "textarea"
 	TypeTime        = "time"
 	TypeTinyMCE     = "tinymce"
 	TypeUpload      = "upload"
+	TypeURL         = "url"
+)
+
+// Actions taken on a relation field when a document it points to is deleted
+const (
+	OnDeleteRestrict = "restrict"
+	OnDeleteNullify  = "nullify"
+	OnDeleteCascade  = "cascade"
 )
 
 type FieldOption struct {
@@ -39,12 +56,56 @@ type Field struct {
 	DataSourceId    primitive.ObjectID `json:"data_source_id" bson:"data_source_id,omitempty"`
 	DataSourceValue string             `json:"data_source_value" bson:"data_source_value,omitempty"`
 	DataSourceLabel string             `json:"data_source_label" bson:"data_source_label,omitempty"`
+
+	// Relation fields may point at documents in any of these classes
+	RelationClassIds []primitive.ObjectID `json:"relation_class_ids" bson:"relation_class_ids,omitempty"`
+	OnDelete         string               `json:"on_delete" bson:"on_delete,omitempty"`
+
+	// Slug fields left blank are generated from the value of this field,
+	// which may also be title
+	SlugSource string `json:"slug_source" bson:"slug_source,omitempty"`
+
+	// Tags and attributes rich text and text area values may contain, in the
+	// format of DefaultAllowedHTML
+	AllowedHTML string `json:"allowed_html" bson:"allowed_html,omitempty"`
+
+	// Forms reject submissions which leave required fields blank
+	Required bool `json:"required" bson:"required,omitempty"`
+
+	// Sub-fields of repeater and group fields. Repeaters store a list of
+	// items, each a map of sub-field values; groups store a single map.
+	Fields []Field `json:"fields" bson:"fields,omitempty"`
 }
 
 // Takes in any value from a Document.Values item and converts it based on the
 // field type, then optionally formats the value if defined
 func (f Field) Apply(value interface{}) string {
+	switch f.Type {
+	case TypeRepeater:
+		return f.applyItems(value)
+	case TypeGroup:
+		return f.applyGroup(value)
+	case TypeGeoPoint:
+		if point, ok := Point(value); ok {
+			return point.String()
+		}
+		return ""
+	case TypeUpload:
+		if value == nil {
+			return ""
+		}
+	case TypeMarkdown:
+		if source, ok := value.(string); ok {
+			return f.SummarizeMarkdown(source)
+		}
+	}
+
 	switch v := value.(type) {
+	case bool:
+		if v {
+			return "Yes"
+		}
+		return "No"
 	case int:
 		return fmt.Sprint(v)
 	case string:
@@ -65,12 +126,78 @@ func (f Field) Apply(value interface{}) string {
 		}
 	case primitive.ObjectID:
 		return v.Hex()
+	case []primitive.ObjectID:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = v[i]
+		}
+		return f.Apply(values)
+	case primitive.A:
+		return f.Apply([]interface{}(v))
+	case []interface{}:
+		// Relation lookups expand into one value per related document
+		applied := make([]string, len(v))
+		for i := range v {
+			applied[i] = f.Apply(v[i])
+		}
+		return strings.Join(applied, ", ")
 	case time.Time:
 		return v.Format("Jan 2, 2006 3:04pm")
 	}
 	return "-nil-"
 }
 
+// Summarizes repeater items by their first sub-field
+func (f Field) applyItems(value interface{}) string {
+	items, ok := Items(value)
+	if !ok || len(f.Fields) == 0 {
+		return "-nil-"
+	}
+
+	first := f.Fields[0]
+	applied := make([]string, len(items))
+	for i, item := range items {
+		applied[i] = first.Apply(item[first.Name])
+	}
+	return strings.Join(applied, ", ")
+}
+
+// Summarizes a group by its non-empty sub-field values
+func (f Field) applyGroup(value interface{}) string {
+	group, ok := Group(value)
+	if !ok {
+		return "-nil-"
+	}
+
+	applied := make([]string, 0, len(f.Fields))
+	for _, sub := range f.Fields {
+		if v, ok := group[sub.Name]; ok && v != "" {
+			applied = append(applied, sub.Apply(v))
+		}
+	}
+	return strings.Join(applied, ", ")
+}
+
+// Reports whether values of the field are converted to their stored form when
+// a document is saved
+func (f Field) IsTyped() bool {
+	switch f.Type {
+	case TypeBoolean, TypeColor, TypeGeoPoint, TypeJSON, TypeSlug, TypeUpload, TypeURL:
+		return true
+	}
+	return false
+}
+
+// Returns the delete rule for relation fields, defaulting to restrict when
+// none is set
+func (f Field) DeleteRule() string {
+	switch f.OnDelete {
+	case OnDeleteNullify, OnDeleteCascade:
+		return f.OnDelete
+	}
+	return OnDeleteRestrict
+}
+
 // Converts the options text to an array for use in HTML templates to
 // generate select options
 func (f Field) OptionList() (options []FieldOption) {
@@ -25,9 +25,15 @@ func TestFieldApply(t *testing.T) {
 		Value  interface{}
 		Expect string
 	}{
+		{"Boolean True", TypeBoolean, "", true, "Yes"},
+		{"Boolean False", TypeBoolean, "", false, "No"},
 		{"Date", TypeDate, "Jan 2, 2006", "2022-04-14", "Apr 14, 2022"},
 		{"Date & Time", TypeDateTime, "Jan 2, 2006 3:04 pm", "2022-04-14T12:08", "Apr 14, 2022 12:08 pm"},
 		{"Email", TypeEmail, "", "test@test.com", "test@test.com"},
+		{"Upload", TypeUpload, "", objectId, objectId.Hex()},
+		{"Empty Upload", TypeUpload, "", nil, ""},
+		{"Location", TypeGeoPoint, "", NewGeoPoint(38.8977, -77.0365), "38.8977, -77.0365"},
+		{"Empty Location", TypeGeoPoint, "", nil, ""},
 		{"Multi-Select", TypeMultiSelect, "", []string{"a", "b"}, "-nil-"},
 		{"Number String", TypeNumber, "", "42", "42"},
 		{"Number Number", TypeNumber, "", 42, "42"},
@@ -38,6 +44,9 @@ func TestFieldApply(t *testing.T) {
 		{"TinyMCE", TypeTinyMCE, "", "tinymce", "tinymce"},
 		{"time.Time", "", "", now, now.Format("Jan 2, 2006 3:04pm")},
 		{"ObjectID", "", "", objectId, objectId.Hex()},
+		{"Relation", TypeRelation, "", []primitive.ObjectID{objectId, objectId}, objectId.Hex() + ", " + objectId.Hex()},
+		{"Relation Array", TypeRelation, "", primitive.A{objectId}, objectId.Hex()},
+		{"Expanded", "", "", []interface{}{"a", 42}, "a, 42"},
 	}
 
 	for _, test := range table {
@@ -51,6 +60,13 @@ func TestFieldApply(t *testing.T) {
 	}
 }
 
+func TestFieldDeleteRule(t *testing.T) {
+	assert.Equal(t, OnDeleteRestrict, Field{}.DeleteRule())
+	assert.Equal(t, OnDeleteRestrict, Field{OnDelete: "bogus"}.DeleteRule())
+	assert.Equal(t, OnDeleteNullify, Field{OnDelete: OnDeleteNullify}.DeleteRule())
+	assert.Equal(t, OnDeleteCascade, Field{OnDelete: OnDeleteCascade}.DeleteRule())
+}
+
 func TestFieldOptionList(t *testing.T) {
 	expect := []FieldOption{
 		{
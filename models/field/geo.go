@@ -0,0 +1,111 @@
+package field
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mean radius of the Earth in meters, shared by the distance calculations of
+// every repository so proximity filters agree
+const EarthRadius = 6371008.8
+
+// A GeoJSON point as stored in Document.Values. Coordinates are longitude
+// then latitude, as GeoJSON requires.
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+func NewGeoPoint(lat, lng float64) GeoPoint {
+	return GeoPoint{
+		Type:        "Point",
+		Coordinates: []float64{lng, lat},
+	}
+}
+
+// Parses a point written as "latitude, longitude"
+func ParseGeoPoint(s string) (point GeoPoint, err error) {
+	latText, lngText, ok := strings.Cut(s, ",")
+	if !ok {
+		return point, fmt.Errorf("cannot convert %q to a location, expected latitude, longitude", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return point, fmt.Errorf("invalid latitude: %s", latText)
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(lngText), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return point, fmt.Errorf("invalid longitude: %s", lngText)
+	}
+	return NewGeoPoint(lat, lng), nil
+}
+
+func (p GeoPoint) Lat() float64 {
+	return p.Coordinates[1]
+}
+
+func (p GeoPoint) Lng() float64 {
+	return p.Coordinates[0]
+}
+
+func (p GeoPoint) String() string {
+	return strconv.FormatFloat(p.Lat(), 'f', -1, 64) + ", " + strconv.FormatFloat(p.Lng(), 'f', -1, 64)
+}
+
+// Great-circle distance to another point in meters, using the haversine
+// formula
+func (p GeoPoint) Distance(to GeoPoint) float64 {
+	lat1, lat2 := p.Lat()*math.Pi/180, to.Lat()*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (to.Lng() - p.Lng()) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(a))
+}
+
+// Reads a point from a Document.Values item, whether it was built in memory
+// or decoded from the database
+func Point(value interface{}) (point GeoPoint, ok bool) {
+	switch v := value.(type) {
+	case GeoPoint:
+		return v, len(v.Coordinates) == 2
+	case *GeoPoint:
+		if v == nil {
+			return
+		}
+		return Point(*v)
+	}
+
+	group, ok := Group(value)
+	if !ok || value == nil {
+		return point, false
+	}
+	if t, _ := group["type"].(string); t != "Point" {
+		return point, false
+	}
+
+	var coordinates []interface{}
+	switch c := group["coordinates"].(type) {
+	case primitive.A:
+		coordinates = c
+	case []interface{}:
+		coordinates = c
+	case []float64:
+		return Point(GeoPoint{Type: "Point", Coordinates: c})
+	}
+	if len(coordinates) != 2 {
+		return point, false
+	}
+
+	point = GeoPoint{Type: "Point", Coordinates: make([]float64, 2)}
+	for i, c := range coordinates {
+		if point.Coordinates[i], ok = c.(float64); !ok {
+			return point, false
+		}
+	}
+	return point, true
+}
@@ -0,0 +1,63 @@
+package field
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGeoPointDistance(t *testing.T) {
+	whiteHouse := NewGeoPoint(38.8977, -77.0365)
+	capitol := NewGeoPoint(38.8899, -77.0091)
+
+	assert.Equal(t, 0.0, whiteHouse.Distance(whiteHouse))
+
+	// Roughly 2.5km apart
+	distance := whiteHouse.Distance(capitol)
+	assert.True(t, math.Abs(distance-2520) < 50)
+	assert.Equal(t, distance, capitol.Distance(whiteHouse))
+}
+
+func TestParseGeoPoint(t *testing.T) {
+	point, err := ParseGeoPoint(" 38.8977 ,-77.0365 ")
+	assert.NoError(t, err)
+	assert.Equal(t, 38.8977, point.Lat())
+	assert.Equal(t, -77.0365, point.Lng())
+	assert.Equal(t, "38.8977, -77.0365", point.String())
+
+	for _, s := range []string{"", "38.8977", "north, west", "38, 181"} {
+		_, err := ParseGeoPoint(s)
+		assert.Error(t, err)
+	}
+}
+
+func TestPoint(t *testing.T) {
+	expect := NewGeoPoint(38.8977, -77.0365)
+
+	table := []struct {
+		Name  string
+		Value interface{}
+		Ok    bool
+	}{
+		{"GeoPoint", expect, true},
+		{"Pointer", &expect, true},
+		{"Map", map[string]interface{}{"type": "Point", "coordinates": []interface{}{-77.0365, 38.8977}}, true},
+		{"Decoded", primitive.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: primitive.A{-77.0365, 38.8977}}}, true},
+		{"Wrong Type", map[string]interface{}{"type": "Polygon", "coordinates": []float64{-77.0365, 38.8977}}, false},
+		{"Short", map[string]interface{}{"type": "Point", "coordinates": []float64{-77.0365}}, false},
+		{"Text", "38.8977, -77.0365", false},
+		{"Nil", nil, false},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			point, ok := Point(test.Value)
+			assert.Equal(t, test.Ok, ok)
+			if test.Ok {
+				assert.DeepEqual(t, expect, point)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package field
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	xhtml "golang.org/x/net/html"
+)
+
+// Prefix of link destinations which point at another document by slug:
+// doc:slug for a document in the same class, doc:class/slug for a document
+// in another class
+const DocLinkPrefix = "doc:"
+
+// Longest summary of a Markdown value, in characters, before it is cut short
+const MarkdownSummaryLength = 100
+
+// Looks up the URL of a document linked with DocLinkPrefix, given the
+// reference after the prefix. Links to documents which are not found render
+// as plain text.
+type LinkResolver func(ref string) (url string, ok bool)
+
+// Renders Markdown source to HTML, resolving document links then removing
+// any HTML the field policy does not allow
+func (f Field) RenderMarkdown(source string, resolve LinkResolver) string {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(
+			parser.WithASTTransformers(util.Prioritized(docLinks{resolve}, 100)),
+		),
+		// Raw HTML is passed through to the sanitizer rather than omitted so
+		// field policies apply to it
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		return ""
+	}
+	return f.Sanitize(buf.String())
+}
+
+// Renders Markdown source as a single line of plain text for places like
+// table cells, cut short at MarkdownSummaryLength characters
+func (f Field) SummarizeMarkdown(source string) string {
+	// Blocks are rendered one per line, so the text alone keeps words apart
+	var text strings.Builder
+	z := xhtml.NewTokenizer(strings.NewReader(f.RenderMarkdown(source, nil)))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		if tt == xhtml.TextToken {
+			text.Write(z.Text())
+		}
+	}
+
+	summary := []rune(strings.Join(strings.Fields(text.String()), " "))
+	if len(summary) <= MarkdownSummaryLength {
+		return string(summary)
+	}
+	return strings.TrimSpace(string(summary[:MarkdownSummaryLength])) + "…"
+}
+
+// Rewrites document links to their URLs, unwrapping links that do not resolve
+type docLinks struct {
+	resolve LinkResolver
+}
+
+func (t docLinks) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	var unresolved []*ast.Link
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := n.(*ast.Link)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		dest := string(link.Destination)
+		if !strings.HasPrefix(dest, DocLinkPrefix) {
+			return ast.WalkContinue, nil
+		}
+
+		if t.resolve != nil {
+			if url, ok := t.resolve(strings.TrimPrefix(dest, DocLinkPrefix)); ok {
+				link.Destination = []byte(url)
+				return ast.WalkContinue, nil
+			}
+		}
+		unresolved = append(unresolved, link)
+		return ast.WalkContinue, nil
+	})
+
+	for _, link := range unresolved {
+		parent := link.Parent()
+		for child := link.FirstChild(); child != nil; child = link.FirstChild() {
+			parent.InsertBefore(parent, link, child)
+		}
+		parent.RemoveChild(parent, link)
+	}
+}
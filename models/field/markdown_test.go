@@ -0,0 +1,58 @@
+package field
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	f := Field{Type: TypeMarkdown}
+	resolve := func(ref string) (string, bool) {
+		if ref == "about" || ref == "news/launch" {
+			return "/pages/" + ref, true
+		}
+		return "", false
+	}
+
+	table := []struct {
+		Name   string
+		Source string
+		Expect string
+	}{
+		{"Paragraph", "Hello *world*", "<p>Hello <em>world</em></p>\n"},
+		{"Heading", "# Title", "<h1>Title</h1>\n"},
+		{"Strikethrough", "~~gone~~", "<p><del>gone</del></p>\n"},
+		{"External Link", "[site](https://example.com)", `<p><a href="https://example.com" rel="noopener noreferrer">site</a></p>` + "\n"},
+		{"Document Link", "[about](doc:about)", `<p><a href="/pages/about">about</a></p>` + "\n"},
+		{"Class Document Link", "[launch](doc:news/launch)", `<p><a href="/pages/news/launch">launch</a></p>` + "\n"},
+		{"Missing Document Link", "see [the **missing** page](doc:missing) here", "<p>see the <strong>missing</strong> page here</p>\n"},
+		{"Javascript Link", "[x](javascript:alert(1))", "<p><a>x</a></p>\n"},
+		{"Raw Script", "<script>alert(1)</script>\n\nok", "\n<p>ok</p>\n"},
+		{"Raw Allowed HTML", `<b onclick="x">bold</b>`, "<p><b>bold</b></p>\n"},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expect, f.RenderMarkdown(test.Source, resolve))
+		})
+	}
+
+	t.Run("No Resolver", func(t *testing.T) {
+		assert.Equal(t, "<p>about</p>\n", f.RenderMarkdown("[about](doc:about)", nil))
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		f := Field{Type: TypeMarkdown, AllowedHTML: "p"}
+		assert.Equal(t, "<p>Hello world</p>\n", f.RenderMarkdown("Hello *world*", nil))
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		assert.Equal(t, "Bold", f.Apply("**Bold**"))
+		assert.Equal(t, "Bold", f.Apply("**Bo**ld"))
+		assert.Equal(t, "Fish & Chips Second paragraph", f.Apply("# Fish & *Chips*\n\nSecond\nparagraph"))
+		long := strings.Repeat("word ", 30)
+		assert.Equal(t, strings.TrimSpace(long[:MarkdownSummaryLength])+"…", f.Apply(long))
+	})
+}
@@ -0,0 +1,136 @@
+package field
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types allowed as sub-fields of repeaters and groups. Each sub-field maps to
+// a single form input so repeated items can be read back in order.
+var nestableTypes = map[string]bool{
+	TypeColor:    true,
+	TypeDate:     true,
+	TypeDateTime: true,
+	TypeEmail:    true,
+	TypeNumber:   true,
+	TypeText:     true,
+	TypeTextArea: true,
+	TypeTime:     true,
+	TypeURL:      true,
+}
+
+// Reports whether the field holds sub-fields rather than a single value
+func (f Field) IsNested() bool {
+	return f.Type == TypeRepeater || f.Type == TypeGroup
+}
+
+// Verifies the sub-field definitions of repeater and group fields
+func (f Field) ValidateFields() error {
+	if !f.IsNested() {
+		return nil
+	}
+
+	if len(f.Fields) == 0 {
+		return fmt.Errorf("%s has no sub-fields", f.Name)
+	}
+
+	names := make(map[string]bool, len(f.Fields))
+	for i, sub := range f.Fields {
+		if sub.Name == "" {
+			return fmt.Errorf("%s.fields[%d] name is empty", f.Name, i)
+		}
+		if sub.Label == "" {
+			return fmt.Errorf("%s.fields[%d] label is empty", f.Name, i)
+		}
+		if !nestableTypes[sub.Type] {
+			return fmt.Errorf("%s.%s cannot be of type %q", f.Name, sub.Name, sub.Type)
+		}
+		if names[sub.Name] {
+			return fmt.Errorf("%s.%s is defined more than once", f.Name, sub.Name)
+		}
+		names[sub.Name] = true
+	}
+	return nil
+}
+
+// Checks a value from Document.Values against the field. Repeater values must
+// be a list of items within the Min and Max item counts, and every sub-field
+// value must be convertible to its type.
+func (f Field) CheckValue(value interface{}) (err error) {
+	switch f.Type {
+	case TypeRepeater:
+		items, ok := Items(value)
+		if !ok {
+			return fmt.Errorf("%s must be a list of items", f.Name)
+		}
+		if min, err := strconv.Atoi(f.Min); err == nil && len(items) < min {
+			return fmt.Errorf("%s needs at least %d items", f.Name, min)
+		}
+		if max, err := strconv.Atoi(f.Max); err == nil && len(items) > max {
+			return fmt.Errorf("%s allows at most %d items", f.Name, max)
+		}
+		for i, item := range items {
+			if err = f.checkItem(item); err != nil {
+				return fmt.Errorf("%s item %d: %w", f.Name, i+1, err)
+			}
+		}
+	case TypeGroup:
+		group, ok := Group(value)
+		if !ok {
+			return fmt.Errorf("%s must be a group of values", f.Name)
+		}
+		if err = f.checkItem(group); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return
+}
+
+func (f Field) checkItem(item map[string]interface{}) error {
+	for _, sub := range f.Fields {
+		if _, err := Convert(item[sub.Name], sub.Type); err != nil {
+			return fmt.Errorf("%s: %w", sub.Label, err)
+		}
+	}
+	return nil
+}
+
+// Reads the items of a repeater value, whether it was built in memory or
+// decoded from the database. A nil value is an empty list.
+func Items(value interface{}) (items []map[string]interface{}, ok bool) {
+	switch v := value.(type) {
+	case nil:
+		return []map[string]interface{}{}, true
+	case []map[string]interface{}:
+		return v, true
+	case primitive.A:
+		return Items([]interface{}(v))
+	case []interface{}:
+		items = make([]map[string]interface{}, len(v))
+		for i := range v {
+			if items[i], ok = Group(v[i]); !ok {
+				return nil, false
+			}
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// Reads the values of a group, whether it was built in memory or decoded
+// from the database. A nil value is an empty group.
+func Group(value interface{}) (group map[string]interface{}, ok bool) {
+	switch v := value.(type) {
+	case nil:
+		return map[string]interface{}{}, true
+	case map[string]interface{}:
+		return v, true
+	case primitive.M:
+		return map[string]interface{}(v), true
+	case primitive.D:
+		return map[string]interface{}(v.Map()), true
+	}
+	return nil, false
+}
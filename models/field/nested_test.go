@@ -0,0 +1,101 @@
+package field
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newFAQ() Field {
+	return Field{
+		Type: TypeRepeater,
+		Name: "faq",
+		Min:  "1",
+		Max:  "3",
+		Fields: []Field{
+			{Type: TypeText, Name: "question", Label: "Question"},
+			{Type: TypeNumber, Name: "votes", Label: "Votes"},
+		},
+	}
+}
+
+func TestFieldValidateFields(t *testing.T) {
+	assert.NoError(t, Field{Type: TypeText, Name: "text"}.ValidateFields())
+	assert.NoError(t, newFAQ().ValidateFields())
+
+	table := []struct {
+		Name   string
+		Fields []Field
+	}{
+		{"Empty", nil},
+		{"No Name", []Field{{Type: TypeText, Label: "Label"}}},
+		{"No Label", []Field{{Type: TypeText, Name: "name"}}},
+		{"Relation", []Field{{Type: TypeRelation, Name: "name", Label: "Label"}}},
+		{"Nested Repeater", []Field{{Type: TypeRepeater, Name: "name", Label: "Label"}}},
+		{"Duplicate", []Field{
+			{Type: TypeText, Name: "name", Label: "Label"},
+			{Type: TypeText, Name: "name", Label: "Label"},
+		}},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			f := Field{Type: TypeGroup, Name: "group", Fields: test.Fields}
+			assert.Error(t, f.ValidateFields())
+		})
+	}
+}
+
+func TestFieldCheckValue(t *testing.T) {
+	faq := newFAQ()
+
+	table := []struct {
+		Name  string
+		Value interface{}
+		Error bool
+	}{
+		{"Items", []map[string]interface{}{{"question": "Why?", "votes": "2"}}, false},
+		{"Decoded Items", primitive.A{primitive.D{{Key: "question", Value: "Why?"}}}, false},
+		{"Too Few", []map[string]interface{}{}, true},
+		{"Too Many", []map[string]interface{}{{}, {}, {}, {}}, true},
+		{"Bad Item", []map[string]interface{}{{"question": "Why?", "votes": "many"}}, true},
+		{"Not A List", "Why?", true},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			err := faq.CheckValue(test.Value)
+			if test.Error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("Group", func(t *testing.T) {
+		group := Field{Type: TypeGroup, Name: "hero", Fields: []Field{{Type: TypeEmail, Name: "contact", Label: "Contact"}}}
+		assert.NoError(t, group.CheckValue(map[string]interface{}{"contact": "test@test.com"}))
+		assert.Error(t, group.CheckValue(map[string]interface{}{"contact": "test"}))
+		assert.Error(t, group.CheckValue([]string{"test"}))
+	})
+
+	t.Run("Scalar", func(t *testing.T) {
+		assert.NoError(t, Field{Type: TypeText}.CheckValue("anything"))
+	})
+}
+
+func TestNestedApply(t *testing.T) {
+	faq := newFAQ()
+	items := primitive.A{
+		primitive.D{{Key: "question", Value: "Why?"}},
+		map[string]interface{}{"question": "How?"},
+	}
+	assert.Equal(t, "Why?, How?", faq.Apply(items))
+	assert.Equal(t, "", faq.Apply(nil))
+	assert.Equal(t, "-nil-", faq.Apply("Why?"))
+
+	group := Field{Type: TypeGroup, Fields: []Field{{Name: "a"}, {Name: "b"}, {Name: "c"}}}
+	assert.Equal(t, "1, 3", group.Apply(map[string]interface{}{"a": "1", "b": "", "c": "3"}))
+}
@@ -0,0 +1,416 @@
+package field
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Tags and attributes rich text fields allow when they have no policy of
+// their own. Each line names a tag followed by the attributes it may carry;
+// the * tag lists attributes allowed on every tag.
+const DefaultAllowedHTML = `* class style
+a href title target rel
+abbr title
+b
+blockquote cite
+br
+caption
+code
+del
+div
+em
+figcaption
+figure
+h1
+h2
+h3
+h4
+h5
+h6
+hr
+i
+img src alt title width height
+li
+ol start
+p
+pre
+s
+span
+strong
+sub
+sup
+table
+tbody
+td colspan rowspan
+th colspan rowspan scope
+thead
+tr
+u
+ul`
+
+// Elements removed along with everything inside them, whether or not a
+// policy allows them
+var droppedElements = map[string]bool{
+	"embed":     true,
+	"frame":     true,
+	"frameset":  true,
+	"iframe":    true,
+	"math":      true,
+	"noembed":   true,
+	"noframes":  true,
+	"noscript":  true,
+	"object":    true,
+	"plaintext": true,
+	"script":    true,
+	"style":     true,
+	"svg":       true,
+	"template":  true,
+	"textarea":  true,
+	"title":     true,
+	"xmp":       true,
+}
+
+var voidElements = map[string]bool{
+	"area":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+// Attributes holding URLs, which must use one of the safe schemes
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"formaction": true,
+	"href":       true,
+	"poster":     true,
+	"src":        true,
+}
+
+var safeSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"tel":    true,
+}
+
+var styleProperties = map[string]bool{
+	"background-color": true,
+	"border":           true,
+	"color":            true,
+	"font-size":        true,
+	"font-style":       true,
+	"font-weight":      true,
+	"height":           true,
+	"list-style-type":  true,
+	"margin":           true,
+	"margin-left":      true,
+	"margin-right":     true,
+	"padding":          true,
+	"padding-left":     true,
+	"text-align":       true,
+	"text-decoration":  true,
+	"vertical-align":   true,
+	"width":            true,
+}
+
+var (
+	policyName = regexp.MustCompile(`^(\*|[a-z][a-z0-9-]*)$`)
+	styleValue = regexp.MustCompile(`^(?:[\w#%., -]|rgba?\([\d\s.,%]+\))+$`)
+)
+
+// Allowlist of HTML tags and the attributes each may carry
+type Policy map[string]map[string]bool
+
+// Reads a policy in the format of DefaultAllowedHTML. Event handler
+// attributes are never allowed, even when listed.
+func ParsePolicy(s string) (Policy, error) {
+	policy := make(Policy)
+	for i, line := range strings.Split(s, "\n") {
+		names := strings.Fields(strings.ToLower(line))
+		if len(names) == 0 {
+			continue
+		}
+		for _, name := range names {
+			if !policyName.MatchString(name) {
+				return nil, fmt.Errorf("line %d: invalid name %q", i+1, name)
+			}
+		}
+
+		tag := names[0]
+		if policy[tag] == nil {
+			policy[tag] = make(map[string]bool)
+		}
+		for _, attr := range names[1:] {
+			if attr == "*" || strings.HasPrefix(attr, "on") {
+				return nil, fmt.Errorf("line %d: attribute %q is not allowed", i+1, attr)
+			}
+			policy[tag][attr] = true
+		}
+	}
+	return policy, nil
+}
+
+// Whether values of the field are sanitized when saved. Rich text is always
+// sanitized, plain text areas only when they define a policy.
+func (f Field) IsSanitized() bool {
+	switch f.Type {
+	case TypeTinyMCE:
+		return true
+	case TypeTextArea:
+		return f.AllowedHTML != ""
+	}
+	return false
+}
+
+// Policy of the field, or the default for fields without one. A policy which
+// fails to parse allows nothing.
+func (f Field) Policy() Policy {
+	allowed := f.AllowedHTML
+	if allowed == "" {
+		allowed = DefaultAllowedHTML
+	}
+	policy, err := ParsePolicy(allowed)
+	if err != nil {
+		return Policy{}
+	}
+	return policy
+}
+
+// Removes any HTML the field policy does not allow
+func (f Field) Sanitize(value string) string {
+	return f.Policy().Sanitize(value)
+}
+
+// Sanitizes a value from Document.Values, including the values of repeater
+// and group sub-fields. Values of other fields are returned unchanged.
+func (f Field) SanitizeValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch {
+	case f.IsSanitized():
+		if s, ok := value.(string); ok {
+			return f.Sanitize(s)
+		}
+	case f.Type == TypeRepeater:
+		if items, ok := Items(value); ok {
+			sanitized := make([]map[string]interface{}, len(items))
+			for i := range items {
+				sanitized[i] = f.sanitizeGroup(items[i])
+			}
+			return sanitized
+		}
+	case f.Type == TypeGroup:
+		if group, ok := Group(value); ok {
+			return f.sanitizeGroup(group)
+		}
+	}
+	return value
+}
+
+func (f Field) sanitizeGroup(group map[string]interface{}) map[string]interface{} {
+	sanitized := make(map[string]interface{}, len(group))
+	for key, value := range group {
+		sanitized[key] = value
+	}
+	for _, sub := range f.Fields {
+		if value, ok := group[sub.Name]; ok {
+			sanitized[sub.Name] = sub.SanitizeValue(value)
+		}
+	}
+	return sanitized
+}
+
+// Rewrites the HTML keeping only allowed tags and attributes. Disallowed tags
+// are unwrapped leaving their text, except for script-like elements which are
+// removed entirely. Comments are dropped and unclosed tags are closed.
+func (p Policy) Sanitize(s string) string {
+	var b strings.Builder
+	var open []string
+	// Name and nesting depth of the dropped element being skipped
+	skipping, depth := "", 0
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		token := z.Token()
+		name := token.Data
+
+		if skipping != "" {
+			switch {
+			case tt == html.StartTagToken && name == skipping:
+				depth++
+			case tt == html.EndTagToken && name == skipping:
+				if depth--; depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedElements[name] {
+				if tt == html.StartTagToken && !voidElements[name] {
+					skipping, depth = name, 1
+				}
+				continue
+			}
+			if p[name] == nil {
+				continue
+			}
+			b.WriteString("<" + name)
+			for _, attr := range p.attributes(token) {
+				b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+			}
+			b.WriteString(">")
+			switch {
+			case voidElements[name]:
+			case tt == html.SelfClosingTagToken:
+				b.WriteString("</" + name + ">")
+			default:
+				open = append(open, name)
+			}
+		case html.EndTagToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != name {
+					continue
+				}
+				for len(open) > i {
+					b.WriteString("</" + open[len(open)-1] + ">")
+					open = open[:len(open)-1]
+				}
+				break
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+// Allowed attributes of the token with unsafe values removed. Links leaving
+// the site or opening new windows always carry rel="noopener noreferrer".
+func (p Policy) attributes(token html.Token) (attrs []html.Attribute) {
+	var rel []string
+	external := false
+
+	for _, attr := range token.Attr {
+		key := attr.Key
+		if attr.Namespace != "" || strings.HasPrefix(key, "on") {
+			continue
+		}
+		if !p[token.Data][key] && !p["*"][key] {
+			continue
+		}
+
+		switch {
+		case urlAttributes[key]:
+			u, ok := safeURL(attr.Val)
+			if !ok {
+				continue
+			}
+			attr.Val = u.String()
+			external = external || u.Host != ""
+		case key == "style":
+			if attr.Val = sanitizeStyle(attr.Val); attr.Val == "" {
+				continue
+			}
+		case key == "target":
+			external = true
+		}
+
+		if token.Data == "a" && key == "rel" {
+			rel = strings.Fields(attr.Val)
+			continue
+		}
+		attrs = append(attrs, html.Attribute{Key: key, Val: attr.Val})
+	}
+
+	if token.Data != "a" {
+		return
+	}
+	if external {
+		for _, value := range []string{"noopener", "noreferrer"} {
+			if !contains(rel, value) {
+				rel = append(rel, value)
+			}
+		}
+	}
+	if len(rel) > 0 {
+		attrs = append(attrs, html.Attribute{Key: "rel", Val: strings.Join(rel, " ")})
+	}
+	return
+}
+
+// Parses a URL attribute value, which must be relative or use a safe scheme.
+// Browsers ignore whitespace and control characters within schemes, so those
+// are removed before checking.
+func safeURL(value string) (*url.URL, bool) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return nil, false
+	}
+	if u.Scheme != "" && !safeSchemes[strings.ToLower(u.Scheme)] {
+		return nil, false
+	}
+	// A colon in the first path segment would be read as a scheme
+	if u.Scheme == "" && strings.Contains(strings.SplitN(u.Path, "/", 2)[0], ":") {
+		return nil, false
+	}
+	return u, true
+}
+
+// Keeps declarations of safe properties with plain values, which rules out
+// url(), expression() and escapes
+func sanitizeStyle(style string) string {
+	var kept []string
+	for _, declaration := range strings.Split(style, ";") {
+		parts := strings.SplitN(declaration, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if styleProperties[property] && styleValue.MatchString(value) {
+			kept = append(kept, property+": "+value)
+		}
+	}
+	return strings.Join(kept, "; ")
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
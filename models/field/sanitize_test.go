@@ -0,0 +1,115 @@
+package field
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("* class\n\nA Href Title\nbr\n")
+	assert.NoError(t, err)
+	assert.True(t, policy["a"]["href"])
+	assert.True(t, policy["*"]["class"])
+	assert.True(t, policy["br"] != nil)
+	assert.False(t, policy["a"]["class"])
+
+	for _, s := range []string{"a onclick", "img on-load", "a *", "a href=x", "<script>"} {
+		_, err := ParsePolicy(s)
+		assert.Error(t, err)
+	}
+
+	_, err = ParsePolicy(DefaultAllowedHTML)
+	assert.NoError(t, err)
+}
+
+func TestSanitize(t *testing.T) {
+	rich := Field{Type: TypeTinyMCE}
+
+	table := []struct {
+		Name   string
+		Input  string
+		Expect string
+	}{
+		{"Plain Text", "Hello & goodbye", "Hello &amp; goodbye"},
+		{"Allowed Markup", `<p class="lead"><strong>Bold</strong> <em>text</em></p>`, `<p class="lead"><strong>Bold</strong> <em>text</em></p>`},
+		{"Script", `<p>Hi</p><script>alert(1)</script>`, `<p>Hi</p>`},
+		{"Uppercase Script", `<SCRIPT SRC="//evil.example/x.js"></SCRIPT>ok`, `ok`},
+		{"Nested Script Text", `<script><script>alert(1)</script>ok`, `ok`},
+		{"Style Element", `<style>body{display:none}</style>ok`, `ok`},
+		{"Iframe", `<iframe src="javascript:alert(1)"><p>inside</p></iframe>ok`, `ok`},
+		{"SVG", `<svg><script>alert(1)</script><circle onload="alert(1)"/></svg>ok`, `ok`},
+		{"Event Handler", `<img src="x.png" onerror="alert(1)">`, `<img src="x.png">`},
+		{"Event Handler Case", `<p OnMouseOver="alert(1)">hi</p>`, `<p>hi</p>`},
+		{"Unknown Tag", `<marquee><b>hi</b></marquee>`, `<b>hi</b>`},
+		{"Disallowed Attribute", `<p id="x" data-x="y">hi</p>`, `<p>hi</p>`},
+		{"Javascript Link", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"Javascript Link Case", `<a href="JaVaScRiPt:alert(1)">x</a>`, `<a>x</a>`},
+		{"Javascript Link Entities", `<a href="&#106;avascript&#58;alert(1)">x</a>`, `<a>x</a>`},
+		{"Javascript Link Whitespace", "<a href=\" java\tscript:alert(1)\">x</a>", `<a>x</a>`},
+		{"Data URI", `<img src="data:text/html;base64,PHNjcmlwdD4=">`, `<img>`},
+		{"VBScript", `<a href="vbscript:msgbox(1)">x</a>`, `<a>x</a>`},
+		{"Relative Link", `<a href="/about?x=1">x</a>`, `<a href="/about?x=1">x</a>`},
+		{"Mailto Link", `<a href="mailto:info@example.com">x</a>`, `<a href="mailto:info@example.com">x</a>`},
+		{"External Link", `<a href="https://example.com/">x</a>`, `<a href="https://example.com/" rel="noopener noreferrer">x</a>`},
+		{"New Window", `<a href="/about" target="_blank">x</a>`, `<a href="/about" target="_blank" rel="noopener noreferrer">x</a>`},
+		{"Existing Rel", `<a href="https://example.com/" rel="nofollow noopener">x</a>`, `<a href="https://example.com/" rel="nofollow noopener noreferrer">x</a>`},
+		{"Style Expression", `<p style="width: expression(alert(1)); color: red">x</p>`, `<p style="color: red">x</p>`},
+		{"Style URL", `<p style="background-color: url(javascript:alert(1))">x</p>`, `<p>x</p>`},
+		{"Style Unknown Property", `<p style="position: fixed">x</p>`, `<p>x</p>`},
+		{"Style RGB", `<span style="color: rgb(255, 0, 0)">x</span>`, `<span style="color: rgb(255, 0, 0)">x</span>`},
+		{"Comment", `<!-- <script>alert(1)</script> -->ok`, `ok`},
+		{"Conditional Comment", `<!--[if IE]><script>alert(1)</script><![endif]-->ok`, `ok`},
+		{"Attribute Breakout", `<p title='"><script>alert(1)</script>'>x</p>`, `<p>x</p>`},
+		{"Escaped Attribute", `<abbr title='"><script>'>x</abbr>`, `<abbr title="&#34;&gt;&lt;script&gt;">x</abbr>`},
+		{"Unclosed Tags", `<p><strong>bold`, `<p><strong>bold</strong></p>`},
+		{"Misnested Tags", `<b><i>x</b></i>`, `<b><i>x</i></b>`},
+		{"Stray End Tag", `x</div>`, `x`},
+		{"Self Closing", `<br/><div/>x`, `<br><div></div>x`},
+		{"Broken Tag", `<img src=x onerror=alert(1)//`, ``},
+		{"Form", `<form action="javascript:alert(1)"><input type="submit"></form>ok`, `ok`},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expect, rich.Sanitize(test.Input))
+		})
+	}
+}
+
+func TestSanitizeValue(t *testing.T) {
+	t.Run("Custom Policy", func(t *testing.T) {
+		f := Field{Type: TypeTextArea, AllowedHTML: "b\na href"}
+		assert.Equal(t, `<b>x</b> <a href="/x">y</a>`, f.SanitizeValue(`<b>x</b> <a href="/x" class="c">y</a>`))
+		assert.Equal(t, `x y`, f.SanitizeValue(`<i>x</i> <p>y</p>`))
+	})
+
+	t.Run("Plain Text Area", func(t *testing.T) {
+		f := Field{Type: TypeTextArea}
+		assert.False(t, f.IsSanitized())
+		assert.Equal(t, "<b>x</b>", f.SanitizeValue("<b>x</b>"))
+	})
+
+	t.Run("Other Types", func(t *testing.T) {
+		f := Field{Type: TypeText}
+		assert.Equal(t, "<b>x</b>", f.SanitizeValue("<b>x</b>"))
+		assert.Equal(t, nil, Field{Type: TypeTinyMCE}.SanitizeValue(nil))
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		f := Field{
+			Type: TypeRepeater,
+			Fields: []Field{
+				{Name: "title", Type: TypeText},
+				{Name: "body", Type: TypeTextArea, AllowedHTML: "p"},
+			},
+		}
+		value := []interface{}{
+			map[string]interface{}{"title": "<b>One</b>", "body": `<p onclick="x">One</p><script>x</script>`},
+		}
+		expect := []map[string]interface{}{
+			{"title": "<b>One</b>", "body": "<p>One</p>"},
+		}
+		assert.DeepEqual(t, expect, f.SanitizeValue(value))
+	})
+}
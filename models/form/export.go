@@ -0,0 +1,76 @@
+package form
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Writes the form submissions as CSV, one column per field after the time
+// each was submitted
+func (s formService) Export(f Form, w io.Writer) (err error) {
+	submissions, err := s.Submissions(f)
+	if err != nil {
+		return
+	}
+
+	out := csv.NewWriter(w)
+	header := make([]string, 0, len(f.Fields)+1)
+	header = append(header, "Submitted")
+	for _, fld := range f.Fields {
+		header = append(header, fld.Label)
+	}
+	if err = out.Write(header); err != nil {
+		return
+	}
+
+	for _, sub := range submissions {
+		record := make([]string, 0, len(header))
+		record = append(record, sub.Created.Format(time.RFC3339))
+		for _, fld := range f.Fields {
+			record = append(record, csvValue(sub.Values[fld.Name]))
+		}
+		if err = out.Write(record); err != nil {
+			return
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// Formats a submitted value for display
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "Yes"
+		}
+		return "No"
+	case []string:
+		return strings.Join(v, ", ")
+	case primitive.A:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = fmt.Sprint(v[i])
+		}
+		return strings.Join(values, ", ")
+	}
+	return fmt.Sprint(value)
+}
+
+// Formats a submitted value for a spreadsheet cell. Values spreadsheets would
+// treat as formulas are prefixed with a quote so they stay text.
+func csvValue(value interface{}) string {
+	s := FormatValue(value)
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		s = "'" + s
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package form
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jbaikge/gocms/models/field"
+)
+
+// Reads field definitions written one per line as
+//
+//	name | label | type | required | option, option
+//
+// Only the name is needed; the label defaults to the name, the type to text
+// and the options are only used by selects.
+func ParseFields(text string) (fields []field.Field, err error) {
+	for i, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		parts := strings.Split(line, "|")
+		for len(parts) < 5 {
+			parts = append(parts, "")
+		}
+		for j := range parts {
+			parts[j] = strings.TrimSpace(parts[j])
+		}
+
+		f := field.Field{
+			Name:  parts[0],
+			Label: parts[1],
+			Type:  parts[2],
+		}
+		if f.Label == "" {
+			f.Label = f.Name
+		}
+		if f.Type == "" {
+			f.Type = field.TypeText
+		}
+
+		switch strings.ToLower(parts[3]) {
+		case "":
+		case "required":
+			f.Required = true
+		default:
+			return nil, fmt.Errorf("line %d: expected required or nothing, not %q", i+1, parts[3])
+		}
+
+		var options []string
+		for _, option := range strings.Split(strings.Join(parts[4:], "|"), ",") {
+			if option = strings.TrimSpace(option); option != "" {
+				options = append(options, option)
+			}
+		}
+		f.Options = strings.Join(options, "\n")
+
+		fields = append(fields, f)
+	}
+	return
+}
+
+// Writes fields in the format read by ParseFields
+func FormatFields(fields []field.Field) string {
+	lines := make([]string, len(fields))
+	for i, f := range fields {
+		required := ""
+		if f.Required {
+			required = "required"
+		}
+		var options []string
+		if f.Options != "" {
+			for _, option := range f.OptionList() {
+				options = append(options, option.Value)
+			}
+		}
+		lines[i] = strings.Join([]string{f.Name, f.Label, f.Type, required, strings.Join(options, ", ")}, " | ")
+		lines[i] = strings.TrimRight(lines[i], " |")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,320 @@
+package form
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jbaikge/gocms/models/field"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Longest value a single form field accepts
+const MaxValueLength = 10000
+
+// Wraps errors from notifiers. The submission was stored regardless.
+var ErrNotification = errors.New("notification failed")
+
+// Field types a form may use
+var fieldTypes = map[string]bool{
+	field.TypeBoolean:     true,
+	field.TypeDate:        true,
+	field.TypeDateTime:    true,
+	field.TypeEmail:       true,
+	field.TypeMultiSelect: true,
+	field.TypeNumber:      true,
+	field.TypeSelect:      true,
+	field.TypeText:        true,
+	field.TypeTextArea:    true,
+	field.TypeTime:        true,
+	field.TypeURL:         true,
+}
+
+var fieldName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// A public form built from field definitions
+type Form struct {
+	Id   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name string             `json:"name" bson:"name"`
+	// Shown in place of the form once it has been submitted
+	SuccessMessage string        `json:"success_message" bson:"success_message"`
+	Fields         []field.Field `json:"fields" bson:"fields"`
+	Created        time.Time     `json:"created" bson:"created"`
+	Updated        time.Time     `json:"updated" bson:"updated"`
+}
+
+type Submission struct {
+	Id         primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	FormId     primitive.ObjectID     `json:"form_id" bson:"form_id"`
+	Values     map[string]interface{} `json:"values" bson:"values"`
+	RemoteAddr string                 `json:"remote_addr" bson:"remote_addr"`
+	Created    time.Time              `json:"created" bson:"created"`
+}
+
+// Problems with a submission, keyed by field name
+type ValidationErrors map[string]string
+
+func (e ValidationErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = e[name]
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Told about every stored submission, such as to send an email
+type Notifier interface {
+	Notify(Form, Submission) error
+}
+
+// Adapts a function to a Notifier
+type NotifierFunc func(Form, Submission) error
+
+func (fn NotifierFunc) Notify(f Form, s Submission) error {
+	return fn(f, s)
+}
+
+type FormRepository interface {
+	DeleteForm(primitive.ObjectID) error
+	DeleteSubmissions(primitive.ObjectID) error
+	GetFormById(primitive.ObjectID) (Form, error)
+	GetForms() ([]Form, error)
+	GetSubmissions(primitive.ObjectID) ([]Submission, error)
+	InsertForm(*Form) error
+	InsertSubmission(*Submission) error
+	UpdateForm(*Form) error
+}
+
+type FormService interface {
+	Delete(Form) error
+	Export(Form, io.Writer) error
+	GetById(primitive.ObjectID) (Form, error)
+	Insert(*Form) error
+	List() ([]Form, error)
+	Submissions(Form) ([]Submission, error)
+	Submit(Form, url.Values, string) (Submission, error)
+	Update(*Form) error
+	Validate(*Form) error
+}
+
+type formService struct {
+	repo      FormRepository
+	notifiers []Notifier
+}
+
+// Notifiers are called in order after each submission is stored
+func NewFormService(repo FormRepository, notifiers ...Notifier) FormService {
+	return formService{
+		repo:      repo,
+		notifiers: notifiers,
+	}
+}
+
+// Removes the form along with its submissions
+func (s formService) Delete(f Form) (err error) {
+	if err = s.repo.DeleteSubmissions(f.Id); err != nil {
+		return
+	}
+	return s.repo.DeleteForm(f.Id)
+}
+
+func (s formService) GetById(id primitive.ObjectID) (Form, error) {
+	return s.repo.GetFormById(id)
+}
+
+func (s formService) Insert(f *Form) (err error) {
+	if err = s.Validate(f); err != nil {
+		return
+	}
+	if !f.Id.IsZero() {
+		return fmt.Errorf("form already has an ID")
+	}
+
+	f.Created = time.Now()
+	f.Updated = f.Created
+	return s.repo.InsertForm(f)
+}
+
+func (s formService) List() ([]Form, error) {
+	return s.repo.GetForms()
+}
+
+// Submissions to the form, newest first
+func (s formService) Submissions(f Form) ([]Submission, error) {
+	return s.repo.GetSubmissions(f.Id)
+}
+
+// Validates the posted values against the form fields and stores them. Invalid
+// submissions return ValidationErrors. Notifier failures are returned wrapped
+// in ErrNotification after every notifier has run.
+func (s formService) Submit(f Form, values url.Values, remoteAddr string) (sub Submission, err error) {
+	sub = Submission{
+		FormId:     f.Id,
+		Values:     make(map[string]interface{}, len(f.Fields)),
+		RemoteAddr: remoteAddr,
+		Created:    time.Now(),
+	}
+
+	invalid := make(ValidationErrors)
+	for _, fld := range f.Fields {
+		value, err := check(fld, values)
+		if err != nil {
+			invalid[fld.Name] = err.Error()
+			continue
+		}
+		sub.Values[fld.Name] = value
+	}
+	if len(invalid) > 0 {
+		return sub, invalid
+	}
+
+	if err = s.repo.InsertSubmission(&sub); err != nil {
+		return
+	}
+
+	for _, notifier := range s.notifiers {
+		if notifyErr := notifier.Notify(f, sub); notifyErr != nil && err == nil {
+			err = fmt.Errorf("%w: %v", ErrNotification, notifyErr)
+		}
+	}
+	return
+}
+
+func (s formService) Update(f *Form) (err error) {
+	if err = s.Validate(f); err != nil {
+		return
+	}
+	if f.Id.IsZero() {
+		return fmt.Errorf("form has no ID")
+	}
+
+	f.Updated = time.Now()
+	return s.repo.UpdateForm(f)
+}
+
+func (s formService) Validate(f *Form) error {
+	if f.Name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if len(f.Fields) == 0 {
+		return fmt.Errorf("form has no fields")
+	}
+
+	names := make(map[string]bool, len(f.Fields))
+	for i, fld := range f.Fields {
+		if !fieldName.MatchString(fld.Name) {
+			return fmt.Errorf("field[%d] name %q must be lowercase alphanumeric", i, fld.Name)
+		}
+		if names[fld.Name] {
+			return fmt.Errorf("field %s is defined more than once", fld.Name)
+		}
+		names[fld.Name] = true
+		if fld.Label == "" {
+			return fmt.Errorf("field %s label is empty", fld.Name)
+		}
+		if !fieldTypes[fld.Type] {
+			return fmt.Errorf("field %s cannot be of type %q", fld.Name, fld.Type)
+		}
+		if (fld.Type == field.TypeSelect || fld.Type == field.TypeMultiSelect) && strings.TrimSpace(fld.Options) == "" {
+			return fmt.Errorf("field %s has no options", fld.Name)
+		}
+	}
+	return nil
+}
+
+// Converts the posted value of a field, enforcing its limits
+func check(fld field.Field, values url.Values) (value interface{}, err error) {
+	posted := values[fld.Name]
+	for _, v := range posted {
+		if len(v) > MaxValueLength {
+			return nil, fmt.Errorf("%s is too long", fld.Label)
+		}
+	}
+
+	if fld.Type == field.TypeMultiSelect {
+		value = posted
+	} else {
+		value = strings.TrimSpace(values.Get(fld.Name))
+	}
+
+	if value, err = field.Convert(value, fld.Type); err != nil {
+		return nil, fmt.Errorf("%s is not a valid %s", fld.Label, typeNames[fld.Type])
+	}
+
+	if fld.Required && isBlank(value) {
+		return nil, fmt.Errorf("%s is required", fld.Label)
+	}
+
+	switch fld.Type {
+	case field.TypeSelect:
+		if s := value.(string); s != "" && !hasOption(fld, s) {
+			return nil, fmt.Errorf("%s is not one of the choices", fld.Label)
+		}
+	case field.TypeMultiSelect:
+		for _, s := range value.([]string) {
+			if !hasOption(fld, s) {
+				return nil, fmt.Errorf("%s is not one of the choices", fld.Label)
+			}
+		}
+	case field.TypeNumber:
+		if s := value.(string); s != "" {
+			n, _ := strconv.ParseFloat(s, 64)
+			if min, err := strconv.ParseFloat(fld.Min, 64); err == nil && n < min {
+				return nil, fmt.Errorf("%s must be at least %s", fld.Label, fld.Min)
+			}
+			if max, err := strconv.ParseFloat(fld.Max, 64); err == nil && n > max {
+				return nil, fmt.Errorf("%s must be at most %s", fld.Label, fld.Max)
+			}
+		}
+	}
+	return
+}
+
+var typeNames = map[string]string{
+	field.TypeBoolean:     "checkbox",
+	field.TypeDate:        "date",
+	field.TypeDateTime:    "date and time",
+	field.TypeEmail:       "email address",
+	field.TypeMultiSelect: "choice",
+	field.TypeNumber:      "number",
+	field.TypeSelect:      "choice",
+	field.TypeText:        "value",
+	field.TypeTextArea:    "value",
+	field.TypeTime:        "time",
+	field.TypeURL:         "URL",
+}
+
+func isBlank(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
+
+func hasOption(fld field.Field, value string) bool {
+	for _, option := range fld.OptionList() {
+		if option.Value == value {
+			return true
+		}
+	}
+	return false
+}
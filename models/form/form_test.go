@@ -0,0 +1,331 @@
+package form
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ FormRepository = &mockFormRepository{}
+
+type mockFormRepository struct {
+	forms       map[primitive.ObjectID]Form
+	submissions []Submission
+}
+
+func NewMockFormRepository() *mockFormRepository {
+	return &mockFormRepository{
+		forms: make(map[primitive.ObjectID]Form),
+	}
+}
+
+func (r *mockFormRepository) DeleteForm(id primitive.ObjectID) (err error) {
+	delete(r.forms, id)
+	return
+}
+
+func (r *mockFormRepository) DeleteSubmissions(formId primitive.ObjectID) (err error) {
+	kept := r.submissions[:0]
+	for _, s := range r.submissions {
+		if s.FormId != formId {
+			kept = append(kept, s)
+		}
+	}
+	r.submissions = kept
+	return
+}
+
+func (r *mockFormRepository) GetFormById(id primitive.ObjectID) (f Form, err error) {
+	f, ok := r.forms[id]
+	if !ok {
+		err = fmt.Errorf("form not found: %s", id.Hex())
+	}
+	return
+}
+
+func (r *mockFormRepository) GetForms() (forms []Form, err error) {
+	for _, f := range r.forms {
+		forms = append(forms, f)
+	}
+	return
+}
+
+func (r *mockFormRepository) GetSubmissions(formId primitive.ObjectID) (subs []Submission, err error) {
+	for i := len(r.submissions) - 1; i >= 0; i-- {
+		if r.submissions[i].FormId == formId {
+			subs = append(subs, r.submissions[i])
+		}
+	}
+	return
+}
+
+func (r *mockFormRepository) InsertForm(f *Form) (err error) {
+	f.Id = primitive.NewObjectID()
+	r.forms[f.Id] = *f
+	return
+}
+
+func (r *mockFormRepository) InsertSubmission(s *Submission) (err error) {
+	s.Id = primitive.NewObjectID()
+	r.submissions = append(r.submissions, *s)
+	return
+}
+
+func (r *mockFormRepository) UpdateForm(f *Form) (err error) {
+	if _, ok := r.forms[f.Id]; !ok {
+		return fmt.Errorf("form not found: %s", f.Id.Hex())
+	}
+	r.forms[f.Id] = *f
+	return
+}
+
+func contactForm() Form {
+	return Form{
+		Name: "Contact",
+		Fields: []field.Field{
+			{Name: "name", Label: "Name", Type: field.TypeText, Required: true},
+			{Name: "email", Label: "Email", Type: field.TypeEmail, Required: true},
+			{Name: "topic", Label: "Topic", Type: field.TypeSelect, Options: "sales\nsupport"},
+			{Name: "guests", Label: "Guests", Type: field.TypeNumber, Min: "1", Max: "4"},
+			{Name: "days", Label: "Days", Type: field.TypeMultiSelect, Options: "mon\ntue"},
+			{Name: "subscribe", Label: "Subscribe", Type: field.TypeBoolean},
+			{Name: "message", Label: "Message", Type: field.TypeTextArea},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	service := NewFormService(NewMockFormRepository())
+
+	f := contactForm()
+	assert.NoError(t, service.Validate(&f))
+
+	table := []struct {
+		Name   string
+		Modify func(*Form)
+	}{
+		{"No Name", func(f *Form) { f.Name = "" }},
+		{"No Fields", func(f *Form) { f.Fields = nil }},
+		{"Bad Field Name", func(f *Form) { f.Fields[0].Name = "Full Name" }},
+		{"Duplicate Field", func(f *Form) { f.Fields[1].Name = "name" }},
+		{"No Label", func(f *Form) { f.Fields[0].Label = "" }},
+		{"Unsupported Type", func(f *Form) { f.Fields[0].Type = field.TypeUpload }},
+		{"No Options", func(f *Form) { f.Fields[2].Options = " " }},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			f := contactForm()
+			test.Modify(&f)
+			assert.Error(t, service.Validate(&f))
+		})
+	}
+}
+
+func TestInsertUpdate(t *testing.T) {
+	repo := NewMockFormRepository()
+	service := NewFormService(repo)
+
+	f := contactForm()
+	assert.NoError(t, service.Insert(&f))
+	assert.False(t, f.Id.IsZero())
+	assert.False(t, f.Created.IsZero())
+	assert.Error(t, service.Insert(&f))
+
+	f.Name = "Contact Us"
+	assert.NoError(t, service.Update(&f))
+	check, err := service.GetById(f.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Contact Us", check.Name)
+
+	f.Id = primitive.NilObjectID
+	assert.Error(t, service.Update(&f))
+}
+
+func TestSubmit(t *testing.T) {
+	valid := url.Values{
+		"name":      {" Ada "},
+		"email":     {"ada@example.com"},
+		"topic":     {"support"},
+		"guests":    {"2"},
+		"days":      {"mon", "tue"},
+		"subscribe": {"on"},
+		"extra":     {"ignored"},
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		repo := NewMockFormRepository()
+		service := NewFormService(repo)
+		f := contactForm()
+		assert.NoError(t, service.Insert(&f))
+
+		sub, err := service.Submit(f, valid, "192.0.2.1")
+		assert.NoError(t, err)
+		assert.False(t, sub.Id.IsZero())
+		assert.Equal(t, f.Id, sub.FormId)
+		assert.Equal(t, "192.0.2.1", sub.RemoteAddr)
+		assert.Equal(t, "Ada", sub.Values["name"])
+		assert.Equal(t, "2", sub.Values["guests"])
+		assert.DeepEqual(t, []string{"mon", "tue"}, sub.Values["days"])
+		assert.Equal(t, true, sub.Values["subscribe"])
+		assert.Equal(t, "", sub.Values["message"])
+		_, ok := sub.Values["extra"]
+		assert.False(t, ok)
+		assert.Equal(t, 1, len(repo.submissions))
+	})
+
+	table := []struct {
+		Name  string
+		Field string
+		Value []string
+	}{
+		{"Missing Required", "name", nil},
+		{"Blank Required", "name", []string{"   "}},
+		{"Invalid Email", "email", []string{"not an email"}},
+		{"Unknown Option", "topic", []string{"billing"}},
+		{"Unknown Multiple Option", "days", []string{"mon", "sun"}},
+		{"Not A Number", "guests", []string{"two"}},
+		{"Below Min", "guests", []string{"0"}},
+		{"Above Max", "guests", []string{"5"}},
+		{"Too Long", "message", []string{string(make([]byte, MaxValueLength+1))}},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			repo := NewMockFormRepository()
+			service := NewFormService(repo)
+			f := contactForm()
+
+			values := url.Values{}
+			for k, v := range valid {
+				values[k] = v
+			}
+			values[test.Field] = test.Value
+
+			_, err := service.Submit(f, values, "")
+			var invalid ValidationErrors
+			assert.True(t, errors.As(err, &invalid))
+			assert.Equal(t, 1, len(invalid))
+			assert.True(t, invalid[test.Field] != "")
+			assert.Equal(t, 0, len(repo.submissions))
+		})
+	}
+
+	t.Run("Notifiers", func(t *testing.T) {
+		repo := NewMockFormRepository()
+		var notified []string
+		service := NewFormService(
+			repo,
+			NotifierFunc(func(f Form, s Submission) error {
+				notified = append(notified, "first")
+				return fmt.Errorf("mail server down")
+			}),
+			NotifierFunc(func(f Form, s Submission) error {
+				notified = append(notified, s.Values["name"].(string))
+				return nil
+			}),
+		)
+
+		_, err := service.Submit(contactForm(), valid, "")
+		assert.True(t, errors.Is(err, ErrNotification))
+		assert.DeepEqual(t, []string{"first", "Ada"}, notified)
+		// Stored even though a notifier failed
+		assert.Equal(t, 1, len(repo.submissions))
+	})
+}
+
+func TestDelete(t *testing.T) {
+	repo := NewMockFormRepository()
+	service := NewFormService(repo)
+
+	f := contactForm()
+	assert.NoError(t, service.Insert(&f))
+	other := contactForm()
+	assert.NoError(t, service.Insert(&other))
+
+	values := url.Values{"name": {"Ada"}, "email": {"ada@example.com"}}
+	for _, form := range []Form{f, other} {
+		_, err := service.Submit(form, values, "")
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, service.Delete(f))
+	_, err := service.GetById(f.Id)
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(repo.submissions))
+	assert.Equal(t, other.Id, repo.submissions[0].FormId)
+}
+
+func TestFields(t *testing.T) {
+	text := `name | Name | text | required
+topic|Topic|select||sales, support ,
+notes`
+
+	fields, err := ParseFields(text)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(fields))
+	assert.Equal(t, "Name", fields[0].Label)
+	assert.True(t, fields[0].Required)
+	assert.Equal(t, field.TypeSelect, fields[1].Type)
+	assert.Equal(t, "sales\nsupport", fields[1].Options)
+	assert.False(t, fields[1].Required)
+	assert.Equal(t, "notes", fields[2].Label)
+	assert.Equal(t, field.TypeText, fields[2].Type)
+
+	expect := `name | Name | text | required
+topic | Topic | select |  | sales, support
+notes | notes | text`
+	assert.Equal(t, expect, FormatFields(fields))
+
+	again, err := ParseFields(FormatFields(fields))
+	assert.NoError(t, err)
+	assert.DeepEqual(t, fields, again)
+
+	_, err = ParseFields("name | Name | text | maybe")
+	assert.Error(t, err)
+}
+
+func TestExport(t *testing.T) {
+	repo := NewMockFormRepository()
+	service := NewFormService(repo)
+
+	f := contactForm()
+	assert.NoError(t, service.Insert(&f))
+
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	repo.submissions = []Submission{
+		{
+			FormId:  f.Id,
+			Created: created,
+			Values: map[string]interface{}{
+				"name":      "=HYPERLINK(\"http://evil.example\")",
+				"email":     "ada@example.com",
+				"days":      []string{"mon", "tue"},
+				"subscribe": true,
+				"message":   "Line one,\n\"quoted\"",
+			},
+		},
+		{
+			FormId:  f.Id,
+			Created: created.Add(time.Hour),
+			Values:  map[string]interface{}{"name": "Bob", "guests": "-3", "subscribe": false},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, service.Export(f, &buf))
+
+	expect := `Submitted,Name,Email,Topic,Guests,Days,Subscribe,Message
+2022-05-01T13:00:00Z,Bob,,,'-3,,No,
+2022-05-01T12:00:00Z,"'=HYPERLINK(""http://evil.example"")",ada@example.com,,,"mon, tue",Yes,"Line one,
+""quoted"""
+`
+	assert.Equal(t, expect, buf.String())
+}
@@ -0,0 +1,113 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How long a lock lasts unless the editor's page refreshes it
+const DefaultTTL = 2 * time.Minute
+
+// Returned when another user holds a lock that has not expired
+var ErrLocked = errors.New("locked by another user")
+
+// Marks a document as being edited. Locks are kept in the repository so every
+// server instance sees them.
+type Lock struct {
+	// The locked document. A document has at most one lock.
+	Id       primitive.ObjectID `json:"id" bson:"_id"`
+	UserId   primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Acquired time.Time          `json:"acquired" bson:"acquired"`
+	Expires  time.Time          `json:"expires" bson:"expires"`
+}
+
+// Whether the lock still holds at the given time
+func (l Lock) Active(now time.Time) bool {
+	return !l.Id.IsZero() && now.Before(l.Expires)
+}
+
+type LockRepository interface {
+	// Stores the lock unless another user holds an active one, in which case
+	// that lock is returned along with ErrLocked. Refreshing a lock keeps its
+	// acquired time.
+	AcquireLock(Lock, time.Time) (Lock, error)
+	DeleteLock(primitive.ObjectID) error
+	GetLock(primitive.ObjectID) (Lock, error)
+}
+
+type LockService interface {
+	Acquire(primitive.ObjectID, primitive.ObjectID) (Lock, error)
+	Break(primitive.ObjectID) error
+	Get(primitive.ObjectID) (Lock, error)
+	Release(primitive.ObjectID, primitive.ObjectID) error
+	TTL() time.Duration
+}
+
+type lockService struct {
+	repo LockRepository
+	ttl  time.Duration
+}
+
+func NewLockService(repo LockRepository) LockService {
+	return newLockService(repo, DefaultTTL)
+}
+
+func newLockService(repo LockRepository, ttl time.Duration) LockService {
+	return lockService{
+		repo: repo,
+		ttl:  ttl,
+	}
+}
+
+// Locks the document for the user, or extends the lock they already hold.
+// When someone else holds the lock it is returned with ErrLocked.
+func (s lockService) Acquire(documentId primitive.ObjectID, userId primitive.ObjectID) (Lock, error) {
+	if documentId.IsZero() {
+		return Lock{}, fmt.Errorf("lock has no document")
+	}
+	if userId.IsZero() {
+		return Lock{}, fmt.Errorf("lock has no user")
+	}
+
+	now := time.Now()
+	return s.repo.AcquireLock(Lock{
+		Id:       documentId,
+		UserId:   userId,
+		Acquired: now,
+		Expires:  now.Add(s.ttl),
+	}, now)
+}
+
+// Removes the lock whoever holds it
+func (s lockService) Break(documentId primitive.ObjectID) error {
+	return s.repo.DeleteLock(documentId)
+}
+
+// Fetches the active lock on the document. Expired locks are reported as
+// missing.
+func (s lockService) Get(documentId primitive.ObjectID) (l Lock, err error) {
+	if l, err = s.repo.GetLock(documentId); err != nil {
+		return
+	}
+	if !l.Active(time.Now()) {
+		return Lock{}, fmt.Errorf("lock on %s has expired", documentId.Hex())
+	}
+	return
+}
+
+// Removes the lock if the user holds it. Locks held by others are left alone.
+func (s lockService) Release(documentId primitive.ObjectID, userId primitive.ObjectID) error {
+	l, err := s.repo.GetLock(documentId)
+	if err != nil || l.UserId != userId {
+		return nil
+	}
+	return s.repo.DeleteLock(documentId)
+}
+
+// How long locks last between refreshes
+func (s lockService) TTL() time.Duration {
+	return s.ttl
+}
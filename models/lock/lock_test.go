@@ -0,0 +1,118 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ LockRepository = &mockLockRepository{}
+
+type mockLockRepository struct {
+	locks map[primitive.ObjectID]Lock
+}
+
+func NewMockLockRepository() *mockLockRepository {
+	return &mockLockRepository{
+		locks: make(map[primitive.ObjectID]Lock),
+	}
+}
+
+func (r *mockLockRepository) AcquireLock(l Lock, now time.Time) (Lock, error) {
+	if held, ok := r.locks[l.Id]; ok {
+		if held.UserId != l.UserId && held.Active(now) {
+			return held, fmt.Errorf("%w: %s", ErrLocked, l.Id.Hex())
+		}
+		if held.UserId == l.UserId {
+			l.Acquired = held.Acquired
+		}
+	}
+	r.locks[l.Id] = l
+	return l, nil
+}
+
+func (r *mockLockRepository) DeleteLock(id primitive.ObjectID) (err error) {
+	delete(r.locks, id)
+	return
+}
+
+func (r *mockLockRepository) GetLock(id primitive.ObjectID) (l Lock, err error) {
+	l, ok := r.locks[id]
+	if !ok {
+		err = fmt.Errorf("lock not found: %s", id.Hex())
+	}
+	return
+}
+
+func TestLockService(t *testing.T) {
+	repo := NewMockLockRepository()
+	service := newLockService(repo, time.Hour)
+	docId := primitive.NewObjectID()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := service.Acquire(primitive.NilObjectID, alice)
+		assert.Error(t, err)
+		_, err = service.Acquire(docId, primitive.NilObjectID)
+		assert.Error(t, err)
+	})
+
+	t.Run("Acquire", func(t *testing.T) {
+		l, err := service.Acquire(docId, alice)
+		assert.NoError(t, err)
+		assert.Equal(t, alice, l.UserId)
+		assert.True(t, l.Active(time.Now()))
+
+		// Heartbeats extend the lock without changing when it was taken
+		refreshed, err := service.Acquire(docId, alice)
+		assert.NoError(t, err)
+		assert.Equal(t, l.Acquired, refreshed.Acquired)
+		assert.False(t, refreshed.Expires.Before(l.Expires))
+
+		held, err := service.Acquire(docId, bob)
+		assert.True(t, errors.Is(err, ErrLocked))
+		assert.Equal(t, alice, held.UserId)
+
+		got, err := service.Get(docId)
+		assert.NoError(t, err)
+		assert.Equal(t, alice, got.UserId)
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		// Only the holder can release
+		assert.NoError(t, service.Release(docId, bob))
+		_, err := service.Get(docId)
+		assert.NoError(t, err)
+
+		assert.NoError(t, service.Release(docId, alice))
+		_, err = service.Get(docId)
+		assert.Error(t, err)
+	})
+
+	t.Run("Break", func(t *testing.T) {
+		_, err := service.Acquire(docId, alice)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Break(docId))
+		l, err := service.Acquire(docId, bob)
+		assert.NoError(t, err)
+		assert.Equal(t, bob, l.UserId)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		short := newLockService(repo, -time.Second)
+		other := primitive.NewObjectID()
+		_, err := short.Acquire(other, alice)
+		assert.NoError(t, err)
+		_, err = short.Get(other)
+		assert.Error(t, err)
+
+		// Expired locks are free for anyone to take
+		l, err := service.Acquire(other, bob)
+		assert.NoError(t, err)
+		assert.Equal(t, bob, l.UserId)
+	})
+}
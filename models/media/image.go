@@ -0,0 +1,274 @@
+package media
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"strconv"
+
+	// Formats which may be resized but not produced
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/image/draw"
+)
+
+// How a resized image fits the requested box
+const (
+	// Scales the image down until it fits within the box, keeping its aspect
+	// ratio. Images are never enlarged.
+	FitContain = "contain"
+	// Scales the image until it covers the box, then crops the overflow
+	FitCover = "cover"
+	// Stretches the image to the exact size of the box
+	FitFill = "fill"
+)
+
+// Formats derivatives may be encoded as
+const (
+	FormatGIF  = "gif"
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// Largest width or height a derivative may have
+const MaxImageSize = 4096
+
+// Most pixels an original may have before it is decoded. A small, highly
+// compressed file can claim dimensions which take gigabytes to decode.
+const MaxSourcePixels = 50_000_000
+
+type ImageOptions struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+}
+
+// Reads options from the w, h, fit and fm query parameters. Missing options
+// take their defaults, invalid ones return an error.
+func ParseImageOptions(query url.Values) (opts ImageOptions, err error) {
+	if w := query.Get("w"); w != "" {
+		if opts.Width, err = strconv.Atoi(w); err != nil {
+			return opts, fmt.Errorf("invalid width: %s", w)
+		}
+	}
+	if h := query.Get("h"); h != "" {
+		if opts.Height, err = strconv.Atoi(h); err != nil {
+			return opts, fmt.Errorf("invalid height: %s", h)
+		}
+	}
+	opts.Fit = query.Get("fit")
+	opts.Format = query.Get("fm")
+	return opts, opts.Validate()
+}
+
+func (o ImageOptions) Validate() error {
+	if o.Width < 0 || o.Height < 0 || o.Width > MaxImageSize || o.Height > MaxImageSize {
+		return fmt.Errorf("image sizes must be between 0 and %d", MaxImageSize)
+	}
+	if o.Width == 0 && o.Height == 0 {
+		return fmt.Errorf("image requires a width or height")
+	}
+
+	switch o.Fit {
+	case "", FitContain:
+	case FitCover, FitFill:
+		if o.Width == 0 || o.Height == 0 {
+			return fmt.Errorf("%s requires both a width and height", o.Fit)
+		}
+	default:
+		return fmt.Errorf("unknown fit: %s", o.Fit)
+	}
+
+	switch o.Format {
+	case "", FormatGIF, FormatJPEG, FormatPNG:
+	default:
+		return fmt.Errorf("unknown format: %s", o.Format)
+	}
+	return nil
+}
+
+// Fills in the defaults for the source media so equivalent requests share a
+// derivative
+func (o ImageOptions) normalize(m Media) ImageOptions {
+	if o.Fit == "" {
+		o.Fit = FitContain
+	}
+	if o.Format == "" {
+		switch m.MimeType {
+		case "image/gif":
+			o.Format = FormatGIF
+		case "image/jpeg":
+			o.Format = FormatJPEG
+		default:
+			o.Format = FormatPNG
+		}
+	}
+	return o
+}
+
+// Query string for the options, always in the same order so it can be signed
+func (o ImageOptions) Encode() string {
+	query := url.Values{}
+	if o.Width > 0 {
+		query.Set("w", strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		query.Set("h", strconv.Itoa(o.Height))
+	}
+	if o.Fit != "" {
+		query.Set("fit", o.Fit)
+	}
+	if o.Format != "" {
+		query.Set("fm", o.Format)
+	}
+	return query.Encode()
+}
+
+// Signs the options for a media item so only URLs generated with the secret
+// are served
+func SignImage(secret []byte, id primitive.ObjectID, opts ImageOptions) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id.Hex() + "?" + opts.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyImage(secret []byte, id primitive.ObjectID, opts ImageOptions, signature string) bool {
+	expect := SignImage(secret, id, opts)
+	return hmac.Equal([]byte(expect), []byte(signature))
+}
+
+// Key of a derivative in the blob store
+func (m Media) DerivativeKey(opts ImageOptions) string {
+	opts = opts.normalize(m)
+	return fmt.Sprintf("derivatives/%s/%dx%d-%s.%s", m.Key(), opts.Width, opts.Height, opts.Fit, opts.Format)
+}
+
+func (o ImageOptions) MimeType() string {
+	return "image/" + o.Format
+}
+
+// Scales the image into the box described by the options
+func Resize(src image.Image, opts ImageOptions) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return src
+	}
+
+	width, height := opts.Width, opts.Height
+	crop := bounds
+
+	switch opts.Fit {
+	case FitFill:
+	case FitCover:
+		// Crop the source to the aspect ratio of the box, centered
+		if srcW*height > srcH*width {
+			cropW := srcH * width / height
+			crop.Min.X += (srcW - cropW) / 2
+			crop.Max.X = crop.Min.X + cropW
+		} else {
+			cropH := srcW * height / width
+			crop.Min.Y += (srcH - cropH) / 2
+			crop.Max.Y = crop.Min.Y + cropH
+		}
+	default:
+		scale := 1.0
+		if width > 0 && float64(width)/float64(srcW) < scale {
+			scale = float64(width) / float64(srcW)
+		}
+		if height > 0 && float64(height)/float64(srcH) < scale {
+			scale = float64(height) / float64(srcH)
+		}
+		width = int(float64(srcW)*scale + 0.5)
+		height = int(float64(srcH)*scale + 0.5)
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
+
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+// Returns a resized copy of an image, generating and storing it the first time
+// the options are requested
+func (s mediaService) Derivative(m Media, opts ImageOptions) (rc io.ReadCloser, mimeType string, err error) {
+	if !m.IsImage() {
+		return nil, "", fmt.Errorf("%s is not an image", m.Filename)
+	}
+	if err = opts.Validate(); err != nil {
+		return
+	}
+
+	opts = opts.normalize(m)
+	mimeType = opts.MimeType()
+	key := m.DerivativeKey(opts)
+
+	if rc, err = s.blobs.Get(key); !errors.Is(err, ErrBlobNotFound) {
+		return
+	}
+
+	original, err := s.blobs.Get(m.Key())
+	if err != nil {
+		return
+	}
+	defer original.Close()
+
+	// Check the header before committing memory to the pixels. What the
+	// header read consumed is replayed ahead of the rest of the original.
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(original, &header))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", m.Filename, err)
+	}
+	if int64(config.Width)*int64(config.Height) > MaxSourcePixels {
+		return nil, "", fmt.Errorf("%s is too large to resize: %dx%d", m.Filename, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&header, original))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", m.Filename, err)
+	}
+
+	var buf bytes.Buffer
+	if err = encodeImage(&buf, Resize(src, opts), opts.Format); err != nil {
+		return
+	}
+
+	if err = s.blobs.Put(key, bytes.NewReader(buf.Bytes())); err != nil {
+		return
+	}
+	if err = s.repo.AddMediaDerivative(m.Id, key); err != nil {
+		return
+	}
+
+	return io.NopCloser(&buf), mimeType, nil
+}
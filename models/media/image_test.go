@@ -0,0 +1,158 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseImageOptions(t *testing.T) {
+	table := []struct {
+		Name   string
+		Query  string
+		Expect ImageOptions
+		Error  bool
+	}{
+		{"Width", "w=100", ImageOptions{Width: 100}, false},
+		{"Cover", "w=100&h=50&fit=cover&fm=jpeg", ImageOptions{Width: 100, Height: 50, Fit: FitCover, Format: FormatJPEG}, false},
+		{"No Size", "fit=contain", ImageOptions{}, true},
+		{"Bad Width", "w=wide", ImageOptions{}, true},
+		{"Too Large", "w=10000", ImageOptions{}, true},
+		{"Negative", "w=-1", ImageOptions{}, true},
+		{"Cover Needs Both", "w=100&fit=cover", ImageOptions{}, true},
+		{"Unknown Fit", "w=100&fit=stretch", ImageOptions{}, true},
+		{"Unknown Format", "w=100&fm=bmp", ImageOptions{}, true},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.Query)
+			assert.NoError(t, err)
+
+			opts, err := ParseImageOptions(query)
+			if test.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.Expect, opts)
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	table := []struct {
+		Name   string
+		Opts   ImageOptions
+		Width  int
+		Height int
+	}{
+		{"Contain Width", ImageOptions{Width: 100}, 100, 50},
+		{"Contain Height", ImageOptions{Height: 100}, 200, 100},
+		{"Contain Box", ImageOptions{Width: 100, Height: 100}, 100, 50},
+		{"Contain Never Enlarges", ImageOptions{Width: 800}, 400, 200},
+		{"Cover", ImageOptions{Width: 100, Height: 100, Fit: FitCover}, 100, 100},
+		{"Fill", ImageOptions{Width: 100, Height: 100, Fit: FitFill}, 100, 100},
+	}
+
+	for _, test := range table {
+		t.Run(test.Name, func(t *testing.T) {
+			bounds := Resize(src, test.Opts).Bounds()
+			assert.Equal(t, test.Width, bounds.Dx())
+			assert.Equal(t, test.Height, bounds.Dy())
+		})
+	}
+}
+
+func TestSignImage(t *testing.T) {
+	secret := []byte("secret")
+	id := primitive.NewObjectID()
+	opts := ImageOptions{Width: 100, Fit: FitContain}
+
+	signature := SignImage(secret, id, opts)
+	assert.True(t, VerifyImage(secret, id, opts, signature))
+	assert.False(t, VerifyImage([]byte("other"), id, opts, signature))
+	assert.False(t, VerifyImage(secret, primitive.NewObjectID(), opts, signature))
+	assert.False(t, VerifyImage(secret, id, ImageOptions{Width: 200, Fit: FitContain}, signature))
+	assert.False(t, VerifyImage(secret, id, opts, ""))
+}
+
+func TestDerivative(t *testing.T) {
+	repo, blobs := NewMockMediaRepository(), mockBlobStore{}
+	service := NewMediaService(repo, blobs)
+
+	m, err := service.Upload("photo.png", bytes.NewReader(newPNG(t, 40, 20)))
+	assert.NoError(t, err)
+
+	opts := ImageOptions{Width: 10, Format: FormatJPEG}
+	rc, mimeType, err := service.Derivative(m, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", mimeType)
+	resized, err := jpeg.Decode(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, resized.Bounds().Dx())
+	assert.Equal(t, 5, resized.Bounds().Dy())
+
+	key := m.DerivativeKey(opts)
+	assert.Equal(t, "derivatives/"+m.Key()+"/10x0-contain.jpeg", key)
+	_, ok := blobs[key]
+	assert.True(t, ok)
+
+	t.Run("Cached", func(t *testing.T) {
+		blobs[key] = []byte("cached")
+		rc, _, err := service.Derivative(m, opts)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.Equal(t, "cached", string(data))
+	})
+
+	t.Run("Default Format", func(t *testing.T) {
+		_, mimeType, err := service.Derivative(m, ImageOptions{Width: 10})
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", mimeType)
+	})
+
+	t.Run("Too Large", func(t *testing.T) {
+		// Rewrite the header of a tiny PNG to claim 10000x10000 pixels
+		data := newPNG(t, 1, 1)
+		ihdr := data[12:29]
+		binary.BigEndian.PutUint32(ihdr[4:], 10000)
+		binary.BigEndian.PutUint32(ihdr[8:], 10000)
+		binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(ihdr))
+
+		bomb, err := service.Upload("bomb.png", bytes.NewReader(data))
+		assert.NoError(t, err)
+		_, _, err = service.Derivative(bomb, opts)
+		assert.Error(t, err)
+		_, ok := blobs[bomb.DerivativeKey(opts)]
+		assert.False(t, ok)
+		assert.NoError(t, service.Delete(bomb))
+	})
+
+	t.Run("Not An Image", func(t *testing.T) {
+		text, err := service.Upload("notes.txt", bytes.NewReader([]byte("notes")))
+		assert.NoError(t, err)
+		_, _, err = service.Derivative(text, opts)
+		assert.Error(t, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		stored, err := service.GetById(m.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(stored.Derivatives))
+
+		assert.NoError(t, service.Delete(stored))
+		assert.Equal(t, 1, len(blobs))
+	})
+}
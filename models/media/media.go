@@ -0,0 +1,218 @@
+package media
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Image formats whose dimensions are read on upload
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Returned by blob stores when nothing is stored under a key
+var ErrBlobNotFound = errors.New("blob not found")
+
+type Media struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Filename string             `json:"filename" bson:"filename"`
+	MimeType string             `json:"mime_type" bson:"mime_type"`
+	Size     int64              `json:"size" bson:"size"`
+	// Hex encoded SHA-256 of the contents
+	Checksum string    `json:"checksum" bson:"checksum"`
+	Width    int       `json:"width" bson:"width,omitempty"`
+	Height   int       `json:"height" bson:"height,omitempty"`
+	Created  time.Time `json:"created" bson:"created"`
+
+	// Blob keys of every resized copy, removed along with the media
+	Derivatives []string `json:"-" bson:"derivatives,omitempty"`
+}
+
+type MediaList struct {
+	Total int64
+	Media []Media
+}
+
+type MediaListParams struct {
+	Page int64
+	Size int64
+}
+
+type MediaRepository interface {
+	AddMediaDerivative(primitive.ObjectID, string) error
+	DeleteMedia(primitive.ObjectID) error
+	GetMediaById(primitive.ObjectID) (Media, error)
+	GetMediaList(MediaListParams) (MediaList, error)
+	InsertMedia(*Media) error
+}
+
+// Holds file contents by key. Putting a key which already exists replaces
+// its contents.
+type BlobStore interface {
+	Delete(string) error
+	Get(string) (io.ReadCloser, error)
+	Put(string, io.Reader) error
+}
+
+type MediaService interface {
+	Delete(Media) error
+	Derivative(Media, ImageOptions) (io.ReadCloser, string, error)
+	GetById(primitive.ObjectID) (Media, error)
+	List(MediaListParams) (MediaList, error)
+	Open(Media) (io.ReadCloser, error)
+	Upload(string, io.Reader) (Media, error)
+}
+
+type mediaService struct {
+	repo  MediaRepository
+	blobs BlobStore
+}
+
+func NewMediaService(repo MediaRepository, blobs BlobStore) MediaService {
+	return mediaService{
+		repo:  repo,
+		blobs: blobs,
+	}
+}
+
+func (p MediaListParams) Offset() (offset int64) {
+	if p.Page > 0 {
+		offset = (p.Page - 1) * p.Size
+	}
+	return
+}
+
+func (m Media) IsImage() bool {
+	return strings.HasPrefix(m.MimeType, "image/")
+}
+
+// Raster image types a browser displays without running anything in them
+var inlineTypes = map[string]bool{
+	"image/bmp":  true,
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// Whether the media may be shown in the browser. Anything else, SVG and HTML
+// included, can carry script and must only be offered as a download.
+func (m Media) Inline() bool {
+	mediaType, _, err := mime.ParseMediaType(m.MimeType)
+	return err == nil && inlineTypes[mediaType]
+}
+
+// Key of the media contents in the blob store
+func (m Media) Key() string {
+	return m.Id.Hex()
+}
+
+func (s mediaService) Delete(m Media) (err error) {
+	if err = s.repo.DeleteMedia(m.Id); err != nil {
+		return
+	}
+	keys := make([]string, 0, len(m.Derivatives)+1)
+	keys = append(keys, m.Derivatives...)
+	keys = append(keys, m.Key())
+	for _, key := range keys {
+		if err = s.blobs.Delete(key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return
+		}
+	}
+	return nil
+}
+
+func (s mediaService) GetById(id primitive.ObjectID) (Media, error) {
+	return s.repo.GetMediaById(id)
+}
+
+func (s mediaService) List(params MediaListParams) (MediaList, error) {
+	return s.repo.GetMediaList(params)
+}
+
+func (s mediaService) Open(m Media) (io.ReadCloser, error) {
+	return s.blobs.Get(m.Key())
+}
+
+// Stores the contents in the blob store and records their metadata. The mime
+// type is sniffed from the contents, falling back to the file extension when
+// the contents are not recognized.
+func (s mediaService) Upload(filename string, r io.Reader) (m Media, err error) {
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		return m, fmt.Errorf("upload requires a filename")
+	}
+
+	m = Media{
+		Id:       primitive.NewObjectID(),
+		Filename: filename,
+		Created:  time.Now(),
+	}
+
+	buffered := bufio.NewReader(r)
+	head, _ := buffered.Peek(512)
+	m.MimeType = detectType(filename, head)
+
+	hash := sha256.New()
+	counter := &countWriter{}
+	if err = s.blobs.Put(m.Key(), io.TeeReader(buffered, io.MultiWriter(hash, counter))); err != nil {
+		return m, fmt.Errorf("storing %s: %w", filename, err)
+	}
+	m.Size = counter.n
+	m.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if m.IsImage() {
+		m.Width, m.Height = s.dimensions(m)
+	}
+
+	if err = s.repo.InsertMedia(&m); err != nil {
+		s.blobs.Delete(m.Key())
+	}
+	return
+}
+
+// Reads the size of an image without decoding it. Formats without a
+// registered decoder, such as SVG, have no dimensions.
+func (s mediaService) dimensions(m Media) (width int, height int) {
+	rc, err := s.blobs.Get(m.Key())
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	config, _, err := image.DecodeConfig(rc)
+	if err != nil {
+		return
+	}
+	return config.Width, config.Height
+}
+
+func detectType(filename string, head []byte) string {
+	detected := http.DetectContentType(head)
+	generic := detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain")
+	if byExtension := mime.TypeByExtension(filepath.Ext(filename)); generic && byExtension != "" {
+		return byExtension
+	}
+	return detected
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
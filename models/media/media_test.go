@@ -0,0 +1,187 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ MediaRepository = mockMediaRepository{}
+
+type mockMediaRepository struct {
+	media map[primitive.ObjectID]Media
+	fail  bool
+}
+
+func NewMockMediaRepository() mockMediaRepository {
+	return mockMediaRepository{
+		media: make(map[primitive.ObjectID]Media),
+	}
+}
+
+func (r mockMediaRepository) AddMediaDerivative(id primitive.ObjectID, key string) (err error) {
+	m, ok := r.media[id]
+	if !ok {
+		return fmt.Errorf("media not found: %s", id.Hex())
+	}
+	m.Derivatives = append(m.Derivatives, key)
+	r.media[id] = m
+	return
+}
+
+func (r mockMediaRepository) DeleteMedia(id primitive.ObjectID) (err error) {
+	delete(r.media, id)
+	return
+}
+
+func (r mockMediaRepository) GetMediaById(id primitive.ObjectID) (m Media, err error) {
+	m, ok := r.media[id]
+	if !ok {
+		err = fmt.Errorf("media not found: %s", id.Hex())
+	}
+	return
+}
+
+func (r mockMediaRepository) GetMediaList(params MediaListParams) (list MediaList, err error) {
+	for _, m := range r.media {
+		list.Media = append(list.Media, m)
+	}
+	list.Total = int64(len(list.Media))
+	return
+}
+
+func (r mockMediaRepository) InsertMedia(m *Media) (err error) {
+	if r.fail {
+		return fmt.Errorf("insert failed")
+	}
+	r.media[m.Id] = *m
+	return
+}
+
+var _ BlobStore = mockBlobStore{}
+
+type mockBlobStore map[string][]byte
+
+func (b mockBlobStore) Delete(key string) (err error) {
+	if _, ok := b[key]; !ok {
+		return ErrBlobNotFound
+	}
+	delete(b, key)
+	return
+}
+
+func (b mockBlobStore) Get(key string) (io.ReadCloser, error) {
+	data, ok := b[key]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b mockBlobStore) Put(key string, r io.Reader) (err error) {
+	b[key], err = io.ReadAll(r)
+	return
+}
+
+func newPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func TestUpload(t *testing.T) {
+	t.Run("Image", func(t *testing.T) {
+		repo, blobs := NewMockMediaRepository(), mockBlobStore{}
+		service := NewMediaService(repo, blobs)
+
+		data := newPNG(t, 40, 30)
+		m, err := service.Upload("C:\\Photos\\pixel.png", bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.False(t, m.Id.IsZero())
+		assert.Equal(t, "pixel.png", m.Filename)
+		assert.Equal(t, "image/png", m.MimeType)
+		assert.Equal(t, len(data), m.Size)
+		assert.Equal(t, 40, m.Width)
+		assert.Equal(t, 30, m.Height)
+
+		sum := sha256.Sum256(data)
+		assert.Equal(t, hex.EncodeToString(sum[:]), m.Checksum)
+		assert.DeepEqual(t, data, blobs[m.Key()])
+
+		stored, err := service.GetById(m.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, m.Checksum, stored.Checksum)
+	})
+
+	t.Run("Extension Fallback", func(t *testing.T) {
+		service := NewMediaService(NewMockMediaRepository(), mockBlobStore{})
+
+		m, err := service.Upload("styles.css", strings.NewReader("body { color: red; }"))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(m.MimeType, "text/css"))
+		assert.False(t, m.IsImage())
+		assert.Equal(t, 0, m.Width)
+	})
+
+	t.Run("No Filename", func(t *testing.T) {
+		service := NewMediaService(NewMockMediaRepository(), mockBlobStore{})
+		_, err := service.Upload("", strings.NewReader("data"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Insert Failure", func(t *testing.T) {
+		repo, blobs := NewMockMediaRepository(), mockBlobStore{}
+		repo.fail = true
+		service := NewMediaService(repo, blobs)
+
+		_, err := service.Upload("file.txt", strings.NewReader("data"))
+		assert.Error(t, err)
+		assert.Equal(t, 0, len(blobs))
+	})
+}
+
+func TestInline(t *testing.T) {
+	for mimeType, inline := range map[string]bool{
+		"image/png":                true,
+		"image/jpeg":               true,
+		"image/svg+xml":            false,
+		"text/html; charset=utf-8": false,
+		"text/plain":               false,
+		"application/octet-stream": false,
+		"":                         false,
+	} {
+		assert.Equal(t, inline, Media{MimeType: mimeType}.Inline())
+	}
+}
+
+func TestDelete(t *testing.T) {
+	repo, blobs := NewMockMediaRepository(), mockBlobStore{}
+	service := NewMediaService(repo, blobs)
+
+	m, err := service.Upload("file.txt", strings.NewReader("data"))
+	assert.NoError(t, err)
+
+	rc, err := service.Open(m)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	assert.NoError(t, service.Delete(m))
+	_, err = service.GetById(m.Id)
+	assert.Error(t, err)
+	_, err = service.Open(m)
+	assert.Error(t, err)
+
+	// Contents already gone from the store
+	assert.NoError(t, service.Delete(m))
+}
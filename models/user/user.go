@@ -1,8 +1,11 @@
 package user
 
 import (
+	"context"
 	"fmt"
+	"log"
 
+	"github.com/jbaikge/gocms/models/audit"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -16,32 +19,60 @@ type User struct {
 }
 
 type UserRepository interface {
-	GetUserByEmail(string) (User, error)
-	GetUserById(primitive.ObjectID) (User, error)
-	InsertUser(*User) error
-	UpdateUser(*User) error
+	GetUserByEmail(context.Context, string) (User, error)
+	GetUserById(context.Context, primitive.ObjectID) (User, error)
+	InsertUser(context.Context, *User) error
+	UpdateUser(context.Context, *User) error
 }
 
 type UserService interface {
-	Authenticate(string, string) (User, error)
-	GetByEmail(string) (User, error)
-	GetById(primitive.ObjectID) (User, error)
-	Insert(*User) error
-	Update(*User) error
+	As(primitive.ObjectID) UserService
+	Authenticate(context.Context, string, string) (User, error)
+	GetByEmail(context.Context, string) (User, error)
+	GetById(context.Context, primitive.ObjectID) (User, error)
+	Insert(context.Context, *User) error
+	Update(context.Context, *User) error
 }
 
 type userService struct {
-	repo UserRepository
+	repo  UserRepository
+	audit audit.Recorder
+	// User credited with changes in the audit log
+	actor primitive.ObjectID
 }
 
-func NewUserService(repo UserRepository) UserService {
+// Changes to users are recorded with the recorder
+func NewUserService(repo UserRepository, recorder audit.Recorder) UserService {
 	return userService{
-		repo: repo,
+		repo:  repo,
+		audit: recorder,
 	}
 }
 
-func (s userService) Authenticate(email string, password string) (user User, err error) {
-	u, err := s.GetByEmail(email)
+// Copy of the service attributing changes to the user
+func (s userService) As(actor primitive.ObjectID) UserService {
+	s.actor = actor
+	return s
+}
+
+// Appends an entry about the user to the audit log. The change is already
+// stored, so a failure is logged rather than returned.
+func (s userService) record(action string, user User, changes []string) {
+	err := s.audit.Record(audit.Entry{
+		ActorId:    s.actor,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetId:   user.Id,
+		Summary:    audit.Summary(action, audit.TargetUser, user.Email),
+		Changes:    changes,
+	})
+	if err != nil {
+		log.Printf("Auditing %s of user %s: %v", action, user.Id.Hex(), err)
+	}
+}
+
+func (s userService) Authenticate(ctx context.Context, email string, password string) (user User, err error) {
+	u, err := s.GetByEmail(ctx, email)
 	if err != nil {
 		return
 	}
@@ -60,20 +91,20 @@ func (s userService) Authenticate(email string, password string) (user User, err
 	return u, nil
 }
 
-func (s userService) GetByEmail(email string) (User, error) {
-	return s.repo.GetUserByEmail(email)
+func (s userService) GetByEmail(ctx context.Context, email string) (User, error) {
+	return s.repo.GetUserByEmail(ctx, email)
 }
 
-func (s userService) GetById(id primitive.ObjectID) (User, error) {
-	return s.repo.GetUserById(id)
+func (s userService) GetById(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return s.repo.GetUserById(ctx, id)
 }
 
-func (s userService) Insert(user *User) (err error) {
+func (s userService) Insert(ctx context.Context, user *User) (err error) {
 	if err = s.Validate(user); err != nil {
 		return
 	}
 
-	check, _ := s.GetByEmail(user.Email)
+	check, _ := s.GetByEmail(ctx, user.Email)
 	if !check.Id.IsZero() {
 		return fmt.Errorf("user with email %s already exists", user.Email)
 	}
@@ -91,10 +122,14 @@ func (s userService) Insert(user *User) (err error) {
 		user.Password = string(hashed)
 	}
 
-	return s.repo.InsertUser(user)
+	if err = s.repo.InsertUser(ctx, user); err != nil {
+		return
+	}
+	s.record(audit.ActionCreate, *user, nil)
+	return
 }
 
-func (s userService) Update(user *User) (err error) {
+func (s userService) Update(ctx context.Context, user *User) (err error) {
 	if err = s.Validate(user); err != nil {
 		return
 	}
@@ -103,7 +138,7 @@ func (s userService) Update(user *User) (err error) {
 		return fmt.Errorf("user has no ID")
 	}
 
-	check, _ := s.GetByEmail(user.Email)
+	check, _ := s.GetByEmail(ctx, user.Email)
 	if !check.Id.IsZero() && check.Id != user.Id {
 		return fmt.Errorf("email already used by another user: %s", user.Email)
 	}
@@ -125,7 +160,15 @@ func (s userService) Update(user *User) (err error) {
 		user.Password = string(hashed)
 	}
 
-	return s.repo.UpdateUser(user)
+	before, err := s.repo.GetUserById(ctx, user.Id)
+	if err != nil {
+		return
+	}
+	if err = s.repo.UpdateUser(ctx, user); err != nil {
+		return
+	}
+	s.record(audit.ActionUpdate, *user, audit.Diff(before, *user))
+	return
 }
 
 func (s userService) Validate(user *User) (err error) {
@@ -1,9 +1,11 @@
 package user
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/zeebo/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -22,7 +24,7 @@ func NewMockUserRepository() mockUserRepository {
 	}
 }
 
-func (r mockUserRepository) DeleteUser(id primitive.ObjectID) (err error) {
+func (r mockUserRepository) DeleteUser(ctx context.Context, id primitive.ObjectID) (err error) {
 	user, ok := r.byId[id]
 	if !ok {
 		// Silent failure
@@ -33,7 +35,7 @@ func (r mockUserRepository) DeleteUser(id primitive.ObjectID) (err error) {
 	return
 }
 
-func (r mockUserRepository) GetUserByEmail(email string) (user User, err error) {
+func (r mockUserRepository) GetUserByEmail(ctx context.Context, email string) (user User, err error) {
 	user, ok := r.byEmail[email]
 	if !ok {
 		err = fmt.Errorf("user not found: %s", email)
@@ -41,7 +43,7 @@ func (r mockUserRepository) GetUserByEmail(email string) (user User, err error)
 	return
 }
 
-func (r mockUserRepository) GetUserById(id primitive.ObjectID) (user User, err error) {
+func (r mockUserRepository) GetUserById(ctx context.Context, id primitive.ObjectID) (user User, err error) {
 	user, ok := r.byId[id]
 	if !ok {
 		err = fmt.Errorf("user not found: %s", id)
@@ -49,15 +51,15 @@ func (r mockUserRepository) GetUserById(id primitive.ObjectID) (user User, err e
 	return
 }
 
-func (r mockUserRepository) InsertUser(user *User) (err error) {
+func (r mockUserRepository) InsertUser(ctx context.Context, user *User) (err error) {
 	user.Id = primitive.NewObjectID()
 	r.byId[user.Id] = *user
 	r.byEmail[user.Email] = *user
 	return
 }
 
-func (r mockUserRepository) UpdateUser(user *User) (err error) {
-	if err = r.DeleteUser(user.Id); err != nil {
+func (r mockUserRepository) UpdateUser(ctx context.Context, user *User) (err error) {
+	if err = r.DeleteUser(ctx, user.Id); err != nil {
 		return
 	}
 	r.byId[user.Id] = *user
@@ -66,13 +68,14 @@ func (r mockUserRepository) UpdateUser(user *User) (err error) {
 }
 
 func TestAuthenticate(t *testing.T) {
-	service := NewUserService(NewMockUserRepository())
+	ctx := context.Background()
+	service := NewUserService(NewMockUserRepository(), audit.Discard)
 
 	noPassUser := User{
 		DisplayName: "Auth User",
 		Email:       "test@test.com",
 	}
-	assert.NoError(t, service.Insert(&noPassUser))
+	assert.NoError(t, service.Insert(ctx, &noPassUser))
 
 	password := "weakPassword"
 	passUser := User{
@@ -80,76 +83,79 @@ func TestAuthenticate(t *testing.T) {
 		Email:       "pass@test.com",
 		Password:    password,
 	}
-	assert.NoError(t, service.Insert(&passUser))
+	assert.NoError(t, service.Insert(ctx, &passUser))
 
-	userBlank, err := service.Authenticate(noPassUser.Email, "badPassword")
+	userBlank, err := service.Authenticate(ctx, noPassUser.Email, "badPassword")
 	assert.Error(t, err)
 	assert.True(t, userBlank.Id.IsZero())
 
 	// Set a password when existing is blank
 	noPassUser.Password = password
-	assert.NoError(t, service.Update(&noPassUser))
+	assert.NoError(t, service.Update(ctx, &noPassUser))
 
 	// Reset password
 	newPassword := "newPassword"
 	passUser.Password = newPassword
-	assert.NoError(t, service.Update(&passUser))
+	assert.NoError(t, service.Update(ctx, &passUser))
 
 	// Leave password alone when updating with a blank password
 	passUser.Password = ""
-	assert.NoError(t, service.Update(&passUser))
+	assert.NoError(t, service.Update(ctx, &passUser))
 
 	// Bad email attempt
-	badEmail, err := service.Authenticate("unknown@test.com", newPassword)
+	badEmail, err := service.Authenticate(ctx, "unknown@test.com", newPassword)
 	assert.Error(t, err)
 	assert.True(t, badEmail.Id.IsZero())
 
 	// Blank password attempt
-	testBlank, err := service.Authenticate(passUser.Email, "")
+	testBlank, err := service.Authenticate(ctx, passUser.Email, "")
 	assert.Error(t, err)
 	assert.True(t, testBlank.Id.IsZero())
 
 	// Invalid password attempt
-	invalid, err := service.Authenticate(passUser.Email, password)
+	invalid, err := service.Authenticate(ctx, passUser.Email, password)
 	assert.Error(t, err)
 	assert.True(t, invalid.Id.IsZero())
 
 	// Correct password
-	valid, err := service.Authenticate(passUser.Email, newPassword)
+	valid, err := service.Authenticate(ctx, passUser.Email, newPassword)
 	assert.NoError(t, err)
 	assert.Equal(t, passUser.Id, valid.Id)
 }
 
 func TestGetByEmail(t *testing.T) {
-	service := NewUserService(NewMockUserRepository())
+	ctx := context.Background()
+	service := NewUserService(NewMockUserRepository(), audit.Discard)
 
 	user := User{DisplayName: "Test Testerly", Email: "test@test.com"}
-	assert.NoError(t, service.Insert(&user))
+	assert.NoError(t, service.Insert(ctx, &user))
 
-	check, err := service.GetByEmail(user.Email)
+	check, err := service.GetByEmail(ctx, user.Email)
 	assert.NoError(t, err)
 	assert.Equal(t, user.Id, check.Id)
 
-	_, err = service.GetByEmail("moo@cow.com")
+	_, err = service.GetByEmail(ctx, "moo@cow.com")
 	assert.Error(t, err)
 }
 
 func TestGetById(t *testing.T) {
-	service := NewUserService(NewMockUserRepository())
+	ctx := context.Background()
+	service := NewUserService(NewMockUserRepository(), audit.Discard)
 
 	user := User{DisplayName: "Test Testerly", Email: "test@test.com"}
-	assert.NoError(t, service.Insert(&user))
+	assert.NoError(t, service.Insert(ctx, &user))
 
-	check, err := service.GetById(user.Id)
+	check, err := service.GetById(ctx, user.Id)
 	assert.NoError(t, err)
 	assert.Equal(t, user.Id, check.Id)
 
-	_, err = service.GetById(primitive.NewObjectID())
+	_, err = service.GetById(ctx, primitive.NewObjectID())
 	assert.Error(t, err)
 }
 
 func TestInsert(t *testing.T) {
-	service := NewUserService(NewMockUserRepository())
+	ctx := context.Background()
+	service := NewUserService(NewMockUserRepository(), audit.Discard)
 
 	tests := []struct {
 		Name  string
@@ -195,7 +201,7 @@ func TestInsert(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			err := service.Insert(&test.User)
+			err := service.Insert(ctx, &test.User)
 			if test.Error {
 				t.Logf("%s: %v", test.Name, err)
 				assert.Error(t, err)
@@ -207,24 +213,25 @@ func TestInsert(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	service := NewUserService(NewMockUserRepository())
+	ctx := context.Background()
+	service := NewUserService(NewMockUserRepository(), audit.Discard)
 
 	user1 := User{DisplayName: "User One", Email: "one@test.com"}
-	assert.NoError(t, service.Insert(&user1))
+	assert.NoError(t, service.Insert(ctx, &user1))
 
 	user2 := User{DisplayName: "User Two", Email: "two@test.com"}
-	assert.NoError(t, service.Insert(&user2))
+	assert.NoError(t, service.Insert(ctx, &user2))
 
 	t.Run("No ID", func(t *testing.T) {
 		user := User{DisplayName: "User NoID", Email: "noid@test.com"}
-		assert.Error(t, service.Update(&user))
+		assert.Error(t, service.Update(ctx, &user))
 	})
 
 	t.Run("Simple Update", func(t *testing.T) {
 		newName := "One User"
 		user1.DisplayName = newName
-		assert.NoError(t, service.Update(&user1))
-		check, err := service.GetById(user1.Id)
+		assert.NoError(t, service.Update(ctx, &user1))
+		check, err := service.GetById(ctx, user1.Id)
 		assert.NoError(t, err)
 		assert.Equal(t, newName, check.DisplayName)
 	})
@@ -232,12 +239,12 @@ func TestUpdate(t *testing.T) {
 	t.Run("Fail Validation", func(t *testing.T) {
 		fail := user1
 		fail.DisplayName = ""
-		assert.Error(t, service.Update(&fail))
+		assert.Error(t, service.Update(ctx, &fail))
 	})
 
 	t.Run("Overtake Email", func(t *testing.T) {
 		overtake := user2
 		overtake.Email = user1.Email
-		assert.Error(t, service.Update(&overtake))
+		assert.Error(t, service.Update(ctx, &overtake))
 	})
 }
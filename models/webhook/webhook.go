@@ -0,0 +1,429 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderDelivery  = "X-Gocms-Delivery"
+	HeaderEvent     = "X-Gocms-Event"
+	HeaderSignature = "X-Gocms-Signature"
+)
+
+// Deliveries are attempted this many times, waiting twice as long as the last
+// time between each attempt
+const (
+	DefaultAttempts = 5
+	DefaultBackoff  = 2 * time.Second
+)
+
+// Number of deliveries listed in the delivery log
+const DeliveryLimit = 50
+
+// Every event a webhook may subscribe to, in the order they are listed
+var Events = []string{
+	document.EventCreated,
+	document.EventUpdated,
+	document.EventPublished,
+	document.EventDeleted,
+	class.EventChanged,
+}
+
+// Sends event payloads to a URL. Webhooks without classes hear about every
+// class.
+type Webhook struct {
+	Id       primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Name     string               `json:"name" bson:"name"`
+	URL      string               `json:"url" bson:"url"`
+	Secret   string               `json:"-" bson:"secret"`
+	Events   []string             `json:"events" bson:"events"`
+	ClassIds []primitive.ObjectID `json:"class_ids" bson:"class_ids"`
+	Active   bool                 `json:"active" bson:"active"`
+	Created  time.Time            `json:"created" bson:"created"`
+	Updated  time.Time            `json:"updated" bson:"updated"`
+}
+
+// One event sent to a webhook, along with the outcome of the latest attempt
+type Delivery struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	WebhookId primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	Event     string             `json:"event" bson:"event"`
+	Payload   string             `json:"payload" bson:"payload"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	// Response code of the latest attempt, zero when no response came back
+	StatusCode int    `json:"status_code" bson:"status_code"`
+	Error      string `json:"error" bson:"error"`
+	// Set to the original delivery when sent again by hand
+	RedeliveryOf primitive.ObjectID `json:"redelivery_of" bson:"redelivery_of,omitempty"`
+	Created      time.Time          `json:"created" bson:"created"`
+	Delivered    time.Time          `json:"delivered" bson:"delivered,omitempty"`
+}
+
+// Body of every delivery. Only one of Document and Class is set, depending on
+// the event.
+type Payload struct {
+	Event    string           `json:"event"`
+	Time     time.Time        `json:"time"`
+	Document *PayloadDocument `json:"document,omitempty"`
+	Class    *PayloadClass    `json:"class,omitempty"`
+}
+
+type PayloadDocument struct {
+	Id        primitive.ObjectID     `json:"id"`
+	ClassId   primitive.ObjectID     `json:"class_id"`
+	Title     string                 `json:"title"`
+	Slug      string                 `json:"slug"`
+	Published time.Time              `json:"published"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+type PayloadClass struct {
+	Id   primitive.ObjectID `json:"id"`
+	Name string             `json:"name"`
+	Slug string             `json:"slug"`
+}
+
+// Reports whether the webhook wants to hear about the event in the class
+func (w Webhook) Wants(event string, classId primitive.ObjectID) bool {
+	if !w.Active || !contains(w.Events, event) {
+		return false
+	}
+	if len(w.ClassIds) == 0 {
+		return true
+	}
+	for _, id := range w.ClassIds {
+		if id == classId {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the delivery got a successful response
+func (d Delivery) Succeeded() bool {
+	return !d.Delivered.IsZero()
+}
+
+// Signature of a payload sent in the signature header. Receivers compute the
+// same value with their copy of the secret to check a delivery came from us.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type WebhookRepository interface {
+	DeleteDeliveries(primitive.ObjectID) error
+	DeleteWebhook(primitive.ObjectID) error
+	// Lists the newest deliveries to a webhook first, up to the limit
+	GetDeliveries(primitive.ObjectID, int64) ([]Delivery, error)
+	GetDeliveryById(primitive.ObjectID) (Delivery, error)
+	GetWebhookById(primitive.ObjectID) (Webhook, error)
+	GetWebhooks() ([]Webhook, error)
+	InsertDelivery(*Delivery) error
+	InsertWebhook(*Webhook) error
+	UpdateDelivery(*Delivery) error
+	UpdateWebhook(*Webhook) error
+}
+
+type WebhookService interface {
+	Delete(Webhook) error
+	Deliveries(Webhook) ([]Delivery, error)
+	GetById(primitive.ObjectID) (Webhook, error)
+	Insert(*Webhook) error
+	List() ([]Webhook, error)
+	NotifyClass(string, class.Class) error
+	NotifyDocument(string, document.Document) error
+	Redeliver(primitive.ObjectID) (Delivery, error)
+	Update(*Webhook) error
+	Validate(*Webhook) error
+	// Blocks until deliveries in progress are done
+	Wait()
+}
+
+type webhookService struct {
+	ctx      context.Context
+	repo     WebhookRepository
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	pending  *sync.WaitGroup
+}
+
+// Deliveries are sent with the client in the background, retrying failures
+// until they succeed or ctx is done. Cancel ctx on shutdown, then Wait.
+func NewWebhookService(ctx context.Context, repo WebhookRepository, client *http.Client) WebhookService {
+	return newWebhookService(ctx, repo, client, DefaultAttempts, DefaultBackoff)
+}
+
+func newWebhookService(ctx context.Context, repo WebhookRepository, client *http.Client, attempts int, backoff time.Duration) webhookService {
+	return webhookService{
+		ctx:      ctx,
+		repo:     repo,
+		client:   client,
+		attempts: attempts,
+		backoff:  backoff,
+		pending:  new(sync.WaitGroup),
+	}
+}
+
+// Removes the webhook along with its delivery log
+func (s webhookService) Delete(w Webhook) (err error) {
+	if err = s.repo.DeleteDeliveries(w.Id); err != nil {
+		return
+	}
+	return s.repo.DeleteWebhook(w.Id)
+}
+
+func (s webhookService) Deliveries(w Webhook) ([]Delivery, error) {
+	return s.repo.GetDeliveries(w.Id, DeliveryLimit)
+}
+
+func (s webhookService) GetById(id primitive.ObjectID) (Webhook, error) {
+	return s.repo.GetWebhookById(id)
+}
+
+// Stores the webhook, generating a secret unless one is given
+func (s webhookService) Insert(w *Webhook) (err error) {
+	if err = s.Validate(w); err != nil {
+		return
+	}
+	if !w.Id.IsZero() {
+		return fmt.Errorf("webhook already has an ID")
+	}
+
+	if w.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return
+		}
+		w.Secret = hex.EncodeToString(secret)
+	}
+	w.Created = time.Now()
+	w.Updated = w.Created
+	return s.repo.InsertWebhook(w)
+}
+
+func (s webhookService) List() ([]Webhook, error) {
+	return s.repo.GetWebhooks()
+}
+
+// Queues the class event for every webhook wanting it
+func (s webhookService) NotifyClass(event string, c class.Class) error {
+	return s.notify(c.Id, Payload{
+		Event: event,
+		Class: &PayloadClass{
+			Id:   c.Id,
+			Name: c.Name,
+			Slug: c.Slug,
+		},
+	})
+}
+
+// Queues the document event for every webhook wanting it
+func (s webhookService) NotifyDocument(event string, doc document.Document) error {
+	return s.notify(doc.ClassId, Payload{
+		Event: event,
+		Document: &PayloadDocument{
+			Id:        doc.Id,
+			ClassId:   doc.ClassId,
+			Title:     doc.Title,
+			Slug:      doc.Slug,
+			Published: doc.Published,
+			Values:    doc.Values,
+		},
+	})
+}
+
+// Stores a delivery for each webhook wanting the event, then sends them in the
+// background. A delivery which cannot be stored does not hold up the others;
+// the first such error is returned once every webhook has had its turn.
+func (s webhookService) notify(classId primitive.ObjectID, payload Payload) (err error) {
+	webhooks, err := s.repo.GetWebhooks()
+	if err != nil {
+		return
+	}
+
+	payload.Time = time.Now()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	for _, w := range webhooks {
+		if !w.Wants(payload.Event, classId) {
+			continue
+		}
+		d := Delivery{
+			WebhookId: w.Id,
+			Event:     payload.Event,
+			Payload:   string(body),
+			Created:   payload.Time,
+		}
+		if insertErr := s.repo.InsertDelivery(&d); insertErr != nil {
+			if err == nil {
+				err = fmt.Errorf("delivery to %s: %w", w.Name, insertErr)
+			}
+			continue
+		}
+		s.send(w, d)
+	}
+	return
+}
+
+// Sends a copy of an earlier delivery, regardless of how that one went
+func (s webhookService) Redeliver(id primitive.ObjectID) (d Delivery, err error) {
+	original, err := s.repo.GetDeliveryById(id)
+	if err != nil {
+		return
+	}
+	w, err := s.repo.GetWebhookById(original.WebhookId)
+	if err != nil {
+		return
+	}
+
+	d = Delivery{
+		WebhookId:    w.Id,
+		Event:        original.Event,
+		Payload:      original.Payload,
+		RedeliveryOf: original.Id,
+		Created:      time.Now(),
+	}
+	if err = s.repo.InsertDelivery(&d); err != nil {
+		return
+	}
+	s.send(w, d)
+	return
+}
+
+func (s webhookService) Update(w *Webhook) (err error) {
+	if err = s.Validate(w); err != nil {
+		return
+	}
+	if w.Id.IsZero() {
+		return fmt.Errorf("webhook has no ID")
+	}
+
+	// The secret is kept when not changed
+	if w.Secret == "" {
+		stored, err := s.repo.GetWebhookById(w.Id)
+		if err != nil {
+			return err
+		}
+		w.Secret = stored.Secret
+	}
+	w.Updated = time.Now()
+	return s.repo.UpdateWebhook(w)
+}
+
+func (s webhookService) Validate(w *Webhook) (err error) {
+	if w.Name == "" {
+		return fmt.Errorf("webhook requires a name")
+	}
+
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL must be an absolute http or https URL: %s", w.URL)
+	}
+
+	if len(w.Events) == 0 {
+		return fmt.Errorf("webhook requires at least one event")
+	}
+	for _, event := range w.Events {
+		if !contains(Events, event) {
+			return fmt.Errorf("unknown event: %s", event)
+		}
+	}
+	return nil
+}
+
+func (s webhookService) Wait() {
+	s.pending.Wait()
+}
+
+// Delivers in the background, retrying with backoff until a successful
+// response, the attempts run out or the service context is done. The delivery
+// log is updated after every attempt.
+func (s webhookService) send(w Webhook, d Delivery) {
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+
+		wait := s.backoff
+		for d.Attempts < s.attempts {
+			if d.Attempts > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-s.ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				wait *= 2
+			}
+			d.StatusCode, d.Error = s.post(w, d)
+			d.Attempts++
+			if d.Error == "" {
+				d.Delivered = time.Now()
+			}
+			// Nothing is left to report a failed update to
+			_ = s.repo.UpdateDelivery(&d)
+			if d.Succeeded() {
+				return
+			}
+		}
+	}()
+}
+
+// Makes one attempt at the delivery, returning the response code and what
+// went wrong, if anything
+func (s webhookService) post(w Webhook, d Delivery) (code int, problem string) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, d.Id.Hex())
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderSignature, Sign(w.Secret, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	// Reading the body lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, resp.Status
+	}
+	return resp.StatusCode, ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,456 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var _ WebhookRepository = &mockWebhookRepository{}
+
+// Deliveries are updated in the background, hence the lock
+type mockWebhookRepository struct {
+	mu         sync.Mutex
+	webhooks   map[primitive.ObjectID]Webhook
+	deliveries []Delivery
+}
+
+func NewMockWebhookRepository() *mockWebhookRepository {
+	return &mockWebhookRepository{
+		webhooks: make(map[primitive.ObjectID]Webhook),
+	}
+}
+
+func (r *mockWebhookRepository) DeleteDeliveries(webhookId primitive.ObjectID) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.deliveries[:0]
+	for _, d := range r.deliveries {
+		if d.WebhookId != webhookId {
+			kept = append(kept, d)
+		}
+	}
+	r.deliveries = kept
+	return
+}
+
+func (r *mockWebhookRepository) DeleteWebhook(id primitive.ObjectID) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.webhooks, id)
+	return
+}
+
+func (r *mockWebhookRepository) GetDeliveries(webhookId primitive.ObjectID, limit int64) (deliveries []Delivery, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.deliveries) - 1; i >= 0 && int64(len(deliveries)) < limit; i-- {
+		if r.deliveries[i].WebhookId == webhookId {
+			deliveries = append(deliveries, r.deliveries[i])
+		}
+	}
+	return
+}
+
+func (r *mockWebhookRepository) GetDeliveryById(id primitive.ObjectID) (d Delivery, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.deliveries {
+		if d.Id == id {
+			return d, nil
+		}
+	}
+	err = fmt.Errorf("delivery not found: %s", id.Hex())
+	return
+}
+
+func (r *mockWebhookRepository) GetWebhookById(id primitive.ObjectID) (w Webhook, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.webhooks[id]
+	if !ok {
+		err = fmt.Errorf("webhook not found: %s", id.Hex())
+	}
+	return
+}
+
+func (r *mockWebhookRepository) GetWebhooks() (webhooks []Webhook, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].Name < webhooks[j].Name })
+	return
+}
+
+func (r *mockWebhookRepository) InsertDelivery(d *Delivery) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d.Id = primitive.NewObjectID()
+	r.deliveries = append(r.deliveries, *d)
+	return
+}
+
+func (r *mockWebhookRepository) InsertWebhook(w *Webhook) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w.Id = primitive.NewObjectID()
+	r.webhooks[w.Id] = *w
+	return
+}
+
+func (r *mockWebhookRepository) UpdateDelivery(d *Delivery) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.deliveries {
+		if r.deliveries[i].Id == d.Id {
+			r.deliveries[i] = *d
+			return
+		}
+	}
+	return fmt.Errorf("delivery not found: %s", d.Id.Hex())
+}
+
+func (r *mockWebhookRepository) UpdateWebhook(w *Webhook) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.webhooks[w.Id]; !ok {
+		return fmt.Errorf("webhook not found: %s", w.Id.Hex())
+	}
+	r.webhooks[w.Id] = *w
+	return
+}
+
+// A request seen by the receiver
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// Answers deliveries with the given status codes in turn, repeating the last
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	codes    []int
+	requests []received
+}
+
+func newReceiver(codes ...int) *receiver {
+	r := &receiver{codes: codes}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, received{header: req.Header, body: body})
+		code := r.codes[len(r.codes)-1]
+		if len(r.requests) <= len(r.codes) {
+			code = r.codes[len(r.requests)-1]
+		}
+		w.WriteHeader(code)
+	}))
+	return r
+}
+
+func TestValidate(t *testing.T) {
+	service := NewWebhookService(context.Background(), NewMockWebhookRepository(), http.DefaultClient)
+	valid := Webhook{Name: "Search", URL: "https://search.local/hook", Events: []string{document.EventCreated}}
+	assert.NoError(t, service.Validate(&valid))
+
+	tests := map[string]func(w *Webhook){
+		"No Name":       func(w *Webhook) { w.Name = "" },
+		"Relative URL":  func(w *Webhook) { w.URL = "/hook" },
+		"Other Scheme":  func(w *Webhook) { w.URL = "ftp://search.local/hook" },
+		"No Events":     func(w *Webhook) { w.Events = nil },
+		"Unknown Event": func(w *Webhook) { w.Events = []string{"document.eaten"} },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := valid
+			change(&w)
+			assert.Error(t, service.Validate(&w))
+		})
+	}
+}
+
+func TestInsertUpdate(t *testing.T) {
+	service := NewWebhookService(context.Background(), NewMockWebhookRepository(), http.DefaultClient)
+
+	w := Webhook{Name: "Search", URL: "https://search.local/hook", Events: []string{document.EventCreated}}
+	assert.NoError(t, service.Insert(&w))
+	assert.Equal(t, 64, len(w.Secret))
+	assert.Error(t, service.Insert(&w))
+
+	secret := w.Secret
+	w.Secret = ""
+	w.Name = "Indexer"
+	assert.NoError(t, service.Update(&w))
+	check, err := service.GetById(w.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Indexer", check.Name)
+	assert.Equal(t, secret, check.Secret)
+}
+
+func TestWants(t *testing.T) {
+	pages := primitive.NewObjectID()
+	w := Webhook{Active: true, Events: []string{document.EventCreated}}
+	assert.True(t, w.Wants(document.EventCreated, pages))
+	assert.False(t, w.Wants(document.EventDeleted, pages))
+
+	w.ClassIds = []primitive.ObjectID{primitive.NewObjectID()}
+	assert.False(t, w.Wants(document.EventCreated, pages))
+	w.ClassIds = append(w.ClassIds, pages)
+	assert.True(t, w.Wants(document.EventCreated, pages))
+
+	w.Active = false
+	assert.False(t, w.Wants(document.EventCreated, pages))
+}
+
+func TestSign(t *testing.T) {
+	// Known HMAC-SHA256 test vector from RFC 4231 (test case 2)
+	expect := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	assert.Equal(t, expect, Sign("Jefe", []byte("what do ya want for nothing?")))
+}
+
+func TestNotify(t *testing.T) {
+	repo := NewMockWebhookRepository()
+	service := newWebhookService(context.Background(), repo, http.DefaultClient, 3, time.Millisecond)
+	recv := newReceiver(http.StatusOK)
+	defer recv.Close()
+
+	pages := class.Class{Id: primitive.NewObjectID(), Name: "Pages", Slug: "pages"}
+	hooks := []Webhook{
+		{Name: "Pages", URL: recv.URL, Events: []string{document.EventUpdated, class.EventChanged}, ClassIds: []primitive.ObjectID{pages.Id}, Active: true},
+		{Name: "Inactive", URL: recv.URL, Events: []string{document.EventUpdated}},
+		{Name: "Other Class", URL: recv.URL, Events: []string{document.EventUpdated}, ClassIds: []primitive.ObjectID{primitive.NewObjectID()}, Active: true},
+		{Name: "Other Event", URL: recv.URL, Events: []string{document.EventDeleted}, Active: true},
+	}
+	for i := range hooks {
+		assert.NoError(t, service.Insert(&hooks[i]))
+	}
+
+	doc := document.Document{
+		Id:      primitive.NewObjectID(),
+		ClassId: pages.Id,
+		Title:   "About",
+		Slug:    "about",
+		Values:  map[string]interface{}{"body": "Hello"},
+	}
+	assert.NoError(t, service.NotifyDocument(document.EventUpdated, doc))
+	assert.NoError(t, service.NotifyClass(class.EventChanged, pages))
+	service.Wait()
+
+	// Deliveries are sent concurrently, so they may arrive in either order
+	assert.Equal(t, 2, len(recv.requests))
+	req, other := recv.requests[0], recv.requests[1]
+	if req.header.Get(HeaderEvent) != document.EventUpdated {
+		req, other = other, req
+	}
+	assert.Equal(t, class.EventChanged, other.header.Get(HeaderEvent))
+	assert.Equal(t, Sign(hooks[0].Secret, req.body), req.header.Get(HeaderSignature))
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+
+	var payload Payload
+	assert.NoError(t, json.Unmarshal(req.body, &payload))
+	assert.Equal(t, document.EventUpdated, payload.Event)
+	assert.Equal(t, doc.Id, payload.Document.Id)
+	assert.Equal(t, "Hello", payload.Document.Values["body"])
+	assert.Nil(t, payload.Class)
+
+	assert.NoError(t, json.Unmarshal(other.body, &payload))
+	assert.Equal(t, "pages", payload.Class.Slug)
+
+	deliveries, err := service.Deliveries(hooks[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(deliveries))
+	assert.Equal(t, class.EventChanged, deliveries[0].Event)
+	assert.Equal(t, deliveries[1].Id.Hex(), req.header.Get(HeaderDelivery))
+	for _, d := range deliveries {
+		assert.True(t, d.Succeeded())
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, http.StatusOK, d.StatusCode)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	pages := primitive.NewObjectID()
+	doc := document.Document{Id: primitive.NewObjectID(), ClassId: pages}
+
+	t.Run("Recovers", func(t *testing.T) {
+		repo := NewMockWebhookRepository()
+		service := newWebhookService(context.Background(), repo, http.DefaultClient, 3, time.Millisecond)
+		recv := newReceiver(http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent)
+		defer recv.Close()
+
+		w := Webhook{Name: "Flaky", URL: recv.URL, Events: []string{document.EventCreated}, Active: true}
+		assert.NoError(t, service.Insert(&w))
+		assert.NoError(t, service.NotifyDocument(document.EventCreated, doc))
+		service.Wait()
+
+		assert.Equal(t, 3, len(recv.requests))
+		deliveries, err := service.Deliveries(w)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(deliveries))
+		assert.Equal(t, 3, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusNoContent, deliveries[0].StatusCode)
+		assert.Equal(t, "", deliveries[0].Error)
+		assert.True(t, deliveries[0].Succeeded())
+	})
+
+	t.Run("Gives Up", func(t *testing.T) {
+		repo := NewMockWebhookRepository()
+		service := newWebhookService(context.Background(), repo, http.DefaultClient, 3, time.Millisecond)
+		recv := newReceiver(http.StatusServiceUnavailable)
+		defer recv.Close()
+
+		w := Webhook{Name: "Down", URL: recv.URL, Events: []string{document.EventCreated}, Active: true}
+		assert.NoError(t, service.Insert(&w))
+		assert.NoError(t, service.NotifyDocument(document.EventCreated, doc))
+		service.Wait()
+
+		assert.Equal(t, 3, len(recv.requests))
+		deliveries, err := service.Deliveries(w)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].StatusCode)
+		assert.Equal(t, "503 Service Unavailable", deliveries[0].Error)
+		assert.False(t, deliveries[0].Succeeded())
+
+		t.Run("Redeliver", func(t *testing.T) {
+			recv.mu.Lock()
+			recv.codes = []int{http.StatusOK}
+			recv.requests = nil
+			recv.mu.Unlock()
+
+			d, err := service.Redeliver(deliveries[0].Id)
+			assert.NoError(t, err)
+			assert.Equal(t, deliveries[0].Id, d.RedeliveryOf)
+			service.Wait()
+
+			assert.Equal(t, 1, len(recv.requests))
+			assert.Equal(t, deliveries[0].Payload, string(recv.requests[0].body))
+			check, err := repo.GetDeliveryById(d.Id)
+			assert.NoError(t, err)
+			assert.True(t, check.Succeeded())
+
+			_, err = service.Redeliver(primitive.NewObjectID())
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		repo := NewMockWebhookRepository()
+		service := newWebhookService(context.Background(), repo, http.DefaultClient, 2, time.Millisecond)
+		recv := newReceiver(http.StatusOK)
+		recv.Close()
+
+		w := Webhook{Name: "Gone", URL: recv.URL, Events: []string{document.EventCreated}, Active: true}
+		assert.NoError(t, service.Insert(&w))
+		assert.NoError(t, service.NotifyDocument(document.EventCreated, doc))
+		service.Wait()
+
+		deliveries, err := service.Deliveries(w)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+		assert.Equal(t, 0, deliveries[0].StatusCode)
+		assert.True(t, deliveries[0].Error != "")
+	})
+}
+
+// Refuses to store deliveries for one webhook
+type failingDeliveryRepository struct {
+	*mockWebhookRepository
+	webhookId primitive.ObjectID
+}
+
+func (r failingDeliveryRepository) InsertDelivery(d *Delivery) error {
+	if d.WebhookId == r.webhookId {
+		return errors.New("disk full")
+	}
+	return r.mockWebhookRepository.InsertDelivery(d)
+}
+
+func TestNotifyFailure(t *testing.T) {
+	repo := &failingDeliveryRepository{mockWebhookRepository: NewMockWebhookRepository()}
+	service := newWebhookService(context.Background(), repo, http.DefaultClient, 1, time.Millisecond)
+	recv := newReceiver(http.StatusOK)
+	defer recv.Close()
+
+	broken := Webhook{Name: "Broken", URL: recv.URL, Events: []string{class.EventChanged}, Active: true}
+	assert.NoError(t, service.Insert(&broken))
+	working := Webhook{Name: "Working", URL: recv.URL, Events: []string{class.EventChanged}, Active: true}
+	assert.NoError(t, service.Insert(&working))
+	repo.webhookId = broken.Id
+
+	// Whichever order the webhooks come back in, the working one still hears
+	// about the change
+	err := service.NotifyClass(class.EventChanged, class.Class{Id: primitive.NewObjectID()})
+	assert.Error(t, err)
+	service.Wait()
+
+	assert.Equal(t, 1, len(recv.requests))
+	deliveries, err := service.Deliveries(working)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(deliveries))
+	assert.True(t, deliveries[0].Succeeded())
+}
+
+func TestShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := NewMockWebhookRepository()
+	service := newWebhookService(ctx, repo, http.DefaultClient, 3, time.Hour)
+	recv := newReceiver(http.StatusServiceUnavailable)
+	defer recv.Close()
+
+	w := Webhook{Name: "Down", URL: recv.URL, Events: []string{class.EventChanged}, Active: true}
+	assert.NoError(t, service.Insert(&w))
+	assert.NoError(t, service.NotifyClass(class.EventChanged, class.Class{Id: primitive.NewObjectID()}))
+
+	// The first attempt fails, leaving the delivery waiting an hour to retry
+	// until the service is told to stop
+	for {
+		deliveries, err := service.Deliveries(w)
+		assert.NoError(t, err)
+		if deliveries[0].Attempts > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	service.Wait()
+
+	deliveries, err := service.Deliveries(w)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.False(t, deliveries[0].Succeeded())
+}
+
+func TestDelete(t *testing.T) {
+	repo := NewMockWebhookRepository()
+	service := newWebhookService(context.Background(), repo, http.DefaultClient, 1, time.Millisecond)
+	recv := newReceiver(http.StatusOK)
+	defer recv.Close()
+
+	w := Webhook{Name: "Search", URL: recv.URL, Events: []string{class.EventChanged}, Active: true}
+	assert.NoError(t, service.Insert(&w))
+	assert.NoError(t, service.NotifyClass(class.EventChanged, class.Class{Id: primitive.NewObjectID()}))
+	service.Wait()
+
+	assert.NoError(t, service.Delete(w))
+	_, err := service.GetById(w.Id)
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(repo.deliveries))
+}
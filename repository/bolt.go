@@ -0,0 +1,1061 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
+	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// One bucket per Mongo collection. Records are keyed by their ObjectID and
+// stored as BSON, so values come back decoded the same way Mongo's do.
+var (
+	bucketAudit       = []byte("audit")
+	bucketClasses     = []byte("classes")
+	bucketDeliveries  = []byte("deliveries")
+	bucketDocuments   = []byte("documents")
+	bucketForms       = []byte("forms")
+	bucketLocks       = []byte("locks")
+	bucketMedia       = []byte("media")
+	bucketSubmissions = []byte("submissions")
+	bucketUsers       = []byte("users")
+	bucketWebhooks    = []byte("webhooks")
+)
+
+var boltBuckets = [][]byte{
+	bucketAudit,
+	bucketClasses,
+	bucketDeliveries,
+	bucketDocuments,
+	bucketForms,
+	bucketLocks,
+	bucketMedia,
+	bucketSubmissions,
+	bucketUsers,
+	bucketWebhooks,
+}
+
+// Keeps everything in a single bbolt file. There are no indexes, queries scan
+// the bucket they read from.
+type boltRepository struct {
+	db *bolt.DB
+}
+
+// Opens the database file, creating it if needed. The file is locked for as
+// long as the process has it open.
+func NewBolt(path string) (Repository, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltRepository{db: db}, nil
+}
+
+// Queries cannot be interrupted part way, so the context is only checked
+// before starting
+func (r *boltRepository) view(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.db.View(fn)
+}
+
+func (r *boltRepository) update(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.db.Update(fn)
+}
+
+// Decodes the record stored under id into v, reporting whether there was one
+func boltGet(tx *bolt.Tx, bucket []byte, id primitive.ObjectID, v interface{}) (bool, error) {
+	data := tx.Bucket(bucket).Get(id[:])
+	if data == nil {
+		return false, nil
+	}
+	return true, bson.Unmarshal(data, v)
+}
+
+func boltPut(tx *bolt.Tx, bucket []byte, id primitive.ObjectID, v interface{}) error {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(id[:], data)
+}
+
+func boltDelete(tx *bolt.Tx, bucket []byte, id primitive.ObjectID) error {
+	return tx.Bucket(bucket).Delete(id[:])
+}
+
+// Decodes every record matching the filter in ID order, which for IDs made
+// by the repository is the order they were inserted in. A nil filter matches
+// everything.
+func boltScan[T any](tx *bolt.Tx, bucket []byte, match func(T) bool) ([]T, error) {
+	found := make([]T, 0, 16)
+	err := tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+		var record T
+		if err := bson.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		if match == nil || match(record) {
+			found = append(found, record)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// Rewrites every record change reports it modified. Records are written after
+// the scan as bbolt cursors do not survive changes to their bucket.
+func boltUpdateWhere[T any](tx *bolt.Tx, bucket []byte, change func(*T) bool) (count int64, err error) {
+	b := tx.Bucket(bucket)
+	keys, values := make([][]byte, 0, 16), make([][]byte, 0, 16)
+	err = b.ForEach(func(k, v []byte) error {
+		var record T
+		if err := bson.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		if !change(&record) {
+			return nil
+		}
+		data, err := bson.Marshal(record)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte(nil), k...))
+		values = append(values, data)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for i := range keys {
+		if err = b.Put(keys[i], values[i]); err != nil {
+			return
+		}
+	}
+	return int64(len(keys)), nil
+}
+
+func boltDeleteWhere[T any](tx *bolt.Tx, bucket []byte, match func(T) bool) (count int64, err error) {
+	b := tx.Bucket(bucket)
+	keys := make([][]byte, 0, 16)
+	err = b.ForEach(func(k, v []byte) error {
+		var record T
+		if err := bson.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		if match(record) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for _, k := range keys {
+		if err = b.Delete(k); err != nil {
+			return
+		}
+	}
+	return int64(len(keys)), nil
+}
+
+// Lists entries newest first
+func (r *boltRepository) GetAuditEntries(filter audit.Filter) (entries []audit.Entry, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) (err error) {
+		entries, err = boltScan(tx, bucketAudit, filter.Matches)
+		return
+	})
+	if err != nil {
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.After(entries[j].Time)
+		}
+		return bytes.Compare(entries[i].Id[:], entries[j].Id[:]) > 0
+	})
+	if filter.Limit > 0 && int64(len(entries)) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return
+}
+
+func (r *boltRepository) InsertAuditEntry(e *audit.Entry) (err error) {
+	e.Id = primitive.NewObjectID()
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketAudit, e.Id, e)
+	})
+}
+
+func (r *boltRepository) DeleteClass(ctx context.Context, id primitive.ObjectID) (err error) {
+	return r.update(ctx, func(tx *bolt.Tx) error {
+		return boltDelete(tx, bucketClasses, id)
+	})
+}
+
+func (r *boltRepository) GetAllClasses(ctx context.Context) (classes []class.Class, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) (err error) {
+		classes, err = boltScan(tx, bucketClasses, func(c class.Class) bool {
+			return c.Deleted.IsZero()
+		})
+		return
+	})
+	sort.Stable(sortClasses(classes))
+	return
+}
+
+func (r *boltRepository) GetClassById(ctx context.Context, id primitive.ObjectID) (c class.Class, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketClasses, id, &c)
+		if err == nil && !found {
+			err = fmt.Errorf("class not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+func (r *boltRepository) GetClassBySlug(ctx context.Context, slug string) (c class.Class, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		classes, err := boltScan(tx, bucketClasses, func(c class.Class) bool {
+			return c.Slug == slug && c.Deleted.IsZero()
+		})
+		if err != nil {
+			return err
+		}
+		if len(classes) == 0 {
+			return fmt.Errorf("class not found: %s", slug)
+		}
+		c = classes[0]
+		return nil
+	})
+	return
+}
+
+func (r *boltRepository) GetTrashedClasses(ctx context.Context, before time.Time) (classes []class.Class, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) (err error) {
+		classes, err = boltScan(tx, bucketClasses, func(c class.Class) bool {
+			return !c.Deleted.IsZero() && c.Deleted.Before(before)
+		})
+		return
+	})
+	sort.SliceStable(classes, func(i, j int) bool {
+		return classes[i].Deleted.After(classes[j].Deleted)
+	})
+	return
+}
+
+func (r *boltRepository) InsertClass(ctx context.Context, c *class.Class) (err error) {
+	inserted := *c
+	inserted.Id = primitive.NewObjectID()
+	now := time.Now()
+	inserted.Created = now
+	inserted.Updated = now
+	inserted.Version = 1
+	err = r.update(ctx, func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketClasses, inserted.Id, inserted)
+	})
+	if err == nil {
+		*c = inserted
+	}
+	return
+}
+
+func (r *boltRepository) UpdateClass(ctx context.Context, c *class.Class) (err error) {
+	updated := *c
+	updated.Updated = time.Now()
+	updated.Version++
+	err = r.update(ctx, func(tx *bolt.Tx) error {
+		return boltPutClass(tx, c.Version, updated)
+	})
+	if err == nil {
+		*c = updated
+	}
+	return
+}
+
+// Replaces the stored class as long as it is still at the version the update
+// was loaded at
+func boltPutClass(tx *bolt.Tx, version int64, updated class.Class) error {
+	var stored class.Class
+	found, err := boltGet(tx, bucketClasses, updated.Id, &stored)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("class not found: %s", updated.Id.Hex())
+	}
+	if stored.Version != version {
+		return fmt.Errorf("%w: %s is at version %d, not %d", class.ErrConflict, updated.Id.Hex(), stored.Version, version)
+	}
+	return boltPut(tx, bucketClasses, updated.Id, updated)
+}
+
+// Saves the class and migrates the documents in a single transaction, any
+// failure rolls back all of it
+func (r *boltRepository) MigrateClass(ctx context.Context, c *class.Class, classIds []primitive.ObjectID, migrations []class.Migration) (err error) {
+	updated := *c
+	updated.Updated = time.Now()
+	updated.Version++
+
+	migrated := make(map[primitive.ObjectID]bool, len(classIds))
+	for _, id := range classIds {
+		migrated[id] = true
+	}
+
+	err = r.update(ctx, func(tx *bolt.Tx) (err error) {
+		if err = boltPutClass(tx, c.Version, updated); err != nil {
+			return
+		}
+		_, err = boltUpdateWhere(tx, bucketDocuments, func(doc *document.Document) bool {
+			if !migrated[doc.ClassId] {
+				return false
+			}
+			changed := false
+			for _, m := range migrations {
+				if m.Apply(doc.Values) {
+					changed = true
+				}
+			}
+			if changed {
+				doc.Version++
+			}
+			return changed
+		})
+		return
+	})
+	if err == nil {
+		*c = updated
+	}
+	return
+}
+
+func (r *boltRepository) ArchiveClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	now := time.Now()
+	err = r.update(ctx, func(tx *bolt.Tx) (err error) {
+		count, err = boltUpdateWhere(tx, bucketDocuments, func(doc *document.Document) bool {
+			if doc.ClassId != classId || !doc.Archived.IsZero() {
+				return false
+			}
+			doc.Archived = now
+			doc.Version++
+			return true
+		})
+		return
+	})
+	return
+}
+
+func (r *boltRepository) CountClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	return r.countDocuments(ctx, func(doc document.Document) bool {
+		return doc.ClassId == classId && doc.Archived.IsZero()
+	})
+}
+
+func (r *boltRepository) CountClassReferences(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		docs, err := boltScan[document.Document](tx, bucketDocuments, nil)
+		if err != nil {
+			return err
+		}
+		ids := make(map[primitive.ObjectID]bool)
+		for _, doc := range docs {
+			if doc.ClassId == classId {
+				ids[doc.Id] = true
+			}
+		}
+		for _, doc := range docs {
+			if doc.ClassId == classId {
+				continue
+			}
+			for _, ref := range doc.References {
+				if ids[ref] {
+					count++
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (r *boltRepository) CountFieldValues(ctx context.Context, classId primitive.ObjectID, key string) (count int64, err error) {
+	return r.countDocuments(ctx, func(doc document.Document) bool {
+		_, ok := doc.Values[key]
+		return ok && doc.ClassId == classId
+	})
+}
+
+func (r *boltRepository) countDocuments(ctx context.Context, match func(document.Document) bool) (count int64, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		docs, err := boltScan(tx, bucketDocuments, match)
+		count = int64(len(docs))
+		return err
+	})
+	return
+}
+
+func (r *boltRepository) CountUnconvertibleValues(ctx context.Context, classId primitive.ObjectID, key string, convert func(interface{}) (interface{}, error)) (count int64, err error) {
+	return r.countDocuments(ctx, func(doc document.Document) bool {
+		value, ok := doc.Values[key]
+		if !ok || doc.ClassId != classId {
+			return false
+		}
+		_, err := convert(value)
+		return err != nil
+	})
+}
+
+func (r *boltRepository) ConvertFieldValues(ctx context.Context, classId primitive.ObjectID, key string, convert func(interface{}) (interface{}, error), drop bool) (count int64, err error) {
+	err = r.update(ctx, func(tx *bolt.Tx) (err error) {
+		count, err = boltUpdateWhere(tx, bucketDocuments, func(doc *document.Document) bool {
+			value, ok := doc.Values[key]
+			if !ok || doc.ClassId != classId {
+				return false
+			}
+			converted, err := convert(value)
+			switch {
+			case err == nil:
+				doc.Values[key] = converted
+			case drop:
+				delete(doc.Values, key)
+			default:
+				return false
+			}
+			doc.Version++
+			return true
+		})
+		return
+	})
+	return
+}
+
+// Nothing to index, proximity filters compare every point
+func (r *boltRepository) EnsureGeoIndex(ctx context.Context, key string) (err error) {
+	return
+}
+
+func (r *boltRepository) DeleteClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	err = r.update(ctx, func(tx *bolt.Tx) (err error) {
+		count, err = boltDeleteWhere(tx, bucketDocuments, func(doc document.Document) bool {
+			return doc.ClassId == classId
+		})
+		return
+	})
+	return
+}
+
+func (r *boltRepository) DropFieldValues(ctx context.Context, classId primitive.ObjectID, key string) (count int64, err error) {
+	err = r.update(ctx, func(tx *bolt.Tx) (err error) {
+		count, err = boltUpdateWhere(tx, bucketDocuments, func(doc *document.Document) bool {
+			if _, ok := doc.Values[key]; !ok || doc.ClassId != classId {
+				return false
+			}
+			delete(doc.Values, key)
+			doc.Version++
+			return true
+		})
+		return
+	})
+	return
+}
+
+func (r *boltRepository) RenameFieldValues(ctx context.Context, classId primitive.ObjectID, from string, to string) (count int64, err error) {
+	err = r.update(ctx, func(tx *bolt.Tx) (err error) {
+		count, err = boltUpdateWhere(tx, bucketDocuments, func(doc *document.Document) bool {
+			value, ok := doc.Values[from]
+			if !ok || doc.ClassId != classId {
+				return false
+			}
+			doc.Values[to] = value
+			delete(doc.Values, from)
+			doc.Version++
+			return true
+		})
+		return
+	})
+	return
+}
+
+func (r *boltRepository) CountDocumentsByClass(ctx context.Context, now time.Time) (counts []document.ClassCount, err error) {
+	docs, err := r.findDocuments(ctx, isLive)
+	if err != nil {
+		return
+	}
+	index := make(map[primitive.ObjectID]int)
+	counts = make([]document.ClassCount, 0, 16)
+	for _, doc := range docs {
+		i, ok := index[doc.ClassId]
+		if !ok {
+			i = len(counts)
+			index[doc.ClassId] = i
+			counts = append(counts, document.ClassCount{ClassId: doc.ClassId})
+		}
+		counts[i].Total++
+		switch {
+		case doc.IsDraft():
+			counts[i].Drafts++
+		case doc.Published.After(now):
+			counts[i].Scheduled++
+		}
+	}
+	return
+}
+
+func (r *boltRepository) DeleteDocument(ctx context.Context, id primitive.ObjectID) (err error) {
+	return r.update(ctx, func(tx *bolt.Tx) error {
+		return boltDelete(tx, bucketDocuments, id)
+	})
+}
+
+func (r *boltRepository) GetChildDocumentBySlug(ctx context.Context, parentId primitive.ObjectID, slug string) (doc document.Document, err error) {
+	docs, err := r.findDocuments(ctx, func(d document.Document) bool {
+		return d.ParentId == parentId && d.Slug == slug && d.Deleted.IsZero()
+	})
+	if err == nil && len(docs) == 0 {
+		err = fmt.Errorf("document not found for %s-%s", parentId.Hex(), slug)
+	}
+	if err != nil {
+		return
+	}
+	return docs[0], nil
+}
+
+func (r *boltRepository) GetClassDocumentBySlug(ctx context.Context, classId primitive.ObjectID, slug string) (doc document.Document, err error) {
+	docs, err := r.findDocuments(ctx, func(d document.Document) bool {
+		return d.ClassId == classId && d.Slug == slug && d.Deleted.IsZero()
+	})
+	if err == nil && len(docs) == 0 {
+		err = fmt.Errorf("document not found for %s-%s", classId.Hex(), slug)
+	}
+	if err != nil {
+		return
+	}
+	return docs[0], nil
+}
+
+func (r *boltRepository) GetDocumentList(ctx context.Context, params document.DocumentListParams) (list document.DocumentList, err error) {
+	docs, err := r.findDocuments(ctx, func(doc document.Document) bool {
+		if doc.ClassId != params.ClassId || !isLive(doc) {
+			return false
+		}
+		if !params.PublishedBefore.IsZero() && (doc.IsDraft() || doc.Published.After(params.PublishedBefore)) {
+			return false
+		}
+		if !params.Near.IsZero() && !params.Near.Contains(doc.Values[params.Near.Field]) {
+			return false
+		}
+		return params.MatchTitle(doc.Title)
+	})
+	if err != nil {
+		return
+	}
+
+	list.Total = int64(len(docs))
+	start, end := pageBounds(params.Offset(), params.Size, list.Total)
+	if start == end {
+		return
+	}
+	list.Documents = docs[start:end]
+	return
+}
+
+func (r *boltRepository) GetDocumentById(ctx context.Context, id primitive.ObjectID) (doc document.Document, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketDocuments, id, &doc)
+		if err == nil && !found {
+			err = fmt.Errorf("document not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+// Missing IDs are skipped, the rest come back in the order asked for
+func (r *boltRepository) GetDocumentsByIds(ctx context.Context, ids []primitive.ObjectID) (docs []document.Document, err error) {
+	docs = make([]document.Document, 0, len(ids))
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			var doc document.Document
+			found, err := boltGet(tx, bucketDocuments, id, &doc)
+			if err != nil {
+				return err
+			}
+			if found {
+				docs = append(docs, doc)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Lists the user's drafts, most recently updated first
+func (r *boltRepository) GetDraftDocuments(ctx context.Context, userId primitive.ObjectID, limit int64) (docs []document.Document, err error) {
+	docs, err = r.findDocuments(ctx, func(d document.Document) bool {
+		return isLive(d) && d.IsDraft() && d.CreatedBy == userId
+	})
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Updated.After(docs[j].Updated)
+	})
+	return limitDocuments(docs, limit), err
+}
+
+func (r *boltRepository) GetRecentDocuments(ctx context.Context, limit int64) (docs []document.Document, err error) {
+	docs, err = r.findDocuments(ctx, isLive)
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Updated.After(docs[j].Updated)
+	})
+	return limitDocuments(docs, limit), err
+}
+
+// Lists documents to be published after now, soonest first
+func (r *boltRepository) GetScheduledDocuments(ctx context.Context, now time.Time, limit int64) (docs []document.Document, err error) {
+	docs, err = r.findDocuments(ctx, func(d document.Document) bool {
+		return isLive(d) && d.Published.After(now)
+	})
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Published.Before(docs[j].Published)
+	})
+	return limitDocuments(docs, limit), err
+}
+
+func (r *boltRepository) GetReferencingDocuments(ctx context.Context, id primitive.ObjectID) (docs []document.Document, err error) {
+	return r.findDocuments(ctx, func(d document.Document) bool {
+		for _, ref := range d.References {
+			if ref == id {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (r *boltRepository) GetTrashedDocuments(ctx context.Context, before time.Time) (docs []document.Document, err error) {
+	docs, err = r.findDocuments(ctx, func(d document.Document) bool {
+		return !d.Deleted.IsZero() && d.Deleted.Before(before)
+	})
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Deleted.After(docs[j].Deleted)
+	})
+	return
+}
+
+func (r *boltRepository) findDocuments(ctx context.Context, match func(document.Document) bool) (docs []document.Document, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) (err error) {
+		docs, err = boltScan(tx, bucketDocuments, match)
+		return
+	})
+	return
+}
+
+// Matches documents which are neither archived nor trashed
+func isLive(doc document.Document) bool {
+	return doc.Archived.IsZero() && doc.Deleted.IsZero()
+}
+
+func (r *boltRepository) InsertDocument(ctx context.Context, doc *document.Document) (err error) {
+	inserted := *doc
+	inserted.Id = primitive.NewObjectID()
+	now := time.Now()
+	inserted.Created = now
+	inserted.Updated = now
+	inserted.Version = 1
+	err = r.update(ctx, func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketDocuments, inserted.Id, inserted)
+	})
+	if err == nil {
+		*doc = inserted
+	}
+	return
+}
+
+func (r *boltRepository) UpdateDocument(ctx context.Context, doc *document.Document) (err error) {
+	updated := *doc
+	updated.Updated = time.Now()
+	updated.Version++
+	err = r.update(ctx, func(tx *bolt.Tx) error {
+		var stored document.Document
+		found, err := boltGet(tx, bucketDocuments, doc.Id, &stored)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("document not found: %s", doc.Id.Hex())
+		}
+		if stored.Version != doc.Version {
+			return fmt.Errorf("%w: %s is at version %d, not %d", document.ErrConflict, doc.Id.Hex(), stored.Version, doc.Version)
+		}
+		return boltPut(tx, bucketDocuments, updated.Id, updated)
+	})
+	if err == nil {
+		*doc = updated
+	}
+	return
+}
+
+func (r *boltRepository) AcquireLock(l lock.Lock, now time.Time) (held lock.Lock, err error) {
+	err = r.update(context.Background(), func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketLocks, l.Id, &held)
+		if err != nil {
+			return err
+		}
+		if found && held.UserId != l.UserId && held.Active(now) {
+			return fmt.Errorf("%w: %s", lock.ErrLocked, l.Id.Hex())
+		}
+		if found && held.UserId == l.UserId {
+			l.Acquired = held.Acquired
+		}
+		held = l
+		return boltPut(tx, bucketLocks, l.Id, l)
+	})
+	return
+}
+
+func (r *boltRepository) DeleteLock(id primitive.ObjectID) (err error) {
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltDelete(tx, bucketLocks, id)
+	})
+}
+
+func (r *boltRepository) GetLock(id primitive.ObjectID) (l lock.Lock, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketLocks, id, &l)
+		if err == nil && !found {
+			err = fmt.Errorf("lock not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+func (r *boltRepository) AddMediaDerivative(id primitive.ObjectID, key string) (err error) {
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		var m media.Media
+		found, err := boltGet(tx, bucketMedia, id, &m)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("media not found: %s", id.Hex())
+		}
+		for _, existing := range m.Derivatives {
+			if existing == key {
+				return nil
+			}
+		}
+		m.Derivatives = append(m.Derivatives, key)
+		return boltPut(tx, bucketMedia, id, m)
+	})
+}
+
+func (r *boltRepository) DeleteMedia(id primitive.ObjectID) (err error) {
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltDelete(tx, bucketMedia, id)
+	})
+}
+
+func (r *boltRepository) GetMediaById(id primitive.ObjectID) (m media.Media, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketMedia, id, &m)
+		if err == nil && !found {
+			err = fmt.Errorf("media not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+// Lists the newest media first. Only the requested page is decoded, walking
+// the bucket backwards from the newest ID.
+func (r *boltRepository) GetMediaList(params media.MediaListParams) (list media.MediaList, err error) {
+	list.Media = make([]media.Media, 0, params.Size)
+	err = r.view(context.Background(), func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMedia)
+		list.Total = int64(b.Stats().KeyN)
+
+		c := b.Cursor()
+		skip := params.Offset()
+		for k, v := c.Last(); k != nil && int64(len(list.Media)) < params.Size; k, v = c.Prev() {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			var m media.Media
+			if err := bson.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			list.Media = append(list.Media, m)
+		}
+		return nil
+	})
+	return
+}
+
+func (r *boltRepository) InsertMedia(m *media.Media) (err error) {
+	if m.Id.IsZero() {
+		m.Id = primitive.NewObjectID()
+	}
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketMedia, m.Id, m)
+	})
+}
+
+func (r *boltRepository) DeleteForm(id primitive.ObjectID) (err error) {
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltDelete(tx, bucketForms, id)
+	})
+}
+
+func (r *boltRepository) DeleteSubmissions(formId primitive.ObjectID) (err error) {
+	return r.update(context.Background(), func(tx *bolt.Tx) (err error) {
+		_, err = boltDeleteWhere(tx, bucketSubmissions, func(s form.Submission) bool {
+			return s.FormId == formId
+		})
+		return
+	})
+}
+
+func (r *boltRepository) GetFormById(id primitive.ObjectID) (f form.Form, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketForms, id, &f)
+		if err == nil && !found {
+			err = fmt.Errorf("form not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+func (r *boltRepository) GetForms() (forms []form.Form, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) (err error) {
+		forms, err = boltScan[form.Form](tx, bucketForms, nil)
+		return
+	})
+	sort.SliceStable(forms, func(i, j int) bool { return forms[i].Name < forms[j].Name })
+	return
+}
+
+// Lists the newest submissions first
+func (r *boltRepository) GetSubmissions(formId primitive.ObjectID) (subs []form.Submission, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) (err error) {
+		subs, err = boltScan(tx, bucketSubmissions, func(s form.Submission) bool {
+			return s.FormId == formId
+		})
+		return
+	})
+	sort.SliceStable(subs, func(i, j int) bool {
+		if !subs[i].Created.Equal(subs[j].Created) {
+			return subs[i].Created.After(subs[j].Created)
+		}
+		return bytes.Compare(subs[i].Id[:], subs[j].Id[:]) > 0
+	})
+	return
+}
+
+func (r *boltRepository) InsertForm(f *form.Form) (err error) {
+	f.Id = primitive.NewObjectID()
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketForms, f.Id, f)
+	})
+}
+
+func (r *boltRepository) InsertSubmission(s *form.Submission) (err error) {
+	s.Id = primitive.NewObjectID()
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketSubmissions, s.Id, s)
+	})
+}
+
+func (r *boltRepository) UpdateForm(f *form.Form) (err error) {
+	return r.replace(bucketForms, f.Id, f, "form")
+}
+
+// Overwrites a record which must already exist
+func (r *boltRepository) replace(bucket []byte, id primitive.ObjectID, v interface{}, kind string) error {
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		if tx.Bucket(bucket).Get(id[:]) == nil {
+			return fmt.Errorf("%s not found: %s", kind, id.Hex())
+		}
+		return boltPut(tx, bucket, id, v)
+	})
+}
+
+func (r *boltRepository) DeleteDeliveries(webhookId primitive.ObjectID) (err error) {
+	return r.update(context.Background(), func(tx *bolt.Tx) (err error) {
+		_, err = boltDeleteWhere(tx, bucketDeliveries, func(d webhook.Delivery) bool {
+			return d.WebhookId == webhookId
+		})
+		return
+	})
+}
+
+func (r *boltRepository) DeleteWebhook(id primitive.ObjectID) (err error) {
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltDelete(tx, bucketWebhooks, id)
+	})
+}
+
+// Lists the newest deliveries first
+func (r *boltRepository) GetDeliveries(webhookId primitive.ObjectID, limit int64) (deliveries []webhook.Delivery, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) (err error) {
+		deliveries, err = boltScan(tx, bucketDeliveries, func(d webhook.Delivery) bool {
+			return d.WebhookId == webhookId
+		})
+		return
+	})
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		if !deliveries[i].Created.Equal(deliveries[j].Created) {
+			return deliveries[i].Created.After(deliveries[j].Created)
+		}
+		return bytes.Compare(deliveries[i].Id[:], deliveries[j].Id[:]) > 0
+	})
+	if int64(len(deliveries)) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return
+}
+
+func (r *boltRepository) GetDeliveryById(id primitive.ObjectID) (d webhook.Delivery, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketDeliveries, id, &d)
+		if err == nil && !found {
+			err = fmt.Errorf("delivery not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+func (r *boltRepository) GetWebhookById(id primitive.ObjectID) (w webhook.Webhook, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketWebhooks, id, &w)
+		if err == nil && !found {
+			err = fmt.Errorf("webhook not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+func (r *boltRepository) GetWebhooks() (webhooks []webhook.Webhook, err error) {
+	err = r.view(context.Background(), func(tx *bolt.Tx) (err error) {
+		webhooks, err = boltScan[webhook.Webhook](tx, bucketWebhooks, nil)
+		return
+	})
+	sort.SliceStable(webhooks, func(i, j int) bool { return webhooks[i].Name < webhooks[j].Name })
+	return
+}
+
+func (r *boltRepository) InsertDelivery(d *webhook.Delivery) (err error) {
+	d.Id = primitive.NewObjectID()
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketDeliveries, d.Id, d)
+	})
+}
+
+func (r *boltRepository) InsertWebhook(w *webhook.Webhook) (err error) {
+	w.Id = primitive.NewObjectID()
+	return r.update(context.Background(), func(tx *bolt.Tx) error {
+		return boltPut(tx, bucketWebhooks, w.Id, w)
+	})
+}
+
+func (r *boltRepository) UpdateDelivery(d *webhook.Delivery) (err error) {
+	return r.replace(bucketDeliveries, d.Id, d, "delivery")
+}
+
+func (r *boltRepository) UpdateWebhook(w *webhook.Webhook) (err error) {
+	return r.replace(bucketWebhooks, w.Id, w, "webhook")
+}
+
+func (r *boltRepository) GetUserByEmail(ctx context.Context, email string) (u user.User, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		users, err := boltScan(tx, bucketUsers, func(u user.User) bool {
+			return u.Email == email
+		})
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return fmt.Errorf("user not found: %s", email)
+		}
+		u = users[0]
+		return nil
+	})
+	return
+}
+
+func (r *boltRepository) GetUserById(ctx context.Context, id primitive.ObjectID) (u user.User, err error) {
+	err = r.view(ctx, func(tx *bolt.Tx) error {
+		found, err := boltGet(tx, bucketUsers, id, &u)
+		if err == nil && !found {
+			err = fmt.Errorf("user not found: %s", id.Hex())
+		}
+		return err
+	})
+	return
+}
+
+func (r *boltRepository) InsertUser(ctx context.Context, u *user.User) (err error) {
+	id := primitive.NewObjectID()
+	err = r.update(ctx, func(tx *bolt.Tx) error {
+		inserted := *u
+		inserted.Id = id
+		return boltPut(tx, bucketUsers, id, inserted)
+	})
+	if err == nil {
+		u.Id = id
+	}
+	return
+}
+
+func (r *boltRepository) UpdateUser(ctx context.Context, u *user.User) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return r.replace(bucketUsers, u.Id, u, "user")
+}
+
+func (r *boltRepository) empty() (err error) {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
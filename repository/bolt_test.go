@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/zeebo/assert"
+)
+
+func TestBoltReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gocms.db")
+
+	repo, err := NewBolt(path)
+	assert.NoError(t, err)
+	c := class.Class{Name: "Places", Slug: "places"}
+	assert.NoError(t, repo.InsertClass(ctx, &c))
+	doc := document.Document{
+		ClassId: c.Id,
+		Slug:    "white_house",
+		Values:  map[string]interface{}{"location": field.NewGeoPoint(38.8977, -77.0365)},
+	}
+	assert.NoError(t, repo.InsertDocument(ctx, &doc))
+
+	// The file is locked while open, a second handle has to wait its turn
+	_, err = NewBolt(path)
+	assert.Error(t, err)
+	assert.NoError(t, repo.(*boltRepository).db.Close())
+
+	repo, err = NewBolt(path)
+	assert.NoError(t, err)
+	defer repo.(*boltRepository).db.Close()
+
+	check, err := repo.GetClassBySlug(ctx, "places")
+	assert.NoError(t, err)
+	assert.Equal(t, c.Id, check.Id)
+	assert.Equal(t, int64(1), check.Version)
+
+	stored, err := repo.GetClassDocumentBySlug(ctx, c.Id, "white_house")
+	assert.NoError(t, err)
+	point, ok := field.Point(stored.Values["location"])
+	assert.True(t, ok)
+	assert.Equal(t, -77.0365, point.Coordinates[0])
+
+	// Cancelled requests are turned away before touching the file
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = repo.GetDocumentById(cancelled, doc.Id)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/media"
+	"github.com/jbaikge/gocms/models/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The memory repository stores and hands out copies, so nothing a caller
+// holds shares a map or slice with what is stored. Otherwise a handler
+// changing a document it loaded would change it for every other request.
+
+func copyClass(c class.Class) class.Class {
+	c.Parents = copyIds(c.Parents)
+	c.FieldsetIds = copyIds(c.FieldsetIds)
+	c.Fields = copyFields(c.Fields)
+	c.Inherited = copyFields(c.Inherited)
+	return c
+}
+
+func copyClasses(classes []class.Class) []class.Class {
+	copies := make([]class.Class, len(classes))
+	for i, c := range classes {
+		copies[i] = copyClass(c)
+	}
+	return copies
+}
+
+// Expanded relations are left behind, as they are in Mongo
+func copyDocument(doc document.Document) document.Document {
+	doc.Values = copyValues(doc.Values)
+	doc.References = copyIds(doc.References)
+	doc.Related = nil
+	return doc
+}
+
+func copyDocuments(docs []document.Document) []document.Document {
+	copies := make([]document.Document, len(docs))
+	for i, doc := range docs {
+		copies[i] = copyDocument(doc)
+	}
+	return copies
+}
+
+func copyEntry(e audit.Entry) audit.Entry {
+	e.Changes = copyStrings(e.Changes)
+	return e
+}
+
+func copyForm(f form.Form) form.Form {
+	f.Fields = copyFields(f.Fields)
+	return f
+}
+
+func copySubmission(s form.Submission) form.Submission {
+	s.Values = copyValues(s.Values)
+	return s
+}
+
+func copyMedia(m media.Media) media.Media {
+	m.Derivatives = copyStrings(m.Derivatives)
+	return m
+}
+
+func copyWebhook(w webhook.Webhook) webhook.Webhook {
+	w.Events = copyStrings(w.Events)
+	w.ClassIds = copyIds(w.ClassIds)
+	return w
+}
+
+func copyFields(fields []field.Field) []field.Field {
+	if fields == nil {
+		return nil
+	}
+	copies := make([]field.Field, len(fields))
+	for i, f := range fields {
+		f.RelationClassIds = copyIds(f.RelationClassIds)
+		f.Fields = copyFields(f.Fields)
+		copies[i] = f
+	}
+	return copies
+}
+
+func copyIds(ids []primitive.ObjectID) []primitive.ObjectID {
+	if ids == nil {
+		return nil
+	}
+	return append(make([]primitive.ObjectID, 0, len(ids)), ids...)
+}
+
+func copyFloats(values []float64) []float64 {
+	if values == nil {
+		return nil
+	}
+	return append(make([]float64, 0, len(values)), values...)
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(values)), values...)
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	copies := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		copies[k] = copyValue(v)
+	}
+	return copies
+}
+
+// Copies the maps and slices document values are made of: repeater items,
+// groups, relation IDs and points. Anything else is a plain value.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyValues(v)
+	case []map[string]interface{}:
+		copies := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			copies[i] = copyValues(item)
+		}
+		return copies
+	case []interface{}:
+		copies := make([]interface{}, len(v))
+		for i, item := range v {
+			copies[i] = copyValue(item)
+		}
+		return copies
+	case []primitive.ObjectID:
+		return copyIds(v)
+	case []string:
+		return copyStrings(v)
+	case []float64:
+		return copyFloats(v)
+	case field.GeoPoint:
+		v.Coordinates = copyFloats(v.Coordinates)
+		return v
+	}
+	return value
+}
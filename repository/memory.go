@@ -1,13 +1,20 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -17,21 +24,69 @@ func (s sortClasses) Len() int           { return len(s) }
 func (s sortClasses) Less(i, j int) bool { return s[i].Name < s[j].Name }
 func (s sortClasses) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 
+// Safe for concurrent use. Every method holds the mutex for its whole run and
+// works on copies of what callers pass in or get back.
 type memoryRepository struct {
-	classes   []class.Class
-	documents []document.Document
-	users     []user.User
+	mutex       *sync.RWMutex
+	audit       []audit.Entry
+	classes     []class.Class
+	deliveries  []webhook.Delivery
+	documents   []document.Document
+	forms       []form.Form
+	locks       []lock.Lock
+	media       []media.Media
+	submissions []form.Submission
+	users       []user.User
+	webhooks    []webhook.Webhook
 }
 
 func NewMemory() Repository {
 	return &memoryRepository{
-		classes:   make([]class.Class, 0, 128),
-		documents: make([]document.Document, 0, 128),
-		users:     make([]user.User, 0, 128),
+		mutex:       new(sync.RWMutex),
+		audit:       make([]audit.Entry, 0, 128),
+		classes:     make([]class.Class, 0, 128),
+		deliveries:  make([]webhook.Delivery, 0, 128),
+		documents:   make([]document.Document, 0, 128),
+		forms:       make([]form.Form, 0, 16),
+		locks:       make([]lock.Lock, 0, 16),
+		media:       make([]media.Media, 0, 128),
+		submissions: make([]form.Submission, 0, 128),
+		users:       make([]user.User, 0, 128),
+		webhooks:    make([]webhook.Webhook, 0, 16),
 	}
 }
 
-func (r *memoryRepository) DeleteClass(id primitive.ObjectID) (err error) {
+// Lists entries newest first. Entries are appended in order, so the newest are
+// at the end.
+func (r *memoryRepository) GetAuditEntries(filter audit.Filter) (entries []audit.Entry, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	entries = make([]audit.Entry, 0, 16)
+	for i := len(r.audit) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && int64(len(entries)) == filter.Limit {
+			break
+		}
+		if filter.Matches(r.audit[i]) {
+			entries = append(entries, copyEntry(r.audit[i]))
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) InsertAuditEntry(e *audit.Entry) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	e.Id = primitive.NewObjectID()
+	r.audit = append(r.audit, copyEntry(*e))
+	return
+}
+
+func (r *memoryRepository) DeleteClass(ctx context.Context, id primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	for i, class := range r.classes {
 		if class.Id == id {
 			r.classes = append(r.classes[:i], r.classes[i+1:]...)
@@ -41,52 +96,310 @@ func (r *memoryRepository) DeleteClass(id primitive.ObjectID) (err error) {
 	return
 }
 
-func (r *memoryRepository) GetAllClasses() ([]class.Class, error) {
-	sort.Sort(sortClasses(r.classes))
-	return r.classes, nil
+func (r *memoryRepository) GetAllClasses(ctx context.Context) ([]class.Class, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	classes := make([]class.Class, 0, len(r.classes))
+	for _, c := range r.classes {
+		if c.Deleted.IsZero() {
+			classes = append(classes, copyClass(c))
+		}
+	}
+	sort.Sort(sortClasses(classes))
+	return classes, nil
 }
 
-func (r *memoryRepository) GetClassById(id primitive.ObjectID) (class class.Class, err error) {
+func (r *memoryRepository) GetClassById(ctx context.Context, id primitive.ObjectID) (class class.Class, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	for _, c := range r.classes {
 		if c.Id == id {
-			return c, nil
+			return copyClass(c), nil
 		}
 	}
 	err = fmt.Errorf("class not found: %s", id.Hex())
 	return
 }
 
-func (r *memoryRepository) GetClassBySlug(slug string) (class class.Class, err error) {
+func (r *memoryRepository) GetClassBySlug(ctx context.Context, slug string) (class class.Class, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	for _, c := range r.classes {
-		if c.Slug == slug {
-			return c, nil
+		if c.Slug == slug && c.Deleted.IsZero() {
+			return copyClass(c), nil
 		}
 	}
 	err = fmt.Errorf("class not found: %s", slug)
 	return
 }
 
-func (r *memoryRepository) InsertClass(class *class.Class) (err error) {
+func (r *memoryRepository) GetTrashedClasses(ctx context.Context, before time.Time) (classes []class.Class, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	classes = make([]class.Class, 0, 8)
+	for _, c := range r.classes {
+		if !c.Deleted.IsZero() && c.Deleted.Before(before) {
+			classes = append(classes, copyClass(c))
+		}
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Deleted.After(classes[j].Deleted)
+	})
+	return
+}
+
+func (r *memoryRepository) InsertClass(ctx context.Context, class *class.Class) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	class.Id = primitive.NewObjectID()
 	now := time.Now()
 	class.Created = now
 	class.Updated = now
-	r.classes = append(r.classes, *class)
+	class.Version = 1
+	r.classes = append(r.classes, copyClass(*class))
 	return
 }
 
-func (r *memoryRepository) UpdateClass(class *class.Class) (err error) {
-	for i, c := range r.classes {
-		if c.Id == class.Id {
-			class.Updated = time.Now()
-			r.classes[i] = *class
+func (r *memoryRepository) UpdateClass(ctx context.Context, c *class.Class) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.updateClass(c)
+}
+
+// Saves the class, the caller holds the mutex
+func (r *memoryRepository) updateClass(c *class.Class) (err error) {
+	for i, stored := range r.classes {
+		if stored.Id == c.Id {
+			if stored.Version != c.Version {
+				return fmt.Errorf("%w: %s is at version %d, not %d", class.ErrConflict, c.Id.Hex(), stored.Version, c.Version)
+			}
+			c.Updated = time.Now()
+			c.Version++
+			r.classes[i] = copyClass(*c)
 			return
 		}
 	}
-	return fmt.Errorf("class not found: %s", class.Id.Hex())
+	return fmt.Errorf("class not found: %s", c.Id.Hex())
+}
+
+func (r *memoryRepository) ArchiveClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for i := range r.documents {
+		if r.documents[i].ClassId == classId && r.documents[i].Archived.IsZero() {
+			r.documents[i].Archived = now
+			r.documents[i].Version++
+			count++
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) CountClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, doc := range r.documents {
+		if doc.ClassId == classId && doc.Archived.IsZero() {
+			count++
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) CountClassReferences(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	ids := make(map[primitive.ObjectID]bool)
+	for _, doc := range r.documents {
+		if doc.ClassId == classId {
+			ids[doc.Id] = true
+		}
+	}
+	for _, doc := range r.documents {
+		if doc.ClassId == classId {
+			continue
+		}
+		for _, ref := range doc.References {
+			if ids[ref] {
+				count++
+				break
+			}
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) CountFieldValues(ctx context.Context, classId primitive.ObjectID, key string) (count int64, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, doc := range r.documents {
+		if _, ok := doc.Values[key]; ok && doc.ClassId == classId {
+			count++
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) CountUnconvertibleValues(ctx context.Context, classId primitive.ObjectID, key string, convert func(interface{}) (interface{}, error)) (count int64, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, doc := range r.documents {
+		value, ok := doc.Values[key]
+		if !ok || doc.ClassId != classId {
+			continue
+		}
+		if _, err := convert(value); err != nil {
+			count++
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) ConvertFieldValues(ctx context.Context, classId primitive.ObjectID, key string, convert func(interface{}) (interface{}, error), drop bool) (count int64, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, doc := range r.documents {
+		value, ok := doc.Values[key]
+		if !ok || doc.ClassId != classId {
+			continue
+		}
+		converted, err := convert(value)
+		switch {
+		case err == nil:
+			doc.Values[key] = converted
+		case drop:
+			delete(doc.Values, key)
+		default:
+			continue
+		}
+		r.documents[i].Version++
+		count++
+	}
+	return
+}
+
+// Nothing to index, proximity filters compare every point
+func (r *memoryRepository) EnsureGeoIndex(ctx context.Context, key string) (err error) {
+	return
+}
+
+func (r *memoryRepository) DeleteClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	kept := r.documents[:0]
+	for _, doc := range r.documents {
+		if doc.ClassId == classId {
+			count++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	r.documents = kept
+	return
+}
+
+func (r *memoryRepository) DropFieldValues(ctx context.Context, classId primitive.ObjectID, key string) (count int64, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, doc := range r.documents {
+		if _, ok := doc.Values[key]; ok && doc.ClassId == classId {
+			delete(doc.Values, key)
+			r.documents[i].Version++
+			count++
+		}
+	}
+	return
+}
+
+// Holds the mutex throughout so the class and its documents change together.
+// Only saving the class can fail, so it goes first.
+func (r *memoryRepository) MigrateClass(ctx context.Context, c *class.Class, classIds []primitive.ObjectID, migrations []class.Migration) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err = r.updateClass(c); err != nil {
+		return
+	}
+
+	migrated := make(map[primitive.ObjectID]bool, len(classIds))
+	for _, id := range classIds {
+		migrated[id] = true
+	}
+	for i, doc := range r.documents {
+		if !migrated[doc.ClassId] {
+			continue
+		}
+		changed := false
+		for _, m := range migrations {
+			if m.Apply(doc.Values) {
+				changed = true
+			}
+		}
+		if changed {
+			r.documents[i].Version++
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) RenameFieldValues(ctx context.Context, classId primitive.ObjectID, from string, to string) (count int64, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, doc := range r.documents {
+		if value, ok := doc.Values[from]; ok && doc.ClassId == classId {
+			doc.Values[to] = value
+			delete(doc.Values, from)
+			r.documents[i].Version++
+			count++
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) CountDocumentsByClass(ctx context.Context, now time.Time) (counts []document.ClassCount, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	index := make(map[primitive.ObjectID]int)
+	counts = make([]document.ClassCount, 0, len(r.classes))
+	for _, doc := range r.liveDocuments() {
+		i, ok := index[doc.ClassId]
+		if !ok {
+			i = len(counts)
+			index[doc.ClassId] = i
+			counts = append(counts, document.ClassCount{ClassId: doc.ClassId})
+		}
+		counts[i].Total++
+		switch {
+		case doc.IsDraft():
+			counts[i].Drafts++
+		case doc.Published.After(now):
+			counts[i].Scheduled++
+		}
+	}
+	return
 }
 
-func (r *memoryRepository) DeleteDocument(id primitive.ObjectID) (err error) {
+func (r *memoryRepository) DeleteDocument(ctx context.Context, id primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	for i, doc := range r.documents {
 		if doc.Id == id {
 			r.documents = append(r.documents[:i], r.documents[i+1:]...)
@@ -96,97 +409,639 @@ func (r *memoryRepository) DeleteDocument(id primitive.ObjectID) (err error) {
 	return
 }
 
-func (r *memoryRepository) GetChildDocumentBySlug(parentId primitive.ObjectID, slug string) (doc document.Document, err error) {
+func (r *memoryRepository) GetChildDocumentBySlug(ctx context.Context, parentId primitive.ObjectID, slug string) (doc document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	for _, d := range r.documents {
-		if d.ParentId == parentId && d.Slug == slug {
-			return d, nil
+		if d.ParentId == parentId && d.Slug == slug && d.Deleted.IsZero() {
+			return copyDocument(d), nil
 		}
 	}
 	err = fmt.Errorf("document not found for %s-%s", parentId.Hex(), slug)
 	return
 }
 
-func (r *memoryRepository) GetClassDocumentBySlug(classId primitive.ObjectID, slug string) (doc document.Document, err error) {
+func (r *memoryRepository) GetClassDocumentBySlug(ctx context.Context, classId primitive.ObjectID, slug string) (doc document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	for _, d := range r.documents {
-		if d.ClassId == classId && d.Slug == slug {
-			return d, nil
+		if d.ClassId == classId && d.Slug == slug && d.Deleted.IsZero() {
+			return copyDocument(d), nil
 		}
 	}
 	err = fmt.Errorf("document not found for %s-%s", classId.Hex(), slug)
 	return
 }
 
-func (r *memoryRepository) GetDocumentList(params document.DocumentListParams) (list document.DocumentList, err error) {
+func (r *memoryRepository) GetDocumentList(ctx context.Context, params document.DocumentListParams) (list document.DocumentList, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	docs := make([]document.Document, 0, len(r.documents))
 	for _, doc := range r.documents {
-		if doc.ClassId == params.ClassId {
-			docs = append(docs, doc)
+		if doc.ClassId != params.ClassId || !doc.Archived.IsZero() || !doc.Deleted.IsZero() {
+			continue
+		}
+		if !params.PublishedBefore.IsZero() && (doc.IsDraft() || doc.Published.After(params.PublishedBefore)) {
+			continue
+		}
+		if !params.Near.IsZero() && !params.Near.Contains(doc.Values[params.Near.Field]) {
+			continue
 		}
+		if !params.MatchTitle(doc.Title) {
+			continue
+		}
+		docs = append(docs, doc)
 	}
 
 	list.Total = int64(len(docs))
-	if list.Total == 0 {
+	start, end := pageBounds(params.Offset(), params.Size, list.Total)
+	if start == end {
 		return
 	}
-
-	end := params.Offset() + params.Size
-	if end > list.Total {
-		end = list.Total
-	}
-	list.Documents = docs[params.Offset():end]
+	list.Documents = copyDocuments(docs[start:end])
 
 	return
 }
 
-func (r *memoryRepository) GetDocumentById(id primitive.ObjectID) (doc document.Document, err error) {
+func (r *memoryRepository) GetDocumentById(ctx context.Context, id primitive.ObjectID) (doc document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	for _, d := range r.documents {
 		if d.Id == id {
-			return d, nil
+			return copyDocument(d), nil
 		}
 	}
 	err = fmt.Errorf("document not found: %s", id.Hex())
 	return
 }
 
-func (r *memoryRepository) InsertDocument(doc *document.Document) (err error) {
+func (r *memoryRepository) GetDocumentsByIds(ctx context.Context, ids []primitive.ObjectID) (docs []document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	docs = make([]document.Document, 0, len(ids))
+	for _, id := range ids {
+		for _, d := range r.documents {
+			if d.Id == id {
+				docs = append(docs, copyDocument(d))
+				break
+			}
+		}
+	}
+	return
+}
+
+// Lists the user's drafts, most recently updated first
+func (r *memoryRepository) GetDraftDocuments(ctx context.Context, userId primitive.ObjectID, limit int64) (docs []document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	docs = make([]document.Document, 0, limit)
+	for _, d := range r.liveDocuments() {
+		if d.IsDraft() && d.CreatedBy == userId {
+			docs = append(docs, d)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Updated.After(docs[j].Updated)
+	})
+	return copyDocuments(limitDocuments(docs, limit)), nil
+}
+
+func (r *memoryRepository) GetRecentDocuments(ctx context.Context, limit int64) (docs []document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	docs = r.liveDocuments()
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Updated.After(docs[j].Updated)
+	})
+	return copyDocuments(limitDocuments(docs, limit)), nil
+}
+
+// Lists documents to be published after now, soonest first
+func (r *memoryRepository) GetScheduledDocuments(ctx context.Context, now time.Time, limit int64) (docs []document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	docs = make([]document.Document, 0, limit)
+	for _, d := range r.liveDocuments() {
+		if d.Published.After(now) {
+			docs = append(docs, d)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Published.Before(docs[j].Published)
+	})
+	return copyDocuments(limitDocuments(docs, limit)), nil
+}
+
+// Documents which are neither archived nor trashed
+func (r *memoryRepository) liveDocuments() (docs []document.Document) {
+	docs = make([]document.Document, 0, len(r.documents))
+	for _, d := range r.documents {
+		if d.Archived.IsZero() && d.Deleted.IsZero() {
+			docs = append(docs, d)
+		}
+	}
+	return
+}
+
+// Slice bounds of a page of a list total long. Negative offsets and sizes
+// give an empty page rather than a panic.
+func pageBounds(offset, size, total int64) (start, end int64) {
+	if offset < 0 || size <= 0 || offset >= total {
+		return 0, 0
+	}
+	end = offset + size
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+func limitDocuments(docs []document.Document, limit int64) []document.Document {
+	if int64(len(docs)) > limit {
+		return docs[:limit]
+	}
+	return docs
+}
+
+func (r *memoryRepository) GetReferencingDocuments(ctx context.Context, id primitive.ObjectID) (docs []document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	docs = make([]document.Document, 0, 8)
+	for _, d := range r.documents {
+		for _, ref := range d.References {
+			if ref == id {
+				docs = append(docs, copyDocument(d))
+				break
+			}
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) GetTrashedDocuments(ctx context.Context, before time.Time) (docs []document.Document, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	docs = make([]document.Document, 0, 8)
+	for _, d := range r.documents {
+		if !d.Deleted.IsZero() && d.Deleted.Before(before) {
+			docs = append(docs, copyDocument(d))
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Deleted.After(docs[j].Deleted)
+	})
+	return
+}
+
+func (r *memoryRepository) InsertDocument(ctx context.Context, doc *document.Document) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	doc.Id = primitive.NewObjectID()
 	now := time.Now()
 	doc.Created = now
 	doc.Updated = now
-	r.documents = append(r.documents, *doc)
+	doc.Version = 1
+	r.documents = append(r.documents, copyDocument(*doc))
 	return
 }
 
-func (r *memoryRepository) UpdateDocument(doc *document.Document) (err error) {
+func (r *memoryRepository) UpdateDocument(ctx context.Context, doc *document.Document) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	for i, d := range r.documents {
 		if d.Id == doc.Id {
+			if d.Version != doc.Version {
+				return fmt.Errorf("%w: %s is at version %d, not %d", document.ErrConflict, doc.Id.Hex(), d.Version, doc.Version)
+			}
 			doc.Updated = time.Now()
-			r.documents[i] = *doc
+			doc.Version++
+			r.documents[i] = copyDocument(*doc)
 			return
 		}
 	}
 	return fmt.Errorf("document not found: %s", doc.Id.Hex())
 }
 
-func (r *memoryRepository) GetUserByEmail(email string) (u user.User, err error) {
+func (r *memoryRepository) AcquireLock(l lock.Lock, now time.Time) (lock.Lock, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, held := range r.locks {
+		if held.Id != l.Id {
+			continue
+		}
+		if held.UserId != l.UserId && held.Active(now) {
+			return held, fmt.Errorf("%w: %s", lock.ErrLocked, l.Id.Hex())
+		}
+		if held.UserId == l.UserId {
+			l.Acquired = held.Acquired
+		}
+		r.locks[i] = l
+		return l, nil
+	}
+	r.locks = append(r.locks, l)
+	return l, nil
+}
+
+func (r *memoryRepository) DeleteLock(id primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, l := range r.locks {
+		if l.Id == id {
+			r.locks = append(r.locks[:i], r.locks[i+1:]...)
+			break
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) GetLock(id primitive.ObjectID) (l lock.Lock, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, l := range r.locks {
+		if l.Id == id {
+			return l, nil
+		}
+	}
+	err = fmt.Errorf("lock not found: %s", id.Hex())
+	return
+}
+
+func (r *memoryRepository) AddMediaDerivative(id primitive.ObjectID, key string) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, m := range r.media {
+		if m.Id != id {
+			continue
+		}
+		for _, existing := range m.Derivatives {
+			if existing == key {
+				return
+			}
+		}
+		r.media[i].Derivatives = append(m.Derivatives, key)
+		return
+	}
+	return fmt.Errorf("media not found: %s", id.Hex())
+}
+
+func (r *memoryRepository) DeleteMedia(id primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, m := range r.media {
+		if m.Id == id {
+			r.media = append(r.media[:i], r.media[i+1:]...)
+			break
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) GetMediaById(id primitive.ObjectID) (m media.Media, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, m := range r.media {
+		if m.Id == id {
+			return copyMedia(m), nil
+		}
+	}
+	err = fmt.Errorf("media not found: %s", id.Hex())
+	return
+}
+
+// Lists the newest media first
+func (r *memoryRepository) GetMediaList(params media.MediaListParams) (list media.MediaList, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list.Total = int64(len(r.media))
+
+	start := params.Offset()
+	if start > list.Total {
+		start = list.Total
+	}
+	end := start + params.Size
+	if end > list.Total {
+		end = list.Total
+	}
+
+	list.Media = make([]media.Media, 0, end-start)
+	for i := list.Total - 1 - start; i >= list.Total-end; i-- {
+		list.Media = append(list.Media, copyMedia(r.media[i]))
+	}
+	return
+}
+
+func (r *memoryRepository) InsertMedia(m *media.Media) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if m.Id.IsZero() {
+		m.Id = primitive.NewObjectID()
+	}
+	r.media = append(r.media, copyMedia(*m))
+	return
+}
+
+func (r *memoryRepository) DeleteForm(id primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, f := range r.forms {
+		if f.Id == id {
+			r.forms = append(r.forms[:i], r.forms[i+1:]...)
+			break
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) DeleteSubmissions(formId primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	kept := r.submissions[:0]
+	for _, s := range r.submissions {
+		if s.FormId != formId {
+			kept = append(kept, s)
+		}
+	}
+	r.submissions = kept
+	return
+}
+
+func (r *memoryRepository) GetFormById(id primitive.ObjectID) (f form.Form, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, f := range r.forms {
+		if f.Id == id {
+			return copyForm(f), nil
+		}
+	}
+	err = fmt.Errorf("form not found: %s", id.Hex())
+	return
+}
+
+func (r *memoryRepository) GetForms() ([]form.Form, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	forms := make([]form.Form, len(r.forms))
+	for i, f := range r.forms {
+		forms[i] = copyForm(f)
+	}
+	sort.Slice(forms, func(i, j int) bool { return forms[i].Name < forms[j].Name })
+	return forms, nil
+}
+
+// Lists the newest submissions first
+func (r *memoryRepository) GetSubmissions(formId primitive.ObjectID) (subs []form.Submission, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	subs = make([]form.Submission, 0, 16)
+	for i := len(r.submissions) - 1; i >= 0; i-- {
+		if r.submissions[i].FormId == formId {
+			subs = append(subs, copySubmission(r.submissions[i]))
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) InsertForm(f *form.Form) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f.Id = primitive.NewObjectID()
+	r.forms = append(r.forms, copyForm(*f))
+	return
+}
+
+func (r *memoryRepository) InsertSubmission(s *form.Submission) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s.Id = primitive.NewObjectID()
+	r.submissions = append(r.submissions, copySubmission(*s))
+	return
+}
+
+func (r *memoryRepository) UpdateForm(f *form.Form) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.forms {
+		if existing.Id == f.Id {
+			r.forms[i] = copyForm(*f)
+			return
+		}
+	}
+	return fmt.Errorf("form not found: %s", f.Id.Hex())
+}
+
+func (r *memoryRepository) DeleteDeliveries(webhookId primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	kept := r.deliveries[:0]
+	for _, d := range r.deliveries {
+		if d.WebhookId != webhookId {
+			kept = append(kept, d)
+		}
+	}
+	r.deliveries = kept
+	return
+}
+
+func (r *memoryRepository) DeleteWebhook(id primitive.ObjectID) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, w := range r.webhooks {
+		if w.Id == id {
+			r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+			break
+		}
+	}
+	return
+}
+
+// Lists the newest deliveries first
+func (r *memoryRepository) GetDeliveries(webhookId primitive.ObjectID, limit int64) (deliveries []webhook.Delivery, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	deliveries = make([]webhook.Delivery, 0, 16)
+	for i := len(r.deliveries) - 1; i >= 0 && int64(len(deliveries)) < limit; i-- {
+		if r.deliveries[i].WebhookId == webhookId {
+			deliveries = append(deliveries, r.deliveries[i])
+		}
+	}
+	return
+}
+
+func (r *memoryRepository) GetDeliveryById(id primitive.ObjectID) (d webhook.Delivery, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, d := range r.deliveries {
+		if d.Id == id {
+			return d, nil
+		}
+	}
+	err = fmt.Errorf("delivery not found: %s", id.Hex())
 	return
 }
 
-func (r *memoryRepository) GetUserById(id primitive.ObjectID) (u user.User, err error) {
+func (r *memoryRepository) GetWebhookById(id primitive.ObjectID) (w webhook.Webhook, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, w := range r.webhooks {
+		if w.Id == id {
+			return copyWebhook(w), nil
+		}
+	}
+	err = fmt.Errorf("webhook not found: %s", id.Hex())
+	return
+}
+
+func (r *memoryRepository) GetWebhooks() ([]webhook.Webhook, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	webhooks := make([]webhook.Webhook, len(r.webhooks))
+	for i, w := range r.webhooks {
+		webhooks[i] = copyWebhook(w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].Name < webhooks[j].Name })
+	return webhooks, nil
+}
+
+func (r *memoryRepository) InsertDelivery(d *webhook.Delivery) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	d.Id = primitive.NewObjectID()
+	r.deliveries = append(r.deliveries, *d)
+	return
+}
+
+func (r *memoryRepository) InsertWebhook(w *webhook.Webhook) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	w.Id = primitive.NewObjectID()
+	r.webhooks = append(r.webhooks, copyWebhook(*w))
+	return
+}
+
+func (r *memoryRepository) UpdateDelivery(d *webhook.Delivery) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.deliveries {
+		if existing.Id == d.Id {
+			r.deliveries[i] = *d
+			return
+		}
+	}
+	return fmt.Errorf("delivery not found: %s", d.Id.Hex())
+}
+
+func (r *memoryRepository) UpdateWebhook(w *webhook.Webhook) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.webhooks {
+		if existing.Id == w.Id {
+			r.webhooks[i] = copyWebhook(*w)
+			return
+		}
+	}
+	return fmt.Errorf("webhook not found: %s", w.Id.Hex())
+}
+
+func (r *memoryRepository) GetUserByEmail(ctx context.Context, email string) (u user.User, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	err = fmt.Errorf("user not found: %s", email)
 	return
 }
 
-func (r *memoryRepository) InsertUser(u *user.User) (err error) {
+func (r *memoryRepository) GetUserById(ctx context.Context, id primitive.ObjectID) (u user.User, err error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, u := range r.users {
+		if u.Id == id {
+			return u, nil
+		}
+	}
+	err = fmt.Errorf("user not found: %s", id.Hex())
 	return
 }
 
-func (r *memoryRepository) UpdateUser(u *user.User) (err error) {
+// Users hold no maps or slices, storing the value is copy enough
+func (r *memoryRepository) InsertUser(ctx context.Context, u *user.User) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	u.Id = primitive.NewObjectID()
+	r.users = append(r.users, *u)
 	return
 }
 
+func (r *memoryRepository) UpdateUser(ctx context.Context, u *user.User) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.users {
+		if existing.Id == u.Id {
+			r.users[i] = *u
+			return
+		}
+	}
+	return fmt.Errorf("user not found: %s", u.Id.Hex())
+}
+
 func (r *memoryRepository) empty() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.audit = r.audit[:0]
 	r.classes = r.classes[:0]
 	r.documents = r.documents[:0]
+	r.forms = r.forms[:0]
+	r.locks = r.locks[:0]
+	r.media = r.media[:0]
+	r.submissions = r.submissions[:0]
 	r.users = r.users[:0]
+	r.deliveries = r.deliveries[:0]
+	r.webhooks = r.webhooks[:0]
 	return
 }
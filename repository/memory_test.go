@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryCopies(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+
+	c := class.Class{
+		Name:   "Pages",
+		Slug:   "pages",
+		Fields: []field.Field{{Name: "body", Label: "Body", Type: field.TypeText}},
+	}
+	assert.NoError(t, repo.InsertClass(ctx, &c))
+	c.Fields[0].Label = "Changed after insert"
+
+	all, err := repo.GetAllClasses(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "Body", all[0].Fields[0].Label)
+	all[0].Fields[0].Label = "Changed after get"
+	check, err := repo.GetClassById(ctx, c.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Body", check.Fields[0].Label)
+
+	related := primitive.NewObjectID()
+	doc := document.Document{
+		ClassId: c.Id,
+		Slug:    "about",
+		Values: map[string]interface{}{
+			"body":  "Original",
+			"links": []interface{}{map[string]interface{}{"url": "/original"}},
+		},
+		References: []primitive.ObjectID{related},
+	}
+	assert.NoError(t, repo.InsertDocument(ctx, &doc))
+	doc.Values["body"] = "Changed after insert"
+
+	stored, err := repo.GetDocumentById(ctx, doc.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Original", stored.Values["body"])
+
+	// Nested values and references are copied too
+	stored.Values["links"].([]interface{})[0].(map[string]interface{})["url"] = "/changed"
+	stored.References[0] = primitive.NewObjectID()
+	list, err := repo.GetDocumentList(ctx, document.DocumentListParams{ClassId: c.Id, Size: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, "/original", list.Documents[0].Values["links"].([]interface{})[0].(map[string]interface{})["url"])
+	assert.Equal(t, related, list.Documents[0].References[0])
+}
+
+// Run with -race to catch unguarded access
+func TestMemoryConcurrency(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+
+	pages := class.Class{Name: "Pages", Slug: "pages"}
+	assert.NoError(t, repo.InsertClass(ctx, &pages))
+	shared := document.Document{ClassId: pages.Id, Slug: "shared", Values: map[string]interface{}{"hits": 0}}
+	assert.NoError(t, repo.InsertDocument(ctx, &shared))
+
+	const workers, rounds = 16, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userId := primitive.NewObjectID()
+			for i := 0; i < rounds; i++ {
+				c := class.Class{Name: fmt.Sprintf("Class %d-%d", w, i), Slug: fmt.Sprintf("class-%d-%d", w, i)}
+				if err := repo.InsertClass(ctx, &c); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := repo.GetAllClasses(ctx); err != nil {
+					errs <- err
+					return
+				}
+
+				doc := document.Document{
+					ClassId: pages.Id,
+					Slug:    fmt.Sprintf("doc-%d-%d", w, i),
+					Values:  map[string]interface{}{"worker": w},
+				}
+				if err := repo.InsertDocument(ctx, &doc); err != nil {
+					errs <- err
+					return
+				}
+
+				// Everyone bumps the same document, retrying when someone
+				// else saved first
+				for {
+					current, err := repo.GetDocumentById(ctx, shared.Id)
+					if err != nil {
+						errs <- err
+						return
+					}
+					current.Values["hits"] = current.Values["hits"].(int) + 1
+					err = repo.UpdateDocument(ctx, &current)
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, document.ErrConflict) {
+						errs <- err
+						return
+					}
+				}
+
+				list, err := repo.GetDocumentList(ctx, document.DocumentListParams{ClassId: pages.Id, Size: 20})
+				if err != nil {
+					errs <- err
+					return
+				}
+				for _, d := range list.Documents {
+					d.Values["scribble"] = w
+				}
+
+				if _, err := repo.CountDocumentsByClass(ctx, time.Now()); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := repo.GetRecentDocuments(ctx, 5); err != nil {
+					errs <- err
+					return
+				}
+				if err := repo.InsertAuditEntry(&audit.Entry{ActorId: userId, Action: audit.ActionUpdate}); err != nil {
+					errs <- err
+					return
+				}
+				now := time.Now()
+				l := lock.Lock{Id: shared.Id, UserId: userId, Acquired: now, Expires: now.Add(time.Millisecond)}
+				if _, err := repo.AcquireLock(l, now); err != nil && !errors.Is(err, lock.ErrLocked) {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	classes, err := repo.GetAllClasses(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, workers*rounds+1, len(classes))
+
+	list, err := repo.GetDocumentList(ctx, document.DocumentListParams{ClassId: pages.Id, Size: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(workers*rounds+1), list.Total)
+
+	final, err := repo.GetDocumentById(ctx, shared.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, workers*rounds, final.Values["hits"])
+	assert.Equal(t, int64(workers*rounds+1), final.Version)
+	_, scribbled := final.Values["scribble"]
+	assert.False(t, scribbled)
+}
@@ -3,72 +3,211 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
 	"time"
 
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Limits how long a single query may take. Reads cover finds and counts,
+// writes cover everything else. A zero timeout leaves the query to the
+// caller's context alone.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:  5 * time.Second,
+	Write: 10 * time.Second,
+}
+
 type mongoRepository struct {
-	context   context.Context
-	db        *mongo.Database
-	classes   *mongo.Collection
-	documents *mongo.Collection
-	users     *mongo.Collection
+	// Parent context for the audit, form, lock, media and webhook queries,
+	// whose methods take no context from the caller, and for empty. It is
+	// deliberately detached from any request: those writes should finish even
+	// when the request that caused them is cancelled, and the read and write
+	// timeouts still bound each query.
+	background  context.Context
+	timeouts    Timeouts
+	db          *mongo.Database
+	audit       *mongo.Collection
+	classes     *mongo.Collection
+	deliveries  *mongo.Collection
+	documents   *mongo.Collection
+	forms       *mongo.Collection
+	locks       *mongo.Collection
+	media       *mongo.Collection
+	submissions *mongo.Collection
+	users       *mongo.Collection
+	webhooks    *mongo.Collection
 }
 
-func NewMongo(ctx context.Context, db *mongo.Database) Repository {
+func NewMongo(ctx context.Context, db *mongo.Database, timeouts Timeouts) Repository {
 	return &mongoRepository{
-		context:   ctx,
-		db:        db,
-		classes:   db.Collection("classes"),
-		documents: db.Collection("documents"),
-		users:     db.Collection("users"),
+		background:  ctx,
+		timeouts:    timeouts,
+		db:          db,
+		audit:       db.Collection("audit"),
+		classes:     db.Collection("classes"),
+		deliveries:  db.Collection("deliveries"),
+		documents:   db.Collection("documents"),
+		forms:       db.Collection("forms"),
+		locks:       db.Collection("locks"),
+		media:       db.Collection("media"),
+		submissions: db.Collection("submissions"),
+		users:       db.Collection("users"),
+		webhooks:    db.Collection("webhooks"),
+	}
+}
+
+func (m mongoRepository) read(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.timeouts.Read)
+}
+
+func (m mongoRepository) write(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.timeouts.Write)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Lists entries newest first
+func (m mongoRepository) GetAuditEntries(filter audit.Filter) (entries []audit.Entry, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	query := bson.M{}
+	if !filter.ActorId.IsZero() {
+		query["actor_id"] = filter.ActorId
+	}
+	if !filter.ClassId.IsZero() {
+		query["class_id"] = filter.ClassId
+	}
+	times := bson.M{}
+	if !filter.From.IsZero() {
+		times["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		times["$lt"] = filter.To
+	}
+	if len(times) > 0 {
+		query["time"] = times
+	}
+
+	sort := bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}
+	opts := options.Find().SetSort(sort)
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	cursor, err := m.audit.Find(ctx, query, opts)
+	if err != nil {
+		return
 	}
+	entries = make([]audit.Entry, 0, 16)
+	err = cursor.All(ctx, &entries)
+	return
+}
+
+func (m mongoRepository) InsertAuditEntry(e *audit.Entry) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	e.Id = primitive.NewObjectID()
+	_, err = m.audit.InsertOne(ctx, e)
+	return
 }
 
-func (m mongoRepository) DeleteClass(id primitive.ObjectID) (err error) {
+func (m mongoRepository) DeleteClass(ctx context.Context, id primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
 	filter := bson.M{"_id": id}
-	_, err = m.classes.DeleteOne(m.context, filter)
+	_, err = m.classes.DeleteOne(ctx, filter)
 	// Can include a check for the result and DeletedCount later if it is useful
 	return
 }
 
-func (m mongoRepository) GetAllClasses() (classes []class.Class, err error) {
-	filter := bson.D{}
+func (m mongoRepository) GetAllClasses(ctx context.Context) (classes []class.Class, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.M{"deleted": bson.M{"$exists": false}}
 	sort := bson.D{bson.E{Key: "name", Value: 1}}
 	opts := options.Find().SetSort(sort)
 
-	cursor, err := m.classes.Find(m.context, filter, opts)
+	cursor, err := m.classes.Find(ctx, filter, opts)
 	if err != nil {
 		return
 	}
-	err = cursor.All(m.context, &classes)
+	err = cursor.All(ctx, &classes)
 	return
 }
 
-func (m mongoRepository) GetClassById(id primitive.ObjectID) (class class.Class, err error) {
+func (m mongoRepository) GetClassById(ctx context.Context, id primitive.ObjectID) (class class.Class, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
 	filter := bson.M{"_id": id}
-	err = m.classes.FindOne(m.context, filter).Decode(&class)
+	err = m.classes.FindOne(ctx, filter).Decode(&class)
+	return
+}
+
+func (m mongoRepository) GetClassBySlug(ctx context.Context, slug string) (class class.Class, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "slug", Value: slug},
+		{Key: "deleted", Value: bson.M{"$exists": false}},
+	}
+	err = m.classes.FindOne(ctx, filter).Decode(&class)
 	return
 }
 
-func (m mongoRepository) GetClassBySlug(slug string) (class class.Class, err error) {
-	filter := bson.M{"slug": slug}
-	err = m.classes.FindOne(m.context, filter).Decode(&class)
+func (m mongoRepository) GetTrashedClasses(ctx context.Context, before time.Time) (classes []class.Class, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.M{"deleted": bson.M{"$lt": before}}
+	sort := bson.D{{Key: "deleted", Value: -1}}
+	opts := options.Find().SetSort(sort)
+
+	cursor, err := m.classes.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	classes = make([]class.Class, 0, 8)
+	err = cursor.All(ctx, &classes)
 	return
 }
 
-func (m mongoRepository) InsertClass(class *class.Class) (err error) {
+func (m mongoRepository) InsertClass(ctx context.Context, class *class.Class) (err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
 	now := time.Now()
 	class.Created = now
 	class.Updated = now
-	result, err := m.classes.InsertOne(m.context, class)
+	class.Version = 1
+	result, err := m.classes.InsertOne(ctx, class)
 	if err != nil {
 		return
 	}
@@ -80,70 +219,513 @@ func (m mongoRepository) InsertClass(class *class.Class) (err error) {
 	return
 }
 
-func (m mongoRepository) UpdateClass(class *class.Class) (err error) {
-	class.Updated = time.Now()
-	filter := bson.M{"_id": class.Id}
-	result, err := m.classes.ReplaceOne(m.context, filter, class)
+// Replaces the class as long as the stored version is the one it was loaded
+// at, bumping the version in the same write
+func (m mongoRepository) UpdateClass(ctx context.Context, c *class.Class) (err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	version := c.Version
+	c.Updated = time.Now()
+	c.Version++
+	filter := bson.M{"_id": c.Id, "version": versionFilter(version)}
+	result, err := m.classes.ReplaceOne(ctx, filter, c)
+	if err == nil && result.MatchedCount == 0 {
+		err = m.updateMiss(ctx, m.classes, c.Id, class.ErrConflict, "did not match a Class to update")
+	}
+	if err != nil {
+		c.Version = version
+	}
+	return
+}
+
+// Matches the version a model was loaded at. Models stored before versions
+// were introduced have none and count as version zero.
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// Explains why a versioned replace matched nothing: the model is still there
+// at another version, or it is gone
+func (m mongoRepository) updateMiss(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, conflict error, missing string) error {
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s", conflict, id.Hex())
+	}
+	return errors.New(missing)
+}
+
+func (m mongoRepository) ArchiveClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "class_id", Value: classId},
+		{Key: "archived", Value: bson.M{"$exists": false}},
+	}
+	update := bson.M{
+		"$set": bson.M{"archived": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}
+	result, err := m.documents.UpdateMany(ctx, filter, update)
 	if err != nil {
 		return
 	}
-	if result.MatchedCount == 0 {
-		return errors.New("did not match a Class to update")
+	return result.ModifiedCount, nil
+}
+
+func (m mongoRepository) CountClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "class_id", Value: classId},
+		{Key: "archived", Value: bson.M{"$exists": false}},
+	}
+	return m.documents.CountDocuments(ctx, filter)
+}
+
+func (m mongoRepository) CountClassReferences(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	ids, err := m.documents.Distinct(ctx, "_id", bson.M{"class_id": classId})
+	if err != nil || len(ids) == 0 {
+		return
+	}
+	filter := bson.D{
+		{Key: "class_id", Value: bson.M{"$ne": classId}},
+		{Key: "references", Value: bson.M{"$in": ids}},
+	}
+	return m.documents.CountDocuments(ctx, filter)
+}
+
+func (m mongoRepository) CountFieldValues(ctx context.Context, classId primitive.ObjectID, key string) (count int64, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	return m.documents.CountDocuments(ctx, fieldValueFilter(classId, key))
+}
+
+func (m mongoRepository) CountUnconvertibleValues(ctx context.Context, classId primitive.ObjectID, key string, convert func(interface{}) (interface{}, error)) (count int64, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	cursor, err := m.documents.Find(ctx, fieldValueFilter(classId, key))
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc document.Document
+		if err = cursor.Decode(&doc); err != nil {
+			return
+		}
+		if _, convertErr := convert(doc.Values[key]); convertErr != nil {
+			count++
+		}
+	}
+	err = cursor.Err()
+	return
+}
+
+func (m mongoRepository) ConvertFieldValues(ctx context.Context, classId primitive.ObjectID, key string, convert func(interface{}) (interface{}, error), drop bool) (count int64, err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	cursor, err := m.documents.Find(ctx, fieldValueFilter(classId, key))
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc document.Document
+		if err = cursor.Decode(&doc); err != nil {
+			return
+		}
+
+		var update bson.M
+		converted, convertErr := convert(doc.Values[key])
+		switch {
+		case convertErr == nil:
+			update = bson.M{"$set": bson.M{"values." + key: converted}, "$inc": bson.M{"version": 1}}
+		case drop:
+			update = bson.M{"$unset": bson.M{"values." + key: ""}, "$inc": bson.M{"version": 1}}
+		default:
+			continue
+		}
+		if _, err = m.documents.UpdateByID(ctx, doc.Id, update); err != nil {
+			return
+		}
+		count++
 	}
+	err = cursor.Err()
 	return
 }
 
-func (m mongoRepository) DeleteDocument(id primitive.ObjectID) (err error) {
+func (m mongoRepository) EnsureGeoIndex(ctx context.Context, key string) (err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	model := mongo.IndexModel{
+		Keys: bson.D{{Key: "values." + key, Value: "2dsphere"}},
+	}
+	_, err = m.documents.Indexes().CreateOne(ctx, model)
+	return
+}
+
+func (m mongoRepository) DeleteClassDocuments(ctx context.Context, classId primitive.ObjectID) (count int64, err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	filter := bson.M{"class_id": classId}
+	result, err := m.documents.DeleteMany(ctx, filter)
+	if err != nil {
+		return
+	}
+	return result.DeletedCount, nil
+}
+
+func (m mongoRepository) DropFieldValues(ctx context.Context, classId primitive.ObjectID, key string) (count int64, err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"values." + key: ""}, "$inc": bson.M{"version": 1}}
+	result, err := m.documents.UpdateMany(ctx, fieldValueFilter(classId, key), update)
+	if err != nil {
+		return
+	}
+	return result.ModifiedCount, nil
+}
+
+// Saves the class and migrates the documents in a transaction. Standalone
+// servers cannot run transactions, so there the documents are migrated class
+// by class before the class is saved, and a failure part way through is
+// reported as a MigrationError.
+func (m mongoRepository) MigrateClass(ctx context.Context, c *class.Class, classIds []primitive.ObjectID, migrations []class.Migration) (err error) {
+	saved := *c
+
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// Transactions are retried on transient errors
+		*c = saved
+		if err := m.migrateClass(sc, c, classIds, migrations); err != nil {
+			// Everything is rolled back, so nothing was migrated after all
+			var partial *class.MigrationError
+			if errors.As(err, &partial) {
+				return nil, partial.Err
+			}
+			return nil, err
+		}
+		return nil, nil
+	})
+	if err == nil {
+		return
+	}
+
+	*c = saved
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(errIllegalOperation) {
+		return m.migrateClass(ctx, c, classIds, migrations)
+	}
+	return
+}
+
+// Returned by standalone servers asked to run a transaction
+const errIllegalOperation = 20
+
+func (m mongoRepository) migrateClass(ctx context.Context, c *class.Class, classIds []primitive.ObjectID, migrations []class.Migration) error {
+	migrated := make([]primitive.ObjectID, 0, len(classIds))
+	for _, id := range classIds {
+		for _, migration := range migrations {
+			var err error
+			switch migration.Action {
+			case class.MigrateRename:
+				_, err = m.RenameFieldValues(ctx, id, migration.Field, migration.To)
+			case class.MigrateConvert:
+				_, err = m.ConvertFieldValues(ctx, id, migration.Field, migration.Convert, migration.DropUnconvertible)
+			case class.MigrateDrop:
+				_, err = m.DropFieldValues(ctx, id, migration.Field)
+			}
+			if err != nil {
+				return &class.MigrationError{Migrated: migrated, Err: fmt.Errorf("%s %s: %w", migration.Action, migration.Field, err)}
+			}
+		}
+		migrated = append(migrated, id)
+	}
+
+	if err := m.UpdateClass(ctx, c); err != nil {
+		return &class.MigrationError{Migrated: migrated, Err: err}
+	}
+	return nil
+}
+
+func (m mongoRepository) RenameFieldValues(ctx context.Context, classId primitive.ObjectID, from string, to string) (count int64, err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	update := bson.M{"$rename": bson.M{"values." + from: "values." + to}, "$inc": bson.M{"version": 1}}
+	result, err := m.documents.UpdateMany(ctx, fieldValueFilter(classId, from), update)
+	if err != nil {
+		return
+	}
+	return result.ModifiedCount, nil
+}
+
+// Matches documents of the class with a value stored under key
+func fieldValueFilter(classId primitive.ObjectID, key string) bson.D {
+	return bson.D{
+		{Key: "class_id", Value: classId},
+		{Key: "values." + key, Value: bson.M{"$exists": true}},
+	}
+}
+
+// Counts documents in each state with a single pass over every class
+func (m mongoRepository) CountDocumentsByClass(ctx context.Context, now time.Time) (counts []document.ClassCount, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	countIf := func(condition bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{condition, 1, 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: liveDocuments()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$class_id",
+			"total":     bson.M{"$sum": 1},
+			"drafts":    countIf(bson.M{"$eq": bson.A{"$published", time.Time{}}}),
+			"scheduled": countIf(bson.M{"$gt": bson.A{"$published", now}}),
+		}}},
+	}
+
+	cursor, err := m.documents.Aggregate(ctx, pipeline)
+	if err != nil {
+		return
+	}
+	counts = make([]document.ClassCount, 0, 16)
+	err = cursor.All(ctx, &counts)
+	return
+}
+
+func (m mongoRepository) DeleteDocument(ctx context.Context, id primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
 	filter := bson.M{"_id": id}
-	_, err = m.documents.DeleteOne(m.context, filter)
+	_, err = m.documents.DeleteOne(ctx, filter)
 	return
 }
 
-func (m mongoRepository) GetDocumentById(id primitive.ObjectID) (doc document.Document, err error) {
+func (m mongoRepository) GetDocumentById(ctx context.Context, id primitive.ObjectID) (doc document.Document, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
 	filter := bson.M{"_id": id}
-	err = m.documents.FindOne(m.context, filter).Decode(&doc)
+	err = m.documents.FindOne(ctx, filter).Decode(&doc)
 	return
 }
 
-func (m mongoRepository) GetChildDocumentBySlug(id primitive.ObjectID, slug string) (doc document.Document, err error) {
-	filter := bson.D{{Key: "parent_id", Value: id}, {Key: "slug", Value: slug}}
-	err = m.documents.FindOne(m.context, filter).Decode(&doc)
+func (m mongoRepository) GetChildDocumentBySlug(ctx context.Context, id primitive.ObjectID, slug string) (doc document.Document, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "parent_id", Value: id},
+		{Key: "slug", Value: slug},
+		{Key: "deleted", Value: bson.M{"$exists": false}},
+	}
+	err = m.documents.FindOne(ctx, filter).Decode(&doc)
 	return
 }
 
-func (m mongoRepository) GetClassDocumentBySlug(id primitive.ObjectID, slug string) (doc document.Document, err error) {
-	filter := bson.D{{Key: "class_id", Value: id}, {Key: "slug", Value: slug}}
-	err = m.documents.FindOne(m.context, filter).Decode(&doc)
+func (m mongoRepository) GetClassDocumentBySlug(ctx context.Context, id primitive.ObjectID, slug string) (doc document.Document, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "class_id", Value: id},
+		{Key: "slug", Value: slug},
+		{Key: "deleted", Value: bson.M{"$exists": false}},
+	}
+	err = m.documents.FindOne(ctx, filter).Decode(&doc)
 	return
 }
 
-func (m mongoRepository) GetDocumentList(params document.DocumentListParams) (list document.DocumentList, err error) {
-	filter := bson.D{{Key: "class_id", Value: params.ClassId}}
+func (m mongoRepository) GetDocumentList(ctx context.Context, params document.DocumentListParams) (list document.DocumentList, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "class_id", Value: params.ClassId},
+		{Key: "archived", Value: bson.M{"$exists": false}},
+		{Key: "deleted", Value: bson.M{"$exists": false}},
+	}
+	if !params.PublishedBefore.IsZero() {
+		// Drafts are stored with the zero time, which is before everything
+		published := bson.M{"$gt": time.Time{}, "$lte": params.PublishedBefore}
+		filter = append(filter, bson.E{Key: "published", Value: published})
+	}
+	if near := params.Near; !near.IsZero() {
+		// $geoWithin, unlike $near, may be used when counting
+		sphere := bson.A{near.Point.Coordinates, near.Radius / field.EarthRadius}
+		filter = append(filter, bson.E{
+			Key:   "values." + near.Field,
+			Value: bson.M{"$geoWithin": bson.M{"$centerSphere": sphere}},
+		})
+	}
+	if params.Title != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(params.Title), Options: "i"}
+		filter = append(filter, bson.E{Key: "title", Value: pattern})
+	}
 
 	countOpts := options.Count()
-	list.Total, err = m.documents.CountDocuments(m.context, filter, countOpts)
+	list.Total, err = m.documents.CountDocuments(ctx, filter, countOpts)
 	if err != nil {
 		return
 	}
-	if list.Total == 0 {
+	if list.Total == 0 || params.Size <= 0 || params.Offset() < 0 {
 		return
 	}
 
 	findOpts := options.Find().SetLimit(params.Size).SetSkip(params.Offset())
-	cursor, err := m.documents.Find(m.context, filter, findOpts)
+	cursor, err := m.documents.Find(ctx, filter, findOpts)
 	if err != nil {
 		return
 	}
-	err = cursor.All(m.context, &list.Documents)
+	err = cursor.All(ctx, &list.Documents)
 	return
 }
 
-func (m mongoRepository) InsertDocument(doc *document.Document) (err error) {
+func (m mongoRepository) GetDocumentsByIds(ctx context.Context, ids []primitive.ObjectID) (docs []document.Document, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": bson.M{"$in": ids}}
+	cursor, err := m.documents.Find(ctx, filter)
+	if err != nil {
+		return
+	}
+	found := make([]document.Document, 0, len(ids))
+	if err = cursor.All(ctx, &found); err != nil {
+		return
+	}
+
+	// $in does not preserve order, relations depend on it
+	lookup := make(map[primitive.ObjectID]document.Document, len(found))
+	for _, doc := range found {
+		lookup[doc.Id] = doc
+	}
+	docs = make([]document.Document, 0, len(found))
+	for _, id := range ids {
+		if doc, ok := lookup[id]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+func (m mongoRepository) GetReferencingDocuments(ctx context.Context, id primitive.ObjectID) (docs []document.Document, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.M{"references": id}
+	cursor, err := m.documents.Find(ctx, filter)
+	if err != nil {
+		return
+	}
+	docs = make([]document.Document, 0, 8)
+	err = cursor.All(ctx, &docs)
+	return
+}
+
+// Lists the user's drafts, most recently updated first
+func (m mongoRepository) GetDraftDocuments(ctx context.Context, userId primitive.ObjectID, limit int64) (docs []document.Document, err error) {
+	filter := liveDocuments()
+	filter["published"] = time.Time{}
+	if userId.IsZero() {
+		filter["created_by"] = bson.M{"$exists": false}
+	} else {
+		filter["created_by"] = userId
+	}
+	sort := bson.D{{Key: "updated", Value: -1}}
+	return m.findDocuments(ctx, filter, sort, limit)
+}
+
+func (m mongoRepository) GetRecentDocuments(ctx context.Context, limit int64) (docs []document.Document, err error) {
+	sort := bson.D{{Key: "updated", Value: -1}}
+	return m.findDocuments(ctx, liveDocuments(), sort, limit)
+}
+
+// Lists documents to be published after now, soonest first
+func (m mongoRepository) GetScheduledDocuments(ctx context.Context, now time.Time, limit int64) (docs []document.Document, err error) {
+	filter := liveDocuments()
+	filter["published"] = bson.M{"$gt": now}
+	sort := bson.D{{Key: "published", Value: 1}}
+	return m.findDocuments(ctx, filter, sort, limit)
+}
+
+func (m mongoRepository) findDocuments(ctx context.Context, filter bson.M, sort bson.D, limit int64) (docs []document.Document, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	opts := options.Find().SetSort(sort).SetLimit(limit)
+	cursor, err := m.documents.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	docs = make([]document.Document, 0, limit)
+	err = cursor.All(ctx, &docs)
+	return
+}
+
+// Filter matching documents which are neither archived nor trashed
+func liveDocuments() bson.M {
+	return bson.M{
+		"archived": bson.M{"$exists": false},
+		"deleted":  bson.M{"$exists": false},
+	}
+}
+
+func (m mongoRepository) GetTrashedDocuments(ctx context.Context, before time.Time) (docs []document.Document, err error) {
+	ctx, cancel := m.read(ctx)
+	defer cancel()
+
+	filter := bson.M{"deleted": bson.M{"$lt": before}}
+	sort := bson.D{{Key: "deleted", Value: -1}}
+	opts := options.Find().SetSort(sort)
+
+	cursor, err := m.documents.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	docs = make([]document.Document, 0, 8)
+	err = cursor.All(ctx, &docs)
+	return
+}
+
+func (m mongoRepository) InsertDocument(ctx context.Context, doc *document.Document) (err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
 	now := time.Now()
 	doc.Created = now
 	doc.Updated = now
+	doc.Version = 1
 
-	result, err := m.documents.InsertOne(m.context, doc)
+	result, err := m.documents.InsertOne(ctx, doc)
 	id, ok := result.InsertedID.(primitive.ObjectID)
 	if !ok {
 		return errors.New("unable to cast newly inserted Document ID to ObjectID")
@@ -153,41 +735,392 @@ func (m mongoRepository) InsertDocument(doc *document.Document) (err error) {
 	return
 }
 
-func (m mongoRepository) UpdateDocument(doc *document.Document) (err error) {
+// Replaces the document as long as nobody saved it since it was loaded
+func (m mongoRepository) UpdateDocument(ctx context.Context, doc *document.Document) (err error) {
+	ctx, cancel := m.write(ctx)
+	defer cancel()
+
+	version := doc.Version
 	doc.Updated = time.Now()
+	doc.Version++
+
+	filter := bson.M{"_id": doc.Id, "version": versionFilter(version)}
+	result, err := m.documents.ReplaceOne(ctx, filter, doc)
+	if err == nil && result.MatchedCount == 0 {
+		err = m.updateMiss(ctx, m.documents, doc.Id, document.ErrConflict, "did not match a Document to update")
+	}
+	if err != nil {
+		doc.Version = version
+	}
+	return
+}
+
+// Refreshes the user's own lock first so its acquired time is kept. Failing
+// that, the lock is written over an expired one or inserted; the unique _id
+// makes the insert fail while someone else holds an active lock.
+func (m mongoRepository) AcquireLock(l lock.Lock, now time.Time) (lock.Lock, error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
 
-	filter := bson.M{"_id": doc.Id}
-	result, err := m.documents.ReplaceOne(m.context, filter, doc)
+	filter := bson.M{"_id": l.Id, "user_id": l.UserId}
+	var refreshed lock.Lock
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := m.locks.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"expires": l.Expires}}, after).Decode(&refreshed)
+	if err == nil {
+		return refreshed, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return lock.Lock{}, err
+	}
+
+	filter = bson.M{"_id": l.Id, "expires": bson.M{"$lte": now}}
+	_, err = m.locks.ReplaceOne(ctx, filter, l, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		held, err := m.GetLock(l.Id)
+		if err != nil {
+			return lock.Lock{}, err
+		}
+		return held, fmt.Errorf("%w: %s", lock.ErrLocked, l.Id.Hex())
+	}
+	if err != nil {
+		return lock.Lock{}, err
+	}
+	return l, nil
+}
+
+func (m mongoRepository) DeleteLock(id primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	_, err = m.locks.DeleteOne(ctx, bson.M{"_id": id})
+	return
+}
+
+func (m mongoRepository) GetLock(id primitive.ObjectID) (l lock.Lock, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	err = m.locks.FindOne(ctx, bson.M{"_id": id}).Decode(&l)
+	return
+}
+
+func (m mongoRepository) AddMediaDerivative(id primitive.ObjectID, key string) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	update := bson.M{"$addToSet": bson.M{"derivatives": key}}
+	result, err := m.media.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return
 	}
 	if result.MatchedCount == 0 {
-		return errors.New("did not match a Document to update")
+		return errors.New("did not match Media to add a derivative to")
 	}
 	return
 }
 
-func (r mongoRepository) GetUserByEmail(email string) (u user.User, err error) {
+func (m mongoRepository) DeleteMedia(id primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	_, err = m.media.DeleteOne(ctx, filter)
 	return
 }
 
-func (r mongoRepository) GetUserById(id primitive.ObjectID) (u user.User, err error) {
+func (m mongoRepository) GetMediaById(id primitive.ObjectID) (media media.Media, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	err = m.media.FindOne(ctx, filter).Decode(&media)
 	return
 }
 
-func (r mongoRepository) InsertUser(u *user.User) (err error) {
+// Lists the newest media first
+func (m mongoRepository) GetMediaList(params media.MediaListParams) (list media.MediaList, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	filter := bson.M{}
+
+	if list.Total, err = m.media.CountDocuments(ctx, filter); err != nil {
+		return
+	}
+
+	sort := bson.D{{Key: "_id", Value: -1}}
+	opts := options.Find().SetSort(sort).SetLimit(params.Size).SetSkip(params.Offset())
+	cursor, err := m.media.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	list.Media = make([]media.Media, 0, params.Size)
+	err = cursor.All(ctx, &list.Media)
+	return
+}
+
+func (m mongoRepository) InsertMedia(media *media.Media) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	if media.Id.IsZero() {
+		media.Id = primitive.NewObjectID()
+	}
+	_, err = m.media.InsertOne(ctx, media)
+	return
+}
+
+func (m mongoRepository) DeleteForm(id primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	_, err = m.forms.DeleteOne(ctx, filter)
+	return
+}
+
+func (m mongoRepository) DeleteSubmissions(formId primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"form_id": formId}
+	_, err = m.submissions.DeleteMany(ctx, filter)
 	return
 }
 
-func (r mongoRepository) UpdateUser(u *user.User) (err error) {
+func (m mongoRepository) GetFormById(id primitive.ObjectID) (f form.Form, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	err = m.forms.FindOne(ctx, filter).Decode(&f)
+	return
+}
+
+func (m mongoRepository) GetForms() (forms []form.Form, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	sort := bson.D{{Key: "name", Value: 1}}
+	opts := options.Find().SetSort(sort)
+	cursor, err := m.forms.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return
+	}
+	forms = make([]form.Form, 0, 16)
+	err = cursor.All(ctx, &forms)
+	return
+}
+
+// Lists the newest submissions first
+func (m mongoRepository) GetSubmissions(formId primitive.ObjectID) (subs []form.Submission, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	filter := bson.M{"form_id": formId}
+	sort := bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}
+	opts := options.Find().SetSort(sort)
+	cursor, err := m.submissions.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	subs = make([]form.Submission, 0, 16)
+	err = cursor.All(ctx, &subs)
+	return
+}
+
+func (m mongoRepository) InsertForm(f *form.Form) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	f.Id = primitive.NewObjectID()
+	_, err = m.forms.InsertOne(ctx, f)
+	return
+}
+
+func (m mongoRepository) InsertSubmission(s *form.Submission) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	s.Id = primitive.NewObjectID()
+	_, err = m.submissions.InsertOne(ctx, s)
+	return
+}
+
+func (m mongoRepository) UpdateForm(f *form.Form) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": f.Id}
+	result, err := m.forms.ReplaceOne(ctx, filter, f)
+	if err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("did not match a Form to update")
+	}
+	return
+}
+
+func (m mongoRepository) DeleteDeliveries(webhookId primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"webhook_id": webhookId}
+	_, err = m.deliveries.DeleteMany(ctx, filter)
+	return
+}
+
+func (m mongoRepository) DeleteWebhook(id primitive.ObjectID) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	_, err = m.webhooks.DeleteOne(ctx, filter)
+	return
+}
+
+// Lists the newest deliveries first
+func (m mongoRepository) GetDeliveries(webhookId primitive.ObjectID, limit int64) (deliveries []webhook.Delivery, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	filter := bson.M{"webhook_id": webhookId}
+	sort := bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}
+	opts := options.Find().SetSort(sort).SetLimit(limit)
+	cursor, err := m.deliveries.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	deliveries = make([]webhook.Delivery, 0, 16)
+	err = cursor.All(ctx, &deliveries)
+	return
+}
+
+func (m mongoRepository) GetDeliveryById(id primitive.ObjectID) (d webhook.Delivery, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	err = m.deliveries.FindOne(ctx, filter).Decode(&d)
+	return
+}
+
+func (m mongoRepository) GetWebhookById(id primitive.ObjectID) (w webhook.Webhook, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	err = m.webhooks.FindOne(ctx, filter).Decode(&w)
+	return
+}
+
+func (m mongoRepository) GetWebhooks() (webhooks []webhook.Webhook, err error) {
+	ctx, cancel := m.read(m.background)
+	defer cancel()
+
+	sort := bson.D{{Key: "name", Value: 1}}
+	opts := options.Find().SetSort(sort)
+	cursor, err := m.webhooks.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return
+	}
+	webhooks = make([]webhook.Webhook, 0, 16)
+	err = cursor.All(ctx, &webhooks)
+	return
+}
+
+func (m mongoRepository) InsertDelivery(d *webhook.Delivery) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	d.Id = primitive.NewObjectID()
+	_, err = m.deliveries.InsertOne(ctx, d)
+	return
+}
+
+func (m mongoRepository) InsertWebhook(w *webhook.Webhook) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	w.Id = primitive.NewObjectID()
+	_, err = m.webhooks.InsertOne(ctx, w)
+	return
+}
+
+func (m mongoRepository) UpdateDelivery(d *webhook.Delivery) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": d.Id}
+	result, err := m.deliveries.ReplaceOne(ctx, filter, d)
+	if err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("did not match a Delivery to update")
+	}
+	return
+}
+
+func (m mongoRepository) UpdateWebhook(w *webhook.Webhook) (err error) {
+	ctx, cancel := m.write(m.background)
+	defer cancel()
+
+	filter := bson.M{"_id": w.Id}
+	result, err := m.webhooks.ReplaceOne(ctx, filter, w)
+	if err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("did not match a Webhook to update")
+	}
+	return
+}
+
+func (r mongoRepository) GetUserByEmail(ctx context.Context, email string) (u user.User, err error) {
+	return
+}
+
+func (r mongoRepository) GetUserById(ctx context.Context, id primitive.ObjectID) (u user.User, err error) {
+	return
+}
+
+func (r mongoRepository) InsertUser(ctx context.Context, u *user.User) (err error) {
+	return
+}
+
+func (r mongoRepository) UpdateUser(ctx context.Context, u *user.User) (err error) {
 	return
 }
 
 func (m mongoRepository) empty() (err error) {
-	if err := m.documents.Drop(m.context); err != nil {
+	if err := m.audit.Drop(m.background); err != nil {
+		return err
+	}
+	if err := m.documents.Drop(m.background); err != nil {
+		return err
+	}
+	if err := m.classes.Drop(m.background); err != nil {
+		return err
+	}
+	if err := m.media.Drop(m.background); err != nil {
+		return err
+	}
+	if err := m.forms.Drop(m.background); err != nil {
+		return err
+	}
+	if err := m.locks.Drop(m.background); err != nil {
+		return err
+	}
+	if err := m.submissions.Drop(m.background); err != nil {
+		return err
+	}
+	if err := m.deliveries.Drop(m.background); err != nil {
 		return err
 	}
-	if err := m.classes.Drop(m.context); err != nil {
+	if err := m.webhooks.Drop(m.background); err != nil {
 		return err
 	}
 	return
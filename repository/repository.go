@@ -1,15 +1,26 @@
 package repository
 
 import (
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
 )
 
 type Repository interface {
+	audit.AuditRepository
 	class.ClassRepository
+	class.ClassDocumentRepository
 	document.DocumentRepository
+	form.FormRepository
+	lock.LockRepository
+	media.MediaRepository
 	user.UserRepository
+	webhook.WebhookRepository
 
 	// Only used for testing
 	empty() error
@@ -2,14 +2,23 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
 	"github.com/zeebo/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,7 +26,7 @@ import (
 )
 
 func repositories(t *testing.T) (repos []Repository) {
-	repos = make([]Repository, 0, 2)
+	repos = make([]Repository, 0, 3)
 
 	// Mongo Repository
 	dbHost := "localhost:27017"
@@ -39,22 +48,29 @@ func repositories(t *testing.T) (repos []Repository) {
 	// Clean out db before messing with it
 	assert.NoError(t, mongoDB.Drop(context.Background()))
 
-	repos = append(repos, NewMongo(context.Background(), mongoDB))
+	repos = append(repos, NewMongo(context.Background(), mongoDB, DefaultTimeouts))
 
 	// Memory Repository
 	repos = append(repos, NewMemory())
 
+	// Bolt Repository
+	boltRepo, err := NewBolt(filepath.Join(t.TempDir(), "gocms.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { boltRepo.(*boltRepository).db.Close() })
+	repos = append(repos, boltRepo)
+
 	return
 }
 
 func TestRepository(t *testing.T) {
+	ctx := context.Background()
 	for _, repo := range repositories(t) {
 		t.Run(reflect.TypeOf(repo).Elem().Name(), func(t *testing.T) {
 			t.Run("DeleteClass", func(t *testing.T) {
 				class := class.Class{}
-				assert.NoError(t, repo.InsertClass(&class))
-				assert.NoError(t, repo.DeleteClass(class.Id))
-				_, err := repo.GetClassById(class.Id)
+				assert.NoError(t, repo.InsertClass(ctx, &class))
+				assert.NoError(t, repo.DeleteClass(ctx, class.Id))
+				_, err := repo.GetClassById(ctx, class.Id)
 				// Make sure a "no documents in result" error pops out
 				assert.Error(t, err)
 			})
@@ -67,10 +83,10 @@ func TestRepository(t *testing.T) {
 					{Name: "News", Slug: "news"},
 				}
 				for _, class := range classes {
-					assert.NoError(t, repo.InsertClass(&class))
+					assert.NoError(t, repo.InsertClass(ctx, &class))
 				}
 
-				all, err := repo.GetAllClasses()
+				all, err := repo.GetAllClasses(ctx)
 				assert.NoError(t, err)
 				assert.Equal(t, len(classes), len(all))
 
@@ -84,13 +100,13 @@ func TestRepository(t *testing.T) {
 				class := class.Class{
 					Slug: "get_class_by_id",
 				}
-				assert.NoError(t, repo.InsertClass(&class))
+				assert.NoError(t, repo.InsertClass(ctx, &class))
 
-				check, err := repo.GetClassById(class.Id)
+				check, err := repo.GetClassById(ctx, class.Id)
 				assert.NoError(t, err)
 				assert.Equal(t, class.Slug, check.Slug)
 
-				_, err = repo.GetClassById(primitive.NewObjectID())
+				_, err = repo.GetClassById(ctx, primitive.NewObjectID())
 				assert.Error(t, err)
 			})
 
@@ -98,13 +114,13 @@ func TestRepository(t *testing.T) {
 				class := class.Class{
 					Slug: "get_class_by_slug",
 				}
-				assert.NoError(t, repo.InsertClass(&class))
+				assert.NoError(t, repo.InsertClass(ctx, &class))
 
-				check, err := repo.GetClassBySlug(class.Slug)
+				check, err := repo.GetClassBySlug(ctx, class.Slug)
 				assert.NoError(t, err)
 				assert.Equal(t, class.Slug, check.Slug)
 
-				_, err = repo.GetClassBySlug("invalid_slug")
+				_, err = repo.GetClassBySlug(ctx, "invalid_slug")
 				assert.Error(t, err)
 			})
 
@@ -112,7 +128,7 @@ func TestRepository(t *testing.T) {
 				class := class.Class{
 					Slug: "insert_class",
 				}
-				assert.NoError(t, repo.InsertClass(&class))
+				assert.NoError(t, repo.InsertClass(ctx, &class))
 				assert.False(t, class.Id.IsZero())
 				assert.False(t, class.Created.IsZero())
 				assert.False(t, class.Updated.IsZero())
@@ -122,28 +138,78 @@ func TestRepository(t *testing.T) {
 				class := class.Class{
 					Slug: "update_class",
 				}
-				assert.NoError(t, repo.InsertClass(&class))
+				assert.NoError(t, repo.InsertClass(ctx, &class))
 				updated := class.Updated
 
 				class.Slug += "_update"
-				assert.NoError(t, repo.UpdateClass(&class))
+				assert.NoError(t, repo.UpdateClass(ctx, &class))
 				assert.True(t, updated.Before(class.Updated))
 
-				check, err := repo.GetClassById(class.Id)
+				check, err := repo.GetClassById(ctx, class.Id)
 				assert.NoError(t, err)
 				assert.Equal(t, class.Slug, check.Slug)
 
 				class.Id = primitive.NewObjectID()
 				class.Slug = "update_class_fail"
-				assert.Error(t, repo.UpdateClass(&class))
+				assert.Error(t, repo.UpdateClass(ctx, &class))
+			})
+
+			t.Run("UpdateClassConflict", func(t *testing.T) {
+				first := class.Class{Slug: "update_class_conflict"}
+				assert.NoError(t, repo.InsertClass(ctx, &first))
+				assert.Equal(t, int64(1), first.Version)
+				second := first
+
+				first.Name = "First"
+				assert.NoError(t, repo.UpdateClass(ctx, &first))
+				assert.Equal(t, int64(2), first.Version)
+
+				second.Name = "Second"
+				err := repo.UpdateClass(ctx, &second)
+				assert.True(t, errors.Is(err, class.ErrConflict))
+				assert.Equal(t, int64(1), second.Version)
+
+				check, err := repo.GetClassById(ctx, first.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, "First", check.Name)
+				assert.Equal(t, int64(2), check.Version)
+			})
+
+			t.Run("GetTrashedClasses", func(t *testing.T) {
+				trashed := class.Class{
+					Name:    "Trashed",
+					Slug:    "trashed_class",
+					Deleted: time.Now().Add(-time.Hour),
+				}
+				assert.NoError(t, repo.InsertClass(ctx, &trashed))
+
+				// Trashed classes disappear from lists and slug lookups
+				_, err := repo.GetClassBySlug(ctx, trashed.Slug)
+				assert.Error(t, err)
+				all, err := repo.GetAllClasses(ctx)
+				assert.NoError(t, err)
+				for _, c := range all {
+					assert.True(t, trashed.Id != c.Id)
+				}
+				_, err = repo.GetClassById(ctx, trashed.Id)
+				assert.NoError(t, err)
+
+				list, err := repo.GetTrashedClasses(ctx, time.Now())
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(list))
+				assert.Equal(t, trashed.Id, list[0].Id)
+
+				list, err = repo.GetTrashedClasses(ctx, time.Now().Add(-2*time.Hour))
+				assert.NoError(t, err)
+				assert.Equal(t, 0, len(list))
 			})
 
 			t.Run("DeleteDocument", func(t *testing.T) {
 				doc := document.Document{}
-				assert.NoError(t, repo.InsertDocument(&doc))
-				assert.NoError(t, repo.DeleteDocument(doc.Id))
+				assert.NoError(t, repo.InsertDocument(ctx, &doc))
+				assert.NoError(t, repo.DeleteDocument(ctx, doc.Id))
 
-				_, err := repo.GetDocumentById(doc.Id)
+				_, err := repo.GetDocumentById(ctx, doc.Id)
 				assert.Error(t, err)
 			})
 
@@ -153,14 +219,14 @@ func TestRepository(t *testing.T) {
 					ParentId: primitive.NewObjectID(),
 					Slug:     "get_child_document_slug",
 				}
-				assert.NoError(t, repo.InsertDocument(&doc))
+				assert.NoError(t, repo.InsertDocument(ctx, &doc))
 
-				check, err := repo.GetChildDocumentBySlug(doc.ParentId, doc.Slug)
+				check, err := repo.GetChildDocumentBySlug(ctx, doc.ParentId, doc.Slug)
 				assert.NoError(t, err)
 				assert.False(t, doc.Id.IsZero())
 				assert.Equal(t, doc.Id, check.Id)
 
-				_, err = repo.GetChildDocumentBySlug(doc.ParentId, "invalid_slug")
+				_, err = repo.GetChildDocumentBySlug(ctx, doc.ParentId, "invalid_slug")
 				assert.Error(t, err)
 			})
 
@@ -170,14 +236,14 @@ func TestRepository(t *testing.T) {
 					ParentId: primitive.NewObjectID(),
 					Slug:     "get_class_document_slug",
 				}
-				assert.NoError(t, repo.InsertDocument(&doc))
+				assert.NoError(t, repo.InsertDocument(ctx, &doc))
 
-				check, err := repo.GetClassDocumentBySlug(doc.ClassId, doc.Slug)
+				check, err := repo.GetClassDocumentBySlug(ctx, doc.ClassId, doc.Slug)
 				assert.NoError(t, err)
 				assert.False(t, doc.Id.IsZero())
 				assert.Equal(t, doc.Id, check.Id)
 
-				_, err = repo.GetClassDocumentBySlug(doc.ClassId, "invalid_slug")
+				_, err = repo.GetClassDocumentBySlug(ctx, doc.ClassId, "invalid_slug")
 				assert.Error(t, err)
 			})
 
@@ -190,7 +256,7 @@ func TestRepository(t *testing.T) {
 						ClassId: classId,
 						Slug:    fmt.Sprintf("test_%d", i),
 					}
-					assert.NoError(t, repo.InsertDocument(&doc))
+					assert.NoError(t, repo.InsertDocument(ctx, &doc))
 					ids[i] = doc.Id
 				}
 
@@ -199,7 +265,7 @@ func TestRepository(t *testing.T) {
 					Size:    2,
 					Page:    1,
 				}
-				page1, err := repo.GetDocumentList(params)
+				page1, err := repo.GetDocumentList(ctx, params)
 				assert.NoError(t, err)
 				assert.Equal(t, 3, page1.Total)
 				assert.Equal(t, 2, len(page1.Documents))
@@ -208,7 +274,7 @@ func TestRepository(t *testing.T) {
 				}
 
 				params.Page = 2
-				page2, err := repo.GetDocumentList(params)
+				page2, err := repo.GetDocumentList(ctx, params)
 				assert.NoError(t, err)
 				assert.Equal(t, 3, page2.Total)
 				assert.Equal(t, 1, len(page2.Documents))
@@ -216,27 +282,228 @@ func TestRepository(t *testing.T) {
 					assert.Equal(t, ids[i], page1.Documents[i].Id)
 				}
 
+				// Nonsense sizes and pages list nothing rather than panic
+				for _, size := range []int64{0, -5} {
+					params.Size = size
+					empty, err := repo.GetDocumentList(ctx, params)
+					assert.NoError(t, err)
+					assert.Equal(t, 3, empty.Total)
+					assert.Equal(t, 0, len(empty.Documents))
+				}
+				params.Page, params.Size = -1, 2
+				page0, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(page0.Documents))
+
 				params.ClassId = primitive.NewObjectID()
-				noResults, err := repo.GetDocumentList(params)
+				noResults, err := repo.GetDocumentList(ctx, params)
 				assert.NoError(t, err)
 				assert.Equal(t, 0, noResults.Total)
 			})
 
+			t.Run("GetDocumentListFilters", func(t *testing.T) {
+				assert.NoError(t, repo.EnsureGeoIndex(ctx, "location"))
+
+				classId := primitive.NewObjectID()
+				now := time.Now()
+				docs := []document.Document{
+					{Slug: "white_house", Title: "The White House", Published: now.Add(-time.Hour), Values: map[string]interface{}{"location": field.NewGeoPoint(38.8977, -77.0365)}},
+					{Slug: "capitol", Title: "U.S. Capitol", Published: now.Add(-time.Hour), Values: map[string]interface{}{"location": field.NewGeoPoint(38.8899, -77.0091)}},
+					{Slug: "future", Published: now.Add(time.Hour), Values: map[string]interface{}{"location": field.NewGeoPoint(38.8977, -77.0365)}},
+					{Slug: "nowhere", Published: now.Add(-time.Hour)},
+					{Slug: "draft", Values: map[string]interface{}{"location": field.NewGeoPoint(38.8977, -77.0365)}},
+				}
+				for i := range docs {
+					docs[i].ClassId = classId
+					assert.NoError(t, repo.InsertDocument(ctx, &docs[i]))
+				}
+
+				params := document.DocumentListParams{
+					ClassId:         classId,
+					Size:            10,
+					Page:            1,
+					PublishedBefore: now,
+				}
+				published, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 3, published.Total)
+
+				params.Near = document.NearParams{
+					Field:  "location",
+					Point:  field.NewGeoPoint(38.8976, -77.0366),
+					Radius: 1000,
+				}
+				near, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, near.Total)
+				assert.Equal(t, docs[0].Id, near.Documents[0].Id)
+
+				params.Near.Radius = 5000
+				wider, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, wider.Total)
+
+				params.Title = "white HOUSE"
+				titled, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, titled.Total)
+				assert.Equal(t, docs[0].Id, titled.Documents[0].Id)
+
+				// Titles are matched literally, not as patterns
+				params.Title = "u.s."
+				literal, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, literal.Total)
+				params.Title = "u.s.."
+				literal, err = repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, literal.Total)
+			})
+
+			t.Run("DocumentActivity", func(t *testing.T) {
+				assert.NoError(t, repo.empty())
+
+				pages := primitive.NewObjectID()
+				posts := primitive.NewObjectID()
+				userId := primitive.NewObjectID()
+				now := time.Now()
+				docs := []document.Document{
+					{ClassId: pages, Slug: "live", Published: now.Add(-time.Hour)},
+					{ClassId: pages, Slug: "soon", Published: now.Add(time.Hour)},
+					{ClassId: pages, Slug: "later", Published: now.Add(2 * time.Hour)},
+					{ClassId: posts, Slug: "mine", CreatedBy: userId},
+					{ClassId: posts, Slug: "theirs", CreatedBy: primitive.NewObjectID()},
+					{ClassId: posts, Slug: "trashed", CreatedBy: userId, Deleted: now},
+				}
+				for i := range docs {
+					assert.NoError(t, repo.InsertDocument(ctx, &docs[i]))
+					time.Sleep(time.Millisecond)
+				}
+				// Editing moves a document to the top of the recent list
+				assert.NoError(t, repo.UpdateDocument(ctx, &docs[0]))
+
+				counts, err := repo.CountDocumentsByClass(ctx, now)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(counts))
+				byClass := make(map[primitive.ObjectID]document.ClassCount)
+				for _, count := range counts {
+					byClass[count.ClassId] = count
+				}
+				assert.Equal(t, int64(3), byClass[pages].Total)
+				assert.Equal(t, int64(1), byClass[pages].Published())
+				assert.Equal(t, int64(2), byClass[pages].Scheduled)
+				assert.Equal(t, int64(2), byClass[posts].Total)
+				assert.Equal(t, int64(2), byClass[posts].Drafts)
+
+				recent, err := repo.GetRecentDocuments(ctx, 3)
+				assert.NoError(t, err)
+				assert.Equal(t, 3, len(recent))
+				assert.Equal(t, "live", recent[0].Slug)
+				assert.Equal(t, "theirs", recent[1].Slug)
+				assert.Equal(t, "mine", recent[2].Slug)
+
+				scheduled, err := repo.GetScheduledDocuments(ctx, now, 10)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(scheduled))
+				assert.Equal(t, "soon", scheduled[0].Slug)
+				assert.Equal(t, "later", scheduled[1].Slug)
+
+				drafts, err := repo.GetDraftDocuments(ctx, userId, 10)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(drafts))
+				assert.Equal(t, "mine", drafts[0].Slug)
+
+				// Later tests expect the trash to start out empty
+				assert.NoError(t, repo.DeleteDocument(ctx, docs[5].Id))
+			})
+
 			t.Run("GetDocumentById", func(t *testing.T) {
 				doc := document.Document{}
-				assert.NoError(t, repo.InsertDocument(&doc))
+				assert.NoError(t, repo.InsertDocument(ctx, &doc))
 
-				check, err := repo.GetDocumentById(doc.Id)
+				check, err := repo.GetDocumentById(ctx, doc.Id)
 				assert.NoError(t, err)
 				assert.False(t, doc.Id.IsZero())
 				assert.Equal(t, doc.Id, check.Id)
 			})
 
+			t.Run("GetDocumentsByIds", func(t *testing.T) {
+				ids := make([]primitive.ObjectID, 3)
+				for i := range ids {
+					doc := document.Document{Slug: fmt.Sprintf("by_ids_%d", i)}
+					assert.NoError(t, repo.InsertDocument(ctx, &doc))
+					ids[i] = doc.Id
+				}
+
+				// Order follows the requested IDs and missing IDs are skipped
+				request := []primitive.ObjectID{ids[2], primitive.NewObjectID(), ids[0]}
+				docs, err := repo.GetDocumentsByIds(ctx, request)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(docs))
+				assert.Equal(t, ids[2], docs[0].Id)
+				assert.Equal(t, ids[0], docs[1].Id)
+			})
+
+			t.Run("GetReferencingDocuments", func(t *testing.T) {
+				target := document.Document{Slug: "referenced"}
+				assert.NoError(t, repo.InsertDocument(ctx, &target))
+
+				referrer := document.Document{
+					Slug:       "referrer",
+					References: []primitive.ObjectID{target.Id},
+				}
+				assert.NoError(t, repo.InsertDocument(ctx, &referrer))
+
+				docs, err := repo.GetReferencingDocuments(ctx, target.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(docs))
+				assert.Equal(t, referrer.Id, docs[0].Id)
+
+				docs, err = repo.GetReferencingDocuments(ctx, referrer.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, len(docs))
+			})
+
+			t.Run("GetTrashedDocuments", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				trashed := document.Document{
+					ClassId:   classId,
+					ParentId:  primitive.NewObjectID(),
+					Slug:      "trashed_document",
+					Deleted:   time.Now().Add(-time.Hour),
+					DeletedBy: primitive.NewObjectID(),
+				}
+				assert.NoError(t, repo.InsertDocument(ctx, &trashed))
+				kept := document.Document{ClassId: classId, Slug: "kept_document"}
+				assert.NoError(t, repo.InsertDocument(ctx, &kept))
+
+				// Trashed documents disappear from lists and slug lookups
+				_, err := repo.GetClassDocumentBySlug(ctx, classId, trashed.Slug)
+				assert.Error(t, err)
+				_, err = repo.GetChildDocumentBySlug(ctx, trashed.ParentId, trashed.Slug)
+				assert.Error(t, err)
+				params := document.DocumentListParams{ClassId: classId, Page: 1, Size: 10}
+				list, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, list.Total)
+				assert.Equal(t, kept.Id, list.Documents[0].Id)
+
+				docs, err := repo.GetTrashedDocuments(ctx, time.Now())
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(docs))
+				assert.Equal(t, trashed.Id, docs[0].Id)
+				assert.Equal(t, trashed.DeletedBy, docs[0].DeletedBy)
+
+				docs, err = repo.GetTrashedDocuments(ctx, time.Now().Add(-2*time.Hour))
+				assert.NoError(t, err)
+				assert.Equal(t, 0, len(docs))
+			})
+
 			t.Run("InsertDocument", func(t *testing.T) {
 				doc := document.Document{
 					Slug: "create_document",
 				}
-				assert.NoError(t, repo.InsertDocument(&doc))
+				assert.NoError(t, repo.InsertDocument(ctx, &doc))
 				assert.False(t, doc.Id.IsZero())
 				assert.False(t, doc.Created.IsZero())
 				assert.False(t, doc.Updated.IsZero())
@@ -247,47 +514,619 @@ func TestRepository(t *testing.T) {
 					ClassId: primitive.NewObjectID(),
 					Slug:    "update_document",
 				}
-				assert.NoError(t, repo.InsertDocument(&doc))
+				assert.NoError(t, repo.InsertDocument(ctx, &doc))
 
 				updated := doc.Updated
 				doc.Slug += "_update"
-				assert.NoError(t, repo.UpdateDocument(&doc))
+				assert.NoError(t, repo.UpdateDocument(ctx, &doc))
 				assert.True(t, updated.Before(doc.Updated))
 
-				check, err := repo.GetDocumentById(doc.Id)
+				check, err := repo.GetDocumentById(ctx, doc.Id)
 				assert.NoError(t, err)
 				assert.Equal(t, doc.Slug, check.Slug)
 			})
 
+			t.Run("UpdateDocumentConflict", func(t *testing.T) {
+				first := document.Document{ClassId: primitive.NewObjectID(), Slug: "update_document_conflict"}
+				assert.NoError(t, repo.InsertDocument(ctx, &first))
+				assert.Equal(t, int64(1), first.Version)
+				second := first
+
+				first.Title = "First"
+				assert.NoError(t, repo.UpdateDocument(ctx, &first))
+				assert.Equal(t, int64(2), first.Version)
+
+				second.Title = "Second"
+				err := repo.UpdateDocument(ctx, &second)
+				assert.True(t, errors.Is(err, document.ErrConflict))
+				assert.Equal(t, int64(1), second.Version)
+
+				// Migrations move documents on a version too
+				_, err = repo.DropFieldValues(ctx, first.ClassId, "missing")
+				assert.NoError(t, err)
+				first.Values = map[string]interface{}{"body": "text"}
+				assert.NoError(t, repo.UpdateDocument(ctx, &first))
+				count, err := repo.DropFieldValues(ctx, first.ClassId, "body")
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), count)
+				assert.True(t, errors.Is(repo.UpdateDocument(ctx, &first), document.ErrConflict))
+
+				check, err := repo.GetDocumentById(ctx, first.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, "First", check.Title)
+				assert.Equal(t, int64(4), check.Version)
+			})
+
+			t.Run("ArchiveClassDocuments", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				for i := 0; i < 3; i++ {
+					doc := document.Document{ClassId: classId, Slug: fmt.Sprintf("archive_%d", i)}
+					assert.NoError(t, repo.InsertDocument(ctx, &doc))
+				}
+
+				count, err := repo.ArchiveClassDocuments(ctx, classId)
+				assert.NoError(t, err)
+				assert.Equal(t, 3, count)
+
+				params := document.DocumentListParams{ClassId: classId, Page: 1, Size: 10}
+				list, err := repo.GetDocumentList(ctx, params)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, list.Total)
+
+				count, err = repo.CountClassDocuments(ctx, classId)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, count)
+			})
+
+			t.Run("CountClassDocuments", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				for i := 0; i < 4; i++ {
+					doc := document.Document{ClassId: classId, Slug: fmt.Sprintf("count_%d", i)}
+					assert.NoError(t, repo.InsertDocument(ctx, &doc))
+				}
+
+				count, err := repo.CountClassDocuments(ctx, classId)
+				assert.NoError(t, err)
+				assert.Equal(t, 4, count)
+
+				count, err = repo.CountClassDocuments(ctx, primitive.NewObjectID())
+				assert.NoError(t, err)
+				assert.Equal(t, 0, count)
+			})
+
+			t.Run("CountClassReferences", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				target := document.Document{ClassId: classId, Slug: "count_references"}
+				assert.NoError(t, repo.InsertDocument(ctx, &target))
+
+				// References from inside the class do not count
+				sibling := document.Document{
+					ClassId:    classId,
+					Slug:       "count_references_sibling",
+					References: []primitive.ObjectID{target.Id},
+				}
+				assert.NoError(t, repo.InsertDocument(ctx, &sibling))
+
+				for i := 0; i < 2; i++ {
+					referrer := document.Document{
+						ClassId:    primitive.NewObjectID(),
+						Slug:       "count_references_referrer",
+						References: []primitive.ObjectID{target.Id},
+					}
+					assert.NoError(t, repo.InsertDocument(ctx, &referrer))
+				}
+
+				count, err := repo.CountClassReferences(ctx, classId)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, count)
+
+				count, err = repo.CountClassReferences(ctx, primitive.NewObjectID())
+				assert.NoError(t, err)
+				assert.Equal(t, 0, count)
+			})
+
+			t.Run("DeleteClassDocuments", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				ids := make([]primitive.ObjectID, 2)
+				for i := range ids {
+					doc := document.Document{ClassId: classId, Slug: fmt.Sprintf("delete_class_%d", i)}
+					assert.NoError(t, repo.InsertDocument(ctx, &doc))
+					ids[i] = doc.Id
+				}
+				other := document.Document{ClassId: primitive.NewObjectID(), Slug: "delete_class_other"}
+				assert.NoError(t, repo.InsertDocument(ctx, &other))
+
+				count, err := repo.DeleteClassDocuments(ctx, classId)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, count)
+
+				for _, id := range ids {
+					_, err := repo.GetDocumentById(ctx, id)
+					assert.Error(t, err)
+				}
+				_, err = repo.GetDocumentById(ctx, other.Id)
+				assert.NoError(t, err)
+			})
+
+			// Inserts documents holding the given values and returns their IDs
+			insertValues := func(t *testing.T, classId primitive.ObjectID, prefix string, values ...map[string]interface{}) (ids []primitive.ObjectID) {
+				for i, v := range values {
+					doc := document.Document{
+						ClassId: classId,
+						Slug:    fmt.Sprintf("%s_%d", prefix, i),
+						Values:  v,
+					}
+					assert.NoError(t, repo.InsertDocument(ctx, &doc))
+					ids = append(ids, doc.Id)
+				}
+				return
+			}
+
+			t.Run("CountFieldValues", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				insertValues(t, classId, "count_values",
+					map[string]interface{}{"a": "1"},
+					map[string]interface{}{"a": "2", "b": "3"},
+					map[string]interface{}{"b": "4"},
+				)
+				insertValues(t, primitive.NewObjectID(), "count_values_other", map[string]interface{}{"a": "5"})
+
+				count, err := repo.CountFieldValues(ctx, classId, "a")
+				assert.NoError(t, err)
+				assert.Equal(t, 2, count)
+
+				count, err = repo.CountFieldValues(ctx, classId, "c")
+				assert.NoError(t, err)
+				assert.Equal(t, 0, count)
+			})
+
+			t.Run("ConvertFieldValues", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				ids := insertValues(t, classId, "convert_values",
+					map[string]interface{}{"a": "1"},
+					map[string]interface{}{"a": "bad"},
+					map[string]interface{}{"b": "2"},
+				)
+				convert := func(value interface{}) (interface{}, error) {
+					if value == "bad" {
+						return nil, fmt.Errorf("cannot convert")
+					}
+					return fmt.Sprintf("%s!", value), nil
+				}
+
+				count, err := repo.CountUnconvertibleValues(ctx, classId, "a", convert)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, count)
+
+				count, err = repo.ConvertFieldValues(ctx, classId, "a", convert, false)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, count)
+
+				doc, err := repo.GetDocumentById(ctx, ids[0])
+				assert.NoError(t, err)
+				assert.Equal(t, "1!", doc.Values["a"])
+
+				// Values which fail to convert are kept unless asked otherwise
+				doc, err = repo.GetDocumentById(ctx, ids[1])
+				assert.NoError(t, err)
+				assert.Equal(t, "bad", doc.Values["a"])
+				assert.Equal(t, int64(1), doc.Version)
+
+				count, err = repo.ConvertFieldValues(ctx, classId, "a", convert, true)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, count)
+
+				doc, err = repo.GetDocumentById(ctx, ids[0])
+				assert.NoError(t, err)
+				assert.Equal(t, "1!!", doc.Values["a"])
+
+				doc, err = repo.GetDocumentById(ctx, ids[1])
+				assert.NoError(t, err)
+				_, ok := doc.Values["a"]
+				assert.False(t, ok)
+
+				doc, err = repo.GetDocumentById(ctx, ids[2])
+				assert.NoError(t, err)
+				assert.Equal(t, "2", doc.Values["b"])
+			})
+
+			t.Run("DropFieldValues", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				ids := insertValues(t, classId, "drop_values",
+					map[string]interface{}{"a": "1", "b": "2"},
+					map[string]interface{}{"b": "3"},
+				)
+				otherIds := insertValues(t, primitive.NewObjectID(), "drop_values_other", map[string]interface{}{"a": "4"})
+
+				count, err := repo.DropFieldValues(ctx, classId, "a")
+				assert.NoError(t, err)
+				assert.Equal(t, 1, count)
+
+				doc, err := repo.GetDocumentById(ctx, ids[0])
+				assert.NoError(t, err)
+				_, ok := doc.Values["a"]
+				assert.False(t, ok)
+				assert.Equal(t, "2", doc.Values["b"])
+
+				doc, err = repo.GetDocumentById(ctx, otherIds[0])
+				assert.NoError(t, err)
+				assert.Equal(t, "4", doc.Values["a"])
+			})
+
+			t.Run("RenameFieldValues", func(t *testing.T) {
+				classId := primitive.NewObjectID()
+				ids := insertValues(t, classId, "rename_values",
+					map[string]interface{}{"a": "1"},
+					map[string]interface{}{"b": "2"},
+				)
+
+				count, err := repo.RenameFieldValues(ctx, classId, "a", "c")
+				assert.NoError(t, err)
+				assert.Equal(t, 1, count)
+
+				doc, err := repo.GetDocumentById(ctx, ids[0])
+				assert.NoError(t, err)
+				_, ok := doc.Values["a"]
+				assert.False(t, ok)
+				assert.Equal(t, "1", doc.Values["c"])
+
+				doc, err = repo.GetDocumentById(ctx, ids[1])
+				assert.NoError(t, err)
+				assert.Equal(t, "2", doc.Values["b"])
+			})
+
+			t.Run("MigrateClass", func(t *testing.T) {
+				c := class.Class{Name: "Migrate", Slug: "migrate"}
+				assert.NoError(t, repo.InsertClass(ctx, &c))
+				inheritorId := primitive.NewObjectID()
+				ids := insertValues(t, c.Id, "migrate_values", map[string]interface{}{"a": "1", "b": "2"})
+				ids = append(ids, insertValues(t, inheritorId, "migrate_inherited", map[string]interface{}{"a": "3"})...)
+				otherIds := insertValues(t, primitive.NewObjectID(), "migrate_other", map[string]interface{}{"a": "4"})
+				migrations := []class.Migration{
+					{Action: class.MigrateRename, Field: "a", To: "c", Confirmed: true},
+					{Action: class.MigrateDrop, Field: "b"},
+				}
+
+				// A stale class changes nothing at all
+				stale := c
+				stale.Version--
+				assert.Error(t, repo.MigrateClass(ctx, &stale, []primitive.ObjectID{c.Id, inheritorId}, migrations))
+				doc, err := repo.GetDocumentById(ctx, ids[0])
+				assert.NoError(t, err)
+				assert.Equal(t, "1", doc.Values["a"])
+
+				c.TableFields = "c"
+				assert.NoError(t, repo.MigrateClass(ctx, &c, []primitive.ObjectID{c.Id, inheritorId}, migrations))
+				assert.Equal(t, int64(2), c.Version)
+				check, err := repo.GetClassById(ctx, c.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, "c", check.TableFields)
+
+				doc, err = repo.GetDocumentById(ctx, ids[0])
+				assert.NoError(t, err)
+				assert.DeepEqual(t, map[string]interface{}{"c": "1"}, doc.Values)
+				assert.Equal(t, int64(2), doc.Version)
+				doc, err = repo.GetDocumentById(ctx, ids[1])
+				assert.NoError(t, err)
+				assert.DeepEqual(t, map[string]interface{}{"c": "3"}, doc.Values)
+				doc, err = repo.GetDocumentById(ctx, otherIds[0])
+				assert.NoError(t, err)
+				assert.DeepEqual(t, map[string]interface{}{"a": "4"}, doc.Values)
+			})
+
+			t.Run("InsertMedia", func(t *testing.T) {
+				m := media.Media{Filename: "insert.png", MimeType: "image/png", Width: 4, Height: 3}
+				assert.NoError(t, repo.InsertMedia(&m))
+				assert.False(t, m.Id.IsZero())
+
+				// IDs assigned before insert are kept since blobs are stored
+				// under them
+				preset := media.Media{Id: primitive.NewObjectID(), Filename: "preset.txt"}
+				assert.NoError(t, repo.InsertMedia(&preset))
+				check, err := repo.GetMediaById(preset.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, "preset.txt", check.Filename)
+			})
+
+			t.Run("GetMediaById", func(t *testing.T) {
+				m := media.Media{Filename: "get.png", MimeType: "image/png", Width: 4, Height: 3}
+				assert.NoError(t, repo.InsertMedia(&m))
+
+				check, err := repo.GetMediaById(m.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, m.Filename, check.Filename)
+				assert.Equal(t, 4, check.Width)
+
+				_, err = repo.GetMediaById(primitive.NewObjectID())
+				assert.Error(t, err)
+			})
+
+			t.Run("GetMediaList", func(t *testing.T) {
+				assert.NoError(t, repo.empty())
+
+				ids := make([]primitive.ObjectID, 3)
+				for i := range ids {
+					m := media.Media{Id: primitive.NewObjectID(), Filename: fmt.Sprintf("list_%d.txt", i)}
+					assert.NoError(t, repo.InsertMedia(&m))
+					ids[i] = m.Id
+				}
+
+				page1, err := repo.GetMediaList(media.MediaListParams{Page: 1, Size: 2})
+				assert.NoError(t, err)
+				assert.Equal(t, 3, page1.Total)
+				assert.Equal(t, 2, len(page1.Media))
+				assert.Equal(t, ids[2], page1.Media[0].Id)
+				assert.Equal(t, ids[1], page1.Media[1].Id)
+
+				page2, err := repo.GetMediaList(media.MediaListParams{Page: 2, Size: 2})
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(page2.Media))
+				assert.Equal(t, ids[0], page2.Media[0].Id)
+
+				page3, err := repo.GetMediaList(media.MediaListParams{Page: 3, Size: 2})
+				assert.NoError(t, err)
+				assert.Equal(t, 0, len(page3.Media))
+			})
+
+			t.Run("AddMediaDerivative", func(t *testing.T) {
+				m := media.Media{Filename: "derivative.png"}
+				assert.NoError(t, repo.InsertMedia(&m))
+				assert.NoError(t, repo.AddMediaDerivative(m.Id, "derivatives/a"))
+				assert.NoError(t, repo.AddMediaDerivative(m.Id, "derivatives/b"))
+				assert.NoError(t, repo.AddMediaDerivative(m.Id, "derivatives/a"))
+
+				check, err := repo.GetMediaById(m.Id)
+				assert.NoError(t, err)
+				assert.DeepEqual(t, []string{"derivatives/a", "derivatives/b"}, check.Derivatives)
+
+				assert.Error(t, repo.AddMediaDerivative(primitive.NewObjectID(), "derivatives/c"))
+			})
+
+			t.Run("DeleteMedia", func(t *testing.T) {
+				m := media.Media{Filename: "delete.txt"}
+				assert.NoError(t, repo.InsertMedia(&m))
+				assert.NoError(t, repo.DeleteMedia(m.Id))
+				_, err := repo.GetMediaById(m.Id)
+				assert.Error(t, err)
+			})
+
+			t.Run("GetForms", func(t *testing.T) {
+				assert.NoError(t, repo.empty())
+				for _, name := range []string{"Signup", "Contact"} {
+					f := form.Form{Name: name}
+					assert.NoError(t, repo.InsertForm(&f))
+					assert.False(t, f.Id.IsZero())
+				}
+
+				forms, err := repo.GetForms()
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(forms))
+				assert.Equal(t, "Contact", forms[0].Name)
+				assert.Equal(t, "Signup", forms[1].Name)
+			})
+
+			t.Run("UpdateForm", func(t *testing.T) {
+				f := form.Form{Name: "Update"}
+				assert.NoError(t, repo.InsertForm(&f))
+
+				f.Name = "Updated"
+				assert.NoError(t, repo.UpdateForm(&f))
+				check, err := repo.GetFormById(f.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, "Updated", check.Name)
+
+				f.Id = primitive.NewObjectID()
+				assert.Error(t, repo.UpdateForm(&f))
+				_, err = repo.GetFormById(f.Id)
+				assert.Error(t, err)
+			})
+
+			t.Run("Submissions", func(t *testing.T) {
+				contact := form.Form{Name: "Contact"}
+				assert.NoError(t, repo.InsertForm(&contact))
+				other := form.Form{Name: "Other"}
+				assert.NoError(t, repo.InsertForm(&other))
+
+				now := time.Now()
+				for i := 0; i < 3; i++ {
+					s := form.Submission{
+						FormId:  contact.Id,
+						Values:  map[string]interface{}{"n": fmt.Sprint(i)},
+						Created: now.Add(time.Duration(i) * time.Second),
+					}
+					assert.NoError(t, repo.InsertSubmission(&s))
+					assert.False(t, s.Id.IsZero())
+				}
+				s := form.Submission{FormId: other.Id, Created: now}
+				assert.NoError(t, repo.InsertSubmission(&s))
+
+				subs, err := repo.GetSubmissions(contact.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, 3, len(subs))
+				assert.Equal(t, "2", subs[0].Values["n"])
+				assert.Equal(t, "0", subs[2].Values["n"])
+
+				assert.NoError(t, repo.DeleteSubmissions(contact.Id))
+				assert.NoError(t, repo.DeleteForm(contact.Id))
+				subs, err = repo.GetSubmissions(contact.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, len(subs))
+				_, err = repo.GetFormById(contact.Id)
+				assert.Error(t, err)
+
+				subs, err = repo.GetSubmissions(other.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(subs))
+			})
+
+			t.Run("AuditEntries", func(t *testing.T) {
+				actor, classId := primitive.NewObjectID(), primitive.NewObjectID()
+				start := time.Now().Truncate(time.Second)
+				entries := []audit.Entry{
+					{ActorId: actor, ClassId: classId, Time: start},
+					{ActorId: actor, Time: start.Add(time.Minute)},
+					{ClassId: classId, Time: start.Add(2 * time.Minute)},
+				}
+				for i := range entries {
+					entries[i].Action = audit.ActionUpdate
+					entries[i].TargetType = audit.TargetDocument
+					entries[i].TargetId = primitive.NewObjectID()
+					assert.NoError(t, repo.InsertAuditEntry(&entries[i]))
+					assert.False(t, entries[i].Id.IsZero())
+				}
+
+				all, err := repo.GetAuditEntries(audit.Filter{})
+				assert.NoError(t, err)
+				assert.Equal(t, 3, len(all))
+				assert.Equal(t, entries[2].Id, all[0].Id)
+				assert.Equal(t, entries[0].Id, all[2].Id)
+
+				byActor, err := repo.GetAuditEntries(audit.Filter{ActorId: actor})
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(byActor))
+
+				byClass, err := repo.GetAuditEntries(audit.Filter{ClassId: classId, Limit: 1})
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(byClass))
+				assert.Equal(t, entries[2].Id, byClass[0].Id)
+
+				byTime, err := repo.GetAuditEntries(audit.Filter{From: start.Add(time.Minute), To: start.Add(2 * time.Minute)})
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(byTime))
+				assert.Equal(t, entries[1].Id, byTime[0].Id)
+			})
+
+			t.Run("Webhooks", func(t *testing.T) {
+				search := webhook.Webhook{Name: "Search", URL: "http://search.local/hook", Events: []string{document.EventUpdated}}
+				assert.NoError(t, repo.InsertWebhook(&search))
+				assert.False(t, search.Id.IsZero())
+				builder := webhook.Webhook{Name: "Builder", URL: "http://builder.local/hook"}
+				assert.NoError(t, repo.InsertWebhook(&builder))
+
+				webhooks, err := repo.GetWebhooks()
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(webhooks))
+				assert.Equal(t, "Builder", webhooks[0].Name)
+
+				search.Active = true
+				assert.NoError(t, repo.UpdateWebhook(&search))
+				check, err := repo.GetWebhookById(search.Id)
+				assert.NoError(t, err)
+				assert.True(t, check.Active)
+				assert.DeepEqual(t, []string{document.EventUpdated}, check.Events)
+				assert.Error(t, repo.UpdateWebhook(&webhook.Webhook{Id: primitive.NewObjectID()}))
+
+				assert.NoError(t, repo.DeleteWebhook(builder.Id))
+				_, err = repo.GetWebhookById(builder.Id)
+				assert.Error(t, err)
+				assert.NoError(t, repo.DeleteWebhook(search.Id))
+			})
+
+			t.Run("Deliveries", func(t *testing.T) {
+				hookId, otherId := primitive.NewObjectID(), primitive.NewObjectID()
+				now := time.Now().Truncate(time.Millisecond)
+				for i := 0; i < 3; i++ {
+					d := webhook.Delivery{
+						WebhookId: hookId,
+						Event:     fmt.Sprintf("event%d", i),
+						Created:   now.Add(time.Duration(i) * time.Second),
+					}
+					assert.NoError(t, repo.InsertDelivery(&d))
+					assert.False(t, d.Id.IsZero())
+				}
+				other := webhook.Delivery{WebhookId: otherId, Created: now}
+				assert.NoError(t, repo.InsertDelivery(&other))
+
+				deliveries, err := repo.GetDeliveries(hookId, 2)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, len(deliveries))
+				assert.Equal(t, "event2", deliveries[0].Event)
+				assert.Equal(t, "event1", deliveries[1].Event)
+
+				d := deliveries[0]
+				d.Attempts, d.StatusCode, d.Delivered = 1, 204, now
+				assert.NoError(t, repo.UpdateDelivery(&d))
+				check, err := repo.GetDeliveryById(d.Id)
+				assert.NoError(t, err)
+				assert.Equal(t, 204, check.StatusCode)
+				assert.True(t, check.Succeeded())
+
+				assert.NoError(t, repo.DeleteDeliveries(hookId))
+				deliveries, err = repo.GetDeliveries(hookId, 10)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, len(deliveries))
+				deliveries, err = repo.GetDeliveries(otherId, 10)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(deliveries))
+			})
+
+			t.Run("Locks", func(t *testing.T) {
+				docId := primitive.NewObjectID()
+				alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+				now := time.Now().Truncate(time.Millisecond)
+
+				_, err := repo.GetLock(docId)
+				assert.Error(t, err)
+
+				held, err := repo.AcquireLock(lock.Lock{Id: docId, UserId: alice, Acquired: now, Expires: now.Add(time.Minute)}, now)
+				assert.NoError(t, err)
+				assert.Equal(t, alice, held.UserId)
+
+				// A heartbeat pushes the expiry out but keeps the acquired time
+				later := now.Add(30 * time.Second)
+				held, err = repo.AcquireLock(lock.Lock{Id: docId, UserId: alice, Acquired: later, Expires: later.Add(time.Minute)}, later)
+				assert.NoError(t, err)
+				assert.True(t, held.Acquired.Equal(now))
+				assert.True(t, held.Expires.Equal(later.Add(time.Minute)))
+
+				held, err = repo.AcquireLock(lock.Lock{Id: docId, UserId: bob, Acquired: later, Expires: later.Add(time.Minute)}, later)
+				assert.True(t, errors.Is(err, lock.ErrLocked))
+				assert.Equal(t, alice, held.UserId)
+
+				// Once expired anyone can take the lock over
+				expired := later.Add(2 * time.Minute)
+				held, err = repo.AcquireLock(lock.Lock{Id: docId, UserId: bob, Acquired: expired, Expires: expired.Add(time.Minute)}, expired)
+				assert.NoError(t, err)
+				assert.Equal(t, bob, held.UserId)
+				check, err := repo.GetLock(docId)
+				assert.NoError(t, err)
+				assert.Equal(t, bob, check.UserId)
+				assert.True(t, check.Acquired.Equal(expired))
+
+				assert.NoError(t, repo.DeleteLock(docId))
+				_, err = repo.GetLock(docId)
+				assert.Error(t, err)
+			})
+
 			t.Run("GetUserByEmail", func(t *testing.T) {
 				u := user.User{
 					Email: "test@test.com",
 				}
-				assert.NoError(t, repo.InsertUser(&u))
+				assert.NoError(t, repo.InsertUser(ctx, &u))
 
-				check, err := repo.GetUserByEmail(u.Email)
+				check, err := repo.GetUserByEmail(ctx, u.Email)
 				assert.NoError(t, err)
 				assert.Equal(t, u.Id, check.Id)
 
-				_, err = repo.GetUserByEmail("bad@test.com")
+				_, err = repo.GetUserByEmail(ctx, "bad@test.com")
 				assert.Error(t, err)
 			})
 
 			t.Run("GetUserById", func(t *testing.T) {
 				u := user.User{}
-				assert.NoError(t, repo.InsertUser(&u))
+				assert.NoError(t, repo.InsertUser(ctx, &u))
 
-				check, err := repo.GetUserById(u.Id)
+				check, err := repo.GetUserById(ctx, u.Id)
 				assert.NoError(t, err)
 				assert.Equal(t, u.Id, check.Id)
 
-				_, err = repo.GetUserById(primitive.NewObjectID())
+				_, err = repo.GetUserById(ctx, primitive.NewObjectID())
 				assert.Error(t, err)
 			})
 
 			t.Run("InsertUser", func(t *testing.T) {
 				u := user.User{}
-				assert.NoError(t, repo.InsertUser(&u))
+				assert.NoError(t, repo.InsertUser(ctx, &u))
 				assert.False(t, u.Id.IsZero())
 			})
 
@@ -295,18 +1134,39 @@ func TestRepository(t *testing.T) {
 				u := user.User{
 					Email: "test@test.com",
 				}
-				assert.NoError(t, repo.InsertUser(&u))
+				assert.NoError(t, repo.InsertUser(ctx, &u))
 
 				u.Email = "new-email@test.com"
-				assert.NoError(t, repo.UpdateUser(&u))
+				assert.NoError(t, repo.UpdateUser(ctx, &u))
 
-				check, err := repo.GetUserById(u.Id)
+				check, err := repo.GetUserById(ctx, u.Id)
 				assert.NoError(t, err)
 				assert.Equal(t, u.Id, check.Id)
 
 				u.Id = primitive.NewObjectID()
-				assert.Error(t, repo.UpdateUser(&u))
+				assert.Error(t, repo.UpdateUser(ctx, &u))
 			})
 		})
 	}
 }
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), time.Minute)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) <= time.Minute)
+
+	// Without a timeout only the caller's deadline applies
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelParent()
+	ctx, cancel = withTimeout(parent, 0)
+	defer cancel()
+	deadline, ok = ctx.Deadline()
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) > time.Minute)
+
+	// A caller giving up ends the query whatever the timeout
+	cancelParent()
+	assert.True(t, errors.Is(ctx.Err(), context.Canceled))
+}
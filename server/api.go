@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Proximity searches without a radius look this many meters around the point
+const defaultRadius = 1000.0
+
+// The public representation of a document. Admin bookkeeping such as trash
+// and reference details stays out of the API.
+type APIDocument struct {
+	Id        primitive.ObjectID     `json:"id"`
+	Title     string                 `json:"title"`
+	Slug      string                 `json:"slug"`
+	Published time.Time              `json:"published"`
+	Values    map[string]interface{} `json:"values"`
+	// Markdown fields rendered to HTML
+	HTML map[string]string `json:"html,omitempty"`
+}
+
+func NewAPIDocument(doc document.Document) APIDocument {
+	return APIDocument{
+		Id:        doc.Id,
+		Title:     doc.Title,
+		Slug:      doc.Slug,
+		Published: doc.Published,
+		Values:    doc.Values,
+	}
+}
+
+// Lists the published documents of a class. Documents may be limited to those
+// near a point with ?near=lat,lng, optionally with a radius in meters and the
+// location field to search, which defaults to the first in the class.
+func (s *Server) HandleAPIDocumentList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var class class.Class
+
+		// Class gauranteed to be set by middleware preceding this handler
+		_ = getContext(c, "class", &class)
+
+		if class.Fieldset {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("class %s is a fieldset", class.Slug),
+			})
+			return
+		}
+
+		page, perPage := pageParams(c)
+
+		params := document.DocumentListParams{
+			ClassId:         class.Id,
+			Page:            page,
+			Size:            perPage,
+			PublishedBefore: time.Now(),
+		}
+
+		var err error
+		if params.Near, err = nearParams(c, class); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		list, err := s.documentService.List(ctx, params)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		documents := make([]APIDocument, len(list.Documents))
+		for i, doc := range list.Documents {
+			documents[i] = NewAPIDocument(doc)
+			documents[i].HTML = s.markdownHTML(ctx, class, doc)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"total":     list.Total,
+			"page":      params.Page,
+			"per_page":  params.Size,
+			"documents": documents,
+		})
+	}
+}
+
+// Reads the proximity filter from the query. No filter is returned when the
+// near parameter is missing.
+func nearParams(c *gin.Context, class class.Class) (near document.NearParams, err error) {
+	if c.Query("near") == "" {
+		return
+	}
+
+	if near.Point, err = field.ParseGeoPoint(c.Query("near")); err != nil {
+		return
+	}
+
+	near.Radius = defaultRadius
+	if radius := c.Query("radius"); radius != "" {
+		if near.Radius, err = strconv.ParseFloat(radius, 64); err != nil || near.Radius <= 0 {
+			return near, fmt.Errorf("invalid radius: %s", radius)
+		}
+	}
+
+	name := c.Query("field")
+	for _, f := range class.AllFields() {
+		if f.Type == field.TypeGeoPoint && (name == "" || name == f.Name) {
+			near.Field = f.Name
+			return
+		}
+	}
+
+	if name == "" {
+		return near, fmt.Errorf("class %s has no location fields", class.Slug)
+	}
+	return near, fmt.Errorf("class %s has no location field %s", class.Slug, name)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+)
+
+func TestAPIDocumentList(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+	router := s.Routes()
+
+	c := class.Class{
+		Name: "Places",
+		Slug: "places",
+		Fields: []field.Field{
+			{Name: "location", Label: "Location", Type: field.TypeGeoPoint},
+		},
+	}
+	assert.NoError(t, classService.Insert(ctx, &c))
+
+	docs := []document.Document{
+		{Slug: "white_house", Published: time.Now().Add(-time.Hour), Values: map[string]interface{}{"location": "38.8977, -77.0365"}},
+		{Slug: "capitol", Published: time.Now().Add(-time.Hour), Values: map[string]interface{}{"location": "38.8899, -77.0091"}},
+		{Slug: "unpublished", Published: time.Now().Add(time.Hour), Values: map[string]interface{}{"location": "38.8977, -77.0365"}},
+	}
+	for i := range docs {
+		docs[i].ClassId = c.Id
+		assert.NoError(t, docService.Insert(ctx, &docs[i]))
+	}
+
+	get := func(url string) (code int, body struct {
+		Total     int64
+		PerPage   int64 `json:"per_page"`
+		Documents []APIDocument
+	}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		json.NewDecoder(w.Body).Decode(&body)
+		return w.Code, body
+	}
+
+	t.Run("Published", func(t *testing.T) {
+		code, body := get("/api/classes/places/documents")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2, body.Total)
+	})
+
+	t.Run("Near", func(t *testing.T) {
+		code, body := get("/api/classes/places/documents?near=38.8976,-77.0366&radius=500")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, body.Total)
+		assert.Equal(t, "white_house", body.Documents[0].Slug)
+
+		_, body = get("/api/classes/places/documents?near=38.8976,-77.0366&radius=5000")
+		assert.Equal(t, 2, body.Total)
+	})
+
+	t.Run("Page Size", func(t *testing.T) {
+		code, body := get("/api/classes/places/documents?pp=-5")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, body.PerPage)
+		assert.Equal(t, 2, body.Total)
+		assert.Equal(t, 1, len(body.Documents))
+
+		code, body = get("/api/classes/places/documents?pp=100000")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, MaxPerPage, body.PerPage)
+		assert.Equal(t, 2, len(body.Documents))
+
+		code, body = get("/api/classes/places/documents?p=-3&pp=1")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "white_house", body.Documents[0].Slug)
+	})
+
+	t.Run("Bad Parameters", func(t *testing.T) {
+		for _, query := range []string{"near=north", "near=38,-77&radius=far", "near=38,-77&field=address"} {
+			code, _ := get("/api/classes/places/documents?" + query)
+			assert.Equal(t, http.StatusBadRequest, code)
+		}
+	})
+
+	t.Run("Unknown Class", func(t *testing.T) {
+		code, _ := get("/api/classes/nowhere/documents")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/class"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dates in the audit filter form, as sent by date inputs
+const auditDateFormat = "2006-01-02"
+
+// An audit entry with names for its actor and class
+type AuditRow struct {
+	audit.Entry
+	Actor string
+	Class class.Class
+}
+
+// Reads the audit filter from the query string: user and class IDs, and a
+// range of dates. Both dates are inclusive.
+func auditFilter(c *gin.Context) (filter audit.Filter, err error) {
+	if user := c.Query("user"); user != "" {
+		if filter.ActorId, err = primitive.ObjectIDFromHex(user); err != nil {
+			return filter, fmt.Errorf("invalid user ID: %s", user)
+		}
+	}
+	if class := c.Query("class"); class != "" {
+		if filter.ClassId, err = primitive.ObjectIDFromHex(class); err != nil {
+			return filter, fmt.Errorf("invalid class ID: %s", class)
+		}
+	}
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.ParseInLocation(auditDateFormat, from, time.Local); err != nil {
+			return filter, fmt.Errorf("invalid from date: %s", from)
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.ParseInLocation(auditDateFormat, to, time.Local); err != nil {
+			return filter, fmt.Errorf("invalid to date: %s", to)
+		}
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	return
+}
+
+func (s *Server) HandleAuditLog() gin.HandlerFunc {
+	name := "admin-audit"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/audit.html",
+	)))
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		obj := gin.H{
+			"User":    c.Query("user"),
+			"ClassId": c.Query("class"),
+			"From":    c.Query("from"),
+			"To":      c.Query("to"),
+			"Query":   c.Request.URL.RawQuery,
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		filter, err := auditFilter(c)
+		if err != nil {
+			obj["Error"] = err.Error()
+			c.HTML(http.StatusBadRequest, name, obj)
+			return
+		}
+
+		entries, err := s.auditService.List(filter)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		// Trashed classes are not in the nav list, so they are looked up too
+		classes := make(map[primitive.ObjectID]class.Class)
+		if list, ok := obj["ClassList"].([]class.Class); ok {
+			for _, cls := range list {
+				classes[cls.Id] = cls
+			}
+		}
+		actors := make(map[primitive.ObjectID]string)
+		rows := make([]AuditRow, len(entries))
+		for i, e := range entries {
+			rows[i].Entry = e
+			if !e.ActorId.IsZero() {
+				if _, ok := actors[e.ActorId]; !ok {
+					actors[e.ActorId] = e.ActorId.Hex()
+					if u, err := s.userService.GetById(ctx, e.ActorId); err == nil && u.DisplayName != "" {
+						actors[e.ActorId] = u.DisplayName
+					}
+				}
+				rows[i].Actor = actors[e.ActorId]
+			}
+			if !e.ClassId.IsZero() {
+				if _, ok := classes[e.ClassId]; !ok {
+					classes[e.ClassId], _ = s.classService.GetById(ctx, e.ClassId)
+				}
+				rows[i].Class = classes[e.ClassId]
+			}
+		}
+		obj["Rows"] = rows
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
+
+// Downloads every entry matching the filter as JSON lines
+func (s *Server) HandleAuditExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := auditFilter(c)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := s.auditService.Export(filter, &buf); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		c.Data(http.StatusOK, "application/x-ndjson", buf.Bytes())
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/audit"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+	router := s.router
+
+	router.GET("/admin/audit", s.HandleAuditLog())
+	router.GET("/admin/audit/export", s.HandleAuditExport())
+
+	editor := primitive.NewObjectID()
+	pages := class.Class{Name: "Pages", Slug: "pages"}
+	assert.NoError(t, classService.As(editor).Insert(ctx, &pages))
+	events := class.Class{Name: "Events", Slug: "events"}
+	assert.NoError(t, classService.Insert(ctx, &events))
+	about := document.Document{ClassId: pages.Id, Title: "About Us", Slug: "about"}
+	assert.NoError(t, docService.As(editor).Insert(ctx, &about))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("List", func(t *testing.T) {
+		w := get("/admin/audit")
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.True(t, strings.Contains(body, "created document &#34;About Us&#34;"))
+		assert.True(t, strings.Contains(body, "created class &#34;Events&#34;"))
+		// Changes made without a user are credited to the system
+		assert.True(t, strings.Contains(body, "System"))
+	})
+
+	t.Run("Filtered", func(t *testing.T) {
+		w := get("/admin/audit?class=" + pages.Id.Hex() + "&user=" + editor.Hex())
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.True(t, strings.Contains(body, "created document &#34;About Us&#34;"))
+		assert.True(t, strings.Contains(body, "created class &#34;Pages&#34;"))
+		assert.False(t, strings.Contains(body, "created class &#34;Events&#34;"))
+
+		tomorrow := time.Now().AddDate(0, 0, 1).Format(auditDateFormat)
+		w = get("/admin/audit?from=" + tomorrow)
+		assert.True(t, strings.Contains(w.Body.String(), "No changes recorded."))
+	})
+
+	t.Run("Invalid Filter", func(t *testing.T) {
+		w := get("/admin/audit?from=yesterday")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), "invalid from date"))
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		w := get("/admin/audit/export?user=" + editor.Hex())
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+		var entries []audit.Entry
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var e audit.Entry
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+			entries = append(entries, e)
+		}
+		assert.Equal(t, 2, len(entries))
+		assert.Equal(t, about.Id, entries[0].TargetId)
+		assert.Equal(t, pages.Id, entries[1].TargetId)
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+)
+
+// Reports whether the save failed because someone else saved first
+func isConflict(err error) bool {
+	return errors.Is(err, class.ErrConflict) || errors.Is(err, document.ErrConflict)
+}
+
+// A property which differs between the stored document and the editor's copy
+type ConflictRow struct {
+	Label  string
+	Theirs string
+	Mine   string
+}
+
+// Shown in the document builder when a save loses to someone else's
+type DocumentConflict struct {
+	Current document.Document
+	// Display name of whoever saved the current version, blank if unknown
+	Editor string
+	Rows   []ConflictRow
+}
+
+// Loads the stored version of the document and lists where it differs from
+// the editor's copy
+func (s *Server) documentConflict(ctx context.Context, c class.Class, mine document.Document) (conflict *DocumentConflict, err error) {
+	current, err := s.documentService.GetById(ctx, mine.Id)
+	if err != nil {
+		return
+	}
+
+	conflict = &DocumentConflict{
+		Current: current,
+		Editor:  s.userName(ctx, current.UpdatedBy),
+	}
+	add := func(label string, theirs, mine interface{}) {
+		if reflect.DeepEqual(theirs, mine) {
+			return
+		}
+		row := ConflictRow{Label: label, Theirs: fmt.Sprint(theirs), Mine: fmt.Sprint(mine)}
+		// Values posted as text may only differ from the stored ones in type
+		if row.Theirs != row.Mine {
+			conflict.Rows = append(conflict.Rows, row)
+		}
+	}
+
+	add("Title", current.Title, mine.Title)
+	add("Slug", current.Slug, mine.Slug)
+	add("Published", publishedLabel(current), publishedLabel(mine))
+	for _, f := range c.AllFields() {
+		add(f.Label, current.Values[f.Name], mine.Values[f.Name])
+	}
+	return
+}
+
+func publishedLabel(doc document.Document) string {
+	if doc.IsDraft() {
+		return "Draft"
+	}
+	return doc.Published.Local().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+)
+
+func TestConflicts(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+	router := s.router
+
+	router.POST("/admin/classes/:class/edit", s.MiddlewareClass(), s.HandleClassBuilder())
+	router.POST("/admin/classes/:class/:doc_id", s.MiddlewareClass(), s.HandleDocumentBuilder())
+
+	serve := func(path string, values url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	pages := class.Class{
+		Name: "Pages",
+		Slug: "pages",
+		Fields: []field.Field{
+			{Name: "body", Label: "Body", Type: field.TypeText},
+		},
+	}
+	assert.NoError(t, classService.Insert(ctx, &pages))
+	about := document.Document{ClassId: pages.Id, Title: "About", Slug: "about", Values: map[string]interface{}{"body": "Original"}}
+	assert.NoError(t, docService.Insert(ctx, &about))
+	path := "/admin/classes/pages/" + about.Id.Hex()
+	loaded := strconv.FormatInt(about.Version, 10)
+
+	t.Run("Document", func(t *testing.T) {
+		w := serve(path, url.Values{
+			"version": {loaded},
+			"title":   {"About"},
+			"slug":    {"about"},
+			"body":    {"Theirs"},
+		})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+
+		// Saving from the same starting point now conflicts and shows both
+		// versions without storing anything
+		w = serve(path, url.Values{
+			"version": {loaded},
+			"title":   {"About Us"},
+			"slug":    {"about"},
+			"body":    {"Mine"},
+		})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		body := w.Body.String()
+		assert.True(t, strings.Contains(body, "Your changes have not been saved."))
+		assert.True(t, strings.Contains(body, "<td>Theirs</td>"))
+		assert.True(t, strings.Contains(body, "<td>Mine</td>"))
+		assert.True(t, strings.Contains(body, "<td>About Us</td>"))
+
+		stored, err := docService.GetById(ctx, about.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "Theirs", stored.Values["body"])
+
+		// The conflict screen carries the current version, so submitting it
+		// again is a deliberate overwrite
+		current := strconv.FormatInt(stored.Version, 10)
+		assert.True(t, strings.Contains(body, `name="version" value="`+current+`"`))
+		w = serve(path, url.Values{
+			"version": {current},
+			"title":   {"About Us"},
+			"slug":    {"about"},
+			"body":    {"Mine"},
+		})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		stored, err = docService.GetById(ctx, about.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "Mine", stored.Values["body"])
+	})
+
+	t.Run("Class", func(t *testing.T) {
+		loaded := strconv.FormatInt(pages.Version, 10)
+		w := serve("/admin/classes/pages/edit", url.Values{
+			"version": {loaded},
+			"name":    {"Pages"},
+			"slug":    {"pages"},
+		})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+
+		w = serve("/admin/classes/pages/edit", url.Values{
+			"version": {loaded},
+			"name":    {"Site Pages"},
+			"slug":    {"pages"},
+		})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), class.ErrConflict.Error()))
+	})
+}
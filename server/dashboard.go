@@ -0,0 +1,92 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Number of documents listed in each dashboard section
+const dashboardLimit = 10
+
+// Document counts of a class shown on the dashboard
+type DashboardCount struct {
+	Class class.Class
+	document.ClassCount
+}
+
+// A dashboard document paired with its class so it can be labelled and linked
+type DashboardDocument struct {
+	Class    class.Class
+	Document document.Document
+}
+
+func (s *Server) HandleAdminDashboard() gin.HandlerFunc {
+	name := "admin-dashboard"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/dashboard.html",
+	)))
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		activity, err := s.documentService.Activity(ctx, adminUserId(c), dashboardLimit)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		classes, err := s.classService.All(ctx)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		// Every class is listed, including those without any documents yet
+		lookup := make(map[primitive.ObjectID]class.Class, len(classes))
+		counts := make([]DashboardCount, 0, len(classes))
+		for _, cls := range classes {
+			lookup[cls.Id] = cls
+			if cls.Fieldset {
+				continue
+			}
+			count := DashboardCount{Class: cls}
+			for _, cc := range activity.Counts {
+				if cc.ClassId == cls.Id {
+					count.ClassCount = cc
+				}
+			}
+			counts = append(counts, count)
+		}
+
+		// Documents of trashed classes are left off, having nowhere to link
+		withClass := func(docs []document.Document) []DashboardDocument {
+			list := make([]DashboardDocument, 0, len(docs))
+			for _, doc := range docs {
+				if cls, ok := lookup[doc.ClassId]; ok {
+					list = append(list, DashboardDocument{Class: cls, Document: doc})
+				}
+			}
+			return list
+		}
+
+		obj := gin.H{
+			"Counts":    counts,
+			"Recent":    withClass(activity.Recent),
+			"Scheduled": withClass(activity.Scheduled),
+			"Drafts":    withClass(activity.Drafts),
+			"Now":       time.Now(),
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
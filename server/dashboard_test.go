@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+)
+
+func TestAdminDashboard(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+	router := s.router
+
+	router.Use(sessions.Sessions("gocms", cookie.NewStore([]byte("secret"))))
+	router.GET("/admin/dashboard", s.HandleAdminDashboard())
+
+	pages := class.Class{Name: "Pages", Slug: "pages"}
+	assert.NoError(t, classService.Insert(ctx, &pages))
+	events := class.Class{Name: "Events", Slug: "events"}
+	assert.NoError(t, classService.Insert(ctx, &events))
+
+	docs := []document.Document{
+		{ClassId: pages.Id, Title: "About", Slug: "about", Published: time.Now().Add(-time.Hour)},
+		{ClassId: pages.Id, Title: "Launch", Slug: "launch", Published: time.Now().Add(24 * time.Hour)},
+		{ClassId: pages.Id, Title: "Unfinished", Slug: "unfinished"},
+	}
+	for i := range docs {
+		assert.NoError(t, docService.Insert(ctx, &docs[i]))
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/admin/dashboard", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	// Pages: one published, one scheduled, one draft; events are empty
+	assert.True(t, strings.Contains(body, `<td class="text-end">1</td>
+        <td class="text-end">1</td>
+        <td class="text-end">1</td>
+        <td class="text-end">3</td>`))
+	assert.True(t, strings.Contains(body, `href="/admin/classes/events/">Events</a>`))
+	assert.True(t, strings.Contains(body, `href="/admin/classes/pages/`+docs[1].Id.Hex()+`">Launch</a>`))
+	// Documents created without a logged in user are that user's drafts
+	assert.True(t, strings.Contains(body, "Unfinished"))
+	assert.False(t, strings.Contains(body, "You have no drafts"))
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/theme"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Name of the hidden input people never see but bots fill in. Form field
+// names must start with a letter, so it never collides with one.
+const formHoneypot = "_website"
+
+// Data the theme form template is executed with
+type FormPage struct {
+	Form form.Form
+	// Posted values, kept so a rejected submission can be corrected
+	Values url.Values
+	Errors form.ValidationErrors
+	// Problem with the submission as a whole, such as too many attempts
+	Error    string
+	Sent     bool
+	Path     string
+	Honeypot string
+
+	theme *theme.Theme
+}
+
+// URL of a theme asset, which changes along with its contents
+func (p FormPage) Asset(name string) string {
+	return p.theme.AssetURL(name)
+}
+
+// Posted value of a field
+func (p FormPage) Value(name string) string {
+	return p.Values.Get(name)
+}
+
+// Reports whether an option of a select field was posted
+func (p FormPage) Checked(name, option string) bool {
+	for _, value := range p.Values[name] {
+		if value == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Shows a form at /forms/:id using the theme form template and accepts its
+// submissions. Submissions from bots filling in the honeypot are dropped
+// without telling them; each address may only submit so often.
+func (s *Server) HandleForm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			s.renderNotFound(c, path)
+			return
+		}
+		f, err := s.formService.GetById(id)
+		if err != nil {
+			s.renderNotFound(c, path)
+			return
+		}
+
+		tmpl, err := s.theme.Template("form.html")
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		page := FormPage{
+			Form:     f,
+			Values:   url.Values{},
+			Sent:     c.Query("sent") != "",
+			Path:     path,
+			Honeypot: formHoneypot,
+			theme:    s.theme,
+		}
+
+		if c.Request.Method != http.MethodPost {
+			s.renderSite(c, http.StatusOK, tmpl, page)
+			return
+		}
+
+		if err := c.Request.ParseForm(); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		page.Values = c.Request.PostForm
+
+		if !s.formLimiter.Allow(c.ClientIP(), time.Now()) {
+			page.Error = "Too many submissions, please try again later."
+			s.renderSite(c, http.StatusTooManyRequests, tmpl, page)
+			return
+		}
+
+		if page.Values.Get(formHoneypot) != "" {
+			c.Redirect(http.StatusSeeOther, path+"?sent=1")
+			return
+		}
+
+		_, err = s.formService.Submit(f, page.Values, c.ClientIP())
+		var invalid form.ValidationErrors
+		switch {
+		case errors.As(err, &invalid):
+			page.Errors = invalid
+			s.renderSite(c, http.StatusUnprocessableEntity, tmpl, page)
+			return
+		case errors.Is(err, form.ErrNotification):
+			// The submission is stored, so the visitor need not know
+			log.Printf("Form %s: %v", f.Id.Hex(), err)
+		case err != nil:
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, path+"?sent=1")
+	}
+}
+
+// Looks up the form named by the id parameter
+func (s *Server) formParam(c *gin.Context) (f form.Form, err error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return
+	}
+	return s.formService.GetById(id)
+}
+
+func (s *Server) HandleFormList() gin.HandlerFunc {
+	name := "admin-form-list"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/form-list.html",
+	)))
+
+	return func(c *gin.Context) {
+		forms, err := s.formService.List()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		obj := gin.H{
+			"Forms": forms,
+			"Error": c.Query("error"),
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
+
+// Creates a form at /admin/forms/new or edits one at /admin/forms/:id. Fields
+// are written one per line in the format of form.ParseFields.
+func (s *Server) HandleFormBuilder() gin.HandlerFunc {
+	name := "admin-form-builder"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/form-builder.html",
+	)))
+
+	return func(c *gin.Context) {
+		var f form.Form
+		var err error
+
+		// No ID parameter means we are on /new
+		if c.Param("id") != "" {
+			if f, err = s.formParam(c); err != nil {
+				c.AbortWithError(http.StatusNotFound, err)
+				return
+			}
+		}
+		fields := form.FormatFields(f.Fields)
+
+		if c.Request.Method == http.MethodPost {
+			f.Name = strings.TrimSpace(c.PostForm("name"))
+			f.SuccessMessage = strings.TrimSpace(c.PostForm("success_message"))
+			fields = c.PostForm("fields")
+
+			if f.Fields, err = form.ParseFields(fields); err == nil {
+				if f.Id.IsZero() {
+					err = s.formService.Insert(&f)
+				} else {
+					err = s.formService.Update(&f)
+				}
+			}
+
+			if err == nil {
+				c.Redirect(http.StatusSeeOther, "/admin/forms/")
+				return
+			}
+		}
+
+		obj := gin.H{
+			"Form":   f,
+			"Fields": fields,
+			"Error":  err,
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusBadRequest
+		}
+		c.HTML(status, name, obj)
+	}
+}
+
+// Deletes a form along with its submissions
+func (s *Server) HandleFormDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, err := s.formParam(c)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		redirect := "/admin/forms/"
+		if err := s.formService.Delete(f); err != nil {
+			redirect += "?" + url.Values{"error": {err.Error()}}.Encode()
+		}
+		c.Redirect(http.StatusSeeOther, redirect)
+	}
+}
+
+func (s *Server) HandleFormSubmissions() gin.HandlerFunc {
+	name := "admin-form-submissions"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/form-submissions.html",
+	)))
+
+	type row struct {
+		Submission form.Submission
+		Values     []string
+	}
+
+	return func(c *gin.Context) {
+		f, err := s.formParam(c)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		submissions, err := s.formService.Submissions(f)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		rows := make([]row, len(submissions))
+		for i, sub := range submissions {
+			rows[i].Submission = sub
+			rows[i].Values = make([]string, len(f.Fields))
+			for j, fld := range f.Fields {
+				rows[i].Values[j] = form.FormatValue(sub.Values[fld.Name])
+			}
+		}
+
+		obj := gin.H{
+			"Form": f,
+			"Rows": rows,
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
+
+var unsafeFilename = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Downloads the form submissions as CSV
+func (s *Server) HandleFormExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, err := s.formParam(c)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := s.formService.Export(f, &buf); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		filename := strings.Trim(unsafeFilename.ReplaceAllString(strings.ToLower(f.Name), "-"), "-")
+		if filename == "" {
+			filename = "form"
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-submissions.csv"`, filename))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+)
+
+func TestForm(t *testing.T) {
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	formService := s.formService
+	s.SetFormRateLimit(3, time.Hour)
+	router := s.Routes()
+
+	contact := form.Form{
+		Name:           "Contact Us",
+		SuccessMessage: "Thanks for writing",
+		Fields: []field.Field{
+			{Name: "name", Label: "Name", Type: field.TypeText, Required: true},
+			{Name: "topic", Label: "Topic", Type: field.TypeSelect, Options: "sales\nsupport"},
+		},
+	}
+	assert.NoError(t, formService.Insert(&contact))
+	path := "/forms/" + contact.Id.Hex()
+
+	post := func(values url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Show", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.True(t, strings.Contains(body, `name="name"`))
+		assert.True(t, strings.Contains(body, `<option value="support">`))
+		assert.True(t, strings.Contains(body, `name="`+formHoneypot+`"`))
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		for _, p := range []string{"/forms/nope", "/forms/000000000000000000000000"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, p, nil)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		w := post(url.Values{"name": {""}, "topic": {"billing"}})
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		body := w.Body.String()
+		assert.True(t, strings.Contains(body, "Name is required"))
+		assert.True(t, strings.Contains(body, "Topic is not one of the choices"))
+	})
+
+	t.Run("Honeypot", func(t *testing.T) {
+		w := post(url.Values{"name": {"Bot"}, formHoneypot: {"http://spam.example"}})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+
+		subs, err := formService.Submissions(contact)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(subs))
+	})
+
+	t.Run("Submit", func(t *testing.T) {
+		w := post(url.Values{"name": {"Ada"}, "topic": {"sales"}})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, path+"?sent=1", w.Header().Get("Location"))
+
+		subs, err := formService.Submissions(contact)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(subs))
+		assert.Equal(t, "Ada", subs[0].Values["name"])
+
+		w = httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path+"?sent=1", nil)
+		router.ServeHTTP(w, req)
+		assert.True(t, strings.Contains(w.Body.String(), "Thanks for writing"))
+	})
+
+	t.Run("RateLimit", func(t *testing.T) {
+		// Every earlier post counted, valid or not
+		w := post(url.Values{"name": {"Ada"}})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		subs, err := formService.Submissions(contact)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(subs))
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: contact.Id.Hex()}}
+		c.Request, _ = http.NewRequest(http.MethodGet, "/admin/forms/"+contact.Id.Hex()+"/export", nil)
+		s.HandleFormExport()(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `attachment; filename="contact-us-submissions.csv"`, w.Header().Get("Content-Disposition"))
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		assert.Equal(t, 2, len(lines))
+		assert.Equal(t, "Submitted,Name,Topic", lines[0])
+		assert.True(t, strings.HasSuffix(lines[1], ",Ada,sales"))
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, time.Minute)
+	now := time.Now()
+
+	assert.True(t, limiter.Allow("a", now))
+	assert.True(t, limiter.Allow("a", now.Add(time.Second)))
+	assert.False(t, limiter.Allow("a", now.Add(2*time.Second)))
+	assert.True(t, limiter.Allow("b", now.Add(2*time.Second)))
+
+	// The first attempt has left the window
+	assert.True(t, limiter.Allow("a", now.Add(time.Minute+time.Millisecond)))
+	assert.False(t, limiter.Allow("a", now.Add(time.Minute+2*time.Millisecond)))
+
+	// Keys with nothing in the window are forgotten
+	limiter.Allow("c", now.Add(time.Hour))
+	assert.Equal(t, 1, len(limiter.hits))
+}
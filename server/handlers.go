@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"html/template"
@@ -10,9 +11,11 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
 	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -31,6 +34,29 @@ func getContext[T any](c *gin.Context, key string, into *T) (err error) {
 	return fmt.Errorf("key not found: %s", key)
 }
 
+// Fetches the ID of the logged in admin user from the session
+func adminUserId(c *gin.Context) (id primitive.ObjectID) {
+	// Handlers called without the session middleware have nobody logged in
+	if _, ok := c.Get(sessions.DefaultKey); !ok {
+		return
+	}
+	session := sessions.Default(c)
+	id, _ = session.Get("adminUserId").(primitive.ObjectID)
+	return
+}
+
+// Display name of the user, blank for the system or users who cannot be found
+func (s *Server) userName(ctx context.Context, id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	u, err := s.userService.GetById(ctx, id)
+	if err != nil {
+		return ""
+	}
+	return u.DisplayName
+}
+
 func (s *Server) HandleAdminLogin() gin.HandlerFunc {
 	name := "admin-login"
 	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
@@ -66,6 +92,7 @@ func (s *Server) HandleClassBuilder() gin.HandlerFunc {
 	)))
 
 	return func(c *gin.Context) {
+		ctx := c.Request.Context()
 		var class class.Class
 		var err error
 
@@ -80,15 +107,20 @@ func (s *Server) HandleClassBuilder() gin.HandlerFunc {
 			if err := c.Bind(&class); err != nil {
 				return
 			}
+			if err = bindInheritance(c, &class); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
 
 			// Insert or update depending on the state of class.Id
 			var newUrl string
+			classes := s.classService.As(adminUserId(c))
 			if class.Id.IsZero() {
 				newUrl = fmt.Sprintf("/admin/classes/%s/fields", class.Slug)
-				err = s.classService.Insert(&class)
+				err = classes.Insert(ctx, &class)
 			} else {
 				newUrl = fmt.Sprintf("/admin/classes/%s/", class.Slug)
-				err = s.classService.Update(&class)
+				err = classes.Update(ctx, &class)
 			}
 
 			// If all went well, bounce to the next page
@@ -105,7 +137,81 @@ func (s *Server) HandleClassBuilder() gin.HandlerFunc {
 		if list, ok := c.Get("classList"); ok {
 			obj["ClassList"] = list
 		}
-		c.HTML(http.StatusOK, name, obj)
+
+		status := http.StatusOK
+		if isConflict(err) {
+			status = http.StatusConflict
+		}
+		c.HTML(status, name, obj)
+	}
+}
+
+// Reads the base class and fieldsets from the class builder form. Unchecked
+// boxes are never posted, so the fieldset flag is always read explicitly.
+func bindInheritance(c *gin.Context, class *class.Class) (err error) {
+	class.Fieldset = c.PostForm("fieldset") != ""
+
+	class.BaseId = primitive.NilObjectID
+	if hex := c.PostForm("base_id"); hex != "" {
+		if class.BaseId, err = primitive.ObjectIDFromHex(hex); err != nil {
+			return
+		}
+	}
+
+	class.FieldsetIds = nil
+	for _, hex := range c.PostFormArray("fieldset_ids") {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return err
+		}
+		class.FieldsetIds = append(class.FieldsetIds, id)
+	}
+	return
+}
+
+func (s *Server) HandleClassDelete() gin.HandlerFunc {
+	name := "admin-class-delete"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/class-delete.html",
+	)))
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var class class.Class
+		var err error
+
+		// Class gauranteed to be set by middleware preceding this handler
+		_ = getContext(c, "class", &class)
+
+		if c.Request.Method == http.MethodPost {
+			if err = s.classService.Trash(ctx, class, c.PostForm("mode"), adminUserId(c)); err == nil {
+				c.Redirect(http.StatusSeeOther, "/admin/trash")
+				return
+			}
+		}
+
+		dependents, depErr := s.classService.Dependents(ctx, class)
+		if depErr != nil {
+			c.AbortWithError(http.StatusInternalServerError, depErr)
+			return
+		}
+
+		obj := gin.H{
+			"Class":      class,
+			"Dependents": dependents,
+			"Error":      err,
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusConflict
+		}
+		c.HTML(status, name, obj)
 	}
 }
 
@@ -122,24 +228,35 @@ func (s *Server) HandleClassFieldBuilderGet() gin.HandlerFunc {
 		Label    string `json:"label"`
 		Template string `json:"template"`
 	}{
+		{field.TypeBoolean, "Checkbox", "boolean"},
+		{field.TypeColor, "Color", "color"},
 		{field.TypeDate, "Date", "date"},
 		{field.TypeDateTime, "Date & Time", "date"},
 		{field.TypeEmail, "Email", "email"},
+		{field.TypeGroup, "Group", "group"},
+		{field.TypeJSON, "JSON", "json"},
+		{field.TypeGeoPoint, "Location", "geopoint"},
+		{field.TypeMarkdown, "Markdown", "markdown"},
 		{field.TypeMultiSelect, "Multi-Select", "select"},
 		{field.TypeNumber, "Number", "number"},
+		{field.TypeRelation, "Relation", "relation"},
+		{field.TypeRepeater, "Repeater", "repeater"},
 		{field.TypeSelect, "Select (Class)", "select-class"},
 		{field.TypeSelect, "Select (Static)", "select-static"},
+		{field.TypeSlug, "Slug", "slug"},
 		{field.TypeText, "Text", "text"},
 		{field.TypeTextArea, "Textarea", "textarea"},
 		{field.TypeTime, "Time", "time"},
 		{field.TypeTinyMCE, "TinyMCE", "tinymce"},
 		{field.TypeUpload, "Upload", "upload"},
+		{field.TypeURL, "URL", "url"},
 	}
 
 	return func(c *gin.Context) {
 		obj := gin.H{
-			"FieldTypes": types,
-			"Error":      nil,
+			"FieldTypes":         types,
+			"DefaultAllowedHTML": field.DefaultAllowedHTML,
+			"Error":              nil,
 		}
 		if list, ok := c.Get("classList"); ok {
 			obj["ClassList"] = list
@@ -154,13 +271,20 @@ func (s *Server) HandleClassFieldBuilderGet() gin.HandlerFunc {
 
 func (s *Server) HandleClassFieldBuilderPost() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		// The builder first submits a dry run to find out which document
+		// values are affected, then resubmits with the migrations to apply
+		var req struct {
+			DryRun     bool              `json:"dry_run"`
+			Migrations []class.Migration `json:"migrations"`
+		}
 		var class class.Class
 
 		// class gauranteed to be set per middleware preceding this handler
 		_ = getContext(c, "class", &class)
 
 		// Bind values to Fields field
-		if err := c.ShouldBind(&class); err != nil {
+		if err := c.ShouldBindBodyWith(&class, binding.JSON); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"success": false,
 				"error":   err.Error(),
@@ -168,7 +292,7 @@ func (s *Server) HandleClassFieldBuilderPost() gin.HandlerFunc {
 			return
 		}
 
-		if err := s.classService.Update(&class); err != nil {
+		if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"success": false,
 				"error":   err.Error(),
@@ -176,6 +300,34 @@ func (s *Server) HandleClassFieldBuilderPost() gin.HandlerFunc {
 			return
 		}
 
+		if req.DryRun {
+			migrations, err := s.classService.Migrations(ctx, class)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"error":   err.Error(),
+				})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"success":    true,
+				"migrations": migrations,
+			})
+			return
+		}
+
+		if err := s.classService.As(adminUserId(c)).Update(ctx, &class, req.Migrations...); err != nil {
+			status := http.StatusBadRequest
+			if isConflict(err) {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+
 		c.JSON(http.StatusAccepted, gin.H{"success": true})
 	}
 }
@@ -193,19 +345,28 @@ func (s *Server) HandleDocumentBuilder() gin.HandlerFunc {
 	loc, _ := time.LoadLocation("America/New_York")
 
 	return func(c *gin.Context) {
+		ctx := c.Request.Context()
 		var class class.Class
 		var doc document.Document
+		var conflict *DocumentConflict
+		var locked *EditLock
+		var heartbeat time.Duration
 
 		// Class gauranteed to be set from middleware preceding this handler
 		_ = getContext(c, "class", &class)
 
+		if class.Fieldset {
+			c.AbortWithError(http.StatusNotFound, fmt.Errorf("class %s is a fieldset", class.Slug))
+			return
+		}
+
 		if id := c.Param("doc_id"); id != "" {
 			bsonId, err := primitive.ObjectIDFromHex(id)
 			if err != nil {
 				c.AbortWithError(http.StatusBadRequest, err)
 				return
 			}
-			if doc, err = s.documentService.GetById(bsonId); err != nil {
+			if doc, err = s.documentService.GetById(ctx, bsonId); err != nil {
 				c.AbortWithError(http.StatusNotFound, err)
 				return
 			}
@@ -214,35 +375,89 @@ func (s *Server) HandleDocumentBuilder() gin.HandlerFunc {
 			doc.Published = time.Now().In(loc)
 		}
 
-		if c.Request.Method == http.MethodPost {
+		// Editors hold a lock on existing documents while the builder is open.
+		// Saves are refused while someone else holds it.
+		if viewer := adminUserId(c); !doc.Id.IsZero() && !viewer.IsZero() {
+			var err error
+			if locked, err = s.editLock(ctx, doc.Id, viewer); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			if locked == nil {
+				heartbeat = s.lockHeartbeat()
+			}
+		}
+
+		if c.Request.Method == http.MethodPost && locked == nil {
+			// The version the editor loaded, so saves over someone else's
+			// changes are caught
+			if version := c.PostForm("version"); version != "" {
+				var err error
+				if doc.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+					c.AbortWithError(http.StatusBadRequest, err)
+					return
+				}
+			}
 			doc.Title = c.PostForm("title")
 			doc.Slug = c.PostForm("slug")
-			if published, err := time.ParseInLocation(layout, c.PostForm("published"), loc); err == nil {
+			// Documents without a publish date are kept as drafts
+			if c.PostForm("published") == "" {
+				doc.Published = time.Time{}
+			} else if published, err := time.ParseInLocation(layout, c.PostForm("published"), loc); err == nil {
 				doc.Published = published
 			}
 			if doc.Values == nil {
 				doc.Values = make(map[string]interface{})
 			}
-			for _, field := range class.Fields {
-				doc.Values[field.Name] = c.PostForm(field.Name)
+			for _, f := range class.AllFields() {
+				if f.Type == field.TypeRelation {
+					// Relations arrive as an ordered list of hex IDs
+					doc.Values[f.Name] = document.RelationIds(c.PostFormArray(f.Name))
+					continue
+				}
+				if f.IsNested() {
+					doc.Values[f.Name] = nestedValue(c, f)
+					continue
+				}
+				if f.Type == field.TypeUpload {
+					value, err := s.uploadValue(c, f)
+					if err != nil {
+						c.AbortWithError(http.StatusBadRequest, err)
+						return
+					}
+					doc.Values[f.Name] = value
+					continue
+				}
+				doc.Values[f.Name] = c.PostForm(f.Name)
 			}
+			doc.UpdatedBy = adminUserId(c)
+			docs := s.documentService.As(doc.UpdatedBy)
 			if doc.Id.IsZero() {
-				if err := s.documentService.Insert(&doc); err != nil {
+				doc.CreatedBy = doc.UpdatedBy
+				if err := docs.Insert(ctx, &doc); err != nil {
 					c.AbortWithError(http.StatusBadRequest, err)
 					return
 				}
-			} else {
-				if err := s.documentService.Update(&doc); err != nil {
-					c.AbortWithError(http.StatusBadRequest, err)
+			} else if err := docs.Update(ctx, &doc); isConflict(err) {
+				if conflict, err = s.documentConflict(ctx, class, doc); err != nil {
+					c.AbortWithError(http.StatusInternalServerError, err)
 					return
 				}
+				// Submitting the form again replaces their version with this one
+				doc.Version = conflict.Current.Version
+			} else if err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
 			}
 
-			c.Redirect(http.StatusSeeOther, "/admin/classes/"+class.Slug+"/"+doc.Id.Hex())
-			return
+			if conflict == nil {
+				c.Redirect(http.StatusSeeOther, "/admin/classes/"+class.Slug+"/"+doc.Id.Hex())
+				return
+			}
 		}
 
-		for i, field := range class.Fields {
+		fields := class.AllFields()
+		for i, field := range fields {
 			if field.DataSourceId.IsZero() {
 				continue
 			}
@@ -254,7 +469,7 @@ func (s *Server) HandleDocumentBuilder() gin.HandlerFunc {
 				},
 			}
 			for _, doc := range docs {
-				class.Fields[i].Options += fmt.Sprintf(
+				fields[i].Options += fmt.Sprintf(
 					"%s|%s\n",
 					field.Apply(doc.Value(field.DataSourceValue)),
 					field.Apply(doc.Value(field.DataSourceLabel)),
@@ -262,20 +477,88 @@ func (s *Server) HandleDocumentBuilder() gin.HandlerFunc {
 			}
 		}
 
+		relations, err := s.relationOptions(ctx, class, doc)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		referencedBy, err := s.referencedBy(ctx, doc)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		uploads, mediaOptions, err := s.uploadOptions(fields, doc)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		previews := make([]media.Media, 0, len(uploads))
+		for _, m := range uploads {
+			previews = append(previews, *m)
+		}
+
 		obj := gin.H{
-			"Document": doc,
-			"Class":    class,
-			"Error":    nil,
+			"Document":     doc,
+			"Class":        class,
+			"Fields":       fields,
+			"Relations":    relations,
+			"Nested":       nestedOptions(fields, doc),
+			"ReferencedBy": referencedBy,
+			"Uploads":      uploads,
+			"Thumbnails":   s.thumbnails(previews),
+			"MediaOptions": mediaOptions,
+			"Conflict":     conflict,
+			"Lock":         locked,
+			"Heartbeat":    heartbeat.Milliseconds(),
+			"Error":        nil,
 		}
 		if list, ok := c.Get("classList"); ok {
 			obj["ClassList"] = list
 		}
 
+		status := http.StatusOK
+		if conflict != nil {
+			status = http.StatusConflict
+		}
+		if locked != nil && c.Request.Method == http.MethodPost {
+			status = http.StatusLocked
+		}
 		if c.GetHeader("Accept") == "application/json" {
-			c.JSON(http.StatusOK, obj)
+			c.JSON(status, obj)
 		} else {
-			c.HTML(http.StatusOK, name, obj)
+			c.HTML(status, name, obj)
+		}
+	}
+}
+
+func (s *Server) HandleDocumentTrash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var class class.Class
+
+		// Class gauranteed to be set from middleware preceding this handler
+		_ = getContext(c, "class", &class)
+
+		id, err := primitive.ObjectIDFromHex(c.Param("doc_id"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
 		}
+
+		doc, err := s.documentService.GetById(ctx, id)
+		if err != nil || doc.ClassId != class.Id {
+			c.AbortWithError(http.StatusNotFound, fmt.Errorf("document not found: %s", id.Hex()))
+			return
+		}
+
+		if err := s.documentService.Trash(ctx, doc, adminUserId(c)); err != nil {
+			c.AbortWithError(http.StatusConflict, err)
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/admin/classes/"+class.Slug+"/")
 	}
 }
 
@@ -288,32 +571,36 @@ func (s *Server) HandleDocumentList() gin.HandlerFunc {
 	)))
 
 	return func(c *gin.Context) {
+		ctx := c.Request.Context()
 		var class class.Class
 
 		// Class gauranteed to be set by middleware preceding this handler
 		_ = getContext(c, "class", &class)
 
-		page, err := strconv.ParseInt(c.Query("p"), 10, 64)
-		if err != nil || page == 0 {
-			page = 1
+		if class.Fieldset {
+			c.AbortWithError(http.StatusNotFound, fmt.Errorf("class %s is a fieldset", class.Slug))
+			return
 		}
 
-		perPage, err := strconv.ParseInt(c.Query("pp"), 10, 64)
-		if err != nil || perPage == 0 {
-			perPage = 10
-		}
+		page, perPage := pageParams(c)
 
 		params := document.DocumentListParams{
 			ClassId: class.Id,
 			Page:    page,
 			Size:    perPage,
 		}
-		list, err := s.documentService.List(params)
+		list, err := s.documentService.List(ctx, params)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
 
+		// Resolve relation.key columns in the table
+		if err := s.documentService.Expand(ctx, list.Documents); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
 		obj := gin.H{
 			"Class":      class,
 			"Table":      NewTable(class, list.Documents),
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/event"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How often an idle feed sends a comment so proxies keep the connection open
+const liveKeepAlive = 30 * time.Second
+
+// Events waiting for a slow client beyond this are dropped
+const liveBuffer = 16
+
+// A change streamed to admins watching the live feed
+type liveEvent struct {
+	// Either "class" or "document", also used as the SSE event name
+	Kind string `json:"kind"`
+	// One of created, updated, trashed, restored or deleted
+	Action  string             `json:"action"`
+	Id      primitive.ObjectID `json:"id"`
+	ClassId primitive.ObjectID `json:"class_id"`
+	Title   string             `json:"title"`
+	ActorId primitive.ObjectID `json:"actor_id"`
+	// Display name of the actor, blank for system changes or unknown users
+	Actor string    `json:"actor"`
+	Time  time.Time `json:"time"`
+}
+
+// Describes the change in words editors know. Trashing and restoring reach
+// the event bus as updates.
+func liveAction(op event.Op, deleted, previouslyDeleted time.Time) string {
+	switch {
+	case op == event.Insert:
+		return "created"
+	case op == event.Delete:
+		return "deleted"
+	case !deleted.IsZero() && previouslyDeleted.IsZero():
+		return "trashed"
+	case deleted.IsZero() && !previouslyDeleted.IsZero():
+		return "restored"
+	}
+	return "updated"
+}
+
+// Fans changes out to every connected feed
+type liveHub struct {
+	mutex   *sync.RWMutex
+	clients map[chan liveEvent]struct{}
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{
+		mutex:   new(sync.RWMutex),
+		clients: make(map[chan liveEvent]struct{}),
+	}
+}
+
+// Registers a client. The returned function must be called once the client
+// goes away.
+func (h *liveHub) subscribe() (events chan liveEvent, unsubscribe func()) {
+	events = make(chan liveEvent, liveBuffer)
+
+	h.mutex.Lock()
+	h.clients[events] = struct{}{}
+	h.mutex.Unlock()
+
+	return events, func() {
+		h.mutex.Lock()
+		delete(h.clients, events)
+		h.mutex.Unlock()
+	}
+}
+
+// Sends the event to every client without waiting on slow ones
+func (h *liveHub) publish(e liveEvent) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for events := range h.clients {
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
+
+// Subscribes the hub to changes made through the class and document services
+func (s *Server) watchChanges() {
+	// Events carry no request, so actor names are looked up on a background
+	// context. Handlers run inside the request making the change, and the
+	// repository timeouts bound the lookup.
+	ctx := context.Background()
+	for _, op := range []event.Op{event.Insert, event.Update, event.Delete} {
+		s.classService.Events().After(op, func(e *event.Event[class.Class]) error {
+			s.live.publish(liveEvent{
+				Kind:    "class",
+				Action:  liveAction(e.Op, e.Model.Deleted, e.Previous.Deleted),
+				Id:      e.Model.Id,
+				ClassId: e.Model.Id,
+				Title:   e.Model.Name,
+				ActorId: e.Actor,
+				Actor:   s.userName(ctx, e.Actor),
+				Time:    time.Now(),
+			})
+			return nil
+		})
+		s.documentService.Events().After(op, func(e *event.Event[document.Document]) error {
+			s.live.publish(liveEvent{
+				Kind:    "document",
+				Action:  liveAction(e.Op, e.Model.Deleted, e.Previous.Deleted),
+				Id:      e.Model.Id,
+				ClassId: e.Model.ClassId,
+				Title:   e.Model.Title,
+				ActorId: e.Actor,
+				Actor:   s.userName(ctx, e.Actor),
+				Time:    time.Now(),
+			})
+			return nil
+		})
+	}
+}
+
+// Streams class and document changes as server-sent events. Every admin may
+// see every class, so the feed sits behind the admin login and is narrowed to
+// the class given with the class parameter, if any. Changes made by the
+// viewer are left out as their own pages already show them.
+func (s *Server) HandleLiveEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var classId primitive.ObjectID
+		if hex := c.Query("class"); hex != "" {
+			id, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+			classId = id
+		}
+		viewer := adminUserId(c)
+
+		events, unsubscribe := s.live.subscribe()
+		defer unsubscribe()
+
+		keepAlive := time.NewTicker(liveKeepAlive)
+		defer keepAlive.Stop()
+
+		// Tells the browser the feed is open before the first change comes in
+		c.SSEvent("ready", gin.H{"class_id": classId})
+		c.Writer.Flush()
+
+		done := c.Request.Context().Done()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-done:
+				return false
+			case <-keepAlive.C:
+				io.WriteString(w, ":\n\n")
+			case e := <-events:
+				if !classId.IsZero() && e.ClassId != classId {
+					return true
+				}
+				if !viewer.IsZero() && e.ActorId == viewer {
+					return true
+				}
+				c.SSEvent(e.Kind, e)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/event"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLiveAction(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, "created", liveAction(event.Insert, time.Time{}, time.Time{}))
+	assert.Equal(t, "updated", liveAction(event.Update, time.Time{}, time.Time{}))
+	assert.Equal(t, "trashed", liveAction(event.Update, now, time.Time{}))
+	assert.Equal(t, "restored", liveAction(event.Update, time.Time{}, now))
+	assert.Equal(t, "deleted", liveAction(event.Delete, now, time.Time{}))
+}
+
+func TestLiveEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+	router := s.router
+	router.GET("/admin/events", s.HandleLiveEvents())
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	pages := class.Class{Name: "Pages", Slug: "pages"}
+	assert.NoError(t, classService.Insert(ctx, &pages))
+	posts := class.Class{Name: "Posts", Slug: "posts"}
+	assert.NoError(t, classService.Insert(ctx, &posts))
+
+	t.Run("Invalid Class", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/admin/events?class=pages")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/admin/events?class="+pages.Id.Hex(), nil)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"))
+
+		// Reads the next event, skipping keep-alive comments
+		lines := bufio.NewScanner(resp.Body)
+		next := func() (name string, e liveEvent) {
+			for lines.Scan() {
+				line := lines.Text()
+				switch {
+				case strings.HasPrefix(line, "event:"):
+					name = strings.TrimPrefix(line, "event:")
+				case strings.HasPrefix(line, "data:") && name != "ready":
+					assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &e))
+				case line == "" && name != "":
+					return
+				}
+			}
+			t.Fatal(lines.Err())
+			return
+		}
+
+		name, _ := next()
+		assert.Equal(t, "ready", name)
+
+		// Changes to other classes are filtered out
+		other := document.Document{ClassId: posts.Id, Title: "Elsewhere", Slug: "elsewhere"}
+		assert.NoError(t, docService.Insert(ctx, &other))
+
+		editor := primitive.NewObjectID()
+		about := document.Document{ClassId: pages.Id, Title: "About", Slug: "about"}
+		assert.NoError(t, docService.As(editor).Insert(ctx, &about))
+		name, e := next()
+		assert.Equal(t, "document", name)
+		assert.Equal(t, "created", e.Action)
+		assert.Equal(t, about.Id, e.Id)
+		assert.Equal(t, editor, e.ActorId)
+		assert.Equal(t, "About", e.Title)
+
+		assert.NoError(t, docService.Trash(ctx, about, editor))
+		name, e = next()
+		assert.Equal(t, "document", name)
+		assert.Equal(t, "trashed", e.Action)
+
+		pages.MenuLabel = "Site Pages"
+		assert.NoError(t, classService.Update(ctx, &pages))
+		name, e = next()
+		assert.Equal(t, "class", name)
+		assert.Equal(t, "updated", e.Action)
+		assert.Equal(t, pages.Id, e.ClassId)
+	})
+}
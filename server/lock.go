@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/lock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Shown in the document builder while someone else is editing the document
+type EditLock struct {
+	lock.Lock
+	// Display name of the lock holder, blank if unknown
+	Editor string
+}
+
+// Takes or refreshes the viewer's lock on the document. When another user
+// holds it their lock is returned instead and the viewer must not save.
+func (s *Server) editLock(ctx context.Context, documentId, viewer primitive.ObjectID) (held *EditLock, err error) {
+	l, err := s.lockService.Acquire(documentId, viewer)
+	if errors.Is(err, lock.ErrLocked) {
+		return &EditLock{Lock: l, Editor: s.userName(ctx, l.UserId)}, nil
+	}
+	return
+}
+
+// How often the builder refreshes its lock, leaving room for a few missed
+// beats before the lock runs out
+func (s *Server) lockHeartbeat() time.Duration {
+	return s.lockService.TTL() / 4
+}
+
+// Handles the lock requests sent by the document builder. A heartbeat takes or
+// extends the viewer's lock, release gives it up when the editor leaves the
+// page and break removes whoever's lock is in the way.
+func (s *Server) HandleDocumentLock(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var class class.Class
+
+		// Class gauranteed to be set from middleware preceding this handler
+		_ = getContext(c, "class", &class)
+
+		id, err := primitive.ObjectIDFromHex(c.Param("doc_id"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		viewer := adminUserId(c)
+
+		switch action {
+		case "heartbeat":
+			held, err := s.editLock(c.Request.Context(), id, viewer)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			if held != nil {
+				c.JSON(http.StatusLocked, gin.H{
+					"success": false,
+					"error":   lock.ErrLocked.Error(),
+					"editor":  held.Editor,
+					"expires": held.Expires,
+				})
+				return
+			}
+			current, err := s.lockService.Get(id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true, "expires": current.Expires})
+		case "release":
+			if err := s.lockService.Release(id, viewer); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		case "break":
+			if err := s.lockService.Break(id); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			c.Redirect(http.StatusSeeOther, "/admin/classes/"+class.Slug+"/"+id.Hex())
+		default:
+			c.AbortWithStatus(http.StatusNotFound)
+		}
+	}
+}
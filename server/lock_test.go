@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDocumentLocks(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService, lockService := s.classService, s.documentService, s.lockService
+	router := s.router
+
+	// Stands in for the login, logging each request in as the user it names
+	router.Use(sessions.Sessions("gocms", cookie.NewStore([]byte("secret"))))
+	router.Use(func(c *gin.Context) {
+		if id, err := primitive.ObjectIDFromHex(c.GetHeader("X-User")); err == nil {
+			sessions.Default(c).Set("adminUserId", id)
+		}
+	})
+	router.GET("/admin/classes/:class/:doc_id", s.MiddlewareClass(), s.HandleDocumentBuilder())
+	router.POST("/admin/classes/:class/:doc_id", s.MiddlewareClass(), s.HandleDocumentBuilder())
+	router.POST("/admin/classes/:class/:doc_id/lock", s.MiddlewareClass(), s.HandleDocumentLock("heartbeat"))
+	router.POST("/admin/classes/:class/:doc_id/lock/release", s.MiddlewareClass(), s.HandleDocumentLock("release"))
+	router.POST("/admin/classes/:class/:doc_id/lock/break", s.MiddlewareClass(), s.HandleDocumentLock("break"))
+
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	serve := func(method, path string, as primitive.ObjectID, values url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-User", as.Hex())
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	pages := class.Class{
+		Name: "Pages",
+		Slug: "pages",
+		Fields: []field.Field{
+			{Name: "body", Label: "Body", Type: field.TypeText},
+		},
+	}
+	assert.NoError(t, classService.Insert(ctx, &pages))
+	about := document.Document{ClassId: pages.Id, Title: "About", Slug: "about"}
+	assert.NoError(t, docService.Insert(ctx, &about))
+	path := "/admin/classes/pages/" + about.Id.Hex()
+
+	t.Run("Builder", func(t *testing.T) {
+		w := serve(http.MethodGet, path, alice, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.False(t, strings.Contains(w.Body.String(), "is being edited by"))
+		assert.True(t, strings.Contains(w.Body.String(), "EditLock.hold()"))
+
+		held, err := lockService.Get(about.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, alice, held.UserId)
+
+		w = serve(http.MethodGet, path, bob, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.True(t, strings.Contains(body, "This document is being edited by another editor"))
+		assert.True(t, strings.Contains(body, path+"/lock/break"))
+		assert.False(t, strings.Contains(body, "EditLock.hold()"))
+
+		// Saves from anyone but the holder are turned away
+		w = serve(http.MethodPost, path, bob, url.Values{
+			"version": {strconv.FormatInt(about.Version, 10)},
+			"title":   {"Bob's About"},
+			"slug":    {"about"},
+		})
+		assert.Equal(t, http.StatusLocked, w.Code)
+		stored, err := docService.GetById(ctx, about.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "About", stored.Title)
+	})
+
+	t.Run("Heartbeat", func(t *testing.T) {
+		w := serve(http.MethodPost, path+"/lock", alice, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result struct {
+			Success bool
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.True(t, result.Success)
+
+		w = serve(http.MethodPost, path+"/lock", bob, nil)
+		assert.Equal(t, http.StatusLocked, w.Code)
+	})
+
+	t.Run("Break", func(t *testing.T) {
+		w := serve(http.MethodPost, path+"/lock/break", bob, nil)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, path, w.Header().Get("Location"))
+
+		// Bob now holds the lock and Alice's next heartbeat tells her so
+		w = serve(http.MethodGet, path, bob, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = serve(http.MethodPost, path+"/lock", alice, nil)
+		assert.Equal(t, http.StatusLocked, w.Code)
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		// Releasing someone else's lock leaves it in place
+		w := serve(http.MethodPost, path+"/lock/release", alice, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		held, err := lockService.Get(about.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, bob, held.UserId)
+
+		w = serve(http.MethodPost, path+"/lock/release", bob, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, err = lockService.Get(about.Id)
+		assert.Error(t, err)
+
+		w = serve(http.MethodPost, path, alice, url.Values{
+			"version": {strconv.FormatInt(about.Version, 10)},
+			"title":   {"Alice's About"},
+			"slug":    {"about"},
+		})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+)
+
+// Renders the source posted by a Markdown field in the document builder so
+// writers can preview it as it will be published
+func (s *Server) HandleMarkdownPreview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var class class.Class
+
+		// Class gauranteed to be set by middleware preceding this handler
+		_ = getContext(c, "class", &class)
+
+		name := c.PostForm("field")
+		for _, f := range class.AllFields() {
+			if f.Type != field.TypeMarkdown || f.Name != name {
+				continue
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"html":    s.documentService.RenderMarkdown(ctx, class, f, c.PostForm("source")),
+			})
+			return
+		}
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("class %s has no markdown field %s", class.Slug, name),
+		})
+	}
+}
+
+// Rendered HTML of every Markdown field in the document, keyed by field name
+func (s *Server) markdownHTML(ctx context.Context, class class.Class, doc document.Document) map[string]string {
+	var rendered map[string]string
+	for _, f := range class.AllFields() {
+		source, ok := doc.Values[f.Name].(string)
+		if f.Type != field.TypeMarkdown || !ok {
+			continue
+		}
+		if rendered == nil {
+			rendered = make(map[string]string)
+		}
+		rendered[f.Name] = s.documentService.RenderMarkdown(ctx, class, f, source)
+	}
+	return rendered
+}
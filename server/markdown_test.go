@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+)
+
+func TestMarkdown(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+
+	c := class.Class{
+		Name: "Pages",
+		Slug: "pages",
+		Fields: []field.Field{
+			{Name: "body", Label: "Body", Type: field.TypeMarkdown},
+		},
+	}
+	assert.NoError(t, classService.Insert(ctx, &c))
+
+	about := document.Document{
+		ClassId:   c.Id,
+		Slug:      "about",
+		Published: time.Now().Add(-time.Hour),
+		Values:    map[string]interface{}{"body": "See [home](doc:home) <script>alert(1)</script>"},
+	}
+	assert.NoError(t, docService.Insert(ctx, &about))
+	home := document.Document{ClassId: c.Id, Slug: "home", Published: time.Now().Add(-time.Hour)}
+	assert.NoError(t, docService.Insert(ctx, &home))
+
+	preview := func(values url.Values) (code int, body struct {
+		Success bool
+		HTML    string
+	}) {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/admin/classes/pages/preview", strings.NewReader(values.Encode()))
+		ctx.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx.Set("class", c)
+		s.HandleMarkdownPreview()(ctx)
+		ctx.Writer.WriteHeaderNow()
+		json.NewDecoder(w.Body).Decode(&body)
+		return w.Code, body
+	}
+
+	t.Run("Preview", func(t *testing.T) {
+		code, body := preview(url.Values{"field": {"body"}, "source": {"**Hi** [about](doc:about)"}})
+		assert.Equal(t, http.StatusOK, code)
+		assert.True(t, body.Success)
+		assert.Equal(t, `<p><strong>Hi</strong> <a href="/pages/about">about</a></p>`+"\n", body.HTML)
+	})
+
+	t.Run("Preview Unknown Field", func(t *testing.T) {
+		code, body := preview(url.Values{"field": {"title"}, "source": {"x"}})
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.False(t, body.Success)
+	})
+
+	t.Run("API", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.Routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/classes/pages/documents", nil))
+
+		var body struct {
+			Documents []APIDocument
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, 2, len(body.Documents))
+		for _, doc := range body.Documents {
+			if doc.Slug != "about" {
+				continue
+			}
+			assert.Equal(t, about.Values["body"], doc.Values["body"])
+			assert.Equal(t, `<p>See <a href="/pages/home">home</a> </p>`+"\n", doc.HTML["body"])
+		}
+	})
+}
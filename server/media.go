@@ -0,0 +1,303 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/media"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Number of recent media offered as suggestions by upload fields
+const mediaOptionCount = 50
+
+// Size of the previews shown in the admin
+var thumbnailOptions = media.ImageOptions{Width: 320, Height: 320}
+
+func (s *Server) HandleMediaLibrary() gin.HandlerFunc {
+	name := "admin-media-library"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/media-library.html",
+	)))
+
+	return func(c *gin.Context) {
+		page, _ := pageParams(c)
+
+		params := media.MediaListParams{
+			Page: page,
+			Size: 24,
+		}
+		list, err := s.mediaService.List(params)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		obj := gin.H{
+			"Media":      list.Media,
+			"Thumbnails": s.thumbnails(list.Media),
+			"Pagination": NewPagination(params.Page, params.Size, list.Total),
+			"Error":      c.Query("error"),
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
+
+// Stores every file posted under files. JSON requests get the new media back,
+// everyone else returns to the library.
+func (s *Server) HandleMediaUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploaded := make([]media.Media, 0, 1)
+
+		form, err := c.MultipartForm()
+		if err == nil && len(form.File["files"]) == 0 {
+			err = fmt.Errorf("no files uploaded")
+		}
+		if err == nil {
+			for _, header := range form.File["files"] {
+				var m media.Media
+				if m, err = s.uploadFile(header); err != nil {
+					break
+				}
+				uploaded = append(uploaded, m)
+			}
+		}
+
+		if c.GetHeader("Accept") == "application/json" {
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"error":   err.Error(),
+				})
+				return
+			}
+			c.JSON(http.StatusCreated, gin.H{
+				"success": true,
+				"media":   uploaded,
+			})
+			return
+		}
+
+		target := "/admin/media"
+		if err != nil {
+			target += "?error=" + url.QueryEscape(err.Error())
+		}
+		c.Redirect(http.StatusSeeOther, target)
+	}
+}
+
+func (s *Server) HandleMediaDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		m, err := s.mediaService.GetById(id)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		target := "/admin/media"
+		if err := s.mediaService.Delete(m); err != nil {
+			target += "?error=" + url.QueryEscape(err.Error())
+		}
+		c.Redirect(http.StatusSeeOther, target)
+	}
+}
+
+// Sent with media so a file opened directly can neither load anything nor run
+// script
+const mediaPolicy = "default-src 'none'; style-src 'unsafe-inline'; sandbox"
+
+// Serves the contents of a media item. Contents never change for an ID, so
+// they may be cached indefinitely.
+func (s *Server) HandleMedia() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		m, err := s.mediaService.GetById(id)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		etag := `"` + m.Checksum + `"`
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		rc, err := s.mediaService.Open(m)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		defer rc.Close()
+
+		// Uploads are public and share the admin's origin, so only plain images
+		// are shown in place. Everything else is downloaded and sandboxed in
+		// case a browser renders it anyway.
+		disposition := "attachment"
+		if m.Inline() {
+			disposition = "inline"
+		}
+		c.DataFromReader(http.StatusOK, m.Size, m.MimeType, rc, map[string]string{
+			"Content-Disposition":     mime.FormatMediaType(disposition, map[string]string{"filename": m.Filename}),
+			"Content-Security-Policy": mediaPolicy,
+			"X-Content-Type-Options":  "nosniff",
+		})
+	}
+}
+
+// Serves a resized copy of an image. The options must be signed, see
+// ImageURL.
+func (s *Server) HandleImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		opts, err := media.ParseImageOptions(c.Request.URL.Query())
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if !media.VerifyImage(s.imageSecret, id, opts, c.Query("s")) {
+			c.AbortWithError(http.StatusForbidden, fmt.Errorf("invalid image signature"))
+			return
+		}
+
+		m, err := s.mediaService.GetById(id)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		etag := `"` + m.Checksum + "-" + opts.Encode() + `"`
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		rc, mimeType, err := s.mediaService.Derivative(m, opts)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		defer rc.Close()
+
+		c.DataFromReader(http.StatusOK, -1, mimeType, rc, map[string]string{
+			"Content-Security-Policy": mediaPolicy,
+			"X-Content-Type-Options":  "nosniff",
+		})
+	}
+}
+
+// Builds the signed URL of a resized copy of an image
+func (s *Server) ImageURL(m media.Media, opts media.ImageOptions) string {
+	query := opts.Encode() + "&s=" + media.SignImage(s.imageSecret, m.Id, opts)
+	return "/media/" + m.Id.Hex() + "/image?" + query
+}
+
+// Preview URLs keyed by media ID. Images which could not be decoded on upload
+// have no size and no preview.
+func (s *Server) thumbnails(list []media.Media) map[string]string {
+	urls := make(map[string]string, len(list))
+	for _, m := range list {
+		if m.IsImage() && m.Width > 0 {
+			urls[m.Id.Hex()] = s.ImageURL(m, thumbnailOptions)
+		}
+	}
+	return urls
+}
+
+func (s *Server) uploadFile(header *multipart.FileHeader) (m media.Media, err error) {
+	f, err := header.Open()
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return s.mediaService.Upload(header.Filename, f)
+}
+
+// Upload fields post a new file under name.upload, which takes the place of
+// the media ID posted under the field name
+func (s *Server) uploadValue(c *gin.Context, f field.Field) (interface{}, error) {
+	header, err := c.FormFile(f.Name + ".upload")
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		return c.PostForm(f.Name), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.uploadFile(header)
+	if err != nil {
+		return nil, err
+	}
+	return m.Id, nil
+}
+
+// Looks up the media referenced by every upload field, keyed by field name,
+// along with recent media to choose from. Missing media are left out so the
+// builder only offers the ID.
+func (s *Server) uploadOptions(fields []field.Field, doc document.Document) (uploads map[string]*media.Media, options []media.Media, err error) {
+	uploads = make(map[string]*media.Media)
+	if !hasUploadField(fields) {
+		return
+	}
+
+	for _, f := range fields {
+		if f.Type != field.TypeUpload {
+			continue
+		}
+		id, ok := doc.Values[f.Name].(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		if m, err := s.mediaService.GetById(id); err == nil {
+			uploads[f.Name] = &m
+		}
+	}
+
+	list, err := s.mediaService.List(media.MediaListParams{Page: 1, Size: mediaOptionCount})
+	options = list.Media
+	return
+}
+
+func hasUploadField(fields []field.Field) bool {
+	for _, f := range fields {
+		if f.Type == field.TypeUpload {
+			return true
+		}
+	}
+	return false
+}
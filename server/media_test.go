@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/media"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Builds a multipart body holding the form values and a single file
+func multipartBody(t *testing.T, values map[string]string, fileField, filename, contents string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range values {
+		assert.NoError(t, writer.WriteField(key, value))
+	}
+	if fileField != "" {
+		part, err := writer.CreateFormFile(fileField, filename)
+		assert.NoError(t, err)
+		_, err = part.Write([]byte(contents))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	return &body, writer.FormDataContentType()
+}
+
+func TestMedia(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService, mediaService := s.classService, s.documentService, s.mediaService
+
+	var uploaded media.Media
+
+	t.Run("Upload", func(t *testing.T) {
+		body, contentType := multipartBody(t, nil, "files", "notes.txt", "hello")
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/admin/media", body)
+		c.Request.Header.Set("Content-Type", contentType)
+		c.Request.Header.Set("Accept", "application/json")
+		s.HandleMediaUpload()(c)
+		c.Writer.WriteHeaderNow()
+
+		var response struct {
+			Media []media.Media
+		}
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, 1, len(response.Media))
+		assert.Equal(t, "notes.txt", response.Media[0].Filename)
+		uploaded = response.Media[0]
+	})
+
+	t.Run("Upload Nothing", func(t *testing.T) {
+		body, contentType := multipartBody(t, nil, "", "", "")
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/admin/media", body)
+		c.Request.Header.Set("Content-Type", contentType)
+		s.HandleMediaUpload()(c)
+		c.Writer.WriteHeaderNow()
+
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/admin/media?error=no+files+uploaded", w.Header().Get("Location"))
+	})
+
+	t.Run("Serve", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: uploaded.Id.Hex()}}
+		c.Request = httptest.NewRequest(http.MethodGet, "/media/"+uploaded.Id.Hex(), nil)
+		s.HandleMedia()(c)
+		c.Writer.WriteHeaderNow()
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "hello", w.Body.String())
+		assert.Equal(t, uploaded.MimeType, w.Header().Get("Content-Type"))
+
+		// Cached copies are still good
+		w = httptest.NewRecorder()
+		c, _ = gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: uploaded.Id.Hex()}}
+		c.Request = httptest.NewRequest(http.MethodGet, "/media/"+uploaded.Id.Hex(), nil)
+		c.Request.Header.Set("If-None-Match", `"`+uploaded.Checksum+`"`)
+		s.HandleMedia()(c)
+		c.Writer.WriteHeaderNow()
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	t.Run("Serve Unsafe", func(t *testing.T) {
+		serve := func(m media.Media) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{{Key: "id", Value: m.Id.Hex()}}
+			c.Request = httptest.NewRequest(http.MethodGet, "/media/"+m.Id.Hex(), nil)
+			s.HandleMedia()(c)
+			c.Writer.WriteHeaderNow()
+			return w
+		}
+
+		// Script in an upload must not run on the admin's origin
+		for filename, contents := range map[string]string{
+			"page.html": "<html><script>alert(1)</script></html>",
+			"logo.svg":  `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`,
+		} {
+			m, err := mediaService.Upload(filename, strings.NewReader(contents))
+			assert.NoError(t, err)
+			w := serve(m)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.True(t, strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment"))
+			assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+			assert.True(t, strings.Contains(w.Header().Get("Content-Security-Policy"), "sandbox"))
+		}
+
+		var buf bytes.Buffer
+		assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+		photo, err := mediaService.Upload("photo.png", &buf)
+		assert.NoError(t, err)
+		w := serve(photo)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Disposition"), "inline"))
+	})
+
+	t.Run("Image", func(t *testing.T) {
+		s.SetImageSecret([]byte("secret"))
+
+		var buf bytes.Buffer
+		assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))))
+		photo, err := mediaService.Upload("photo.png", &buf)
+		assert.NoError(t, err)
+
+		request := func(target string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{{Key: "id", Value: photo.Id.Hex()}}
+			c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+			s.HandleImage()(c)
+			c.Writer.WriteHeaderNow()
+			return w
+		}
+
+		target := s.ImageURL(photo, media.ImageOptions{Width: 10, Fit: media.FitContain})
+		w := request(target)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		resized, err := png.Decode(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, 10, resized.Bounds().Dx())
+		assert.Equal(t, 5, resized.Bounds().Dy())
+
+		// Changing the options invalidates the signature
+		w = request(strings.Replace(target, "w=10", "w=20", 1))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = request("/media/" + photo.Id.Hex() + "/image?w=10")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// Files which are not images cannot be resized
+		target = s.ImageURL(uploaded, media.ImageOptions{Width: 10})
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Params = gin.Params{{Key: "id", Value: uploaded.Id.Hex()}}
+		c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+		s.HandleImage()(c)
+		c.Writer.WriteHeaderNow()
+		assert.Equal(t, http.StatusUnprocessableEntity, c.Writer.Status())
+	})
+
+	t.Run("Upload Field", func(t *testing.T) {
+		c := class.Class{
+			Name: "Posts",
+			Slug: "posts",
+			Fields: []field.Field{
+				{Name: "attachment", Label: "Attachment", Type: field.TypeUpload},
+				{Name: "existing", Label: "Existing", Type: field.TypeUpload},
+			},
+		}
+		assert.NoError(t, classService.Insert(ctx, &c))
+
+		values := map[string]string{
+			"title":     "Post",
+			"slug":      "post",
+			"published": "2022-05-01T12:00",
+			"existing":  uploaded.Id.Hex(),
+		}
+		body, contentType := multipartBody(t, values, "attachment.upload", "photo.txt", "photo")
+
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/admin/classes/posts/new", body)
+		ctx.Request.Header.Set("Content-Type", contentType)
+		ctx.Set("class", c)
+		s.HandleDocumentBuilder()(ctx)
+		ctx.Writer.WriteHeaderNow()
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+
+		doc, err := docService.GetClassChildBySlug(ctx, c.Id, "post")
+		assert.NoError(t, err)
+		assert.Equal(t, uploaded.Id, doc.Values["existing"])
+
+		id, ok := doc.Values["attachment"].(primitive.ObjectID)
+		assert.True(t, ok)
+		attachment, err := mediaService.GetById(id)
+		assert.NoError(t, err)
+		assert.Equal(t, "photo.txt", attachment.Filename)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: uploaded.Id.Hex()}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/admin/media/"+uploaded.Id.Hex()+"/delete", nil)
+		s.HandleMediaDelete()(c)
+		c.Writer.WriteHeaderNow()
+
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		_, err := mediaService.GetById(uploaded.Id)
+		assert.Error(t, err)
+	})
+}
@@ -30,13 +30,14 @@ func (s *Server) MiddlewareAdminAuth(loginPath string) gin.HandlerFunc {
 
 func (s *Server) MiddlewareClass() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		ctx := c.Request.Context()
 		slug := c.Param("class")
 		if slug == "" {
 			c.AbortWithStatus(http.StatusNotFound)
 			return
 		}
 
-		class, err := s.classService.GetBySlug(slug)
+		class, err := s.classService.GetBySlug(ctx, slug)
 		if err != nil {
 			c.AbortWithError(http.StatusNotFound, err)
 			return
@@ -49,7 +50,8 @@ func (s *Server) MiddlewareClass() gin.HandlerFunc {
 
 func (s *Server) MiddlewareNavBar() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		all, err := s.classService.All()
+		ctx := c.Request.Context()
+		all, err := s.classService.All(ctx)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
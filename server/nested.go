@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+)
+
+// A single sub-field input of a repeater item or group. Name is the form
+// input name, made of the parent and sub-field names.
+type NestedInput struct {
+	Field field.Field
+	Name  string
+	Value string
+}
+
+// Holds the inputs of every repeater item, or the only item of a group, along
+// with a blank item the document builder clones when adding to a repeater
+type NestedOptions struct {
+	Items [][]NestedInput
+	Blank []NestedInput
+}
+
+// Builds the inputs for every repeater and group field, keyed by field name
+func nestedOptions(fields []field.Field, doc document.Document) (options map[string]NestedOptions) {
+	options = make(map[string]NestedOptions)
+
+	for _, f := range fields {
+		if !f.IsNested() {
+			continue
+		}
+
+		opts := NestedOptions{Blank: nestedInputs(f, nil)}
+		switch f.Type {
+		case field.TypeRepeater:
+			items, _ := field.Items(doc.Values[f.Name])
+			for _, item := range items {
+				opts.Items = append(opts.Items, nestedInputs(f, item))
+			}
+		case field.TypeGroup:
+			group, _ := field.Group(doc.Values[f.Name])
+			opts.Items = [][]NestedInput{nestedInputs(f, group)}
+		}
+
+		options[f.Name] = opts
+	}
+
+	return
+}
+
+func nestedInputs(f field.Field, values map[string]interface{}) (inputs []NestedInput) {
+	inputs = make([]NestedInput, len(f.Fields))
+	for i, sub := range f.Fields {
+		inputs[i] = NestedInput{
+			Field: sub,
+			Name:  f.Name + "." + sub.Name,
+		}
+		if value, ok := values[sub.Name]; ok && value != nil {
+			inputs[i].Value = fmt.Sprint(value)
+		}
+	}
+	return
+}
+
+// Reads a repeater or group value from the document builder form. Every
+// repeater item posts one input per sub-field, so the nth value of each
+// sub-field belongs to the nth item.
+func nestedValue(c *gin.Context, f field.Field) interface{} {
+	if f.Type == field.TypeGroup {
+		group := make(map[string]interface{}, len(f.Fields))
+		for _, sub := range f.Fields {
+			group[sub.Name] = c.PostForm(f.Name + "." + sub.Name)
+		}
+		return group
+	}
+
+	columns := make(map[string][]string, len(f.Fields))
+	count := 0
+	for _, sub := range f.Fields {
+		columns[sub.Name] = c.PostFormArray(f.Name + "." + sub.Name)
+		if n := len(columns[sub.Name]); n > count {
+			count = n
+		}
+	}
+
+	items := make([]map[string]interface{}, count)
+	for i := range items {
+		items[i] = make(map[string]interface{}, len(f.Fields))
+		for _, sub := range f.Fields {
+			if column := columns[sub.Name]; i < len(column) {
+				items[i][sub.Name] = column[i]
+			} else {
+				items[i][sub.Name] = ""
+			}
+		}
+	}
+	return items
+}
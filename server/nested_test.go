@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/zeebo/assert"
+)
+
+func TestNestedValue(t *testing.T) {
+	faq := field.Field{
+		Type: field.TypeRepeater,
+		Name: "faq",
+		Fields: []field.Field{
+			{Type: field.TypeText, Name: "question", Label: "Question"},
+			{Type: field.TypeTextArea, Name: "answer", Label: "Answer"},
+		},
+	}
+	hero := field.Field{
+		Type: field.TypeGroup,
+		Name: "hero",
+		Fields: []field.Field{
+			{Type: field.TypeText, Name: "heading", Label: "Heading"},
+		},
+	}
+
+	form := url.Values{
+		"faq.question": {"Why?", "How?"},
+		"faq.answer":   {"Because", "Carefully"},
+		"hero.heading": {"Welcome"},
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	expect := []map[string]interface{}{
+		{"question": "Why?", "answer": "Because"},
+		{"question": "How?", "answer": "Carefully"},
+	}
+	assert.DeepEqual(t, expect, nestedValue(c, faq))
+	assert.DeepEqual(t, map[string]interface{}{"heading": "Welcome"}, nestedValue(c, hero))
+
+	t.Run("Options", func(t *testing.T) {
+		doc := document.Document{Values: map[string]interface{}{"faq": expect}}
+		options := nestedOptions([]field.Field{faq, hero}, doc)
+
+		assert.Equal(t, 2, len(options["faq"].Items))
+		assert.Equal(t, "faq.answer", options["faq"].Items[1][1].Name)
+		assert.Equal(t, "Carefully", options["faq"].Items[1][1].Value)
+		assert.Equal(t, "", options["faq"].Blank[0].Value)
+
+		// Groups always have a single item, even before any values are saved
+		assert.Equal(t, 1, len(options["hero"].Items))
+		assert.Equal(t, "", options["hero"].Items[0][0].Value)
+	})
+}
@@ -3,6 +3,16 @@ package server
 import (
 	"fmt"
 	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes lists fall back to and may not exceed when a request picks its
+// own with ?pp
+const (
+	DefaultPerPage = 10
+	MaxPerPage     = 100
 )
 
 type PaginationLink struct {
@@ -21,6 +31,27 @@ type Pagination struct {
 	NextLabel     string
 }
 
+// Reads the page from ?p and the page size from ?pp. Pages start at 1 and
+// sizes are kept between 1 and MaxPerPage so no request can ask for every
+// document at once.
+func pageParams(c *gin.Context) (page, perPage int64) {
+	page, err := strconv.ParseInt(c.Query("p"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err = strconv.ParseInt(c.Query("pp"), 10, 64)
+	switch {
+	case err != nil || perPage == 0:
+		perPage = DefaultPerPage
+	case perPage < 1:
+		perPage = 1
+	case perPage > MaxPerPage:
+		perPage = MaxPerPage
+	}
+	return
+}
+
 func NewPagination(page, perPage, total int64) Pagination {
 	return Pagination{
 		Page:          page,
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits how often each key, such as a client address, may do something
+// within a sliding window
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	mu     sync.Mutex
+	hits   map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+// Records an attempt for the key, reporting whether it is within the limit.
+// Attempts over the limit are not recorded.
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget every key with no recent attempts so the map stays small
+	cutoff := now.Add(-l.window)
+	for k, times := range l.hits {
+		kept := times[:0]
+		for _, t := range times {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.hits, k)
+		} else {
+			l.hits[k] = kept
+		}
+	}
+
+	if len(l.hits[key]) >= l.limit {
+		return false
+	}
+	l.hits[key] = append(l.hits[key], now)
+	return true
+}
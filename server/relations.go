@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Upper bound on the number of documents offered per target class when
+// searching for a document to relate
+const relationSearchLimit = 20
+
+// Holds the currently related documents, in order. Documents to add are
+// searched for through HandleRelationSearch.
+type RelationOptions struct {
+	Selected []document.Document
+}
+
+// A document offered by the relation search
+type RelationChoice struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+	Class string `json:"class"`
+}
+
+// A document referencing the one being edited, paired with its class so the
+// template can build links
+type Referrer struct {
+	Class    class.Class
+	Document document.Document
+}
+
+// Builds the relation pickers for every relation field in the class, keyed by
+// field name
+func (s *Server) relationOptions(ctx context.Context, c class.Class, doc document.Document) (options map[string]RelationOptions, err error) {
+	options = make(map[string]RelationOptions)
+
+	docs := []document.Document{doc}
+	if err = s.documentService.Expand(ctx, docs); err != nil {
+		return
+	}
+
+	for _, f := range c.AllFields() {
+		if f.Type != field.TypeRelation {
+			continue
+		}
+
+		var opts RelationOptions
+		for _, id := range document.RelationIds(doc.Values[f.Name]) {
+			if related, ok := docs[0].Related[id]; ok {
+				opts.Selected = append(opts.Selected, related)
+			}
+		}
+		options[f.Name] = opts
+	}
+
+	return
+}
+
+// Finds documents which may be added to a relation field, matching the q
+// parameter against their titles. The document being edited, passed as
+// exclude, is left out so it cannot relate to itself.
+func (s *Server) HandleRelationSearch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var class class.Class
+
+		// Class gauranteed to be set by middleware preceding this handler
+		_ = getContext(c, "class", &class)
+
+		name := c.Query("field")
+		f := class.Field(name)
+		if f.Type != field.TypeRelation {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("class %s has no relation field %s", class.Slug, name),
+			})
+			return
+		}
+
+		exclude, _ := primitive.ObjectIDFromHex(c.Query("exclude"))
+		choices := make([]RelationChoice, 0, relationSearchLimit)
+		for _, classId := range f.RelationClassIds {
+			target, err := s.classService.GetById(ctx, classId)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+
+			params := document.DocumentListParams{
+				ClassId: classId,
+				Page:    1,
+				Size:    relationSearchLimit,
+				Title:   c.Query("q"),
+			}
+			list, err := s.documentService.List(ctx, params)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			for _, doc := range list.Documents {
+				if doc.Id == exclude {
+					continue
+				}
+				choices = append(choices, RelationChoice{
+					Id:    doc.Id.Hex(),
+					Title: doc.Title,
+					Class: target.SingularName,
+				})
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"documents": choices,
+		})
+	}
+}
+
+// Lists the documents pointing at doc through relation fields
+func (s *Server) referencedBy(ctx context.Context, doc document.Document) (referrers []Referrer, err error) {
+	if doc.Id.IsZero() {
+		return
+	}
+
+	docs, err := s.documentService.ReferencedBy(ctx, doc)
+	if err != nil {
+		return
+	}
+
+	classes := make(map[primitive.ObjectID]class.Class)
+	referrers = make([]Referrer, 0, len(docs))
+	for _, d := range docs {
+		c, ok := classes[d.ClassId]
+		if !ok {
+			if c, err = s.classService.GetById(ctx, d.ClassId); err != nil {
+				return
+			}
+			classes[d.ClassId] = c
+		}
+		referrers = append(referrers, Referrer{Class: c, Document: d})
+	}
+
+	return
+}
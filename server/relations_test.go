@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRelationSearch(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+
+	people := class.Class{Name: "People", Slug: "people", SingularName: "Person"}
+	assert.NoError(t, classService.Insert(ctx, &people))
+	posts := class.Class{
+		Name: "Posts",
+		Slug: "posts",
+		Fields: []field.Field{
+			{Name: "title", Label: "Title", Type: field.TypeText},
+			{Name: "authors", Label: "Authors", Type: field.TypeRelation, RelationClassIds: []primitive.ObjectID{people.Id}},
+		},
+	}
+	assert.NoError(t, classService.Insert(ctx, &posts))
+
+	ada := document.Document{ClassId: people.Id, Slug: "ada", Title: "Ada Lovelace"}
+	assert.NoError(t, docService.Insert(ctx, &ada))
+	alan := document.Document{ClassId: people.Id, Slug: "alan", Title: "Alan Turing"}
+	assert.NoError(t, docService.Insert(ctx, &alan))
+
+	search := func(query url.Values) (code int, body struct {
+		Success   bool
+		Documents []RelationChoice
+	}) {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/admin/classes/posts/relations?"+query.Encode(), nil)
+		ctx.Set("class", posts)
+		s.HandleRelationSearch()(ctx)
+		ctx.Writer.WriteHeaderNow()
+		json.NewDecoder(w.Body).Decode(&body)
+		return w.Code, body
+	}
+
+	t.Run("Title", func(t *testing.T) {
+		code, body := search(url.Values{"field": {"authors"}, "q": {"LOVE"}})
+		assert.Equal(t, http.StatusOK, code)
+		assert.True(t, body.Success)
+		assert.Equal(t, 1, len(body.Documents))
+		assert.Equal(t, ada.Id.Hex(), body.Documents[0].Id)
+		assert.Equal(t, "Ada Lovelace", body.Documents[0].Title)
+		assert.Equal(t, "Person", body.Documents[0].Class)
+	})
+
+	t.Run("Empty Query", func(t *testing.T) {
+		_, body := search(url.Values{"field": {"authors"}})
+		assert.Equal(t, 2, len(body.Documents))
+	})
+
+	t.Run("Exclude", func(t *testing.T) {
+		_, body := search(url.Values{"field": {"authors"}, "exclude": {ada.Id.Hex()}})
+		assert.Equal(t, 1, len(body.Documents))
+		assert.Equal(t, alan.Id.Hex(), body.Documents[0].Id)
+	})
+
+	t.Run("Not A Relation", func(t *testing.T) {
+		code, body := search(url.Values{"field": {"title"}})
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.False(t, body.Success)
+	})
+}
@@ -17,24 +17,41 @@ func (s *Server) Routes() *gin.Engine {
 	store := cookie.NewStore(authKey)
 	router.Use(sessions.Sessions("gocms", store))
 
-	// router.GET("/assets/:filename", s.HandleAsset())
-	// router.GET("/forms/:id", s.HandleForm())
-	// router.POST("/forms/:id", s.HandleForm())
+	router.GET("/assets/:filename", s.HandleAsset())
+	router.GET("/forms/:id", s.HandleForm())
+	router.POST("/forms/:id", s.HandleForm())
+
+	// Anything not matched by another route is looked up as a document
+	router.NoRoute(s.HandleSite())
+
+	router.GET("/media/:id", s.HandleMedia())
+	router.GET("/media/:id/image", s.HandleImage())
+
+	api := router.Group("/api")
+	{
+		class := api.Group("/classes/:class")
+		class.Use(s.MiddlewareClass())
+		{
+			class.GET("/documents", s.HandleAPIDocumentList())
+		}
+	}
 
 	admin := router.Group("/admin")
 	admin.Use(s.MiddlewareAdminAuth("/admin/login"))
 	admin.Use(s.MiddlewareNavBar())
 	{
 		admin.GET("/", func(c *gin.Context) {
-			c.Redirect(http.StatusPermanentRedirect, "/admin/login")
+			c.Redirect(http.StatusSeeOther, "/admin/dashboard")
 		})
 
 		admin.GET("/login", s.HandleAdminLogin())
 		admin.POST("/login", s.HandleAdminLogin())
 
-		// Send successful logins here. Maybe just display a table with classes
-		// and document counts?
-		// admin.GET("/dashboard", s.HandleAdminDashboard())
+		// Successful logins are sent here
+		admin.GET("/dashboard", s.HandleAdminDashboard())
+
+		// Live changes for the document list and builder
+		admin.GET("/events", s.HandleLiveEvents())
 
 		classes := admin.Group("/classes")
 		{
@@ -46,18 +63,57 @@ func (s *Server) Routes() *gin.Engine {
 				class.GET("/", s.HandleDocumentList())
 				class.GET("/edit", s.HandleClassBuilder())
 				class.POST("/edit", s.HandleClassBuilder())
+				class.GET("/delete", s.HandleClassDelete())
+				class.POST("/delete", s.HandleClassDelete())
 				class.GET("/fields", s.HandleClassFieldBuilderGet())
 				class.POST("/fields", s.HandleClassFieldBuilderPost())
 				class.GET("/new", s.HandleDocumentBuilder())
 				class.POST("/new", s.HandleDocumentBuilder())
+				class.POST("/preview", s.HandleMarkdownPreview())
+				class.GET("/relations", s.HandleRelationSearch())
 				class.GET("/:doc_id", s.HandleDocumentBuilder())
 				class.POST("/:doc_id", s.HandleDocumentBuilder())
+				class.POST("/:doc_id/delete", s.HandleDocumentTrash())
+				class.POST("/:doc_id/lock", s.HandleDocumentLock("heartbeat"))
+				class.POST("/:doc_id/lock/release", s.HandleDocumentLock("release"))
+				class.POST("/:doc_id/lock/break", s.HandleDocumentLock("break"))
 			}
 		}
-		// forms := admin.Group("/forms")
-		// 	forms.GET("/new", s.HandleFormBuilder())
-		// 	forms.POST("/new", s.HandleFormBuilder())
-		// }
+
+		admin.GET("/media", s.HandleMediaLibrary())
+		admin.POST("/media", s.HandleMediaUpload())
+		admin.POST("/media/:id/delete", s.HandleMediaDelete())
+
+		admin.GET("/audit", s.HandleAuditLog())
+		admin.GET("/audit/export", s.HandleAuditExport())
+
+		admin.GET("/trash", s.HandleTrash())
+		admin.POST("/trash/:kind/:id/restore", s.HandleTrashAction("restore"))
+		admin.POST("/trash/:kind/:id/purge", s.HandleTrashAction("purge"))
+
+		forms := admin.Group("/forms")
+		{
+			forms.GET("/", s.HandleFormList())
+			forms.GET("/new", s.HandleFormBuilder())
+			forms.POST("/new", s.HandleFormBuilder())
+			forms.GET("/:id", s.HandleFormBuilder())
+			forms.POST("/:id", s.HandleFormBuilder())
+			forms.POST("/:id/delete", s.HandleFormDelete())
+			forms.GET("/:id/submissions", s.HandleFormSubmissions())
+			forms.GET("/:id/export", s.HandleFormExport())
+		}
+
+		webhooks := admin.Group("/webhooks")
+		{
+			webhooks.GET("/", s.HandleWebhookList())
+			webhooks.GET("/new", s.HandleWebhookBuilder())
+			webhooks.POST("/new", s.HandleWebhookBuilder())
+			webhooks.GET("/:id", s.HandleWebhookBuilder())
+			webhooks.POST("/:id", s.HandleWebhookBuilder())
+			webhooks.POST("/:id/delete", s.HandleWebhookDelete())
+			webhooks.GET("/:id/deliveries", s.HandleWebhookDeliveries())
+			webhooks.POST("/:id/deliveries/:delivery_id/redeliver", s.HandleWebhookRedeliver())
+		}
 	}
 
 	return router
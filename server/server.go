@@ -1,39 +1,126 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"time"
+
 	"github.com/gin-contrib/multitemplate"
 	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
+	"github.com/jbaikge/gocms/theme"
+)
+
+// How long items stay in the trash before they are purged, unless changed
+// with SetTrashRetention
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// How many submissions each address may make to public forms within the
+// window, unless changed with SetFormRateLimit
+const (
+	DefaultFormRateLimit  = 5
+	DefaultFormRateWindow = 10 * time.Minute
 )
 
 type Server struct {
+	auditService    audit.AuditService
 	classService    class.ClassService
 	documentService document.DocumentService
+	formService     form.FormService
+	lockService     lock.LockService
+	mediaService    media.MediaService
 	userService     user.UserService
+	webhookService  webhook.WebhookService
 	renderer        multitemplate.Renderer
 	router          *gin.Engine
+	retention       time.Duration
+	imageSecret     []byte
+	theme           *theme.Theme
+	formLimiter     *rateLimiter
+	live            *liveHub
 }
 
 func New(
 	router *gin.Engine,
+	auditService audit.AuditService,
 	classService class.ClassService,
 	documentService document.DocumentService,
+	formService form.FormService,
+	lockService lock.LockService,
+	mediaService media.MediaService,
 	userService user.UserService,
+	webhookService webhook.WebhookService,
 ) *Server {
 	renderer := multitemplate.NewRenderer()
 	router.HTMLRender = renderer
-	return &Server{
+
+	// Image URLs signed with a random secret only last until the next restart
+	imageSecret := make([]byte, 32)
+	rand.Read(imageSecret)
+
+	s := &Server{
+		auditService:    auditService,
 		classService:    classService,
 		documentService: documentService,
+		formService:     formService,
+		lockService:     lockService,
+		mediaService:    mediaService,
 		userService:     userService,
+		webhookService:  webhookService,
 		renderer:        renderer,
 		router:          router,
+		retention:       DefaultTrashRetention,
+		imageSecret:     imageSecret,
+		theme:           theme.Must(theme.New("", false)),
+		formLimiter:     newRateLimiter(DefaultFormRateLimit, DefaultFormRateWindow),
+		live:            newLiveHub(),
 	}
+	s.watchChanges()
+	return s
+}
+
+// Sets the secret image URLs are signed with. Frontends signing their own
+// URLs need the same secret.
+func (s *Server) SetImageSecret(secret []byte) {
+	s.imageSecret = secret
 }
 
-func (s Server) Run(listenAddress string) error {
-	routes := s.Routes()
-	return routes.Run(listenAddress)
+// Sets how many submissions each address may make to public forms within the
+// window
+func (s *Server) SetFormRateLimit(limit int, window time.Duration) {
+	s.formLimiter = newRateLimiter(limit, window)
+}
+
+// Sets the theme the public site is rendered with, replacing the default
+func (s *Server) SetTheme(t *theme.Theme) {
+	s.theme = t
+}
+
+func (s *Server) SetTrashRetention(retention time.Duration) {
+	s.retention = retention
+}
+
+// Serves until ctx is done, then stops taking new requests and waits for the
+// ones in progress to finish
+func (s Server) Run(ctx context.Context, listenAddress string) error {
+	srv := &http.Server{Addr: listenAddress, Handler: s.Routes()}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	return srv.Shutdown(context.Background())
 }
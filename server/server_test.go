@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -12,15 +13,53 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/blob"
+	"github.com/jbaikge/gocms/models/audit"
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
 	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/models/form"
+	"github.com/jbaikge/gocms/models/lock"
+	"github.com/jbaikge/gocms/models/media"
 	"github.com/jbaikge/gocms/models/user"
+	"github.com/jbaikge/gocms/models/webhook"
 	"github.com/jbaikge/gocms/repository"
 	"github.com/zeebo/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Builds a server over the repository wired the way cmd/gocms-web wires it,
+// with media blobs kept in memory. Tests reach the services through the
+// server's fields and add routes to s.router.
+func newTestServer(t *testing.T, repo repository.Repository) *Server {
+	t.Helper()
+	auditService := audit.NewAuditService(repo)
+	// Deliveries still retrying when the test ends are cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	webhookService := webhook.NewWebhookService(ctx, repo, http.DefaultClient)
+	t.Cleanup(func() {
+		cancel()
+		webhookService.Wait()
+	})
+	classService := class.NewClassService(repo, repo)
+	class.Audit(classService.Events(), auditService)
+	class.Notify(classService.Events(), class.NotifierFunc(webhookService.NotifyClass))
+	documentService := document.NewDocumentService(repo, classService)
+	document.Audit(documentService.Events(), auditService)
+	document.Notify(documentService.Events(), document.NotifierFunc(webhookService.NotifyDocument))
+	return New(
+		gin.New(),
+		auditService,
+		classService,
+		documentService,
+		form.NewFormService(repo),
+		lock.NewLockService(repo),
+		media.NewMediaService(repo, blob.NewMemory()),
+		user.NewUserService(repo, auditService),
+		webhookService,
+	)
+}
+
 func TestGetContext(t *testing.T) {
 	var i int
 	var f float64
@@ -34,12 +73,10 @@ func TestGetContext(t *testing.T) {
 }
 
 func TestServer(t *testing.T) {
-	router := gin.Default()
+	ctx := context.Background()
 	repo := repository.NewMemory()
-	classService := class.NewClassService(repo)
-	docService := document.NewDocumentService(repo)
-	userService := user.NewUserService(repo)
-	s := New(router, classService, docService, userService)
+	s := newTestServer(t, repo)
+	router := s.router
 	routes := s.Routes()
 
 	t.Run("MiddlewareClass", func(t *testing.T) {
@@ -62,7 +99,7 @@ func TestServer(t *testing.T) {
 		// A good class will fall through to the correct handler
 		t.Run("GoodClass", func(t *testing.T) {
 			class := class.Class{Slug: "good_class"}
-			assert.NoError(t, repo.InsertClass(&class))
+			assert.NoError(t, repo.InsertClass(ctx, &class))
 			req := httptest.NewRequest(http.MethodGet, "/admin/classes/good_class/edit", nil)
 			w := httptest.NewRecorder()
 			routes.ServeHTTP(w, req)
@@ -170,7 +207,7 @@ func TestServer(t *testing.T) {
 		// Nothing really exciting happens, a bunch of data gets pushed into the
 		// template context and rendered in the browser
 		class := class.Class{Slug: "builder_get"}
-		assert.NoError(t, repo.InsertClass(&class))
+		assert.NoError(t, repo.InsertClass(ctx, &class))
 		target := "/admin/classes/" + class.Slug + "/fields"
 		req := httptest.NewRequest(http.MethodGet, target, nil)
 		w := httptest.NewRecorder()
@@ -191,7 +228,7 @@ func TestServer(t *testing.T) {
 		// Follow all the rules
 		t.Run("Good", func(t *testing.T) {
 			class := class.Class{Name: "Builder Good", Slug: "builder_good"}
-			assert.NoError(t, repo.InsertClass(&class))
+			assert.NoError(t, repo.InsertClass(ctx, &class))
 
 			var data request
 			data.Fields = []field.Field{
@@ -223,7 +260,7 @@ func TestServer(t *testing.T) {
 		// Trigger ClassService.Validate to fail
 		t.Run("Bad", func(t *testing.T) {
 			class := class.Class{Name: "Builder Bad", Slug: "builder_bad"}
-			assert.NoError(t, repo.InsertClass(&class))
+			assert.NoError(t, repo.InsertClass(ctx, &class))
 
 			var data request
 			data.Fields = []field.Field{
@@ -254,7 +291,7 @@ func TestServer(t *testing.T) {
 		// Sending in field data as a non-array
 		t.Run("Malformed", func(t *testing.T) {
 			class := class.Class{Name: "Builder Malformed", Slug: "builder_malformed"}
-			assert.NoError(t, repo.InsertClass(&class))
+			assert.NoError(t, repo.InsertClass(ctx, &class))
 
 			data := struct {
 				Fields field.Field
@@ -302,11 +339,11 @@ func TestServer(t *testing.T) {
 				},
 			},
 		}
-		assert.NoError(t, repo.InsertClass(&class))
+		assert.NoError(t, repo.InsertClass(ctx, &class))
 		baseURL := "/admin/classes/" + class.Slug
 
 		doc := document.Document{ClassId: class.Id, Slug: "builder_doc", Title: "Doc"}
-		assert.NoError(t, repo.InsertDocument(&doc))
+		assert.NoError(t, repo.InsertDocument(ctx, &doc))
 
 		// Retrieve the details for a new document form, ask for JSON instead
 		// of trying to parse the HTML
@@ -400,7 +437,7 @@ func TestServer(t *testing.T) {
 
 	t.Run("HandleDocumentList", func(t *testing.T) {
 		class := class.Class{Name: "Doc Test", Slug: "doc_test"}
-		assert.NoError(t, repo.InsertClass(&class))
+		assert.NoError(t, repo.InsertClass(ctx, &class))
 		baseURL := "/admin/classes/" + class.Slug + "/"
 
 		numDocs := 10
@@ -410,7 +447,7 @@ func TestServer(t *testing.T) {
 				Title:   fmt.Sprintf("Document %d", i),
 				Slug:    fmt.Sprintf("doc_%d", i),
 			}
-			assert.NoError(t, repo.InsertDocument(&doc))
+			assert.NoError(t, repo.InsertDocument(ctx, &doc))
 		}
 
 		// Default listing landing page
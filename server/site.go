@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/theme"
+)
+
+// Data site templates are executed with
+type Page struct {
+	Class    class.Class
+	Document document.Document
+	// Ancestors of the document, outermost first
+	Parents []document.Document
+	Path    string
+	// Rich text and Markdown values ready for output, keyed by field name
+	HTML map[string]template.HTML
+
+	theme *theme.Theme
+}
+
+// URL of a theme asset, which changes along with its contents
+func (p Page) Asset(name string) string {
+	return p.theme.AssetURL(name)
+}
+
+// Value of a field formatted for display
+func (p Page) Value(name string) string {
+	value := p.Document.Value(name)
+	if value == nil {
+		return ""
+	}
+	return p.Class.Field(name).Apply(value)
+}
+
+// Path of one of the page ancestors
+func (p Page) PathTo(parent document.Document) string {
+	segments := strings.Split(p.Path, "/")
+	for i := range p.Parents {
+		if p.Parents[i].Id == parent.Id {
+			// Segments start with the empty string and the class slug
+			return strings.Join(segments[:i+3], "/")
+		}
+	}
+	return ""
+}
+
+// Reports whether visitors may see the document
+func isPublic(doc document.Document, now time.Time) bool {
+	return !doc.Published.IsZero() &&
+		!doc.Published.After(now) &&
+		doc.Archived.IsZero() &&
+		doc.Deleted.IsZero()
+}
+
+// Renders published documents at /class/slug, with the slugs of any children
+// following their parent's. Documents use their class template, or the theme
+// document template when the class has none. Anything else gets the theme
+// not found page.
+func (s *Server) HandleSite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		path := "/" + strings.Trim(c.Request.URL.Path, "/")
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			s.renderNotFound(c, path)
+			return
+		}
+
+		docs, err := s.documentService.Resolve(ctx, path)
+		if err != nil {
+			s.renderNotFound(c, path)
+			return
+		}
+
+		now := time.Now()
+		for _, doc := range docs {
+			if !isPublic(doc, now) {
+				s.renderNotFound(c, path)
+				return
+			}
+		}
+
+		doc := docs[len(docs)-1]
+		class, err := s.classService.GetById(ctx, doc.ClassId)
+		if err != nil || class.Fieldset {
+			s.renderNotFound(c, path)
+			return
+		}
+
+		var tmpl *template.Template
+		if class.Template != "" {
+			tmpl, err = s.theme.Parse(class.Slug, class.Template)
+		} else {
+			tmpl, err = s.theme.Template("document.html")
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		s.renderSite(c, http.StatusOK, tmpl, Page{
+			Class:    class,
+			Document: doc,
+			Parents:  docs[:len(docs)-1],
+			Path:     path,
+			HTML:     s.pageHTML(ctx, class, doc),
+			theme:    s.theme,
+		})
+	}
+}
+
+// Executes a theme template, only writing the response once it succeeds
+func (s *Server) renderSite(c *gin.Context, code int, tmpl *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(code, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (s *Server) renderNotFound(c *gin.Context, path string) {
+	tmpl, err := s.theme.Template("not-found.html")
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	s.renderSite(c, http.StatusNotFound, tmpl, Page{Path: path, theme: s.theme})
+}
+
+// Serves theme assets. Hashed names never change contents, so they may be
+// cached indefinitely; plain names must be revalidated.
+func (s *Server) HandleAsset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("filename")
+		asset, err := s.theme.Asset(name)
+		if errors.Is(err, theme.ErrNotFound) {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		etag := `"` + asset.Hash + `"`
+		c.Header("ETag", etag)
+		c.Header("X-Content-Type-Options", "nosniff")
+		if name == asset.HashedName {
+			c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			c.Header("Cache-Control", "no-cache")
+		}
+
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Data(http.StatusOK, asset.MimeType(), asset.Data)
+	}
+}
+
+// Values of the rich text and Markdown fields as HTML. Rich text is sanitized
+// again in case it was stored before its field had a policy.
+func (s *Server) pageHTML(ctx context.Context, class class.Class, doc document.Document) map[string]template.HTML {
+	rendered := make(map[string]template.HTML)
+	for name, html := range s.markdownHTML(ctx, class, doc) {
+		rendered[name] = template.HTML(html)
+	}
+	for _, f := range class.AllFields() {
+		if value, ok := doc.Values[f.Name].(string); ok && f.IsSanitized() {
+			rendered[f.Name] = template.HTML(f.Sanitize(value))
+		}
+	}
+	return rendered
+}
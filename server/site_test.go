@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/field"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/jbaikge/gocms/theme"
+	"github.com/zeebo/assert"
+)
+
+func TestSite(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+	router := s.Routes()
+
+	pages := class.Class{
+		Name: "Pages",
+		Slug: "pages",
+		Fields: []field.Field{
+			{Name: "summary", Label: "Summary", Type: field.TypeText},
+			{Name: "body", Label: "Body", Type: field.TypeTinyMCE},
+		},
+	}
+	assert.NoError(t, classService.Insert(ctx, &pages))
+
+	people := class.Class{
+		Name:     "People",
+		Slug:     "people",
+		Template: `<h1>{{ .Document.Title }}</h1>{{ range .Parents }}<a href="{{ $.PathTo . }}">{{ .Title }}</a>{{ end }}<p>{{ .Value "role" }}</p>`,
+		Fields: []field.Field{
+			{Name: "role", Label: "Role", Type: field.TypeText},
+		},
+	}
+	assert.NoError(t, classService.Insert(ctx, &people))
+
+	past := time.Now().Add(-time.Hour)
+	about := document.Document{
+		ClassId:   pages.Id,
+		Title:     "About Us",
+		Slug:      "about",
+		Published: past,
+		Values: map[string]interface{}{
+			"summary": "<b>Who</b> we are",
+			"body":    "<p>Our story</p>",
+		},
+	}
+	assert.NoError(t, docService.Insert(ctx, &about))
+
+	// Stored before the body was sanitized on save
+	about.Values["body"] = `<p onclick="steal()">Our story</p>`
+	assert.NoError(t, repo.UpdateDocument(ctx, &about))
+
+	jane := document.Document{
+		ClassId:   people.Id,
+		ParentId:  about.Id,
+		Title:     "Jane",
+		Slug:      "jane",
+		Published: past,
+		Values:    map[string]interface{}{"role": "<Editor>"},
+	}
+	assert.NoError(t, docService.Insert(ctx, &jane))
+
+	draft := document.Document{ClassId: pages.Id, Title: "Draft", Slug: "draft", Published: time.Now().Add(time.Hour)}
+	assert.NoError(t, docService.Insert(ctx, &draft))
+	draftChild := document.Document{ClassId: people.Id, ParentId: draft.Id, Title: "Hidden", Slug: "hidden", Published: past}
+	assert.NoError(t, docService.Insert(ctx, &draftChild))
+
+	get := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	t.Run("Default Template", func(t *testing.T) {
+		w := get(http.MethodGet, "/pages/about")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+		body := w.Body.String()
+		assert.True(t, strings.Contains(body, "<h1>About Us</h1>"))
+		assert.True(t, strings.Contains(body, "&lt;b&gt;Who&lt;/b&gt; we are"))
+		assert.True(t, strings.Contains(body, "<p>Our story</p>"))
+		assert.False(t, strings.Contains(body, "steal()"))
+	})
+
+	t.Run("Class Template", func(t *testing.T) {
+		w := get(http.MethodGet, "/pages/about/jane/")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `<h1>Jane</h1><a href="/pages/about">About Us</a><p>&lt;Editor&gt;</p>`, w.Body.String())
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		for _, target := range []string{
+			"/",
+			"/pages",
+			"/pages/missing",
+			"/people/jane",
+			"/pages/draft",
+			"/pages/draft/hidden",
+			"/missing/about",
+		} {
+			w := get(http.MethodGet, target)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), "Page Not Found"))
+		}
+
+		w := get(http.MethodPost, "/pages/about")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Assets", func(t *testing.T) {
+		w := get(http.MethodGet, "/pages/about")
+		start := strings.Index(w.Body.String(), "/assets/site.")
+		assert.True(t, start > 0)
+		target := w.Body.String()[start:]
+		target = target[:strings.Index(target, `"`)]
+
+		w = get(http.MethodGet, target)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/css"))
+		assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+
+		w = get(http.MethodGet, "/assets/site.css")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+
+		w = get(http.MethodGet, "/assets/missing.css")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Theme", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "partials"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "partials", "role.html"), []byte(`<em>{{ .Value "role" }}</em>`), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "not-found.html"), []byte(`Nope`), 0644))
+		th, err := theme.New(dir, false)
+		assert.NoError(t, err)
+
+		s := newTestServer(t, repo)
+		s.SetTheme(th)
+		themed := s.Routes()
+
+		people.Template = `{{ template "base.html" . }}{{ define "content" }}{{ template "role.html" . }}{{ end }}`
+		assert.NoError(t, classService.Update(ctx, &people))
+
+		w := httptest.NewRecorder()
+		themed.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pages/about/jane", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), "<!DOCTYPE html>"))
+		assert.True(t, strings.Contains(w.Body.String(), "<em>&lt;Editor&gt;</em>"))
+
+		w = httptest.NewRecorder()
+		themed.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pages/missing", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "Nope", w.Body.String())
+	})
+
+	t.Run("Trashed", func(t *testing.T) {
+		assert.NoError(t, docService.Trash(ctx, about, about.Id))
+		w := get(http.MethodGet, "/pages/about")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Template Errors", func(t *testing.T) {
+		broken := class.Class{Name: "Broken", Slug: "broken", Template: "{{ .Document.Title "}
+		assert.Error(t, classService.Insert(ctx, &broken))
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/jbaikge/gocms/models/class"
 	"github.com/jbaikge/gocms/models/document"
 	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestEmptyTable(t *testing.T) {
@@ -49,3 +50,29 @@ func TestPopulatedTable(t *testing.T) {
 		}
 	}
 }
+
+func TestRelationTable(t *testing.T) {
+	class := class.Class{
+		TableLabels: "Title Authors",
+		TableFields: "title authors.title",
+	}
+	authors := []document.Document{
+		{Id: primitive.NewObjectID(), Title: "Alice"},
+		{Id: primitive.NewObjectID(), Title: "Bob"},
+	}
+	doc := document.Document{
+		Title: "Post",
+		Values: map[string]interface{}{
+			"authors": []primitive.ObjectID{authors[1].Id, authors[0].Id},
+		},
+		Related: map[primitive.ObjectID]document.Document{
+			authors[0].Id: authors[0],
+			authors[1].Id: authors[1],
+		},
+	}
+
+	body := NewTable(class, []document.Document{doc}).Body()
+	assert.Equal(t, 1, len(body))
+	assert.Equal(t, "Post", body[0].Columns[0])
+	assert.Equal(t, "Bob, Alice", body[0].Columns[1])
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A trashed document paired with its class so the trash view can label it
+type TrashedDocument struct {
+	Class    class.Class
+	Document document.Document
+}
+
+func (s *Server) HandleTrash() gin.HandlerFunc {
+	name := "admin-trash"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/trash.html",
+	)))
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		classes, err := s.classService.Trashed(ctx)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		docs, err := s.documentService.Trashed(ctx)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		// Trashed classes are still returned by ID, so every document can be
+		// labelled
+		lookup := make(map[primitive.ObjectID]class.Class)
+		documents := make([]TrashedDocument, len(docs))
+		for i, doc := range docs {
+			docClass, ok := lookup[doc.ClassId]
+			if !ok {
+				docClass, _ = s.classService.GetById(ctx, doc.ClassId)
+				lookup[doc.ClassId] = docClass
+			}
+			documents[i] = TrashedDocument{Class: docClass, Document: doc}
+		}
+
+		obj := gin.H{
+			"Classes":   classes,
+			"Documents": documents,
+			"Retention": s.retention,
+			"Error":     c.Query("error"),
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
+
+// Restores or purges a single trashed class or document. The kind parameter
+// is either classes or documents.
+func (s *Server) HandleTrashAction(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		switch c.Param("kind") {
+		case "classes":
+			err = s.classTrashAction(ctx, id, action, adminUserId(c))
+		case "documents":
+			err = s.documentTrashAction(ctx, id, action, adminUserId(c))
+		default:
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		target := "/admin/trash"
+		if err != nil {
+			target += "?error=" + url.QueryEscape(err.Error())
+		}
+		c.Redirect(http.StatusSeeOther, target)
+	}
+}
+
+func (s *Server) classTrashAction(ctx context.Context, id primitive.ObjectID, action string, userId primitive.ObjectID) error {
+	trashed, err := s.classService.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if trashed.Deleted.IsZero() {
+		return fmt.Errorf("class %s is not in the trash", trashed.Slug)
+	}
+
+	classes := s.classService.As(userId)
+	if action == "restore" {
+		return classes.Restore(ctx, trashed)
+	}
+	return classes.Delete(ctx, trashed, trashed.DeleteMode)
+}
+
+func (s *Server) documentTrashAction(ctx context.Context, id primitive.ObjectID, action string, userId primitive.ObjectID) error {
+	trashed, err := s.documentService.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if trashed.Deleted.IsZero() {
+		return fmt.Errorf("document %s is not in the trash", trashed.Slug)
+	}
+
+	docs := s.documentService.As(userId)
+	if action == "restore" {
+		return docs.Restore(ctx, trashed)
+	}
+	return docs.Delete(ctx, trashed)
+}
+
+// Permanently deletes anything which has been in the trash longer than the
+// retention period, checking once per interval until the context is done.
+// Blocks, so run it in its own goroutine.
+func (s *Server) PurgeTrash(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.purgeTrash(ctx, time.Now().Add(-s.retention))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) purgeTrash(ctx context.Context, cutoff time.Time) {
+	docs, err := s.documentService.Purge(ctx, cutoff)
+	if err != nil {
+		log.Printf("Purging documents: %v", err)
+	}
+
+	classes, err := s.classService.Purge(ctx, cutoff)
+	if err != nil {
+		log.Printf("Purging classes: %v", err)
+	}
+
+	if docs > 0 || classes > 0 {
+		log.Printf("Purged %d documents and %d classes from the trash", docs, classes)
+	}
+}
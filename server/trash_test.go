@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService := s.classService, s.documentService
+	s.SetTrashRetention(time.Hour)
+
+	c := class.Class{Name: "Purge", Slug: "purge"}
+	assert.NoError(t, classService.Insert(ctx, &c))
+
+	doc := document.Document{ClassId: c.Id, Slug: "purge"}
+	assert.NoError(t, docService.Insert(ctx, &doc))
+	assert.NoError(t, docService.Trash(ctx, doc, primitive.NewObjectID()))
+
+	// Still within the retention period
+	s.purgeTrash(ctx, time.Now().Add(-s.retention))
+	_, err := docService.GetById(ctx, doc.Id)
+	assert.NoError(t, err)
+
+	// Retention period has passed
+	s.purgeTrash(ctx, time.Now().Add(time.Second))
+	_, err = docService.GetById(ctx, doc.Id)
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/gocms/models/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Looks up the webhook named by the id parameter
+func (s *Server) webhookParam(c *gin.Context) (w webhook.Webhook, err error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return
+	}
+	return s.webhookService.GetById(id)
+}
+
+func (s *Server) HandleWebhookList() gin.HandlerFunc {
+	name := "admin-webhook-list"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/webhook-list.html",
+	)))
+
+	return func(c *gin.Context) {
+		webhooks, err := s.webhookService.List()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		obj := gin.H{
+			"Webhooks": webhooks,
+			"Error":    c.Query("error"),
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
+
+// Creates a webhook at /admin/webhooks/new or edits one at
+// /admin/webhooks/:id. Leaving the secret blank keeps the current one, or
+// generates one for new webhooks.
+func (s *Server) HandleWebhookBuilder() gin.HandlerFunc {
+	name := "admin-webhook-builder"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/webhook-builder.html",
+	)))
+
+	return func(c *gin.Context) {
+		var w webhook.Webhook
+		var err error
+
+		// No ID parameter means we are on /new
+		if c.Param("id") != "" {
+			if w, err = s.webhookParam(c); err != nil {
+				c.AbortWithError(http.StatusNotFound, err)
+				return
+			}
+		} else {
+			w.Active = true
+		}
+
+		if c.Request.Method == http.MethodPost {
+			w.Name = strings.TrimSpace(c.PostForm("name"))
+			w.URL = strings.TrimSpace(c.PostForm("url"))
+			w.Secret = strings.TrimSpace(c.PostForm("secret"))
+			w.Events = c.PostFormArray("events")
+			w.Active = c.PostForm("active") != ""
+			w.ClassIds = nil
+			for _, hex := range c.PostFormArray("class_ids") {
+				id, idErr := primitive.ObjectIDFromHex(hex)
+				if idErr != nil {
+					c.AbortWithError(http.StatusBadRequest, idErr)
+					return
+				}
+				w.ClassIds = append(w.ClassIds, id)
+			}
+
+			if w.Id.IsZero() {
+				err = s.webhookService.Insert(&w)
+			} else {
+				err = s.webhookService.Update(&w)
+			}
+
+			if err == nil {
+				c.Redirect(http.StatusSeeOther, "/admin/webhooks/")
+				return
+			}
+		}
+
+		selected := make(map[string]bool, len(w.Events)+len(w.ClassIds))
+		for _, event := range w.Events {
+			selected[event] = true
+		}
+		for _, id := range w.ClassIds {
+			selected[id.Hex()] = true
+		}
+
+		obj := gin.H{
+			"Webhook":  w,
+			"Events":   webhook.Events,
+			"Selected": selected,
+			"Error":    err,
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusBadRequest
+		}
+		c.HTML(status, name, obj)
+	}
+}
+
+// Deletes a webhook along with its delivery log
+func (s *Server) HandleWebhookDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, err := s.webhookParam(c)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		redirect := "/admin/webhooks/"
+		if err := s.webhookService.Delete(w); err != nil {
+			redirect += "?" + url.Values{"error": {err.Error()}}.Encode()
+		}
+		c.Redirect(http.StatusSeeOther, redirect)
+	}
+}
+
+// Lists the latest deliveries to a webhook with their response codes
+func (s *Server) HandleWebhookDeliveries() gin.HandlerFunc {
+	name := "admin-webhook-deliveries"
+	s.renderer.Add(name, template.Must(template.New("base.html").ParseFS(
+		fs,
+		"templates/admin/base.html",
+		"templates/admin/webhook-deliveries.html",
+	)))
+
+	return func(c *gin.Context) {
+		w, err := s.webhookParam(c)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		deliveries, err := s.webhookService.Deliveries(w)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		obj := gin.H{
+			"Webhook":    w,
+			"Deliveries": deliveries,
+			"Error":      c.Query("error"),
+		}
+		if list, ok := c.Get("classList"); ok {
+			obj["ClassList"] = list
+		}
+
+		c.HTML(http.StatusOK, name, obj)
+	}
+}
+
+// Sends a delivery again in the background, then returns to the log
+func (s *Server) HandleWebhookRedeliver() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, err := s.webhookParam(c)
+		if err != nil {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(c.Param("delivery_id"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		redirect := "/admin/webhooks/" + w.Id.Hex() + "/deliveries"
+		if _, err := s.webhookService.Redeliver(id); err != nil {
+			redirect += "?" + url.Values{"error": {err.Error()}}.Encode()
+		}
+		c.Redirect(http.StatusSeeOther, redirect)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jbaikge/gocms/models/class"
+	"github.com/jbaikge/gocms/models/document"
+	"github.com/jbaikge/gocms/models/webhook"
+	"github.com/jbaikge/gocms/repository"
+	"github.com/zeebo/assert"
+)
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var signatures []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		signatures = append(signatures, r.Header.Get(webhook.HeaderSignature)+" "+string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer receiver.Close()
+
+	repo := repository.NewMemory()
+	s := newTestServer(t, repo)
+	classService, docService, webhookService := s.classService, s.documentService, s.webhookService
+	router := s.router
+
+	router.GET("/admin/webhooks/", s.HandleWebhookList())
+	router.GET("/admin/webhooks/new", s.HandleWebhookBuilder())
+	router.POST("/admin/webhooks/new", s.HandleWebhookBuilder())
+	router.POST("/admin/webhooks/:id/delete", s.HandleWebhookDelete())
+	router.GET("/admin/webhooks/:id/deliveries", s.HandleWebhookDeliveries())
+	router.POST("/admin/webhooks/:id/deliveries/:delivery_id/redeliver", s.HandleWebhookRedeliver())
+
+	serve := func(method, path string, values url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	pages := class.Class{Name: "Pages", Slug: "pages"}
+	assert.NoError(t, classService.Insert(ctx, &pages))
+
+	t.Run("Invalid", func(t *testing.T) {
+		w := serve(http.MethodPost, "/admin/webhooks/new", url.Values{
+			"name": {"Search"},
+			"url":  {"not a url"},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), "absolute http or https URL"))
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		w := serve(http.MethodPost, "/admin/webhooks/new", url.Values{
+			"name":      {"Search"},
+			"url":       {receiver.URL},
+			"secret":    {"shh"},
+			"events":    {document.EventCreated, document.EventPublished},
+			"class_ids": {pages.Id.Hex()},
+			"active":    {"1"},
+		})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+
+		w = serve(http.MethodGet, "/admin/webhooks/", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), receiver.URL))
+	})
+
+	webhooks, err := webhookService.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(webhooks))
+	hook := webhooks[0]
+	deliveriesPath := "/admin/webhooks/" + hook.Id.Hex() + "/deliveries"
+
+	t.Run("Deliver", func(t *testing.T) {
+		doc := document.Document{ClassId: pages.Id, Title: "About", Slug: "about"}
+		assert.NoError(t, docService.Insert(ctx, &doc))
+		webhookService.Wait()
+
+		assert.Equal(t, 1, len(signatures))
+		parts := strings.SplitN(signatures[0], " ", 2)
+		assert.Equal(t, webhook.Sign("shh", []byte(parts[1])), parts[0])
+		assert.True(t, strings.Contains(parts[1], `"event":"document.created"`))
+
+		w := serve(http.MethodGet, deliveriesPath, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), `<span class="badge bg-success">202</span>`))
+	})
+
+	t.Run("Redeliver", func(t *testing.T) {
+		deliveries, err := webhookService.Deliveries(hook)
+		assert.NoError(t, err)
+
+		w := serve(http.MethodPost, deliveriesPath+"/"+deliveries[0].Id.Hex()+"/redeliver", nil)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, deliveriesPath, w.Header().Get("Location"))
+		webhookService.Wait()
+		assert.Equal(t, 2, len(signatures))
+		assert.Equal(t, signatures[0], signatures[1])
+
+		w = serve(http.MethodGet, deliveriesPath, nil)
+		assert.True(t, strings.Contains(w.Body.String(), "Redelivery"))
+
+		w = serve(http.MethodPost, deliveriesPath+"/000000000000000000000000/redeliver", nil)
+		assert.True(t, strings.Contains(w.Header().Get("Location"), "?error="))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		w := serve(http.MethodPost, "/admin/webhooks/"+hook.Id.Hex()+"/delete", nil)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		w = serve(http.MethodGet, deliveriesPath, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,47 @@
+package theme
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// Serves files from the upper filesystem, falling back to the lower one for
+// anything the upper does not have. Directory listings merge both.
+type overlay struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return f, err
+}
+
+func (o overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	if upperErr != nil && lowerErr != nil {
+		return nil, upperErr
+	}
+
+	merged := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, entry := range lower {
+		merged[entry.Name()] = entry
+	}
+	for _, entry := range upper {
+		merged[entry.Name()] = entry
+	}
+
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
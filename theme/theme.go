@@ -0,0 +1,252 @@
+// Themes hold the templates and static assets of the public site. A theme
+// directory needs only the files it changes; everything else falls back to
+// the embedded default theme.
+//
+// Themes are laid out as:
+//
+//	layouts/*.html   Layouts shared by every page
+//	partials/*.html  Partials shared by every page
+//	*.html           Pages, such as document.html, form.html and not-found.html
+//	assets/*         Static files, served with their content hash in the name
+//
+// Templates refer to layouts, partials and pages by file name, so a page
+// usually executes {{ template "base.html" . }} and defines the blocks of
+// the layout.
+package theme
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+//go:embed default
+var embedded embed.FS
+
+const (
+	layoutsDir  = "layouts"
+	partialsDir = "partials"
+	assetsDir   = "assets"
+)
+
+// Number of hex characters of the content hash placed in asset names
+const hashLength = 10
+
+// Returned when a theme has no template or asset by a name
+var ErrNotFound = errors.New("not found in theme")
+
+type Asset struct {
+	// Name of the file in the assets directory, such as site.css
+	Name string
+	// Name with the content hash added, such as site.0123456789.css
+	HashedName string
+	// Hex encoded SHA-256 of the contents
+	Hash string
+	Data []byte
+}
+
+type Theme struct {
+	files  fs.FS
+	reload bool
+	loaded *snapshot
+}
+
+// Parsed templates and loaded assets
+type snapshot struct {
+	// Layouts and partials, cloned for every page
+	base   *template.Template
+	pages  map[string]*template.Template
+	assets map[string]*Asset
+}
+
+// Loads the theme in dir over the default theme. A blank dir uses the default
+// theme alone. Themes which reload read their files again on every use so
+// changes show up without a restart.
+func New(dir string, reload bool) (t *Theme, err error) {
+	defaults, err := fs.Sub(embedded, "default")
+	if err != nil {
+		return
+	}
+
+	t = &Theme{
+		files:  defaults,
+		reload: reload,
+	}
+
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("theme %s is not a directory", dir)
+		}
+		t.files = overlay{upper: os.DirFS(dir), lower: defaults}
+	}
+
+	// Loading up front reports broken themes at startup, even when reloading
+	if t.loaded, err = t.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Panics if the theme could not be loaded, for use with the default theme
+func Must(t *Theme, err error) *Theme {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Page template by file name, such as document.html
+func (t *Theme) Template(name string) (*template.Template, error) {
+	s, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	page, ok := s.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s: %w", name, ErrNotFound)
+	}
+	return page, nil
+}
+
+// Parses template source stored outside the theme, such as a class template,
+// so it may use the theme layouts and partials
+func (t *Theme) Parse(name, source string) (*template.Template, error) {
+	s, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(name, source)
+}
+
+// Finds an asset by its name or hashed name
+func (t *Theme) Asset(name string) (*Asset, error) {
+	s, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	asset, ok := s.assets[name]
+	if !ok {
+		return nil, fmt.Errorf("asset %s: %w", name, ErrNotFound)
+	}
+	return asset, nil
+}
+
+// URL of an asset including its content hash, which changes whenever the
+// contents do. Names without an asset are returned unhashed.
+func (t *Theme) AssetURL(name string) string {
+	if asset, err := t.Asset(name); err == nil {
+		return "/assets/" + asset.HashedName
+	}
+	return "/assets/" + name
+}
+
+func (a Asset) MimeType() string {
+	if mimeType := mime.TypeByExtension(path.Ext(a.Name)); mimeType != "" {
+		return mimeType
+	}
+	return http.DetectContentType(a.Data)
+}
+
+func (t *Theme) current() (*snapshot, error) {
+	if t.reload {
+		return t.load()
+	}
+	return t.loaded, nil
+}
+
+func (t *Theme) load() (s *snapshot, err error) {
+	s = &snapshot{
+		base:  template.New(""),
+		pages: make(map[string]*template.Template),
+	}
+
+	for _, dir := range []string{layoutsDir, partialsDir} {
+		matches, err := fs.Glob(t.files, dir+"/*.html")
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		if _, err = s.base.ParseFS(t.files, matches...); err != nil {
+			return nil, err
+		}
+	}
+
+	pages, err := fs.Glob(t.files, "*.html")
+	if err != nil {
+		return
+	}
+	for _, name := range pages {
+		source, err := fs.ReadFile(t.files, name)
+		if err != nil {
+			return nil, err
+		}
+		if s.pages[name], err = s.parse(name, string(source)); err != nil {
+			return nil, err
+		}
+	}
+
+	s.assets, err = loadAssets(t.files)
+	return
+}
+
+func (s *snapshot) parse(name, source string) (*template.Template, error) {
+	set, err := s.base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return set.New(name).Parse(source)
+}
+
+// Reads every file in the assets directory. Subdirectories and hidden files
+// are skipped.
+func loadAssets(files fs.FS) (assets map[string]*Asset, err error) {
+	assets = make(map[string]*Asset)
+
+	entries, err := fs.ReadDir(files, assetsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return assets, nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		data, err := fs.ReadFile(files, path.Join(assetsDir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		ext := path.Ext(name)
+		asset := &Asset{
+			Name:       name,
+			HashedName: strings.TrimSuffix(name, ext) + "." + hash[:hashLength] + ext,
+			Hash:       hash,
+			Data:       data,
+		}
+		assets[asset.Name] = asset
+		assets[asset.HashedName] = asset
+	}
+	return
+}
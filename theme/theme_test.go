@@ -0,0 +1,132 @@
+package theme
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+type page struct {
+	Title string
+	Path  string
+}
+
+func (p page) Asset(name string) string {
+	return "/assets/" + name
+}
+
+func writeFile(t *testing.T, dir, name, contents string) {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+}
+
+func execute(t *testing.T, theme *Theme, name string, data interface{}) string {
+	tmpl, err := theme.Template(name)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, tmpl.Execute(&buf, data))
+	return buf.String()
+}
+
+func TestDefault(t *testing.T) {
+	theme := Must(New("", false))
+
+	body := execute(t, theme, "not-found.html", page{Path: "/missing"})
+	assert.True(t, strings.Contains(body, "<title>Page Not Found</title>"))
+	assert.True(t, strings.Contains(body, "/missing"))
+
+	_, err := theme.Template("missing.html")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	asset, err := theme.Asset("site.css")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(asset.MimeType(), "text/css"))
+	assert.Equal(t, "/assets/"+asset.HashedName, theme.AssetURL("site.css"))
+	assert.Equal(t, "/assets/missing.js", theme.AssetURL("missing.js"))
+
+	hashed, err := theme.Asset(asset.HashedName)
+	assert.NoError(t, err)
+	assert.Equal(t, asset, hashed)
+}
+
+func TestOverride(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "partials/greeting.html", `Hello {{ .Title }}`)
+	writeFile(t, dir, "not-found.html", `{{ template "base.html" . }}{{ define "content" }}Gone: {{ template "greeting.html" . }}{{ end }}`)
+	writeFile(t, dir, "assets/app.js", `console.log(1)`)
+	writeFile(t, dir, "assets/.hidden", `secret`)
+
+	theme, err := New(dir, false)
+	assert.NoError(t, err)
+
+	// The default layout wraps the overridden page
+	body := execute(t, theme, "not-found.html", page{Title: "World"})
+	assert.True(t, strings.Contains(body, "<!DOCTYPE html>"))
+	assert.True(t, strings.Contains(body, "Gone: Hello World"))
+
+	// Pages which are not overridden come from the default theme
+	_, err = theme.Template("document.html")
+	assert.NoError(t, err)
+
+	// Assets from both themes are available
+	asset, err := theme.Asset("app.js")
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log(1)", string(asset.Data))
+	assert.True(t, strings.HasPrefix(asset.HashedName, "app."))
+	assert.True(t, strings.HasSuffix(asset.HashedName, ".js"))
+	_, err = theme.Asset("site.css")
+	assert.NoError(t, err)
+	_, err = theme.Asset(".hidden")
+	assert.Error(t, err)
+
+	t.Run("Parse", func(t *testing.T) {
+		tmpl, err := theme.Parse("posts", `{{ template "greeting.html" . }}!`)
+		assert.NoError(t, err)
+		var buf bytes.Buffer
+		assert.NoError(t, tmpl.Execute(&buf, page{Title: "Posts"}))
+		assert.Equal(t, "Hello Posts!", buf.String())
+	})
+
+	t.Run("No Reload", func(t *testing.T) {
+		writeFile(t, dir, "partials/greeting.html", `Goodbye {{ .Title }}`)
+		body := execute(t, theme, "not-found.html", page{Title: "World"})
+		assert.True(t, strings.Contains(body, "Gone: Hello World"))
+	})
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "hello.html", `Hello`)
+	writeFile(t, dir, "assets/app.js", `one`)
+
+	theme, err := New(dir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", execute(t, theme, "hello.html", nil))
+	before := theme.AssetURL("app.js")
+
+	writeFile(t, dir, "hello.html", `Hello again`)
+	writeFile(t, dir, "assets/app.js", `two`)
+	assert.Equal(t, "Hello again", execute(t, theme, "hello.html", nil))
+	assert.True(t, before != theme.AssetURL("app.js"))
+
+	// Broken templates are reported when used
+	writeFile(t, dir, "hello.html", `{{ if }}`)
+	_, err = theme.Template("hello.html")
+	assert.Error(t, err)
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "missing"), false)
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	writeFile(t, dir, "layouts/broken.html", `{{ end }}`)
+	_, err = New(dir, false)
+	assert.Error(t, err)
+}